@@ -0,0 +1,332 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"zuri.chat/zccore/service"
+	"zuri.chat/zccore/user"
+	"zuri.chat/zccore/utils"
+)
+
+const (
+	authAttemptsCollection = "auth_attempts"
+	authEventsCollection   = "auth_events"
+)
+
+// auth event types.
+const (
+	AuthEventLoginSucceeded  = "login_succeeded"
+	AuthEventLoginFailed     = "login_failed"
+	AuthEventLoginBlocked    = "login_blocked"
+	AuthEventAccountLocked   = "account_locked"
+	AuthEventTwoFactorFailed = "two_factor_failed"
+	AuthEventResetCodeFailed = "reset_code_failed"
+	AuthEventMagicLinkSent   = "magic_link_sent"
+)
+
+var (
+	ErrTooManyAttempts = errors.New("too many failed attempts, try again later")
+	ErrAccountLocked   = errors.New("too many failed attempts, this account is temporarily locked")
+
+	attemptIndexOnce sync.Once
+)
+
+// attemptPolicy describes how failures against one key are slowed down. The
+// first FreeAttempts failures cost nothing, after that each failure doubles
+// the wait before the next try, and every LockAfter failures lock the key.
+type attemptPolicy struct {
+	FreeAttempts int
+	BaseDelay    time.Duration
+	MaxDelay     time.Duration
+	LockAfter    int
+	LockFor      time.Duration
+	// failures are forgotten this long after the last one
+	Window time.Duration
+}
+
+var (
+	accountLoginPolicy = attemptPolicy{
+		FreeAttempts: 3, BaseDelay: time.Second, MaxDelay: time.Minute,
+		LockAfter: 10, LockFor: 15 * time.Minute, Window: time.Hour,
+	}
+	ipLoginPolicy = attemptPolicy{
+		FreeAttempts: 10, BaseDelay: time.Second, MaxDelay: time.Minute,
+		LockAfter: 50, LockFor: 15 * time.Minute, Window: time.Hour,
+	}
+	resetCodePolicy = attemptPolicy{
+		FreeAttempts: 3, BaseDelay: 2 * time.Second, MaxDelay: 5 * time.Minute,
+		LockAfter: 10, LockFor: time.Hour, Window: 24 * time.Hour,
+	}
+)
+
+// delay returns how long to wait after the given number of failures.
+func (p attemptPolicy) delay(failures int) time.Duration {
+	if failures <= p.FreeAttempts {
+		return 0
+	}
+
+	d := float64(p.BaseDelay) * math.Pow(2, float64(failures-p.FreeAttempts-1))
+	if d > float64(p.MaxDelay) {
+		return p.MaxDelay
+	}
+
+	return time.Duration(d)
+}
+
+// locks reports whether reaching failures locks the key.
+func (p attemptPolicy) locks(failures int) bool {
+	return p.LockAfter > 0 && failures > 0 && failures%p.LockAfter == 0
+}
+
+type attemptKey struct {
+	Key    string
+	Policy attemptPolicy
+}
+
+type authAttempt struct {
+	Key         string    `bson:"_id"`
+	Failures    int       `bson:"failures"`
+	LastFailure time.Time `bson:"last_failure"`
+	NextAllowed time.Time `bson:"next_allowed"`
+	LockedUntil time.Time `bson:"locked_until"`
+	ExpiresAt   time.Time `bson:"expires_at"`
+}
+
+type AuthEvent struct {
+	Type      string    `bson:"type" json:"type"`
+	Email     string    `bson:"email,omitempty" json:"email,omitempty"`
+	IPAddress string    `bson:"ip_address" json:"ip_address"`
+	UserAgent string    `bson:"user_agent" json:"user_agent"`
+	CreatedAt time.Time `bson:"created_at" json:"created_at"`
+}
+
+func accountKey(action, email string) attemptKey {
+	policy := accountLoginPolicy
+	if action == "reset" {
+		policy = resetCodePolicy
+	}
+
+	return attemptKey{Key: fmt.Sprintf("%s:account:%s", action, email), Policy: policy}
+}
+
+func ipKey(action string, r *http.Request) attemptKey {
+	policy := ipLoginPolicy
+	if action == "reset" {
+		policy = resetCodePolicy
+	}
+
+	return attemptKey{Key: fmt.Sprintf("%s:ip:%s", action, utils.ClientIP(r)), Policy: policy}
+}
+
+func attemptsCollection() *mongo.Collection {
+	coll := utils.GetCollection(authAttemptsCollection)
+
+	attemptIndexOnce.Do(func() {
+		indexModel := mongo.IndexModel{
+			Keys:    bson.M{"expires_at": 1},
+			Options: options.Index().SetExpireAfterSeconds(0),
+		}
+
+		if _, err := coll.Indexes().CreateOne(context.Background(), indexModel); err != nil {
+			log.Printf("auth attempts: could not create ttl index: %v", err)
+		}
+	})
+
+	return coll
+}
+
+// maxAttemptRaces bounds how often takeAttempt retries after losing a race
+// with a concurrent attempt on the same key.
+const maxAttemptRaces = 3
+
+// takeAttempt counts an attempt against k before the credentials are checked,
+// and sets the wait the next attempt owes as if this one fails. The count is
+// only moved from the value that was read, so concurrent attempts each get
+// their own count and can't all slip through before a failure is recorded.
+// It returns the attempt's number, or an error and how long to wait when the
+// key is locked or still inside its back off delay.
+func takeAttempt(k attemptKey) (int, time.Duration, error) {
+	coll := attemptsCollection()
+
+	for i := 0; i < maxAttemptRaces; i++ {
+		now := time.Now()
+
+		var a authAttempt
+
+		err := coll.FindOne(context.Background(), bson.M{"_id": k.Key}).Decode(&a)
+		if err != nil && !errors.Is(err, mongo.ErrNoDocuments) {
+			log.Printf("auth attempts: could not read %s: %v", k.Key, err)
+			return 0, 0, nil
+		}
+
+		failures := a.Failures
+		if a.ExpiresAt.Before(now) {
+			failures = 0
+		} else {
+			if a.LockedUntil.After(now) {
+				return 0, a.LockedUntil.Sub(now), ErrAccountLocked
+			}
+
+			if a.NextAllowed.After(now) {
+				return 0, a.NextAllowed.Sub(now), ErrTooManyAttempts
+			}
+		}
+
+		n := failures + 1
+		update := bson.M{"$set": bson.M{
+			"failures":     n,
+			"last_failure": now,
+			"next_allowed": now.Add(k.Policy.delay(n)),
+			"locked_until": time.Time{},
+			"expires_at":   now.Add(k.Policy.Window),
+		}}
+
+		res, err := coll.UpdateOne(context.Background(), bson.M{"_id": k.Key, "failures": a.Failures}, update, options.Update().SetUpsert(true))
+		if mongo.IsDuplicateKeyError(err) {
+			continue
+		}
+
+		if err != nil {
+			log.Printf("auth attempts: could not count attempt on %s: %v", k.Key, err)
+			return 0, 0, nil
+		}
+
+		if res.MatchedCount > 0 || res.UpsertedCount > 0 {
+			return n, 0, nil
+		}
+	}
+
+	return 0, k.Policy.BaseDelay, ErrTooManyAttempts
+}
+
+// lockAttempts locks k after its failure number failures, when the policy says so.
+func lockAttempts(k attemptKey, failures int) bool {
+	if !k.Policy.locks(failures) {
+		return false
+	}
+
+	until := time.Now().Add(k.Policy.LockFor)
+	update := bson.M{"$max": bson.M{"locked_until": until, "expires_at": until.Add(k.Policy.Window)}}
+
+	if _, err := attemptsCollection().UpdateOne(context.Background(), bson.M{"_id": k.Key}, update); err != nil {
+		log.Printf("auth attempts: could not lock %s: %v", k.Key, err)
+	}
+
+	return true
+}
+
+// refundAttempts gives back the attempt taken on each key for a request that
+// did not fail, such as a correct password followed by a 2FA challenge.
+func refundAttempts(keys ...attemptKey) {
+	for _, k := range keys {
+		filter := bson.M{"_id": k.Key, "failures": bson.M{"$gt": 0}}
+		update := bson.M{"$inc": bson.M{"failures": -1}, "$set": bson.M{"next_allowed": time.Now()}}
+
+		if _, err := attemptsCollection().UpdateOne(context.Background(), filter, update); err != nil {
+			log.Printf("auth attempts: could not refund %s: %v", k.Key, err)
+		}
+	}
+}
+
+// attemptSucceeded forgets the failures of the account, keys[0], and gives
+// back the attempt taken on the other keys.
+func attemptSucceeded(keys ...attemptKey) {
+	clearAttempts(keys[0])
+	refundAttempts(keys[1:]...)
+}
+
+func clearAttempts(k attemptKey) {
+	if _, err := attemptsCollection().DeleteOne(context.Background(), bson.M{"_id": k.Key}); err != nil {
+		log.Printf("auth attempts: could not clear %s: %v", k.Key, err)
+	}
+}
+
+// LogAuthEvent appends an entry to the auth events collection.
+func LogAuthEvent(r *http.Request, eventType, email string) {
+	event := AuthEvent{
+		Type:      eventType,
+		Email:     email,
+		IPAddress: utils.ClientIP(r),
+		UserAgent: r.UserAgent(),
+		CreatedAt: time.Now(),
+	}
+
+	if _, err := utils.GetCollection(authEventsCollection).InsertOne(context.Background(), event); err != nil {
+		log.Printf("auth events: could not log %s: %v", eventType, err)
+	}
+}
+
+// takeAttempts takes an attempt on every key, see takeAttempt, and returns
+// the attempt numbers. When a key is blocked it gives back what it took,
+// writes a 429 with Retry-After and returns ok false.
+func takeAttempts(w http.ResponseWriter, keys ...attemptKey) ([]int, bool) {
+	counts := make([]int, 0, len(keys))
+
+	for i, k := range keys {
+		n, wait, err := takeAttempt(k)
+		if err != nil {
+			refundAttempts(keys[:i]...)
+
+			w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+			utils.GetError(err, http.StatusTooManyRequests, w)
+
+			return nil, false
+		}
+
+		counts = append(counts, n)
+	}
+
+	return counts, true
+}
+
+// authFailed logs a failed attempt, already counted by takeAttempts, locks
+// the keys that reached their limit and notifies u when its account key gets
+// locked. u is nil for unknown accounts.
+func (au *AuthHandler) authFailed(r *http.Request, eventType, action, email string, u *user.User, keys []attemptKey, counts []int) {
+	LogAuthEvent(r, eventType, email)
+
+	for i, k := range keys {
+		if i >= len(counts) || !lockAttempts(k, counts[i]) {
+			continue
+		}
+
+		if u == nil || k.Key != accountKey(action, email).Key {
+			log.Printf("auth attempts: %s locked after %d failures", k.Key, counts[i])
+			continue
+		}
+
+		LogAuthEvent(r, AuthEventAccountLocked, email)
+
+		// mail delivery is slow, the failed request shouldn't wait for it.
+		go au.sendLockoutMail(utils.ClientIP(r), u, action, counts[i], time.Now().Add(k.Policy.LockFor))
+	}
+}
+
+func (au *AuthHandler) sendLockoutMail(ip string, u *user.User, action string, failures int, until time.Time) {
+	actions := map[string]string{"login": "sign in", "reset": "reset your password"}
+
+	msger := au.mailService.NewMail(
+		[]string{u.Email},
+		"Your Zuri Chat account has been temporarily locked", service.AccountLocked, map[string]interface{}{
+			"Username":    u.Email,
+			"Attempts":    failures,
+			"Action":      actions[action],
+			"IPAddress":   ip,
+			"LockedUntil": until.UTC().Format(time.RFC1123),
+		})
+
+	if err := au.mailService.SendMail(msger); err != nil {
+		log.Printf("Error occurred while sending mail: %s", err.Error())
+	}
+}
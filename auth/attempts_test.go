@@ -0,0 +1,103 @@
+package auth
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestAttemptPolicyDelay(t *testing.T) {
+	p := attemptPolicy{FreeAttempts: 3, BaseDelay: time.Second, MaxDelay: 10 * time.Second, LockAfter: 10}
+
+	tests := []struct {
+		failures int
+		want     time.Duration
+	}{
+		{0, 0},
+		{3, 0},
+		{4, time.Second},
+		{5, 2 * time.Second},
+		{6, 4 * time.Second},
+		{7, 8 * time.Second},
+		{8, 10 * time.Second},
+		{40, 10 * time.Second},
+	}
+
+	for _, tt := range tests {
+		if got := p.delay(tt.failures); got != tt.want {
+			t.Errorf("delay(%d) = %v, want %v", tt.failures, got, tt.want)
+		}
+	}
+}
+
+func TestAttemptPolicyLocks(t *testing.T) {
+	p := attemptPolicy{LockAfter: 10}
+
+	for failures, want := range map[int]bool{0: false, 9: false, 10: true, 11: false, 20: true} {
+		if got := p.locks(failures); got != want {
+			t.Errorf("locks(%d) = %v, want %v", failures, got, want)
+		}
+	}
+}
+
+// TestLoginThrottled needs the database TestMain connects to.
+func TestLoginThrottled(t *testing.T) {
+	email := fmt.Sprintf("throttle-%d@zuri.chat", time.Now().UnixNano())
+
+	login := func() *httptest.ResponseRecorder {
+		body := fmt.Sprintf(`{"email": %q, "password": "not-the-password"}`, email)
+		req := httptest.NewRequest(http.MethodPost, "/auth/login", strings.NewReader(body))
+		rr := httptest.NewRecorder()
+
+		au.LoginIn(rr, req)
+
+		return rr
+	}
+
+	keys := []attemptKey{accountKey("login", email), ipKey("login", httptest.NewRequest(http.MethodPost, "/auth/login", nil))}
+	for _, k := range keys {
+		clearAttempts(k)
+		defer clearAttempts(k)
+	}
+
+	for i := 0; i < accountLoginPolicy.FreeAttempts; i++ {
+		if rr := login(); rr.Code != http.StatusBadRequest {
+			t.Fatalf("attempt %d: got status %d, want %d", i+1, rr.Code, http.StatusBadRequest)
+		}
+	}
+
+	// past the free attempts, only one of a burst of guesses gets checked.
+	var (
+		wg    sync.WaitGroup
+		mu    sync.Mutex
+		codes = map[int]int{}
+	)
+
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+
+			rr := login()
+
+			mu.Lock()
+			codes[rr.Code]++
+			mu.Unlock()
+
+			if rr.Code == http.StatusTooManyRequests && rr.Header().Get("Retry-After") == "" {
+				t.Error("429 without Retry-After")
+			}
+		}()
+	}
+
+	wg.Wait()
+
+	if codes[http.StatusBadRequest] != 1 || codes[http.StatusTooManyRequests] != 4 {
+		t.Errorf("burst of 5 guesses got %v, want one checked and four throttled", codes)
+	}
+}
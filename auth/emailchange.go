@@ -0,0 +1,354 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"zuri.chat/zccore/service"
+	"zuri.chat/zccore/user"
+	"zuri.chat/zccore/utils"
+)
+
+// how recent a session without a password must be to request an email change.
+const emailChangeReauthWindow = 5 * time.Minute
+
+var (
+	ErrEmailTaken         = errors.New("email address is already in use")
+	ErrSameEmail          = errors.New("new email is the same as the current one")
+	ErrInvalidEmailToken  = errors.New("email change link invalid, used or expired")
+	ErrReauthRequired     = errors.New("sign in again to confirm it is you, then retry")
+	ErrEmailChangePending = errors.New("a recent email change can still be undone from the old address, try again later")
+)
+
+// RequestEmailChange starts moving the logged in user to a new address. The
+// new address gets a confirm link and the current one a cancel link.
+func (au *AuthHandler) RequestEmailChange(w http.ResponseWriter, r *http.Request) {
+	w.Header().Add("content-type", "application/json")
+
+	loggedIn, _ := r.Context().Value("user").(*AuthUser)
+
+	u, err := au.loggedInUser(r)
+	if err != nil {
+		utils.GetError(err, http.StatusBadRequest, w)
+		return
+	}
+
+	body := struct {
+		NewEmail string `json:"new_email" validate:"required,email"`
+		Password string `json:"password"`
+	}{}
+
+	if err := utils.ParseJSONFromRequest(r, &body); err != nil {
+		utils.GetError(err, http.StatusUnprocessableEntity, w)
+		return
+	}
+
+	if err := validate.Struct(body); err != nil {
+		utils.GetError(err, http.StatusBadRequest, w)
+		return
+	}
+
+	// accounts created through an identity provider or magic links have no
+	// password to confirm, they have to have signed in moments ago instead.
+	if u.Password != "" && !ComparePassword(body.Password, u.Password) {
+		utils.GetError(ErrInvalidCredentials, http.StatusBadRequest, w)
+		return
+	}
+
+	if u.Password == "" && !recentlySignedIn(r.Context(), loggedIn) {
+		utils.GetError(ErrReauthRequired, http.StatusForbidden, w)
+		return
+	}
+
+	// a new request would replace the cancel link of a confirmed change.
+	if c := u.EmailChange; c != nil && c.OldEmail != "" && time.Now().Before(c.ExpiredAt) {
+		utils.GetError(ErrEmailChangePending, http.StatusConflict, w)
+		return
+	}
+
+	newEmail := strings.ToLower(body.NewEmail)
+	if newEmail == u.Email {
+		utils.GetError(ErrSameEmail, http.StatusBadRequest, w)
+		return
+	}
+
+	if _, err := FetchUserByEmail(bson.M{"email": newEmail}); err == nil {
+		utils.GetError(ErrEmailTaken, http.StatusBadRequest, w)
+		return
+	}
+
+	ttl := time.Duration(au.configs.VerificationCodeTTL) * time.Second
+
+	confirm, cancel, change, err := user.NewEmailChange(newEmail, ttl)
+	if err != nil {
+		utils.GetError(err, http.StatusInternalServerError, w)
+		return
+	}
+
+	update := bson.M{"$set": bson.M{"email_change": change}}
+	if _, err := utils.GetCollection(userCollection).UpdateOne(r.Context(), userIDFilter(u.ID), update); err != nil {
+		utils.GetError(err, http.StatusInternalServerError, w)
+		return
+	}
+
+	mails := []*service.Mail{
+		au.mailService.NewMail([]string{newEmail}, "Confirm your new email", service.EmailChangeConfirm, map[string]interface{}{
+			"Username":    u.Email,
+			"OldEmail":    u.Email,
+			"NewEmail":    newEmail,
+			"ConfirmLink": au.emailChangeLink("confirm", confirm),
+			"ExpiresIn":   int(ttl.Hours()),
+		}),
+		au.mailService.NewMail([]string{u.Email}, "Email change requested", service.EmailChangeNotice, map[string]interface{}{
+			"Username":   u.Email,
+			"NewEmail":   newEmail,
+			"IPAddress":  utils.ClientIP(r),
+			"CancelLink": au.emailChangeLink("cancel", cancel),
+		}),
+	}
+
+	for _, m := range mails {
+		if err := au.mailService.SendMail(m); err != nil {
+			fmt.Printf("Error occurred while sending mail: %s", err.Error())
+		}
+	}
+
+	utils.GetSuccess("check "+newEmail+" for a link to confirm the change", nil, w)
+}
+
+func (au *AuthHandler) emailChangeLink(action, token string) string {
+	return fmt.Sprintf("%s?action=%s&token=%s", au.configs.EmailChangeURL, action, url.QueryEscape(token))
+}
+
+// findEmailChange returns the user whose pending email change has a token,
+// under field, matching token.
+func findEmailChange(field, token string) (*user.User, error) {
+	u, err := FetchUserByEmail(bson.M{"email_change." + field: utils.HashToken(token)})
+	if err != nil || u.EmailChange == nil || time.Now().After(u.EmailChange.ExpiredAt) {
+		return nil, ErrInvalidEmailToken
+	}
+
+	return u, nil
+}
+
+// recentlySignedIn reports whether loggedIn is a session, not an access
+// token, that was started within emailChangeReauthWindow.
+func recentlySignedIn(ctx context.Context, loggedIn *AuthUser) bool {
+	if loggedIn == nil || loggedIn.TokenID != "" {
+		return false
+	}
+
+	var s Session
+	if err := utils.GetCollection(sessionCollection).FindOne(ctx, bson.M{"_id": loggedIn.ID}).Decode(&s); err != nil {
+		return false
+	}
+
+	return time.Since(s.CreatedAt) < emailChangeReauthWindow
+}
+
+// setEmail moves the user matching filter from oldEmail to newEmail, along
+// with its memberships, pending invites and access tokens, in one transaction
+// so they never end up on different addresses.
+func setEmail(ctx context.Context, filter, update bson.M, oldEmail, newEmail string) error {
+	sess, err := utils.GetDefaultMongoClient().StartSession()
+	if err != nil {
+		return err
+	}
+
+	defer sess.EndSession(ctx)
+
+	_, err = sess.WithTransaction(ctx, func(sc mongo.SessionContext) (interface{}, error) {
+		return nil, moveEmail(sc, filter, update, oldEmail, newEmail)
+	})
+
+	return err
+}
+
+// moveEmail does the moves of setEmail. The user is moved with a single
+// conditional update, so a used link no longer matches, and the unique index
+// on users.email settles two changes racing to the same address.
+func moveEmail(ctx context.Context, filter, update bson.M, oldEmail, newEmail string) error {
+	res, err := utils.GetCollection(userCollection).UpdateOne(ctx, filter, update)
+	if mongo.IsDuplicateKeyError(err) {
+		return ErrEmailTaken
+	}
+
+	if err != nil {
+		return err
+	}
+
+	if res.ModifiedCount == 0 {
+		return ErrInvalidEmailToken
+	}
+
+	moves := []struct {
+		collection string
+		filter     bson.M
+	}{
+		{user.MemberCollectionName, bson.M{"email": oldEmail}},
+		{user.OrganizationsInvitesCollectionName, bson.M{"email": oldEmail, "has_accepted": bson.M{"$ne": true}}},
+		{accessTokenCollection, bson.M{"email": oldEmail}},
+	}
+
+	for _, m := range moves {
+		if _, err := utils.GetCollection(m.collection).UpdateMany(ctx, m.filter, bson.M{"$set": bson.M{"email": newEmail}}); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// changeEmail confirms the pending change of u. The confirm token is dropped
+// so the link works once, and the cancel link stays usable until undoUntil to
+// move the account back.
+func changeEmail(ctx context.Context, u *user.User, tokenHash string, undoUntil time.Time) error {
+	filter := userIDFilter(u.ID)
+	filter["email_change.token"] = tokenHash
+
+	now := time.Now()
+	update := bson.M{
+		"$set": bson.M{
+			"email":                     u.EmailChange.NewEmail,
+			"updated_at":                now,
+			"email_change.old_email":    u.Email,
+			"email_change.confirmed_at": now,
+			"email_change.expired_at":   undoUntil,
+		},
+		"$unset": bson.M{"email_change.token": ""},
+	}
+
+	return setEmail(ctx, filter, update, u.Email, u.EmailChange.NewEmail)
+}
+
+// revertEmail moves u back to the address it had before a confirmed change.
+func revertEmail(ctx context.Context, u *user.User, cancelHash string) error {
+	filter := userIDFilter(u.ID)
+	filter["email"] = u.Email
+	filter["email_change.cancel_token"] = cancelHash
+
+	update := bson.M{
+		"$set":   bson.M{"email": u.EmailChange.OldEmail, "updated_at": time.Now()},
+		"$unset": bson.M{"email_change": ""},
+	}
+
+	return setEmail(ctx, filter, update, u.Email, u.EmailChange.OldEmail)
+}
+
+// signOutEverywhere drops the user's magic links and sessions after its
+// address moved.
+func signOutEverywhere(ctx context.Context, u *user.User) {
+	if _, err := magicLinks().DeleteMany(ctx, bson.M{"email": u.Email}); err != nil {
+		log.Printf("could not drop magic links after email change: %v", err)
+	}
+
+	if err := RevokeUserSessions(u.ID); err != nil {
+		log.Printf("could not revoke sessions after email change: %v", err)
+	}
+}
+
+// ConfirmEmailChange finishes a change from the link sent to the new address
+// and signs the user out everywhere.
+func (au *AuthHandler) ConfirmEmailChange(w http.ResponseWriter, r *http.Request) {
+	w.Header().Add("content-type", "application/json")
+
+	body := struct {
+		Token string `json:"token" validate:"required"`
+	}{}
+
+	if err := utils.ParseJSONFromRequest(r, &body); err != nil {
+		utils.GetError(err, http.StatusUnprocessableEntity, w)
+		return
+	}
+
+	if err := validate.Struct(body); err != nil {
+		utils.GetError(err, http.StatusBadRequest, w)
+		return
+	}
+
+	u, err := findEmailChange("token", body.Token)
+	if err != nil {
+		utils.GetError(err, http.StatusBadRequest, w)
+		return
+	}
+
+	newEmail := u.EmailChange.NewEmail
+	undoUntil := time.Now().Add(time.Duration(au.configs.VerificationCodeTTL) * time.Second)
+
+	if err := changeEmail(r.Context(), u, utils.HashToken(body.Token), undoUntil); err != nil {
+		status := http.StatusInternalServerError
+		if errors.Is(err, ErrEmailTaken) || errors.Is(err, ErrInvalidEmailToken) {
+			status = http.StatusBadRequest
+		}
+
+		utils.GetError(err, status, w)
+
+		return
+	}
+
+	signOutEverywhere(r.Context(), u)
+
+	utils.GetSuccess("Email changed, sign in with "+newEmail, nil, w)
+}
+
+// CancelEmailChange drops a pending change from the link sent to the old
+// address. A change that was already confirmed is undone and the account
+// signed out everywhere, since whoever confirmed it may not be the owner.
+func (au *AuthHandler) CancelEmailChange(w http.ResponseWriter, r *http.Request) {
+	w.Header().Add("content-type", "application/json")
+
+	body := struct {
+		Token string `json:"token" validate:"required"`
+	}{}
+
+	if err := utils.ParseJSONFromRequest(r, &body); err != nil {
+		utils.GetError(err, http.StatusUnprocessableEntity, w)
+		return
+	}
+
+	if err := validate.Struct(body); err != nil {
+		utils.GetError(err, http.StatusBadRequest, w)
+		return
+	}
+
+	u, err := findEmailChange("cancel_token", body.Token)
+	if err != nil {
+		utils.GetError(err, http.StatusBadRequest, w)
+		return
+	}
+
+	if u.EmailChange.OldEmail != "" {
+		if err := revertEmail(r.Context(), u, utils.HashToken(body.Token)); err != nil {
+			status := http.StatusInternalServerError
+			if errors.Is(err, ErrEmailTaken) || errors.Is(err, ErrInvalidEmailToken) {
+				status = http.StatusBadRequest
+			}
+
+			utils.GetError(err, status, w)
+
+			return
+		}
+
+		signOutEverywhere(r.Context(), u)
+
+		utils.GetSuccess("Email change undone, sign in with "+u.EmailChange.OldEmail+" and change your password", nil, w)
+
+		return
+	}
+
+	update := bson.M{"$unset": bson.M{"email_change": ""}}
+	if _, err := utils.GetCollection(userCollection).UpdateOne(r.Context(), userIDFilter(u.ID), update); err != nil {
+		utils.GetError(err, http.StatusInternalServerError, w)
+		return
+	}
+
+	utils.GetSuccess("Email change cancelled", nil, w)
+}
@@ -35,22 +35,27 @@ var (
 	ErrAccessExpired       = errors.New("error fetching user info, access token expired, kindly login again")
 )
 
+// GetAuthToken returns a short lived access token for sess together with the
+// first refresh token of a new family.
 func (au *AuthHandler) GetAuthToken(u *user.User, sess *sessions.Session) (*Token, error) {
-	retoken := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
-		"session_name": Resptoken.SessionName,
-		"cookie":       Resptoken.Cookie,
-		"options":      Resptoken.Options,
-		"id":           Resptoken.ID,
-		"email":        u.Email,
-	})
+	return au.authToken(u, sess, "")
+}
+
+func (au *AuthHandler) authToken(u *user.User, sess *sessions.Session, familyID string) (*Token, error) {
+	tokenString, err := au.accessToken(u, sess)
+	if err != nil {
+		return nil, err
+	}
 
-	tokenString, err := retoken.SignedString([]byte(au.configs.HmacSampleSecret))
+	refreshToken, err := au.issueRefreshToken(u, sess.ID, familyID)
 	if err != nil {
 		return nil, err
 	}
 
 	resp := &Token{
-		SessionID: sess.ID,
+		SessionID:    sess.ID,
+		RefreshToken: refreshToken,
+		ExpiresIn:    au.configs.AccessTokenTTL,
 		User: UserResponse{
 			ID:        u.ID,
 			FirstName: u.FirstName,
@@ -67,6 +72,23 @@ func (au *AuthHandler) GetAuthToken(u *user.User, sess *sessions.Session) (*Toke
 	return resp, nil
 }
 
+// accessToken signs an access token for u bound to sess. The claims are taken
+// from sess itself, never from the last saved session, which another request
+// may have replaced.
+func (au *AuthHandler) accessToken(u *user.User, sess *sessions.Session) (string, error) {
+	now := time.Now()
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
+		"session_name": sess.Name(),
+		"options":      sess.Options,
+		"id":           sess.ID,
+		"email":        u.Email,
+		"iat":          now.Unix(),
+		"exp":          now.Add(time.Duration(au.configs.AccessTokenTTL) * time.Second).Unix(),
+	})
+
+	return token.SignedString([]byte(au.configs.HmacSampleSecret))
+}
+
 func (au *AuthHandler) LoginIn(response http.ResponseWriter, request *http.Request) {
 	response.Header().Add("content-type", "application/json")
 
@@ -81,51 +103,123 @@ func (au *AuthHandler) LoginIn(response http.ResponseWriter, request *http.Reque
 		return
 	}
 
-	vser, err := FetchUserByEmail(bson.M{"email": strings.ToLower(creds.Email)})
+	email := strings.ToLower(creds.Email)
+	keys := []attemptKey{accountKey("login", email), ipKey("login", request)}
+
+	counts, ok := takeAttempts(response, keys...)
+	if !ok {
+		LogAuthEvent(request, AuthEventLoginBlocked, email)
+		return
+	}
+
+	vser, err := FetchUserByEmail(bson.M{"email": email})
 	if err != nil {
+		au.authFailed(request, AuthEventLoginFailed, "login", email, nil, keys, counts)
 		utils.GetError(ErrUserNotFound, http.StatusBadRequest, response)
+
 		return
 	}
 	// check if user is verified
 	if !vser.IsVerified {
+		refundAttempts(keys...)
 		utils.GetError(ErrAccountConfirmError, http.StatusBadRequest, response)
 		return
 	}
 
 	// check password
 	if check := ComparePassword(creds.Password, vser.Password); !check {
+		au.authFailed(request, AuthEventLoginFailed, "login", email, vser, keys, counts)
 		utils.GetError(ErrInvalidCredentials, http.StatusBadRequest, response)
+
 		return
 	}
 
-	store := NewMongoStore(utils.GetCollection(sessionCollection), au.configs.SessionMaxAge, true, []byte(au.configs.SecretKey))
+	// an organization forced a reset, the password must change before a session starts
+	if vser.MustResetPassword {
+		au.sendForcedResetCode(request, vser)
+		attemptSucceeded(keys...)
+		utils.GetError(user.ErrPasswordResetIsRequired, http.StatusForbidden, response)
 
-	var session, e = store.Get(request, au.configs.SessionKey)
-	if e != nil {
-		msg := fmt.Errorf("%s", e.Error())
-		utils.GetError(msg, http.StatusBadRequest, response)
+		return
+	}
 
+	// users with 2FA get a challenge instead of a session
+	if vser.TwoFactor != nil && vser.TwoFactor.Enabled {
+		refundAttempts(keys...)
+		au.sendChallenge(response, vser, signIn{Method: SignInPassword})
 		return
 	}
 
-	// store session
-	session.Values["id"] = vser.ID
-	session.Values["email"] = vser.Email
+	resp, err := au.startSession(response, request, vser, signIn{Method: SignInPassword})
+	if err != nil {
+		utils.GetError(err, sessionErrorStatus(err), response)
+		return
+	}
 
-	if err = sessions.Save(request, response); err != nil {
-		fmt.Printf("Error saving session: %s", err)
+	attemptSucceeded(keys...)
+	LogAuthEvent(request, AuthEventLoginSucceeded, email)
+	utils.GetSuccess("login successful", resp, response)
+}
+
+// sendForcedResetCode mails u a reset code for a reset their organization
+// forced, unless one is still valid.
+func (au *AuthHandler) sendForcedResetCode(r *http.Request, u *user.User) {
+	if p := u.PasswordResets; p != nil && time.Now().Before(p.ExpiredAt) {
 		return
 	}
 
-	resp, err := au.GetAuthToken(vser, session)
-	log.Println(session)
+	if err := au.sendResetCode(r, u); err != nil {
+		log.Printf("could not send forced reset code: %v", err)
+	}
+}
+
+// sessionErrorStatus is the status a failed startSession is reported with.
+func sessionErrorStatus(err error) int {
+	if errors.Is(err, user.ErrPasswordResetIsRequired) {
+		return http.StatusForbidden
+	}
+
+	return http.StatusBadRequest
+}
 
+// startSession stores a new session for u and returns the auth token for it.
+// via records how the user signed in. No session starts while an
+// organization's forced password reset is pending, however the user signs in.
+func (au *AuthHandler) startSession(w http.ResponseWriter, r *http.Request, u *user.User, via signIn) (*Token, error) {
+	if u.MustResetPassword {
+		au.sendForcedResetCode(r, u)
+		return nil, user.ErrPasswordResetIsRequired
+	}
+
+	store := NewMongoStore(utils.GetCollection(sessionCollection), au.configs.SessionMaxAge, true, []byte(au.configs.SecretKey))
+
+	session, err := store.Get(r, au.configs.SessionKey)
 	if err != nil {
-		utils.GetError(err, http.StatusBadRequest, response)
-		return
+		return nil, err
 	}
 
-	utils.GetSuccess("login successful", resp, response)
+	// store session
+	session.Values["id"] = u.ID
+	session.Values["email"] = u.Email
+
+	// an existing cookie session may carry the provider of an earlier login
+	delete(session.Values, "idp")
+	delete(session.Values, "method")
+
+	if via.IdentityProvider != "" {
+		session.Values["idp"] = via.IdentityProvider
+	}
+
+	if via.Method != "" {
+		session.Values["method"] = via.Method
+	}
+
+	if err = sessions.Save(r, w); err != nil {
+		log.Printf("Error saving session: %s", err)
+		return nil, err
+	}
+
+	return au.GetAuthToken(u, session)
 }
 
 func (au *AuthHandler) LogOutUser(w http.ResponseWriter, r *http.Request) {
@@ -151,7 +245,7 @@ func (au *AuthHandler) LogOutUser(w http.ResponseWriter, r *http.Request) {
 	}
 
 	if status {
-		session, erro = NewS(store, sessData.Cookie, sessData.ID, sessData.Email, r, sessData.SessionName, sessData.Gothic)
+		session, erro = NewS(store, sessData.ID, sessData.Email, r, sessData.SessionName, sessData.Gothic)
 		if err != nil && erro != nil {
 			utils.GetError(ErrNotAuthorized, http.StatusUnauthorized, w)
 			return
@@ -172,6 +266,8 @@ func (au *AuthHandler) LogOutUser(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	revokeRefreshTokens(bson.M{"session_id": session.ID})
+
 	utils.GetSuccess("logout successful", map[string]interface{}{}, w)
 }
 
@@ -260,6 +356,9 @@ func (au *AuthHandler) LogOutOtherSessions(w http.ResponseWriter, r *http.Reques
 	utils.GetSuccess("successfully logged out of other sessions", nil, w)
 }
 
+// SocialAuth signs in with a provider access token passed in the URL.
+//
+// Deprecated: use the OIDC flow at /auth/oidc/{provider}/login instead.
 func (au *AuthHandler) SocialAuth(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Access-Control-Allow-Origin", "*")
 	w.Header().Set("Access-Control-Allow-Headers", "Content-Type,access-control-allow-origin, access-control-allow-headers")
@@ -372,6 +471,11 @@ func (au *AuthHandler) SocialAuth(w http.ResponseWriter, r *http.Request) {
 				return
 			}
 
+			if vser.TwoFactor != nil && vser.TwoFactor.Enabled {
+				au.sendChallenge(w, vser, signIn{})
+				return
+			}
+
 			session.Values["id"] = vser.ID
 			session.Values["email"] = vser.Email
 		}
@@ -0,0 +1,238 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"zuri.chat/zccore/service"
+	"zuri.chat/zccore/user"
+	"zuri.chat/zccore/utils"
+)
+
+const (
+	magicLinkPurpose    = "magic_link"
+	magicLinkCollection = "magic_links"
+)
+
+var (
+	ErrInvalidMagicLink  = errors.New("sign in link invalid, used or expired, request a new one")
+	ErrMagicLinkDisabled = errors.New("this organization does not allow signing in with email links")
+
+	magicLinkIndexOnce sync.Once
+)
+
+// magicLink is the single use record behind a signed link, keyed by the hash
+// of the token's id.
+type magicLink struct {
+	ID        string    `bson:"_id"`
+	Email     string    `bson:"email"`
+	IPAddress string    `bson:"ip_address"`
+	ExpiresAt time.Time `bson:"expires_at"`
+	CreatedAt time.Time `bson:"created_at"`
+}
+
+func magicLinks() *mongo.Collection {
+	coll := utils.GetCollection(magicLinkCollection)
+
+	magicLinkIndexOnce.Do(func() {
+		_, err := coll.Indexes().CreateMany(context.Background(), []mongo.IndexModel{
+			{Keys: bson.M{"email": 1, "created_at": -1}},
+			{Keys: bson.M{"expires_at": 1}, Options: options.Index().SetExpireAfterSeconds(0)},
+		})
+
+		if err != nil {
+			log.Printf("magic links: could not create indexes: %v", err)
+		}
+	})
+
+	return coll
+}
+
+// issueMagicLink signs a login token for u and records its id so it can only
+// be used once.
+func (au *AuthHandler) issueMagicLink(r *http.Request, u *user.User) (string, error) {
+	jti, err := utils.GenSecureToken(refreshTokenBytes)
+	if err != nil {
+		return "", err
+	}
+
+	now := time.Now()
+	expires := now.Add(time.Duration(au.configs.MagicLinkTTL) * time.Second)
+
+	token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
+		"purpose": magicLinkPurpose,
+		"email":   u.Email,
+		"jti":     jti,
+		"iat":     now.Unix(),
+		"exp":     expires.Unix(),
+	}).SignedString([]byte(au.configs.HmacSampleSecret))
+	if err != nil {
+		return "", err
+	}
+
+	link := magicLink{
+		ID:        utils.HashToken(jti),
+		Email:     u.Email,
+		IPAddress: utils.ClientIP(r),
+		ExpiresAt: expires,
+		CreatedAt: now,
+	}
+
+	if _, err := magicLinks().InsertOne(r.Context(), link); err != nil {
+		return "", err
+	}
+
+	return token, nil
+}
+
+// consumeMagicLink checks the signature of a link token and deletes its
+// record, so a second use fails. It returns the email the link was sent to.
+func (au *AuthHandler) consumeMagicLink(ctx context.Context, tokenString string) (string, error) {
+	parser := jwt.Parser{ValidMethods: []string{jwt.SigningMethodHS256.Alg()}}
+
+	token, err := parser.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
+		return []byte(au.configs.HmacSampleSecret), nil
+	})
+	if err != nil || !token.Valid {
+		return "", ErrInvalidMagicLink
+	}
+
+	claims, ok := token.Claims.(jwt.MapClaims)
+	if !ok || claims["purpose"] != magicLinkPurpose {
+		return "", ErrInvalidMagicLink
+	}
+
+	email, _ := claims["email"].(string)
+	jti, _ := claims["jti"].(string)
+
+	if email == "" || jti == "" {
+		return "", ErrInvalidMagicLink
+	}
+
+	res, err := magicLinks().DeleteOne(ctx, bson.M{"_id": utils.HashToken(jti), "email": email})
+	if err != nil {
+		return "", err
+	}
+
+	if res.DeletedCount == 0 {
+		return "", ErrInvalidMagicLink
+	}
+
+	return email, nil
+}
+
+// RequestMagicLink emails a single use sign in link. The response is the same
+// whether or not the account exists.
+func (au *AuthHandler) RequestMagicLink(w http.ResponseWriter, r *http.Request) {
+	w.Header().Add("content-type", "application/json")
+
+	body := struct {
+		Email string `json:"email" validate:"email,required"`
+	}{}
+
+	if err := utils.ParseJSONFromRequest(r, &body); err != nil {
+		utils.GetError(err, http.StatusUnprocessableEntity, w)
+		return
+	}
+
+	if err := validate.Struct(body); err != nil {
+		utils.GetError(err, http.StatusBadRequest, w)
+		return
+	}
+
+	const sent = "If an account exists for this email, a sign in link is on its way"
+
+	email := strings.ToLower(body.Email)
+
+	u, err := FetchUserByEmail(bson.M{"email": email})
+	if err != nil || u.Deactivated || !u.IsVerified {
+		utils.GetSuccess(sent, nil, w)
+		return
+	}
+
+	// one link per cooldown, so the endpoint cannot be used to flood an inbox
+	recent := bson.M{"email": u.Email, "created_at": bson.M{"$gt": time.Now().Add(-ResendCooldown)}}
+	if n, err := magicLinks().CountDocuments(r.Context(), recent); err != nil || n > 0 {
+		utils.GetSuccess(sent, nil, w)
+		return
+	}
+
+	token, err := au.issueMagicLink(r, u)
+	if err != nil {
+		utils.GetError(err, http.StatusInternalServerError, w)
+		return
+	}
+
+	msger := au.mailService.NewMail(
+		[]string{u.Email}, "Your Zuri Chat sign in link", service.MagicLink, map[string]interface{}{
+			"Username":  u.Email,
+			"MagicLink": au.configs.MagicLinkURL + "?token=" + url.QueryEscape(token),
+			"ExpiresIn": au.configs.MagicLinkTTL / 60,
+		})
+
+	if err := au.mailService.SendMail(msger); err != nil {
+		fmt.Printf("Error occurred while sending mail: %s", err.Error())
+	}
+
+	LogAuthEvent(r, AuthEventMagicLinkSent, u.Email)
+	utils.GetSuccess(sent, nil, w)
+}
+
+// VerifyMagicLink exchanges a link token for a session. It is a POST so that
+// mail scanners following the link cannot use it up.
+func (au *AuthHandler) VerifyMagicLink(w http.ResponseWriter, r *http.Request) {
+	w.Header().Add("content-type", "application/json")
+
+	body := struct {
+		Token string `json:"token" validate:"required"`
+	}{}
+
+	if err := utils.ParseJSONFromRequest(r, &body); err != nil {
+		utils.GetError(err, http.StatusUnprocessableEntity, w)
+		return
+	}
+
+	if err := validate.Struct(body); err != nil {
+		utils.GetError(err, http.StatusBadRequest, w)
+		return
+	}
+
+	email, err := au.consumeMagicLink(r.Context(), body.Token)
+	if err != nil {
+		utils.GetError(err, http.StatusUnauthorized, w)
+		return
+	}
+
+	u, err := FetchUserByEmail(bson.M{"email": email})
+	if err != nil || u.Deactivated {
+		utils.GetError(ErrInvalidMagicLink, http.StatusUnauthorized, w)
+		return
+	}
+
+	via := signIn{Method: SignInMagicLink}
+
+	if u.TwoFactor != nil && u.TwoFactor.Enabled {
+		au.sendChallenge(w, u, via)
+		return
+	}
+
+	resp, err := au.startSession(w, r, u, via)
+	if err != nil {
+		utils.GetError(err, sessionErrorStatus(err), w)
+		return
+	}
+
+	LogAuthEvent(r, AuthEventLoginSucceeded, u.Email)
+	utils.GetSuccess("login successful", resp, w)
+}
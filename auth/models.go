@@ -14,6 +14,7 @@ import (
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 	"golang.org/x/crypto/bcrypt"
+	"zuri.chat/zccore/oidc"
 	"zuri.chat/zccore/service"
 	"zuri.chat/zccore/user"
 	"zuri.chat/zccore/utils"
@@ -67,14 +68,42 @@ type Credentials struct {
 }
 
 type Token struct {
-	SessionID string       `json:"session_id"`
-	User      UserResponse `json:"user"`
+	SessionID    string       `json:"session_id"`
+	RefreshToken string       `json:"refresh_token"`
+	ExpiresIn    int          `json:"expires_in"`
+	User         UserResponse `json:"user"`
+}
+
+// Ways a session can be signed in, kept on the session so organizations can
+// rule some of them out.
+const (
+	SignInPassword  = "password"
+	SignInOIDC      = "oidc"
+	SignInMagicLink = "magic_link"
+)
+
+// signIn records how a session was started.
+type signIn struct {
+	Method           string
+	IdentityProvider string
 }
 
 //nolint:revive //CODEI8:
 type AuthUser struct {
 	ID    primitive.ObjectID `json:"id"`
 	Email string             `json:"email"`
+	// OIDC provider the session was signed in with, empty for passwords
+	IdentityProvider string `json:"identity_provider,omitempty"`
+	// how the session was signed in, see the SignIn constants
+	SignInMethod string `json:"sign_in_method,omitempty"`
+	// set when the request carried a personal access token instead of a session
+	TokenID string `json:"token_id,omitempty"`
+}
+
+// GetEmail returns the user's email. It lets packages that cannot import auth,
+// such as plugin, read the logged in user from a request's context.
+func (u *AuthUser) GetEmail() string {
+	return u.Email
 }
 
 type MyCustomClaims struct {
@@ -90,8 +119,9 @@ type VerifiedTokenResponse struct {
 
 //nolint:revive //CODEI8:
 type AuthHandler struct {
-	configs     *utils.Configurations
-	mailService service.MailService
+	configs       *utils.Configurations
+	mailService   service.MailService
+	oidcProviders map[string]*oidc.Provider
 }
 
 type UserKey string
@@ -115,6 +145,8 @@ func DeleteOtherSessions(userID, sessionID string) {
 	if err != nil {
 		fmt.Printf("%v", err)
 	}
+
+	revokeRefreshTokens(bson.M{"user_id": userID, "session_id": bson.M{"$ne": sessionID}})
 }
 
 func FetchUserByEmail(filter map[string]interface{}) (*user.User, error) {
@@ -171,6 +203,16 @@ func GetSessionDataFromToken(r *http.Request, hmacSampleSecret []byte) (status b
 	var retTokenD ResToken
 
 	if claims, ok := token.Claims.(jwt.MapClaims); ok && token.Valid {
+		// purpose bound tokens, such as 2FA challenges, are not sessions
+		if _, ok := claims["purpose"]; ok {
+			return false, ResToken{}, fmt.Errorf("failed")
+		}
+
+		// access tokens must expire, older tokens issued without exp are refused
+		if !claims.VerifyExpiresAt(time.Now().Unix(), true) {
+			return false, ResToken{}, fmt.Errorf("failed")
+		}
+
 		//nolint:errcheck //CODEI8:
 		mapstructure.Decode(claims, &retTokenD)
 		retTokenD.SessionName = fmt.Sprintf("%v", claims["session_name"])
@@ -198,5 +240,5 @@ func ClearSession(m *MongoStore, w http.ResponseWriter, session *sessions.Sessio
 }
 
 func NewAuthHandler(c *utils.Configurations, mail service.MailService) *AuthHandler {
-	return &AuthHandler{configs: c, mailService: mail}
+	return &AuthHandler{configs: c, mailService: mail, oidcProviders: newOIDCProviders(c.OIDCProviders)}
 }
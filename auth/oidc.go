@@ -0,0 +1,277 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"zuri.chat/zccore/oidc"
+	"zuri.chat/zccore/user"
+	"zuri.chat/zccore/utils"
+)
+
+const (
+	oidcStateCollection = "oidc_states"
+	oidcStateTTL        = 10 * time.Minute
+	oidcStateBytes      = 32
+)
+
+var (
+	ErrInvalidOIDCState       = errors.New("login request invalid or expired, start again")
+	ErrOIDCEmailUnverified    = errors.New("the identity provider did not return a verified email")
+	ErrIdentityProviderNeeded = errors.New("this organization requires signing in with its identity provider")
+	ErrOIDCAccountUnverified  = errors.New("an unverified account already uses this email, verify it and sign in with your password first")
+
+	oidcStateIndexOnce sync.Once
+)
+
+// oidcState is what the callback needs from the login that started the flow.
+// It is keyed by the hash of the state parameter.
+type oidcState struct {
+	ID           string    `bson:"_id"`
+	Provider     string    `bson:"provider"`
+	Nonce        string    `bson:"nonce"`
+	CodeVerifier string    `bson:"code_verifier"`
+	ExpiresAt    time.Time `bson:"expires_at"`
+}
+
+func newOIDCProviders(raw string) map[string]*oidc.Provider {
+	providers := make(map[string]*oidc.Provider)
+
+	configs, err := oidc.ParseConfigs(raw)
+	if err != nil {
+		log.Printf("OIDC_PROVIDERS ignored: %v", err)
+		return providers
+	}
+
+	for _, c := range configs {
+		providers[strings.ToLower(c.Name)] = oidc.NewProvider(c, nil)
+	}
+
+	return providers
+}
+
+func oidcStates() *mongo.Collection {
+	coll := utils.GetCollection(oidcStateCollection)
+
+	oidcStateIndexOnce.Do(func() {
+		indexModel := mongo.IndexModel{
+			Keys:    bson.M{"expires_at": 1},
+			Options: options.Index().SetExpireAfterSeconds(0),
+		}
+
+		if _, err := coll.Indexes().CreateOne(context.Background(), indexModel); err != nil {
+			log.Printf("oidc states: could not create ttl index: %v", err)
+		}
+	})
+
+	return coll
+}
+
+func (au *AuthHandler) oidcProvider(r *http.Request) (string, *oidc.Provider, error) {
+	name := strings.ToLower(mux.Vars(r)["provider"])
+
+	p, ok := au.oidcProviders[name]
+	if !ok {
+		return "", nil, oidc.ErrUnknownProvider
+	}
+
+	return name, p, nil
+}
+
+// OIDCLogin starts the authorization code flow. Browsers are redirected to the
+// provider, clients asking for JSON get the URL instead.
+func (au *AuthHandler) OIDCLogin(w http.ResponseWriter, r *http.Request) {
+	name, p, err := au.oidcProvider(r)
+	if err != nil {
+		utils.GetError(err, http.StatusNotFound, w)
+		return
+	}
+
+	state, err := oidc.RandomString(oidcStateBytes)
+	if err != nil {
+		utils.GetError(err, http.StatusInternalServerError, w)
+		return
+	}
+
+	nonce, err := oidc.RandomString(oidcStateBytes)
+	if err != nil {
+		utils.GetError(err, http.StatusInternalServerError, w)
+		return
+	}
+
+	verifier, challenge, err := oidc.NewPKCE()
+	if err != nil {
+		utils.GetError(err, http.StatusInternalServerError, w)
+		return
+	}
+
+	authURL, err := p.AuthCodeURL(r.Context(), state, nonce, challenge)
+	if err != nil {
+		utils.GetError(err, http.StatusBadGateway, w)
+		return
+	}
+
+	st := oidcState{
+		ID:           utils.HashToken(state),
+		Provider:     name,
+		Nonce:        nonce,
+		CodeVerifier: verifier,
+		ExpiresAt:    time.Now().Add(oidcStateTTL),
+	}
+
+	if _, err := oidcStates().InsertOne(r.Context(), st); err != nil {
+		utils.GetError(err, http.StatusInternalServerError, w)
+		return
+	}
+
+	if strings.Contains(r.Header.Get("Accept"), "application/json") {
+		w.Header().Add("content-type", "application/json")
+		utils.GetSuccess("redirect to the identity provider", map[string]interface{}{"authorization_url": authURL}, w)
+
+		return
+	}
+
+	http.Redirect(w, r, authURL, http.StatusFound)
+}
+
+// OIDCCallback finishes the flow: the state is consumed, the code exchanged
+// with the PKCE verifier and the ID token verified before a session starts.
+func (au *AuthHandler) OIDCCallback(w http.ResponseWriter, r *http.Request) {
+	w.Header().Add("content-type", "application/json")
+
+	name, p, err := au.oidcProvider(r)
+	if err != nil {
+		utils.GetError(err, http.StatusNotFound, w)
+		return
+	}
+
+	q := r.URL.Query()
+	if e := q.Get("error"); e != "" {
+		utils.GetError(fmt.Errorf("identity provider returned %s: %s", e, q.Get("error_description")), http.StatusBadRequest, w)
+		return
+	}
+
+	state, code := q.Get("state"), q.Get("code")
+	if state == "" || code == "" {
+		utils.GetError(ErrInvalidOIDCState, http.StatusBadRequest, w)
+		return
+	}
+
+	var st oidcState
+
+	filter := bson.M{"_id": utils.HashToken(state), "provider": name}
+	if err := oidcStates().FindOneAndDelete(r.Context(), filter).Decode(&st); err != nil || time.Now().After(st.ExpiresAt) {
+		utils.GetError(ErrInvalidOIDCState, http.StatusBadRequest, w)
+		return
+	}
+
+	tokens, err := p.Exchange(r.Context(), code, st.CodeVerifier)
+	if err != nil {
+		utils.GetError(err, http.StatusBadGateway, w)
+		return
+	}
+
+	claims, err := p.VerifyIDToken(r.Context(), tokens.IDToken, st.Nonce)
+	if err != nil {
+		utils.GetError(err, http.StatusUnauthorized, w)
+		return
+	}
+
+	if claims.Email == "" || !claims.EmailVerified {
+		utils.GetError(ErrOIDCEmailUnverified, http.StatusBadRequest, w)
+		return
+	}
+
+	u, err := findOrCreateOIDCUser(name, claims)
+	if errors.Is(err, ErrOIDCAccountUnverified) {
+		utils.GetError(err, http.StatusConflict, w)
+		return
+	}
+
+	if err != nil {
+		utils.GetError(err, http.StatusInternalServerError, w)
+		return
+	}
+
+	if u.Deactivated {
+		utils.GetError(ErrAccessDenied, http.StatusForbidden, w)
+		return
+	}
+
+	if u.TwoFactor != nil && u.TwoFactor.Enabled {
+		au.sendChallenge(w, u, signIn{Method: SignInOIDC, IdentityProvider: name})
+		return
+	}
+
+	resp, err := au.startSession(w, r, u, signIn{Method: SignInOIDC, IdentityProvider: name})
+	if err != nil {
+		utils.GetError(err, sessionErrorStatus(err), w)
+		return
+	}
+
+	LogAuthEvent(r, AuthEventLoginSucceeded, u.Email)
+	utils.GetSuccess("login successful", resp, w)
+}
+
+// findOrCreateOIDCUser matches the provider's subject first, then the verified
+// email, and creates a verified account when neither exists. Accounts whose
+// email was never verified are not linked, whoever registered them may not
+// own the address.
+func findOrCreateOIDCUser(provider string, c *oidc.Claims) (*user.User, error) {
+	email := strings.ToLower(c.Email)
+
+	u, err := FetchUserByEmail(bson.M{"social.provider": provider, "social.provider_id": c.Subject})
+	if err == nil {
+		return u, nil
+	}
+
+	social := &user.Social{ID: c.Subject, Provider: provider}
+
+	u, err = FetchUserByEmail(bson.M{"email": email})
+	if err == nil {
+		if !u.IsVerified {
+			return nil, ErrOIDCAccountUnverified
+		}
+
+		if u.Social == nil {
+			update := bson.M{"$set": bson.M{"social": social}}
+			if _, err := utils.GetCollection(userCollection).UpdateOne(context.Background(), userIDFilter(u.ID), update); err != nil {
+				return nil, err
+			}
+		}
+
+		return u, nil
+	}
+
+	firstName, lastName := c.GivenName, c.FamilyName
+	if firstName == "" && lastName == "" {
+		firstName = c.Name
+	}
+
+	b := &user.User{
+		FirstName:  firstName,
+		LastName:   lastName,
+		Email:      email,
+		IsVerified: true,
+		Social:     social,
+		Timezone:   "Africa/Lagos", // set default timezone
+		CreatedAt:  time.Now(),
+	}
+
+	detail, _ := utils.StructToMap(b)
+	if _, err := utils.CreateMongoDBDoc(userCollection, detail); err != nil {
+		return nil, err
+	}
+
+	return FetchUserByEmail(bson.M{"email": email})
+}
@@ -0,0 +1,380 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"zuri.chat/zccore/user"
+	"zuri.chat/zccore/utils"
+)
+
+// RoleCollectionName holds the custom roles organizations define.
+const RoleCollectionName = "organization_roles"
+
+// GroupCollectionName holds the member groups organizations define. The roles
+// given to a group add to the role of each of its members.
+const GroupCollectionName = "organization_groups"
+
+// Permissions routes can require. Built in roles grant a fixed set, custom
+// roles any combination.
+const (
+	PermMembersInvite  = "members.invite"
+	PermMembersManage  = "members.manage"
+	PermRolesManage    = "roles.manage"
+	PermPluginsInstall = "plugins.install"
+	PermPluginsManage  = "plugins.manage"
+	PermBillingManage  = "billing.manage"
+	PermSettingsEdit   = "settings.edit"
+	PermAuditRead      = "audit.read"
+	PermDataExport     = "data.export"
+	PermSCIMManage     = "scim.manage"
+	PermGroupsManage   = "groups.manage"
+	PermOrgDelete      = "organization.delete"
+	PermOrgTransfer    = "organization.transfer"
+)
+
+// Permissions lists every permission a role may be given.
+var Permissions = []string{
+	PermMembersInvite,
+	PermMembersManage,
+	PermRolesManage,
+	PermPluginsInstall,
+	PermPluginsManage,
+	PermBillingManage,
+	PermSettingsEdit,
+	PermAuditRead,
+	PermDataExport,
+	PermSCIMManage,
+	PermGroupsManage,
+	PermOrgDelete,
+	PermOrgTransfer,
+}
+
+var (
+	ErrPermissionDenied = errors.New("you do not have permission to do this")
+	ErrNotMember        = errors.New("you are not a member of this organization")
+	ErrSignedOutOfOrg   = errors.New("you were signed out of this organization, sign in again")
+
+	builtinRolePermissions = map[string][]string{
+		"owner": Permissions,
+		"admin": {
+			PermMembersInvite, PermMembersManage, PermRolesManage, PermGroupsManage, PermPluginsInstall,
+			PermPluginsManage, PermBillingManage, PermSettingsEdit, PermAuditRead, PermDataExport, PermSCIMManage, PermOrgDelete,
+		},
+		"editor": nil,
+		"member": nil,
+		"guest":  nil,
+	}
+
+	// roleRanks orders the built in roles for IsAuthorized.
+	roleRanks = map[string]int{"owner": 4, "admin": 3, "member": 2, "guest": 1}
+)
+
+// Role is a named set of permissions. Built in roles are not stored.
+type Role struct {
+	ID          primitive.ObjectID `bson:"_id,omitempty" json:"id,omitempty"`
+	OrgID       string             `bson:"org_id" json:"org_id,omitempty"`
+	Name        string             `bson:"name" json:"name"`
+	Description string             `bson:"description" json:"description"`
+	Permissions []string           `bson:"permissions" json:"permissions"`
+	Builtin     bool               `bson:"-" json:"builtin"`
+	CreatedAt   time.Time          `bson:"created_at" json:"created_at,omitempty"`
+	UpdatedAt   time.Time          `bson:"updated_at" json:"updated_at,omitempty"`
+}
+
+// Has reports whether the role grants perm.
+func (r *Role) Has(perm string) bool {
+	for _, p := range r.Permissions {
+		if p == perm {
+			return true
+		}
+	}
+
+	return false
+}
+
+// IsBuiltinRole reports whether name is one of the roles every organization has.
+func IsBuiltinRole(name string) bool {
+	_, ok := builtinRolePermissions[strings.ToLower(name)]
+	return ok
+}
+
+// BuiltinRoles returns the roles every organization has.
+func BuiltinRoles() []Role {
+	names := []string{"owner", "admin", "editor", "member", "guest"}
+	roles := make([]Role, len(names))
+
+	for i, name := range names {
+		roles[i] = Role{Name: name, Permissions: append([]string{}, builtinRolePermissions[name]...), Builtin: true}
+	}
+
+	return roles
+}
+
+// ValidPermission reports whether perm is a known permission.
+func ValidPermission(perm string) bool {
+	for _, p := range Permissions {
+		if p == perm {
+			return true
+		}
+	}
+
+	return false
+}
+
+// roleRank places a role on the owner > admin > member > guest ladder. Custom
+// roles rank as members, whatever permissions they carry.
+func roleRank(role string) int {
+	if rank, ok := roleRanks[role]; ok {
+		return rank
+	}
+
+	if IsBuiltinRole(role) {
+		return 0
+	}
+
+	return roleRanks["member"]
+}
+
+// FetchRole returns the built in or custom role called name in orgID.
+func FetchRole(orgID, name string) (*Role, error) {
+	name = strings.ToLower(name)
+
+	if perms, ok := builtinRolePermissions[name]; ok {
+		return &Role{Name: name, Permissions: perms, Builtin: true}, nil
+	}
+
+	var role Role
+
+	filter := bson.M{"org_id": orgID, "name": name}
+	if err := utils.GetCollection(RoleCollectionName).FindOne(context.Background(), filter).Decode(&role); err != nil {
+		return nil, err
+	}
+
+	return &role, nil
+}
+
+// RoleHasPermission reports whether the role called name in orgID grants perm.
+func RoleHasPermission(orgID, name, perm string) bool {
+	role, err := FetchRole(orgID, name)
+	return err == nil && role.Has(perm)
+}
+
+// mergeRoles returns base with the permissions of extra added.
+func mergeRoles(base *Role, extra []*Role) *Role {
+	merged := *base
+	merged.Permissions = append([]string{}, base.Permissions...)
+
+	for _, role := range extra {
+		for _, p := range role.Permissions {
+			if !merged.Has(p) {
+				merged.Permissions = append(merged.Permissions, p)
+			}
+		}
+	}
+
+	return &merged
+}
+
+// EffectiveRole returns the member's role called name, with the permissions
+// of the roles given to the member's groups added.
+func EffectiveRole(orgID, memberID, name string) (*Role, error) {
+	role, err := FetchRole(orgID, name)
+	if err != nil {
+		return nil, err
+	}
+
+	filter := bson.M{"org_id": orgID, "members": memberID, "roles.0": bson.M{"$exists": true}}
+
+	cursor, err := utils.GetCollection(GroupCollectionName).Find(context.Background(), filter)
+	if err != nil {
+		return nil, err
+	}
+
+	var groups []struct {
+		Roles []string `bson:"roles"`
+	}
+	if err := cursor.All(context.Background(), &groups); err != nil {
+		return nil, err
+	}
+
+	var extra []*Role
+
+	for _, g := range groups {
+		for _, name := range g.Roles {
+			// a group role deleted since it was given grants nothing
+			if groupRole, err := FetchRole(orgID, name); err == nil {
+				extra = append(extra, groupRole)
+			}
+		}
+	}
+
+	return mergeRoles(role, extra), nil
+}
+
+// MemberHasPermission reports whether a member holding the role called name
+// in orgID is granted perm, by that role or by one of their groups.
+func MemberHasPermission(orgID, memberID, name, perm string) bool {
+	role, err := EffectiveRole(orgID, memberID, name)
+	return err == nil && role.Has(perm)
+}
+
+// orgMember is the caller's membership, as the permission middleware sees it.
+type orgMember struct {
+	ID    primitive.ObjectID `bson:"_id"`
+	Email string             `bson:"email"`
+	Role  string             `bson:"role"`
+}
+
+// memberAccess resolves the logged in user and their membership of the {id}
+// organization, and applies the organization's sign in policy. It writes the
+// error response itself and returns ok false when the request must stop.
+func (au *AuthHandler) memberAccess(w http.ResponseWriter, r *http.Request) (u *user.User, m *orgMember, ok bool) {
+	loggedIn, _ := r.Context().Value("user").(*AuthUser)
+	if loggedIn == nil {
+		utils.GetError(ErrNotAuthorized, http.StatusUnauthorized, w)
+		return nil, nil, false
+	}
+
+	u, err := FetchUserByEmail(bson.M{"email": strings.ToLower(loggedIn.Email)})
+	if err != nil {
+		utils.GetError(ErrUserNotFound, http.StatusBadRequest, w)
+		return nil, nil, false
+	}
+
+	orgID := mux.Vars(r)["id"]
+	m = &orgMember{}
+
+	filter := bson.M{"org_id": orgID, "email": u.Email, "deleted": bson.M{"$ne": true}}
+	if err := utils.GetCollection("members").FindOne(r.Context(), filter).Decode(m); err != nil {
+		utils.GetError(ErrNotMember, http.StatusForbidden, w)
+		return nil, nil, false
+	}
+
+	if status, err := enforceOrgPolicy(orgID, loggedIn, u); err != nil {
+		utils.GetError(err, status, w)
+		return nil, nil, false
+	}
+
+	return u, m, true
+}
+
+// enforceOrgPolicy applies an organization's sign in requirements to the
+// session behind loggedIn.
+func enforceOrgPolicy(orgID string, loggedIn *AuthUser, u *user.User) (int, error) {
+	if signedOutOfOrg(orgID, loggedIn, u.Email) {
+		return http.StatusUnauthorized, ErrSignedOutOfOrg
+	}
+
+	policy := fetchOrgAuthPolicy(orgID)
+
+	if policy.RequiredIdentityProvider != "" && !strings.EqualFold(policy.RequiredIdentityProvider, loggedIn.IdentityProvider) {
+		return http.StatusForbidden, ErrIdentityProviderNeeded
+	}
+
+	if loggedIn.SignInMethod == SignInMagicLink && !policy.MagicLinkEnabled() {
+		return http.StatusForbidden, ErrMagicLinkDisabled
+	}
+
+	if (u.TwoFactor == nil || !u.TwoFactor.Enabled) && policy.TwoFactorEnabled() {
+		return http.StatusForbidden, ErrTwoFactorRequired
+	}
+
+	return 0, nil
+}
+
+// signedOutOfOrg reports whether the member was signed out of orgID after the
+// session or access token behind loggedIn was issued. Both have object ids, so
+// their creation time comes from the id.
+func signedOutOfOrg(orgID string, loggedIn *AuthUser, email string) bool {
+	if loggedIn == nil {
+		return false
+	}
+
+	var m struct {
+		SessionsRevokedAt time.Time `bson:"sessions_revoked_at"`
+	}
+
+	opts := options.FindOne().SetProjection(bson.M{"sessions_revoked_at": 1})
+	//nolint:errcheck //CODEI8: membership is checked by the callers
+	utils.GetCollection("members").FindOne(context.Background(), bson.M{"org_id": orgID, "email": email}, opts).Decode(&m)
+
+	return !m.SessionsRevokedAt.IsZero() && !loggedIn.ID.Timestamp().After(m.SessionsRevokedAt)
+}
+
+func withMemberUser(r *http.Request, u *user.User) *http.Request {
+	loggedIn, _ := r.Context().Value("user").(*AuthUser)
+	uid, _ := primitive.ObjectIDFromHex(u.ID)
+
+	member := &AuthUser{
+		ID:               uid,
+		Email:            loggedIn.Email,
+		IdentityProvider: loggedIn.IdentityProvider,
+		SignInMethod:     loggedIn.SignInMethod,
+		TokenID:          loggedIn.TokenID,
+	}
+
+	//nolint:staticcheck //CODEI8: lint ignore
+	return r.WithContext(context.WithValue(r.Context(), UserContext, member))
+}
+
+// RequirePermission lets the request through only when the caller's role in
+// the {id} organization, or one of their groups, grants perm.
+func (au *AuthHandler) RequirePermission(nextHandler http.HandlerFunc, perm string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+
+		u, m, ok := au.memberAccess(w, r)
+		if !ok {
+			return
+		}
+
+		if !MemberHasPermission(mux.Vars(r)["id"], m.ID.Hex(), m.Role, perm) {
+			utils.GetError(ErrPermissionDenied, http.StatusForbidden, w)
+			return
+		}
+
+		nextHandler.ServeHTTP(w, withMemberUser(r, u))
+	}
+}
+
+// SelfOrPermission guards /members/{mem_id} routes: members may act on their
+// own record, anyone else needs perm.
+func (au *AuthHandler) SelfOrPermission(nextHandler http.HandlerFunc, perm string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+
+		u, m, ok := au.memberAccess(w, r)
+		if !ok {
+			return
+		}
+
+		if m.ID.Hex() != mux.Vars(r)["mem_id"] && !MemberHasPermission(mux.Vars(r)["id"], m.ID.Hex(), m.Role, perm) {
+			utils.GetError(ErrPermissionDenied, http.StatusForbidden, w)
+			return
+		}
+
+		nextHandler.ServeHTTP(w, withMemberUser(r, u))
+	}
+}
+
+// OrgSession guards {id} organization routes open to any logged in user: a
+// member signed out of the organization is refused until they sign in again.
+func (au *AuthHandler) OrgSession(nextHandler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		loggedIn, _ := r.Context().Value("user").(*AuthUser)
+		if loggedIn != nil && signedOutOfOrg(mux.Vars(r)["id"], loggedIn, strings.ToLower(loggedIn.Email)) {
+			utils.GetError(ErrSignedOutOfOrg, http.StatusUnauthorized, w)
+			return
+		}
+
+		nextHandler.ServeHTTP(w, r)
+	}
+}
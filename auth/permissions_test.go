@@ -0,0 +1,71 @@
+package auth
+
+import "testing"
+
+func TestBuiltinRolePermissions(t *testing.T) {
+	tests := []struct {
+		role string
+		perm string
+		want bool
+	}{
+		{"owner", PermOrgTransfer, true},
+		{"admin", PermOrgTransfer, false},
+		{"admin", PermBillingManage, true},
+		{"member", PermMembersInvite, false},
+		{"guest", PermSettingsEdit, false},
+	}
+
+	for _, tt := range tests {
+		role, err := FetchRole("org", tt.role)
+		if err != nil {
+			t.Fatalf("FetchRole(%q) error: %v", tt.role, err)
+		}
+
+		if got := role.Has(tt.perm); got != tt.want {
+			t.Errorf("%s has %s = %v, want %v", tt.role, tt.perm, got, tt.want)
+		}
+	}
+}
+
+func TestRoleRank(t *testing.T) {
+	if roleRank("owner") <= roleRank("admin") || roleRank("admin") <= roleRank("member") || roleRank("member") <= roleRank("guest") {
+		t.Error("built in roles are out of order")
+	}
+
+	if got := roleRank("billing-clerk"); got != roleRank("member") {
+		t.Errorf("custom role rank = %d, want member rank", got)
+	}
+}
+
+func TestValidPermission(t *testing.T) {
+	if !ValidPermission(PermPluginsInstall) {
+		t.Error("plugins.install should be valid")
+	}
+
+	if ValidPermission("plugins.everything") {
+		t.Error("plugins.everything should not be valid")
+	}
+}
+
+func TestMergeRoles(t *testing.T) {
+	base := &Role{Name: "member", Permissions: []string{PermMembersInvite}}
+	extra := []*Role{
+		{Name: "designers", Permissions: []string{PermPluginsInstall, PermMembersInvite}},
+		{Name: "billing", Permissions: []string{PermBillingManage}},
+	}
+
+	merged := mergeRoles(base, extra)
+	if merged.Name != "member" || len(merged.Permissions) != 3 {
+		t.Errorf("mergeRoles() = %+v, want member with 3 permissions", merged)
+	}
+
+	for _, perm := range []string{PermMembersInvite, PermPluginsInstall, PermBillingManage} {
+		if !merged.Has(perm) {
+			t.Errorf("merged role is missing %s", perm)
+		}
+	}
+
+	if len(base.Permissions) != 1 {
+		t.Errorf("mergeRoles() changed the base role: %v", base.Permissions)
+	}
+}
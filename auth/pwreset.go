@@ -2,102 +2,270 @@ package auth
 
 import (
 	"context"
+	"crypto/subtle"
 	"errors"
 	"fmt"
+	"log"
 	"net/http"
 	"strings"
 	"time"
 
 	"github.com/gorilla/mux"
 	"go.mongodb.org/mongo-driver/bson"
-	"go.mongodb.org/mongo-driver/bson/primitive"
-	"golang.org/x/crypto/bcrypt"
+	"go.mongodb.org/mongo-driver/mongo"
 	"zuri.chat/zccore/service"
 	"zuri.chat/zccore/user"
 	"zuri.chat/zccore/utils"
 )
 
 var (
-	DefaultHashCode     = 14
-	GenNumberLength     = 6
+	ResendCooldown      = time.Minute
 	ErrConfirmationCode = errors.New("Account confirmation code used or expired, confirm and try again")
 	ErrResetCode        = errors.New("Invalid password reset code, already used or expired, confirm and try again")
+	ErrAlreadyVerified  = errors.New("account already verified, you can login")
 )
 
-func (au *AuthHandler) validateCode(r *http.Request, column string, errMsg error, vcode string) (*user.User, error) {
-	c := struct {
-		Code string `json:"code" validate:"required"`
-	}{}
+type codeRequest struct {
+	Email string `json:"email" validate:"required,email"`
+	Code  string `json:"code" validate:"required"`
+}
 
-	var filter primitive.M
+// storedCode is what checkCode needs from a verification or reset record.
+type storedCode struct {
+	Token     string
+	Attempts  int
+	ExpiredAt time.Time
+}
 
-	if vcode == "" {
-		if err := utils.ParseJSONFromRequest(r, &c); err != nil {
-			return nil, err
-		}
+// checkCode matches code against the hash stored under field for u. Every
+// guess takes one of the code's attempts before it is compared, in a single
+// update, so parallel guesses can't get past the limit. The code is dropped
+// once it expires or runs out of attempts.
+func (au *AuthHandler) checkCode(u *user.User, field string, stored *storedCode, code string, errMsg error) error {
+	if stored == nil || stored.Token == "" {
+		return errMsg
+	}
 
-		if err := validate.Struct(c); err != nil {
-			return nil, err
+	ctx := context.Background()
+	coll := utils.GetCollection(userCollection)
+
+	filter := userIDFilter(u.ID)
+	filter[field+".token"] = stored.Token
+	filter[field+".attempts"] = bson.M{"$lt": au.configs.CodeMaxAttempts}
+	filter[field+".expired_at"] = bson.M{"$gt": time.Now()}
+
+	err := coll.FindOneAndUpdate(ctx, filter, bson.M{"$inc": bson.M{field + ".attempts": 1}}).Err()
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		drop := userIDFilter(u.ID)
+		drop[field+".token"] = stored.Token
+
+		if _, err := coll.UpdateOne(ctx, drop, bson.M{"$set": bson.M{field: nil}}); err != nil {
+			log.Printf("could not drop %s: %v", field, err)
 		}
 
-		filter = bson.M{column: c.Code}
-	} else {
-		filter = bson.M{column: vcode}
+		return errMsg
 	}
 
-	u, err := FetchUserByEmail(filter)
 	if err != nil {
-		return nil, errMsg
+		return err
+	}
+
+	hash := utils.HashCode(code, au.configs.SecretKey)
+	if subtle.ConstantTimeCompare([]byte(hash), []byte(stored.Token)) == 1 {
+		return nil
 	}
 
-	return u, nil
+	return errMsg
+}
+
+// consumeCode clears the code checkCode accepted and applies set in the same
+// update. Only one request can consume a given code.
+func (au *AuthHandler) consumeCode(u *user.User, field, code string, set bson.M) error {
+	filter := userIDFilter(u.ID)
+	filter[field+".token"] = utils.HashCode(code, au.configs.SecretKey)
+	set[field] = nil
+
+	res, err := utils.GetCollection(userCollection).UpdateOne(context.Background(), filter, bson.M{"$set": set})
+	if err != nil {
+		return err
+	}
+
+	if res.ModifiedCount == 0 {
+		return ErrResetCode
+	}
+
+	return nil
+}
+
+func verificationCode(u *user.User) *storedCode {
+	if u.EmailVerification == nil {
+		return nil
+	}
+
+	v := u.EmailVerification
+
+	return &storedCode{Token: v.Token, Attempts: v.Attempts, ExpiredAt: v.ExpiredAt}
+}
+
+func resetCode(u *user.User) *storedCode {
+	if u.PasswordResets == nil {
+		return nil
+	}
+
+	p := u.PasswordResets
+
+	return &storedCode{Token: p.Token, Attempts: p.Attempts, ExpiredAt: p.ExpiredAt}
+}
+
+func parseCodeRequest(r *http.Request) (*codeRequest, error) {
+	var c codeRequest
+
+	if err := utils.ParseJSONFromRequest(r, &c); err != nil {
+		return nil, err
+	}
+
+	if err := validate.Struct(c); err != nil {
+		return nil, err
+	}
+
+	c.Email = strings.ToLower(c.Email)
+
+	return &c, nil
 }
 
 func (au *AuthHandler) VerifyAccount(w http.ResponseWriter, r *http.Request) {
 	w.Header().Add("content-type", "application/json")
 
-	u, err := au.validateCode(r, "email_verification.token", ErrConfirmationCode, "")
+	c, err := parseCodeRequest(r)
+	if err != nil {
+		utils.GetError(err, http.StatusBadRequest, w)
+		return
+	}
+
+	u, err := FetchUserByEmail(bson.M{"email": c.Email})
 	if err != nil {
+		utils.GetError(ErrConfirmationCode, http.StatusBadRequest, w)
+		return
+	}
+
+	if u.IsVerified {
+		utils.GetError(ErrAlreadyVerified, http.StatusBadRequest, w)
+		return
+	}
+
+	if err := au.checkCode(u, "email_verification", verificationCode(u), c.Code, ErrConfirmationCode); err != nil {
 		utils.GetError(err, http.StatusBadRequest, w)
 		return
 	}
 
 	// set email_verification null
 	// update isverified to true
-	id, _ := primitive.ObjectIDFromHex(u.ID)
-	update := bson.M{"$set": bson.M{"email_verification": nil, "isverified": true}}
+	if err := au.consumeCode(u, "email_verification", c.Code, bson.M{"isverified": true}); err != nil {
+		utils.GetError(ErrConfirmationCode, http.StatusBadRequest, w)
+		return
+	}
+
+	utils.GetSuccess("Email verified, you can now login", nil, w)
+}
+
+// ResendVerification sends a new account confirmation code, replacing the old one.
+func (au *AuthHandler) ResendVerification(w http.ResponseWriter, r *http.Request) {
+	w.Header().Add("content-type", "application/json")
+
+	email := struct {
+		Email string `json:"email" validate:"email,required"`
+	}{}
+
+	if err := utils.ParseJSONFromRequest(r, &email); err != nil {
+		utils.GetError(err, http.StatusUnprocessableEntity, w)
+		return
+	}
+
+	if err := validate.Struct(email); err != nil {
+		utils.GetError(err, http.StatusBadRequest, w)
+		return
+	}
+
+	u, err := FetchUserByEmail(bson.M{"email": strings.ToLower(email.Email)})
+	if err != nil {
+		utils.GetError(ErrUserNotFound, http.StatusBadRequest, w)
+		return
+	}
 
-	if _, err := utils.GetCollection(userCollection).UpdateByID(
-		context.Background(),
-		id, update); err != nil {
+	if u.IsVerified {
+		utils.GetError(ErrAlreadyVerified, http.StatusBadRequest, w)
+		return
+	}
+
+	if v := u.EmailVerification; v != nil && time.Since(v.CreatedAt) < ResendCooldown {
+		utils.GetSuccess("Verification code already sent, check your email", nil, w)
+		return
+	}
+
+	code, verification, err := user.NewEmailVerification(au.configs.SecretKey, time.Duration(au.configs.VerificationCodeTTL)*time.Second)
+	if err != nil {
 		utils.GetError(err, http.StatusInternalServerError, w)
 		return
 	}
 
-	utils.GetSuccess("Email verified, you can now login", nil, w)
+	update := bson.M{"$set": bson.M{"email_verification": verification}}
+	if _, err := utils.GetCollection(userCollection).UpdateOne(context.Background(), userIDFilter(u.ID), update); err != nil {
+		utils.GetError(err, http.StatusInternalServerError, w)
+		return
+	}
+
+	msger := au.mailService.NewMail(
+		[]string{u.Email}, "Account Confirmation", service.MailConfirmation, map[string]interface{}{
+			"Username": u.Email,
+			"Code":     code,
+		})
+
+	if err := au.mailService.SendMail(msger); err != nil {
+		fmt.Printf("Error occurred while sending mail: %s", err.Error())
+	}
+
+	utils.GetSuccess("Verification code sent", nil, w)
 }
 
 func (au *AuthHandler) VerifyPasswordResetCode(w http.ResponseWriter, r *http.Request) {
 	w.Header().Add("content-type", "application/json")
 
-	u, err := au.validateCode(r, "password_resets.token", ErrResetCode, "")
+	c, err := parseCodeRequest(r)
 	if err != nil {
 		utils.GetError(err, http.StatusBadRequest, w)
 		return
 	}
 
-	resp := map[string]interface{}{
-		"isverified":        true,
-		"verification_code": u.PasswordResets.Token,
+	keys := []attemptKey{accountKey("reset", c.Email), ipKey("reset", r)}
+	counts, ok := takeAttempts(w, keys...)
+	if !ok {
+		return
+	}
+
+	u, err := FetchUserByEmail(bson.M{"email": c.Email})
+	if err != nil {
+		au.authFailed(r, AuthEventResetCodeFailed, "reset", c.Email, nil, keys, counts)
+		utils.GetError(ErrResetCode, http.StatusBadRequest, w)
+
+		return
+	}
+
+	if err := au.checkCode(u, "password_resets", resetCode(u), c.Code, ErrResetCode); err != nil {
+		au.authFailed(r, AuthEventResetCodeFailed, "reset", c.Email, u, keys, counts)
+		utils.GetError(err, http.StatusBadRequest, w)
+
+		return
 	}
 
-	utils.GetSuccess("Password reset code valid", resp, w)
+	refundAttempts(keys...)
+	utils.GetSuccess("Password reset code valid", map[string]interface{}{"isverified": true}, w)
 }
 
 func (au *AuthHandler) UpdatePassword(w http.ResponseWriter, r *http.Request) {
 	w.Header().Add("content-type", "application/json")
 
 	rBody := struct {
+		Email           string `json:"email" validate:"required,email"`
 		Password        string `json:"password" validate:"required,min=6"`
 		ConfirmPassword string `json:"confirm_password" validate:"required"`
 	}{}
@@ -105,12 +273,6 @@ func (au *AuthHandler) UpdatePassword(w http.ResponseWriter, r *http.Request) {
 	params := mux.Vars(r)
 	verificationToken := params["verification_code"]
 
-	u, err := au.validateCode(r, "password_resets.token", ErrResetCode, verificationToken)
-	if err != nil {
-		utils.GetError(err, http.StatusBadRequest, w)
-		return
-	}
-
 	if e := utils.ParseJSONFromRequest(r, &rBody); e != nil {
 		utils.GetError(e, http.StatusUnprocessableEntity, w)
 		return
@@ -126,22 +288,70 @@ func (au *AuthHandler) UpdatePassword(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	email := strings.ToLower(rBody.Email)
+
+	keys := []attemptKey{accountKey("reset", email), ipKey("reset", r)}
+	counts, ok := takeAttempts(w, keys...)
+	if !ok {
+		return
+	}
+
+	u, err := FetchUserByEmail(bson.M{"email": email})
+	if err != nil {
+		au.authFailed(r, AuthEventResetCodeFailed, "reset", email, nil, keys, counts)
+		utils.GetError(ErrResetCode, http.StatusBadRequest, w)
+
+		return
+	}
+
+	if err := au.checkCode(u, "password_resets", resetCode(u), verificationToken, ErrResetCode); err != nil {
+		au.authFailed(r, AuthEventResetCodeFailed, "reset", email, u, keys, counts)
+		utils.GetError(err, http.StatusBadRequest, w)
+
+		return
+	}
+
+	policy := user.PasswordPolicyFor(au.configs, u.Email)
+	if err := policy.Check(rBody.Password); err != nil {
+		refundAttempts(keys...)
+		utils.GetError(err, http.StatusBadRequest, w)
+
+		return
+	}
+
+	if user.PasswordReused(rBody.Password, u.Password, u.PasswordHistory, policy.History) {
+		refundAttempts(keys...)
+		utils.GetError(user.ErrPasswordReused, http.StatusBadRequest, w)
+
+		return
+	}
+
 	// update password & delete passwordreset object
-	bytes, err := bcrypt.GenerateFromPassword([]byte(rBody.Password), DefaultHashCode)
+	hash, err := user.GetHash(rBody.Password, au.configs.BcryptCost)
 	if err != nil {
 		utils.GetError(err, http.StatusInternalServerError, w)
 		return
 	}
 
-	id, _ := primitive.ObjectIDFromHex(u.ID)
-	filter := bson.M{"_id": id}
-	update := bson.M{"$set": bson.M{"password_resets": nil, "password": string(bytes)}}
+	set := bson.M{
+		"password":            hash,
+		"password_history":    user.PushPasswordHistory(u.PasswordHistory, u.Password, policy.History),
+		"password_changed_at": time.Now(),
+		"must_reset_password": false,
+	}
 
-	if _, err := utils.GetCollection(userCollection).UpdateOne(context.Background(), filter, update); err != nil {
-		utils.GetError(err, http.StatusInternalServerError, w)
+	if err := au.consumeCode(u, "password_resets", verificationToken, set); err != nil {
+		utils.GetError(err, http.StatusBadRequest, w)
 		return
 	}
 
+	attemptSucceeded(keys...)
+
+	// whoever knew the old password is signed out
+	if err := RevokeUserSessions(u.ID); err != nil {
+		log.Printf("could not revoke sessions after password reset: %v", err)
+	}
+
 	utils.GetSuccess("Password update successful", nil, w)
 }
 
@@ -169,45 +379,48 @@ func (au *AuthHandler) RequestResetPasswordCode(w http.ResponseWriter, r *http.R
 		return
 	}
 
-	// check if user already requested for password
-	if u.PasswordResets != nil {
-		x2x := map[string]interface{}{"password_reset_code": u.PasswordResets.Token}
-		utils.GetSuccess("Password reset code already sent, check your email", x2x, w)
+	// check if user just requested for password
+	if p := u.PasswordResets; p != nil && time.Now().Before(p.ExpiredAt) && time.Since(p.CreatedAt) < ResendCooldown {
+		utils.GetSuccess("Password reset code already sent, check your email", nil, w)
+		return
+	}
 
+	if err := au.sendResetCode(r, u); err != nil {
+		utils.GetError(err, http.StatusInternalServerError, w)
 		return
 	}
 
-	// Update user collection with UserPasswordReset - WIP
-	_, token := utils.RandomGen(GenNumberLength, "d")
+	utils.GetSuccess("Password reset code sent", nil, w)
+}
 
-	userPasswordReset := map[string]interface{}{
-		"ip_address": strings.Split(r.RemoteAddr, ":")[0],
-		"token":      token,
-		"expired_at": time.Now(),
-		"updated_at": time.Now(),
-		"created_at": time.Now(),
+// sendResetCode stores a new password reset code for u and emails it.
+func (au *AuthHandler) sendResetCode(r *http.Request, u *user.User) error {
+	code, userPasswordReset, err := user.NewPasswordReset(
+		au.configs.SecretKey,
+		time.Duration(au.configs.ResetCodeTTL)*time.Second,
+		utils.ClientIP(r),
+	)
+	if err != nil {
+		return err
 	}
 
-	id, _ := primitive.ObjectIDFromHex(u.ID)
-	filter := bson.M{"_id": id}
 	update := bson.M{"$set": bson.M{"password_resets": userPasswordReset}}
 
 	// update db;
-	if _, err := utils.GetCollection(userCollection).UpdateOne(context.Background(), filter, update); err != nil {
-		utils.GetError(err, http.StatusInternalServerError, w)
-		return
+	if _, err := utils.GetCollection(userCollection).UpdateOne(context.Background(), userIDFilter(u.ID), update); err != nil {
+		return err
 	}
 
 	msger := au.mailService.NewMail(
 		[]string{u.Email},
 		"Reset Password Code", service.PasswordReset, map[string]interface{}{
 			"Username": u.Email,
-			"Code":     userPasswordReset["token"].(string),
+			"Code":     code,
 		})
 
 	if err := au.mailService.SendMail(msger); err != nil {
 		fmt.Printf("Error occurred while sending mail: %s", err.Error())
 	}
 
-	utils.GetSuccess("Password reset code sent", nil, w)
+	return nil
 }
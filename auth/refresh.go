@@ -0,0 +1,270 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/sessions"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"zuri.chat/zccore/user"
+	"zuri.chat/zccore/utils"
+)
+
+const (
+	refreshTokenCollection = "refresh_tokens"
+	refreshTokenBytes      = 32
+)
+
+var (
+	ErrInvalidRefreshToken = errors.New("refresh token invalid or expired, kindly login again")
+	ErrRefreshTokenReused  = errors.New("refresh token already used, all sessions from this login were signed out")
+
+	refreshIndexOnce sync.Once
+)
+
+// RefreshToken is one link of a rotation chain. Every login starts a new
+// family and each refresh marks the presented token used and issues the next
+// one, so a token presented twice means it leaked.
+type RefreshToken struct {
+	ID        primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	FamilyID  string             `bson:"family_id" json:"family_id"`
+	TokenHash string             `bson:"token_hash" json:"-"`
+	UserID    string             `bson:"user_id" json:"user_id"`
+	Email     string             `bson:"email" json:"email"`
+	SessionID string             `bson:"session_id" json:"session_id"`
+	Used      bool               `bson:"used" json:"used"`
+	Revoked   bool               `bson:"revoked" json:"revoked"`
+	ExpiresAt time.Time          `bson:"expires_at" json:"expires_at"`
+	CreatedAt time.Time          `bson:"created_at" json:"created_at"`
+}
+
+func ensureRefreshTokenIndexes(coll *mongo.Collection) {
+	refreshIndexOnce.Do(func() {
+		_, err := coll.Indexes().CreateMany(context.Background(), []mongo.IndexModel{
+			{Keys: bson.M{"token_hash": 1}, Options: options.Index().SetUnique(true)},
+			{Keys: bson.M{"family_id": 1}},
+			{Keys: bson.M{"expires_at": 1}, Options: options.Index().SetExpireAfterSeconds(0)},
+		})
+
+		if err != nil {
+			log.Printf("refresh tokens: could not create indexes: %v", err)
+		}
+	})
+}
+
+// issueRefreshToken stores the hash of a new refresh token for sessionID. An
+// empty familyID starts a new family.
+func (au *AuthHandler) issueRefreshToken(u *user.User, sessionID, familyID string) (string, error) {
+	token, err := utils.GenSecureToken(refreshTokenBytes)
+	if err != nil {
+		return "", err
+	}
+
+	if familyID == "" {
+		familyID = utils.GenUUID()
+	}
+
+	coll := utils.GetCollection(refreshTokenCollection)
+	ensureRefreshTokenIndexes(coll)
+
+	now := time.Now()
+	rt := RefreshToken{
+		FamilyID:  familyID,
+		TokenHash: utils.HashToken(token),
+		UserID:    u.ID,
+		Email:     u.Email,
+		SessionID: sessionID,
+		ExpiresAt: now.Add(time.Duration(au.configs.RefreshTokenTTL) * time.Second),
+		CreatedAt: now,
+	}
+
+	if _, err := coll.InsertOne(context.Background(), rt); err != nil {
+		return "", err
+	}
+
+	return token, nil
+}
+
+func findRefreshToken(token string) (*RefreshToken, error) {
+	var rt RefreshToken
+
+	filter := bson.M{"token_hash": utils.HashToken(token)}
+	if err := utils.GetCollection(refreshTokenCollection).FindOne(context.Background(), filter).Decode(&rt); err != nil {
+		return nil, ErrInvalidRefreshToken
+	}
+
+	return &rt, nil
+}
+
+// deleteSessions removes the given sessions from the session store.
+func deleteSessions(ctx context.Context, sessionIDs []interface{}) error {
+	ids := make([]primitive.ObjectID, 0, len(sessionIDs))
+
+	for _, sid := range sessionIDs {
+		s, _ := sid.(string)
+		if id, err := primitive.ObjectIDFromHex(s); err == nil {
+			ids = append(ids, id)
+		}
+	}
+
+	if len(ids) == 0 {
+		return nil
+	}
+
+	_, err := utils.GetCollection(sessionCollection).DeleteMany(ctx, bson.M{"_id": bson.M{"$in": ids}})
+
+	return err
+}
+
+// RevokeRefreshFamily revokes every token of a family and deletes every
+// session those tokens were issued for.
+func RevokeRefreshFamily(familyID string) error {
+	ctx := context.Background()
+	coll := utils.GetCollection(refreshTokenCollection)
+
+	sessionIDs, err := coll.Distinct(ctx, "session_id", bson.M{"family_id": familyID})
+	if err != nil {
+		return err
+	}
+
+	if _, err := coll.UpdateMany(ctx, bson.M{"family_id": familyID}, bson.M{"$set": bson.M{"revoked": true}}); err != nil {
+		return err
+	}
+
+	return deleteSessions(ctx, sessionIDs)
+}
+
+// revokeRefreshTokens revokes the refresh tokens matching filter, e.g. those of
+// a session that logged out.
+func revokeRefreshTokens(filter bson.M) {
+	update := bson.M{"$set": bson.M{"revoked": true}}
+	if _, err := utils.GetCollection(refreshTokenCollection).UpdateMany(context.Background(), filter, update); err != nil {
+		log.Printf("refresh tokens: could not revoke: %v", err)
+	}
+}
+
+// RefreshAccessToken exchanges a refresh token for a new access and refresh
+// token pair.
+func (au *AuthHandler) RefreshAccessToken(w http.ResponseWriter, r *http.Request) {
+	w.Header().Add("content-type", "application/json")
+
+	var body struct {
+		RefreshToken string `json:"refresh_token" validate:"required"`
+	}
+
+	if err := utils.ParseJSONFromRequest(r, &body); err != nil {
+		utils.GetError(err, http.StatusUnprocessableEntity, w)
+		return
+	}
+
+	if err := validate.Struct(body); err != nil {
+		utils.GetError(err, http.StatusBadRequest, w)
+		return
+	}
+
+	rt, err := findRefreshToken(body.RefreshToken)
+	if err != nil || rt.Revoked || time.Now().After(rt.ExpiresAt) {
+		utils.GetError(ErrInvalidRefreshToken, http.StatusUnauthorized, w)
+		return
+	}
+
+	// claim the token, only one request can ever do this
+	filter := bson.M{"_id": rt.ID, "used": false, "revoked": false}
+	update := bson.M{"$set": bson.M{"used": true}}
+
+	res, err := utils.GetCollection(refreshTokenCollection).UpdateOne(r.Context(), filter, update)
+	if err != nil {
+		utils.GetError(err, http.StatusInternalServerError, w)
+		return
+	}
+
+	if res.ModifiedCount == 0 {
+		if err := RevokeRefreshFamily(rt.FamilyID); err != nil {
+			log.Printf("refresh tokens: could not revoke family %s: %v", rt.FamilyID, err)
+		}
+
+		utils.GetError(ErrRefreshTokenReused, http.StatusUnauthorized, w)
+
+		return
+	}
+
+	u, err := FetchUserByEmail(bson.M{"email": strings.ToLower(rt.Email)})
+	if err != nil || u.Deactivated {
+		utils.GetError(ErrInvalidRefreshToken, http.StatusUnauthorized, w)
+		return
+	}
+
+	store := NewMongoStore(utils.GetCollection(sessionCollection), au.configs.SessionMaxAge, true, []byte(au.configs.SecretKey))
+
+	// the session must still exist, a logout ends the family too
+	session := sessions.NewSession(store, au.configs.SessionKey)
+	session.ID = rt.SessionID
+	session.Options = &sessions.Options{
+		Path:     store.Options.Path,
+		Domain:   store.Options.Domain,
+		MaxAge:   store.Options.MaxAge,
+		Secure:   store.Options.Secure,
+		HttpOnly: store.Options.HttpOnly,
+	}
+
+	if err := store.load(session); err != nil {
+		revokeRefreshTokens(bson.M{"family_id": rt.FamilyID})
+		utils.GetError(ErrInvalidRefreshToken, http.StatusUnauthorized, w)
+
+		return
+	}
+
+	if err := store.Save(r, w, session); err != nil {
+		utils.GetError(err, http.StatusInternalServerError, w)
+		return
+	}
+
+	resp, err := au.authToken(u, session, rt.FamilyID)
+	if err != nil {
+		utils.GetError(err, http.StatusInternalServerError, w)
+		return
+	}
+
+	utils.GetSuccess("token refreshed", resp, w)
+}
+
+// RevokeRefreshToken signs out every session that descends from the login the
+// given refresh token belongs to.
+func (au *AuthHandler) RevokeRefreshToken(w http.ResponseWriter, r *http.Request) {
+	w.Header().Add("content-type", "application/json")
+
+	var body struct {
+		RefreshToken string `json:"refresh_token" validate:"required"`
+	}
+
+	if err := utils.ParseJSONFromRequest(r, &body); err != nil {
+		utils.GetError(err, http.StatusUnprocessableEntity, w)
+		return
+	}
+
+	if err := validate.Struct(body); err != nil {
+		utils.GetError(err, http.StatusBadRequest, w)
+		return
+	}
+
+	rt, err := findRefreshToken(body.RefreshToken)
+	if err != nil {
+		utils.GetError(err, http.StatusBadRequest, w)
+		return
+	}
+
+	if err := RevokeRefreshFamily(rt.FamilyID); err != nil {
+		utils.GetError(err, http.StatusInternalServerError, w)
+		return
+	}
+
+	utils.GetSuccess("refresh token revoked", nil, w)
+}
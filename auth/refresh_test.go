@@ -0,0 +1,71 @@
+package auth
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt"
+	"github.com/gorilla/sessions"
+	"zuri.chat/zccore/user"
+	"zuri.chat/zccore/utils"
+)
+
+func TestGetSessionDataFromTokenExpiry(t *testing.T) {
+	secret := []byte("test-secret")
+	now := time.Now()
+
+	tests := []struct {
+		name   string
+		claims jwt.MapClaims
+		want   bool
+	}{
+		{"live", jwt.MapClaims{"id": "sess", "email": "ada@zuri.chat", "exp": now.Add(time.Minute).Unix()}, true},
+		{"expired", jwt.MapClaims{"id": "sess", "email": "ada@zuri.chat", "exp": now.Add(-time.Minute).Unix()}, false},
+		{"without exp", jwt.MapClaims{"id": "sess", "email": "ada@zuri.chat"}, false},
+		{"purpose bound", jwt.MapClaims{"id": "sess", "purpose": "2fa", "exp": now.Add(time.Minute).Unix()}, false},
+	}
+
+	for _, tt := range tests {
+		token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, tt.claims).SignedString(secret)
+		if err != nil {
+			t.Fatalf("%s: could not sign token: %v", tt.name, err)
+		}
+
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		r.Header.Set("Authorization", "Bearer "+token)
+
+		got, data, _ := GetSessionDataFromToken(r, secret)
+		if got != tt.want {
+			t.Errorf("%s: GetSessionDataFromToken() = %v, want %v", tt.name, got, tt.want)
+		}
+
+		if got && data.ID != "sess" {
+			t.Errorf("%s: session id = %q, want sess", tt.name, data.ID)
+		}
+	}
+}
+
+func TestAccessTokenNamesItsSession(t *testing.T) {
+	h := &AuthHandler{configs: &utils.Configurations{HmacSampleSecret: "test-secret", AccessTokenTTL: 60}}
+
+	sess := sessions.NewSession(nil, "f6822af94e29ba112be310d3af45d5c7")
+	sess.ID = "ada-session"
+
+	// the last session saved belongs to someone else.
+	Resptoken = ResToken{ID: "grace-session", SessionName: "other"}
+
+	token, err := h.accessToken(&user.User{Email: "ada@zuri.chat"}, sess)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.Header.Set("Authorization", "Bearer "+token)
+
+	ok, data, _ := GetSessionDataFromToken(r, []byte("test-secret"))
+	if !ok || data.ID != sess.ID || data.SessionName != sess.Name() {
+		t.Errorf("GetSessionDataFromToken() = %v, %q, %q, want true, %q, %q", ok, data.ID, data.SessionName, sess.ID, sess.Name())
+	}
+}
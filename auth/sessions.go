@@ -0,0 +1,132 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"zuri.chat/zccore/utils"
+)
+
+// how stale last_seen may get before a request refreshes it.
+const lastSeenInterval = time.Minute
+
+var ErrSessionNotFound = errors.New("session not found")
+
+type SessionResponse struct {
+	ID        string    `json:"id"`
+	UserAgent string    `json:"user_agent"`
+	IPAddress string    `json:"ip_address"`
+	CreatedAt time.Time `json:"created_at"`
+	LastSeen  time.Time `json:"last_seen"`
+	Current   bool      `json:"current"`
+}
+
+// touchSession records activity on a session, writing at most once per lastSeenInterval.
+func touchSession(id primitive.ObjectID) {
+	now := time.Now()
+	filter := bson.M{"_id": id, "$or": []bson.M{
+		{"last_seen": bson.M{"$lt": now.Add(-lastSeenInterval)}},
+		{"last_seen": bson.M{"$exists": false}},
+	}}
+
+	//nolint:errcheck //CODEI8: best effort, the session is already authenticated
+	utils.GetCollection(sessionCollection).UpdateOne(context.Background(), filter, bson.M{"$set": bson.M{"last_seen": now}})
+}
+
+// RevokeUserSessions signs a user out everywhere: every session is deleted and
+// every refresh token revoked.
+func RevokeUserSessions(userID string) error {
+	uid, err := primitive.ObjectIDFromHex(userID)
+	if err != nil {
+		return err
+	}
+
+	if _, err := utils.DeleteManyMongoDBDoc(sessionCollection, bson.M{"user_id": uid}); err != nil {
+		return err
+	}
+
+	revokeRefreshTokens(bson.M{"user_id": userID})
+
+	return nil
+}
+
+// ListSessions returns the logged in user's active sessions, newest activity first.
+func (au *AuthHandler) ListSessions(w http.ResponseWriter, r *http.Request) {
+	loggedIn, _ := r.Context().Value("user").(*AuthUser)
+
+	u, err := au.loggedInUser(r)
+	if err != nil {
+		utils.GetError(err, http.StatusBadRequest, w)
+		return
+	}
+
+	uid, _ := primitive.ObjectIDFromHex(u.ID)
+	opts := options.Find().SetSort(bson.M{"last_seen": -1}).SetProjection(bson.M{"data": 0})
+
+	cursor, err := utils.GetCollection(sessionCollection).Find(r.Context(), bson.M{"user_id": uid}, opts)
+	if err != nil {
+		utils.GetError(err, http.StatusInternalServerError, w)
+		return
+	}
+
+	var sessions []Session
+	if err := cursor.All(r.Context(), &sessions); err != nil {
+		utils.GetError(err, http.StatusInternalServerError, w)
+		return
+	}
+
+	resp := make([]SessionResponse, len(sessions))
+
+	for i, s := range sessions {
+		resp[i] = SessionResponse{
+			ID:        s.ID.Hex(),
+			UserAgent: s.UserAgent,
+			IPAddress: s.IPAddress,
+			CreatedAt: s.CreatedAt,
+			LastSeen:  s.LastSeen,
+			Current:   loggedIn != nil && s.ID == loggedIn.ID,
+		}
+	}
+
+	utils.GetSuccess("sessions retrieved successfully", resp, w)
+}
+
+// RevokeSession signs the logged in user out of one of their sessions.
+func (au *AuthHandler) RevokeSession(w http.ResponseWriter, r *http.Request) {
+	u, err := au.loggedInUser(r)
+	if err != nil {
+		utils.GetError(err, http.StatusBadRequest, w)
+		return
+	}
+
+	sessionID := mux.Vars(r)["session_id"]
+
+	sid, err := primitive.ObjectIDFromHex(sessionID)
+	if err != nil {
+		utils.GetError(ErrorInvalid, http.StatusBadRequest, w)
+		return
+	}
+
+	uid, _ := primitive.ObjectIDFromHex(u.ID)
+
+	res, err := utils.DeleteManyMongoDBDoc(sessionCollection, bson.M{"_id": sid, "user_id": uid})
+	if err != nil {
+		utils.GetError(err, http.StatusInternalServerError, w)
+		return
+	}
+
+	if res.DeletedCount == 0 {
+		utils.GetError(ErrSessionNotFound, http.StatusNotFound, w)
+		return
+	}
+
+	revokeRefreshTokens(bson.M{"session_id": sessionID})
+
+	utils.GetSuccess("session revoked", nil, w)
+}
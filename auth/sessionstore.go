@@ -3,7 +3,9 @@ package auth
 import (
 	"context"
 	"errors"
+	"log"
 	"net/http"
+	"sync"
 	"time"
 
 	"github.com/gorilla/securecookie"
@@ -13,18 +15,25 @@ import (
 	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
+	"zuri.chat/zccore/utils"
 )
 
 var (
 	ErrorInvalid = errors.New("zuri core session: invalid session id")
 	Resptoken    ResToken
+
+	sessionTTLOnce sync.Once
 )
 
 type Session struct {
-	ID       primitive.ObjectID `bson:"_id,omitempty"`
-	UserID   primitive.ObjectID `bson:"user_id,omitempty" json:"user_id,omitempty"`
-	Data     string
-	Modified time.Time
+	ID        primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	UserID    primitive.ObjectID `bson:"user_id,omitempty" json:"user_id,omitempty"`
+	Data      string             `json:"-"`
+	Modified  time.Time          `json:"modified"`
+	UserAgent string             `bson:"user_agent,omitempty" json:"user_agent"`
+	IPAddress string             `bson:"ip_address,omitempty" json:"ip_address"`
+	CreatedAt time.Time          `bson:"created_at,omitempty" json:"created_at"`
+	LastSeen  time.Time          `bson:"last_seen,omitempty" json:"last_seen"`
 }
 
 type ResToken struct {
@@ -59,15 +68,19 @@ func NewMongoStore(c *mongo.Collection, maxAge int, ensureTTL bool, keyPairs ...
 
 	store.MaxAge(maxAge)
 
-	// if ensureTTL {
-	// 	x := (time.Duration(maxAge) * time.Second)
+	// a store is built per request, the index only needs creating once
+	if ensureTTL && maxAge > 0 {
+		sessionTTLOnce.Do(func() {
+			indexModel := mongo.IndexModel{
+				Keys:    bson.M{"modified": 1},
+				Options: options.Index().SetExpireAfterSeconds(int32(maxAge)),
+			}
 
-	// 	indexModel := mongo.IndexModel{
-	// 		Keys: bson.M{"modified": 1},
-	// 		Options: options.Index().SetExpireAfterSeconds(int32(x.Seconds())),
-	// 	}
-	// 	c.Indexes().CreateOne(context.Background(), indexModel)
-	// }
+			if _, err := c.Indexes().CreateOne(context.Background(), indexModel); err != nil {
+				log.Printf("zuri core session: could not create ttl index: %v", err)
+			}
+		})
+	}
 
 	return store
 }
@@ -104,7 +117,10 @@ func (m *MongoStore) New(r *http.Request, name string) (*sessions.Session, error
 	return session, err
 }
 
-func NewS(m *MongoStore, cookie, id, email string, r *http.Request, name string, gothic interface{}) (*sessions.Session, error) {
+// NewS loads the session an access token names. The token carries the session
+// ID, never the session cookie, so a token cannot be turned into a cookie that
+// outlives it.
+func NewS(m *MongoStore, id, email string, r *http.Request, name string, gothic interface{}) (*sessions.Session, error) {
 	session := sessions.NewSession(m, name)
 	session.Options = &sessions.Options{
 		Path:     m.Options.Path,
@@ -115,20 +131,18 @@ func NewS(m *MongoStore, cookie, id, email string, r *http.Request, name string,
 	}
 
 	session.IsNew = true
-	err := securecookie.DecodeMulti(name, cookie, &session.ID, m.Codecs...)
+	session.ID = id
 
-	if err == nil {
-		if gothic != nil {
-			session.Values["gothic"] = gothic
-		} else {
-			session.Values["id"] = id
-			session.Values["email"] = email
-		}
+	if gothic != nil {
+		session.Values["gothic"] = gothic
+	} else {
+		session.Values["id"] = id
+		session.Values["email"] = email
+	}
 
-		errb := m.load(session)
-		if errb == nil {
-			session.IsNew = false
-		}
+	err := m.load(session)
+	if err == nil {
+		session.IsNew = false
 	}
 
 	return session, err
@@ -139,7 +153,7 @@ func (m *MongoStore) Save(r *http.Request, w http.ResponseWriter, session *sessi
 		session.ID = primitive.NewObjectID().Hex()
 	}
 
-	if err := m.upsert(session); err != nil {
+	if err := m.upsert(r, session); err != nil {
 		return err
 	}
 
@@ -190,7 +204,7 @@ func (m *MongoStore) load(session *sessions.Session) error {
 	return nil
 }
 
-func (m *MongoStore) upsert(session *sessions.Session) error {
+func (m *MongoStore) upsert(r *http.Request, session *sessions.Session) error {
 	ctx := context.Background()
 
 	objID, err := primitive.ObjectIDFromHex(session.ID)
@@ -223,15 +237,21 @@ func (m *MongoStore) upsert(session *sessions.Session) error {
 	}
 
 	encoded, _ := securecookie.EncodeMulti(session.Name(), session.Values, m.Codecs...)
-	s := Session{
-		ID:       objID,
-		UserID:   userID,
-		Data:     encoded,
-		Modified: modified,
-	}
 	opts := options.Update().SetUpsert(true)
-	filter := bson.M{"_id": s.ID}
-	updateData := bson.M{"$set": s}
+	filter := bson.M{"_id": objID}
+	set := bson.M{
+		"data":       encoded,
+		"modified":   modified,
+		"last_seen":  time.Now(),
+		"user_agent": r.UserAgent(),
+		"ip_address": utils.ClientIP(r),
+	}
+
+	if !userID.IsZero() {
+		set["user_id"] = userID
+	}
+
+	updateData := bson.M{"$set": set, "$setOnInsert": bson.M{"created_at": time.Now()}}
 
 	if _, err = m.coll.UpdateOne(ctx, filter, updateData, opts); err != nil {
 		return err
@@ -254,4 +274,4 @@ func (m *MongoStore) delete(session *sessions.Session) error {
 	}
 
 	return nil
-}
\ No newline at end of file
+}
@@ -0,0 +1,301 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"zuri.chat/zccore/user"
+	"zuri.chat/zccore/utils"
+)
+
+const (
+	accessTokenCollection = "personal_access_tokens"
+	// PersonalAccessTokenPrefix marks a bearer token as a personal access
+	// token rather than a session JWT.
+	PersonalAccessTokenPrefix = "zcp_"
+	accessTokenBytes          = 32
+
+	ScopeRead  = "read"
+	ScopeWrite = "write"
+
+	defaultTokenLifetimeDays = 90
+	maxTokenLifetimeDays     = 365
+	maxTokensPerUser         = 50
+)
+
+var (
+	ErrInvalidAccessToken  = errors.New("personal access token invalid, expired or revoked")
+	ErrTokenScope          = errors.New("personal access token does not have the write scope")
+	ErrTokenNotFound       = errors.New("personal access token not found")
+	ErrTooManyTokens       = errors.New("personal access token limit reached, revoke an unused token first")
+	ErrSessionRequired     = errors.New("this action needs a signed in session, personal access tokens cannot be used")
+	ErrInvalidTokenScope   = errors.New("scopes must be read or write")
+	ErrTokenLifetimeBounds = errors.New("expires_in_days must be between 1 and 365")
+
+	accessTokenIndexOnce sync.Once
+)
+
+// PersonalAccessToken lets scripts call the API as a user without their
+// password. Only the hash of the token is kept.
+type PersonalAccessToken struct {
+	ID               primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	Name             string             `bson:"name" json:"name"`
+	UserID           string             `bson:"user_id" json:"user_id"`
+	Email            string             `bson:"email" json:"-"`
+	TokenHash        string             `bson:"token_hash" json:"-"`
+	Prefix           string             `bson:"prefix" json:"prefix"`
+	Scopes           []string           `bson:"scopes" json:"scopes"`
+	IdentityProvider string             `bson:"identity_provider,omitempty" json:"-"`
+	SignInMethod     string             `bson:"sign_in_method,omitempty" json:"-"`
+	ExpiresAt        time.Time          `bson:"expires_at" json:"expires_at"`
+	LastUsedAt       *time.Time         `bson:"last_used_at,omitempty" json:"last_used_at"`
+	LastUsedIP       string             `bson:"last_used_ip,omitempty" json:"last_used_ip,omitempty"`
+	CreatedAt        time.Time          `bson:"created_at" json:"created_at"`
+}
+
+func (t *PersonalAccessToken) hasScope(scope string) bool {
+	for _, s := range t.Scopes {
+		if s == scope {
+			return true
+		}
+	}
+
+	return false
+}
+
+func accessTokens() *mongo.Collection {
+	coll := utils.GetCollection(accessTokenCollection)
+
+	accessTokenIndexOnce.Do(func() {
+		_, err := coll.Indexes().CreateMany(context.Background(), []mongo.IndexModel{
+			{Keys: bson.M{"token_hash": 1}, Options: options.Index().SetUnique(true)},
+			{Keys: bson.M{"user_id": 1}},
+			{Keys: bson.M{"expires_at": 1}, Options: options.Index().SetExpireAfterSeconds(0)},
+		})
+
+		if err != nil {
+			log.Printf("personal access tokens: could not create indexes: %v", err)
+		}
+	})
+
+	return coll
+}
+
+// bearerAccessToken returns the personal access token on r, if it carries one.
+func bearerAccessToken(r *http.Request) (string, bool) {
+	token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+
+	return token, strings.HasPrefix(token, PersonalAccessTokenPrefix)
+}
+
+// authenticateAccessToken looks up a personal access token and records its
+// use, writing at most once per lastSeenInterval.
+func authenticateAccessToken(r *http.Request, token string) (*PersonalAccessToken, error) {
+	var pat PersonalAccessToken
+
+	coll := accessTokens()
+	filter := bson.M{"token_hash": utils.HashToken(token)}
+
+	if err := coll.FindOne(r.Context(), filter).Decode(&pat); err != nil || time.Now().After(pat.ExpiresAt) {
+		return nil, ErrInvalidAccessToken
+	}
+
+	u, err := FetchUserByEmail(bson.M{"email": pat.Email})
+	if err != nil || u.Deactivated {
+		return nil, ErrInvalidAccessToken
+	}
+
+	if u.MustResetPassword {
+		return nil, user.ErrPasswordResetIsRequired
+	}
+
+	now := time.Now()
+	if pat.LastUsedAt == nil || now.Sub(*pat.LastUsedAt) > lastSeenInterval {
+		update := bson.M{"$set": bson.M{"last_used_at": now, "last_used_ip": utils.ClientIP(r)}}
+
+		//nolint:errcheck //CODEI8: best effort, the token is already authenticated
+		coll.UpdateOne(context.Background(), bson.M{"_id": pat.ID}, update)
+	}
+
+	return &pat, nil
+}
+
+// allowedByScope reports whether pat may make r. Tokens without the write
+// scope are limited to safe methods.
+func allowedByScope(pat *PersonalAccessToken, r *http.Request) bool {
+	switch r.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+		return pat.hasScope(ScopeRead) || pat.hasScope(ScopeWrite)
+	default:
+		return pat.hasScope(ScopeWrite)
+	}
+}
+
+// SessionOnly rejects requests authenticated with a personal access token, so
+// a leaked token cannot mint more tokens or change how the account signs in.
+// It must wrap a handler already behind IsAuthenticated.
+func (au *AuthHandler) SessionOnly(nextHandler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if loggedIn, ok := r.Context().Value("user").(*AuthUser); ok && loggedIn.TokenID != "" {
+			utils.GetError(ErrSessionRequired, http.StatusForbidden, w)
+			return
+		}
+
+		nextHandler.ServeHTTP(w, r)
+	}
+}
+
+// CreatePersonalAccessToken mints a token for the logged in user. The token
+// is only ever shown in this response.
+func (au *AuthHandler) CreatePersonalAccessToken(w http.ResponseWriter, r *http.Request) {
+	loggedIn, _ := r.Context().Value("user").(*AuthUser)
+
+	u, err := au.loggedInUser(r)
+	if err != nil {
+		utils.GetError(err, http.StatusBadRequest, w)
+		return
+	}
+
+	body := struct {
+		Name          string   `json:"name" validate:"required,max=100"`
+		Scopes        []string `json:"scopes" validate:"required,min=1"`
+		ExpiresInDays int      `json:"expires_in_days"`
+	}{}
+
+	if err := utils.ParseJSONFromRequest(r, &body); err != nil {
+		utils.GetError(err, http.StatusUnprocessableEntity, w)
+		return
+	}
+
+	if err := validate.Struct(body); err != nil {
+		utils.GetError(err, http.StatusBadRequest, w)
+		return
+	}
+
+	for _, s := range body.Scopes {
+		if s != ScopeRead && s != ScopeWrite {
+			utils.GetError(ErrInvalidTokenScope, http.StatusBadRequest, w)
+			return
+		}
+	}
+
+	if body.ExpiresInDays == 0 {
+		body.ExpiresInDays = defaultTokenLifetimeDays
+	}
+
+	if body.ExpiresInDays < 1 || body.ExpiresInDays > maxTokenLifetimeDays {
+		utils.GetError(ErrTokenLifetimeBounds, http.StatusBadRequest, w)
+		return
+	}
+
+	coll := accessTokens()
+
+	count, err := coll.CountDocuments(r.Context(), bson.M{"user_id": u.ID})
+	if err != nil {
+		utils.GetError(err, http.StatusInternalServerError, w)
+		return
+	}
+
+	if count >= maxTokensPerUser {
+		utils.GetError(ErrTooManyTokens, http.StatusBadRequest, w)
+		return
+	}
+
+	secret, err := utils.GenSecureToken(accessTokenBytes)
+	if err != nil {
+		utils.GetError(err, http.StatusInternalServerError, w)
+		return
+	}
+
+	token := PersonalAccessTokenPrefix + secret
+	now := time.Now()
+
+	pat := PersonalAccessToken{
+		Name:             strings.TrimSpace(body.Name),
+		UserID:           u.ID,
+		Email:            u.Email,
+		TokenHash:        utils.HashToken(token),
+		Prefix:           token[:len(PersonalAccessTokenPrefix)+6],
+		Scopes:           body.Scopes,
+		IdentityProvider: loggedIn.IdentityProvider,
+		SignInMethod:     loggedIn.SignInMethod,
+		ExpiresAt:        now.AddDate(0, 0, body.ExpiresInDays),
+		CreatedAt:        now,
+	}
+
+	res, err := coll.InsertOne(r.Context(), pat)
+	if err != nil {
+		utils.GetError(err, http.StatusInternalServerError, w)
+		return
+	}
+
+	pat.ID, _ = res.InsertedID.(primitive.ObjectID)
+
+	utils.GetSuccess("personal access token created, copy it now as it will not be shown again", map[string]interface{}{
+		"token":   token,
+		"details": pat,
+	}, w)
+}
+
+// ListPersonalAccessTokens returns the logged in user's tokens, without the secrets.
+func (au *AuthHandler) ListPersonalAccessTokens(w http.ResponseWriter, r *http.Request) {
+	u, err := au.loggedInUser(r)
+	if err != nil {
+		utils.GetError(err, http.StatusBadRequest, w)
+		return
+	}
+
+	opts := options.Find().SetSort(bson.M{"created_at": -1})
+
+	cursor, err := accessTokens().Find(r.Context(), bson.M{"user_id": u.ID}, opts)
+	if err != nil {
+		utils.GetError(err, http.StatusInternalServerError, w)
+		return
+	}
+
+	tokens := []PersonalAccessToken{}
+	if err := cursor.All(r.Context(), &tokens); err != nil {
+		utils.GetError(err, http.StatusInternalServerError, w)
+		return
+	}
+
+	utils.GetSuccess("personal access tokens retrieved successfully", tokens, w)
+}
+
+// RevokePersonalAccessToken deletes one of the logged in user's tokens.
+func (au *AuthHandler) RevokePersonalAccessToken(w http.ResponseWriter, r *http.Request) {
+	u, err := au.loggedInUser(r)
+	if err != nil {
+		utils.GetError(err, http.StatusBadRequest, w)
+		return
+	}
+
+	id, err := primitive.ObjectIDFromHex(mux.Vars(r)["token_id"])
+	if err != nil {
+		utils.GetError(ErrorInvalid, http.StatusBadRequest, w)
+		return
+	}
+
+	res, err := accessTokens().DeleteOne(r.Context(), bson.M{"_id": id, "user_id": u.ID})
+	if err != nil {
+		utils.GetError(err, http.StatusInternalServerError, w)
+		return
+	}
+
+	if res.DeletedCount == 0 {
+		utils.GetError(ErrTokenNotFound, http.StatusNotFound, w)
+		return
+	}
+
+	utils.GetSuccess("personal access token revoked", nil, w)
+}
@@ -0,0 +1,53 @@
+package auth
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestBearerAccessToken(t *testing.T) {
+	tests := []struct {
+		header string
+		want   bool
+	}{
+		{"Bearer zcp_abc123", true},
+		{"Bearer eyJhbGciOiJIUzI1NiJ9.e30.sig", false},
+		{"", false},
+	}
+
+	for _, tt := range tests {
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		r.Header.Set("Authorization", tt.header)
+
+		if _, got := bearerAccessToken(r); got != tt.want {
+			t.Errorf("bearerAccessToken(%q) = %v, want %v", tt.header, got, tt.want)
+		}
+	}
+}
+
+func TestAllowedByScope(t *testing.T) {
+	read := &PersonalAccessToken{Scopes: []string{ScopeRead}}
+	write := &PersonalAccessToken{Scopes: []string{ScopeWrite}}
+
+	tests := []struct {
+		pat    *PersonalAccessToken
+		method string
+		want   bool
+	}{
+		{read, http.MethodGet, true},
+		{read, http.MethodPost, false},
+		{read, http.MethodDelete, false},
+		{write, http.MethodGet, true},
+		{write, http.MethodPatch, true},
+		{&PersonalAccessToken{}, http.MethodGet, false},
+	}
+
+	for _, tt := range tests {
+		r := httptest.NewRequest(tt.method, "/", nil)
+
+		if got := allowedByScope(tt.pat, r); got != tt.want {
+			t.Errorf("allowedByScope(%v, %s) = %v, want %v", tt.pat.Scopes, tt.method, got, tt.want)
+		}
+	}
+}
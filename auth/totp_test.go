@@ -0,0 +1,97 @@
+package auth
+
+import (
+	"encoding/base32"
+	"strings"
+	"testing"
+	"time"
+)
+
+// RFC 6238 appendix B vectors for SHA1, truncated to six digits.
+func TestTOTPCode(t *testing.T) {
+	secret := base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString([]byte("12345678901234567890"))
+
+	tests := []struct {
+		unix int64
+		want string
+	}{
+		{59, "287082"},
+		{1111111109, "081804"},
+		{1111111111, "050471"},
+		{1234567890, "005924"},
+		{2000000000, "279037"},
+		{20000000000, "353130"},
+	}
+
+	for _, tt := range tests {
+		got, err := TOTPCode(secret, TOTPStep(time.Unix(tt.unix, 0)))
+		if err != nil {
+			t.Fatalf("TOTPCode(%d) error: %v", tt.unix, err)
+		}
+
+		if got != tt.want {
+			t.Errorf("TOTPCode(%d) = %s, want %s", tt.unix, got, tt.want)
+		}
+	}
+}
+
+func TestValidateTOTP(t *testing.T) {
+	secret, err := GenerateTOTPSecret()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.Unix(1700000000, 0)
+	step := TOTPStep(now)
+
+	tests := []struct {
+		name string
+		step int64
+		ok   bool
+	}{
+		{"current step", step, true},
+		{"previous step", step - 1, true},
+		{"next step", step + 1, true},
+		{"too old", step - 2, false},
+		{"too new", step + 2, false},
+	}
+
+	for _, tt := range tests {
+		code, _ := TOTPCode(secret, tt.step)
+
+		got, ok := ValidateTOTP(secret, code, now)
+		if ok != tt.ok {
+			t.Errorf("%s: ValidateTOTP ok = %v, want %v", tt.name, ok, tt.ok)
+		}
+
+		if ok && got != tt.step {
+			t.Errorf("%s: ValidateTOTP step = %d, want %d", tt.name, got, tt.step)
+		}
+	}
+
+	if _, ok := ValidateTOTP(secret, "12345", now); ok {
+		t.Error("ValidateTOTP accepted a short code")
+	}
+}
+
+func TestRecoveryCodes(t *testing.T) {
+	codes, err := GenerateRecoveryCodes(recoveryCodeNum)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	seen := map[string]bool{}
+
+	for _, code := range codes {
+		if seen[code] {
+			t.Errorf("duplicate recovery code %s", code)
+		}
+
+		seen[code] = true
+
+		typed := strings.ToUpper(strings.ReplaceAll(code, "-", ""))
+		if got := normalizeRecoveryCode(typed); got != code {
+			t.Errorf("normalizeRecoveryCode(%s) = %s, want %s", typed, got, code)
+		}
+	}
+}
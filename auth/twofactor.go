@@ -0,0 +1,419 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"zuri.chat/zccore/user"
+	"zuri.chat/zccore/utils"
+)
+
+const (
+	twoFactorChallenge    = "2fa_challenge"
+	twoFactorChallengeTTL = 5 * time.Minute
+)
+
+var (
+	ErrTwoFactorRequired    = errors.New("this organization requires two-factor authentication, enroll an authenticator to continue")
+	ErrTwoFactorEnabled     = errors.New("two-factor authentication is already enabled")
+	ErrTwoFactorNotEnabled  = errors.New("two-factor authentication is not enabled")
+	ErrTwoFactorNotEnrolled = errors.New("no pending two-factor enrollment, start enrollment first")
+	ErrInvalidTwoFactorCode = errors.New("invalid or already used two-factor code")
+	ErrInvalidChallenge     = errors.New("login challenge invalid or expired, kindly login again")
+)
+
+type twoFactorCode struct {
+	Code         string `json:"code"`
+	RecoveryCode string `json:"recovery_code"`
+}
+
+func userIDFilter(id string) bson.M {
+	if objID, err := primitive.ObjectIDFromHex(id); err == nil {
+		return bson.M{"_id": objID}
+	}
+
+	return bson.M{"_id": id}
+}
+
+func (au *AuthHandler) loggedInUser(r *http.Request) (*user.User, error) {
+	loggedIn, ok := r.Context().Value("user").(*AuthUser)
+	if !ok || loggedIn == nil {
+		return nil, ErrNotAuthorized
+	}
+
+	u, err := FetchUserByEmail(bson.M{"email": strings.ToLower(loggedIn.Email)})
+	if err != nil {
+		return nil, ErrUserNotFound
+	}
+
+	return u, nil
+}
+
+// issueChallenge signs the short lived token a user exchanges, together with
+// a second factor, for a session at /auth/login/2fa. via is how the first
+// factor was given.
+func (au *AuthHandler) issueChallenge(u *user.User, via signIn) (string, error) {
+	now := time.Now()
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
+		"purpose": twoFactorChallenge,
+		"email":   u.Email,
+		"idp":     via.IdentityProvider,
+		"method":  via.Method,
+		"iat":     now.Unix(),
+		"exp":     now.Add(twoFactorChallengeTTL).Unix(),
+	})
+
+	return token.SignedString([]byte(au.configs.HmacSampleSecret))
+}
+
+func (au *AuthHandler) parseChallenge(tokenString string) (email string, via signIn, err error) {
+	token, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
+		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
+			return nil, ErrInvalidChallenge
+		}
+		return []byte(au.configs.HmacSampleSecret), nil
+	})
+
+	if err != nil || !token.Valid {
+		return "", signIn{}, ErrInvalidChallenge
+	}
+
+	claims, ok := token.Claims.(jwt.MapClaims)
+	if !ok || claims["purpose"] != twoFactorChallenge {
+		return "", signIn{}, ErrInvalidChallenge
+	}
+
+	email, _ = claims["email"].(string)
+	if email == "" {
+		return "", signIn{}, ErrInvalidChallenge
+	}
+
+	via.IdentityProvider, _ = claims["idp"].(string)
+	via.Method, _ = claims["method"].(string)
+
+	return email, via, nil
+}
+
+// sendChallenge answers a correct first factor for a user with 2FA enabled.
+func (au *AuthHandler) sendChallenge(w http.ResponseWriter, u *user.User, via signIn) {
+	token, err := au.issueChallenge(u, via)
+	if err != nil {
+		utils.GetError(err, http.StatusInternalServerError, w)
+		return
+	}
+
+	resp := map[string]interface{}{
+		"two_factor_required": true,
+		"challenge_token":     token,
+		"expires_in":          int(twoFactorChallengeTTL.Seconds()),
+	}
+
+	utils.GetSuccess("two-factor authentication required", resp, w)
+}
+
+// verifySecondFactor accepts either a TOTP code or an unused recovery code.
+// Both are consumed atomically so neither can be replayed.
+func (au *AuthHandler) verifySecondFactor(u *user.User, c twoFactorCode) error {
+	if u.TwoFactor == nil || !u.TwoFactor.Enabled {
+		return ErrTwoFactorNotEnabled
+	}
+
+	ctx := context.Background()
+	coll := utils.GetCollection(userCollection)
+	filter := userIDFilter(u.ID)
+
+	if c.RecoveryCode != "" {
+		hash := utils.HashToken(normalizeRecoveryCode(c.RecoveryCode))
+		filter["two_factor.recovery_codes"] = hash
+
+		res, err := coll.UpdateOne(ctx, filter, bson.M{"$pull": bson.M{"two_factor.recovery_codes": hash}})
+		if err != nil {
+			return err
+		}
+
+		if res.ModifiedCount == 0 {
+			return ErrInvalidTwoFactorCode
+		}
+
+		return nil
+	}
+
+	secret, err := utils.DecryptString(u.TwoFactor.Secret, au.configs.EncryptionKey)
+	if err != nil {
+		return err
+	}
+
+	step, ok := ValidateTOTP(secret, c.Code, time.Now())
+	if !ok {
+		return ErrInvalidTwoFactorCode
+	}
+
+	filter["two_factor.last_used_step"] = bson.M{"$lt": step}
+
+	res, err := coll.UpdateOne(ctx, filter, bson.M{"$set": bson.M{"two_factor.last_used_step": step}})
+	if err != nil {
+		return err
+	}
+
+	if res.ModifiedCount == 0 {
+		return ErrInvalidTwoFactorCode
+	}
+
+	return nil
+}
+
+func newRecoveryCodes() (codes, hashes []string, err error) {
+	codes, err = GenerateRecoveryCodes(recoveryCodeNum)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	hashes = make([]string, len(codes))
+	for i, code := range codes {
+		hashes[i] = utils.HashToken(code)
+	}
+
+	return codes, hashes, nil
+}
+
+// EnrollTwoFactor creates a pending TOTP secret for the logged in user. It only
+// takes effect once confirmed with a code at /auth/2fa/activate.
+func (au *AuthHandler) EnrollTwoFactor(w http.ResponseWriter, r *http.Request) {
+	u, err := au.loggedInUser(r)
+	if err != nil {
+		utils.GetError(err, http.StatusBadRequest, w)
+		return
+	}
+
+	if u.TwoFactor != nil && u.TwoFactor.Enabled {
+		utils.GetError(ErrTwoFactorEnabled, http.StatusBadRequest, w)
+		return
+	}
+
+	secret, err := GenerateTOTPSecret()
+	if err != nil {
+		utils.GetError(err, http.StatusInternalServerError, w)
+		return
+	}
+
+	update := bson.M{"$set": bson.M{
+		"two_factor.enabled":        false,
+		"two_factor.pending_secret": utils.EncryptString(secret, au.configs.EncryptionKey),
+	}}
+
+	if _, err := utils.GetCollection(userCollection).UpdateOne(context.Background(), userIDFilter(u.ID), update); err != nil {
+		utils.GetError(err, http.StatusInternalServerError, w)
+		return
+	}
+
+	resp := map[string]interface{}{
+		"secret":      secret,
+		"otpauth_url": TOTPURI(secret, u.Email),
+	}
+
+	utils.GetSuccess("scan the code with your authenticator app and confirm it", resp, w)
+}
+
+// ActivateTwoFactor confirms a pending enrollment and returns the recovery
+// codes. They are only ever shown here.
+func (au *AuthHandler) ActivateTwoFactor(w http.ResponseWriter, r *http.Request) {
+	var body struct {
+		Code string `json:"code" validate:"required"`
+	}
+
+	if err := utils.ParseJSONFromRequest(r, &body); err != nil {
+		utils.GetError(err, http.StatusUnprocessableEntity, w)
+		return
+	}
+
+	if err := validate.Struct(body); err != nil {
+		utils.GetError(err, http.StatusBadRequest, w)
+		return
+	}
+
+	u, err := au.loggedInUser(r)
+	if err != nil {
+		utils.GetError(err, http.StatusBadRequest, w)
+		return
+	}
+
+	if u.TwoFactor == nil || u.TwoFactor.PendingSecret == "" {
+		utils.GetError(ErrTwoFactorNotEnrolled, http.StatusBadRequest, w)
+		return
+	}
+
+	secret, err := utils.DecryptString(u.TwoFactor.PendingSecret, au.configs.EncryptionKey)
+	if err != nil {
+		utils.GetError(err, http.StatusInternalServerError, w)
+		return
+	}
+
+	step, ok := ValidateTOTP(secret, body.Code, time.Now())
+	if !ok {
+		utils.GetError(ErrInvalidTwoFactorCode, http.StatusBadRequest, w)
+		return
+	}
+
+	codes, hashes, err := newRecoveryCodes()
+	if err != nil {
+		utils.GetError(err, http.StatusInternalServerError, w)
+		return
+	}
+
+	update := bson.M{"$set": bson.M{"two_factor": user.UserTwoFactor{
+		Enabled:       true,
+		Secret:        u.TwoFactor.PendingSecret,
+		RecoveryCodes: hashes,
+		LastUsedStep:  step,
+		EnrolledAt:    time.Now(),
+	}}}
+
+	if _, err := utils.GetCollection(userCollection).UpdateOne(context.Background(), userIDFilter(u.ID), update); err != nil {
+		utils.GetError(err, http.StatusInternalServerError, w)
+		return
+	}
+
+	utils.GetSuccess("two-factor authentication enabled, store your recovery codes safely", map[string]interface{}{"recovery_codes": codes}, w)
+}
+
+// DisableTwoFactor turns 2FA off after checking the password and a second factor.
+func (au *AuthHandler) DisableTwoFactor(w http.ResponseWriter, r *http.Request) {
+	var body struct {
+		Password string `json:"password" validate:"required"`
+		twoFactorCode
+	}
+
+	if err := utils.ParseJSONFromRequest(r, &body); err != nil {
+		utils.GetError(err, http.StatusUnprocessableEntity, w)
+		return
+	}
+
+	if err := validate.Struct(body); err != nil {
+		utils.GetError(err, http.StatusBadRequest, w)
+		return
+	}
+
+	u, err := au.loggedInUser(r)
+	if err != nil {
+		utils.GetError(err, http.StatusBadRequest, w)
+		return
+	}
+
+	if !ComparePassword(body.Password, u.Password) {
+		utils.GetError(ErrInvalidCredentials, http.StatusBadRequest, w)
+		return
+	}
+
+	if err := au.verifySecondFactor(u, body.twoFactorCode); err != nil {
+		utils.GetError(err, http.StatusBadRequest, w)
+		return
+	}
+
+	update := bson.M{"$unset": bson.M{"two_factor": ""}}
+	if _, err := utils.GetCollection(userCollection).UpdateOne(context.Background(), userIDFilter(u.ID), update); err != nil {
+		utils.GetError(err, http.StatusInternalServerError, w)
+		return
+	}
+
+	utils.GetSuccess("two-factor authentication disabled", nil, w)
+}
+
+// RegenerateRecoveryCodes replaces every recovery code with a fresh set.
+func (au *AuthHandler) RegenerateRecoveryCodes(w http.ResponseWriter, r *http.Request) {
+	var body twoFactorCode
+
+	if err := utils.ParseJSONFromRequest(r, &body); err != nil {
+		utils.GetError(err, http.StatusUnprocessableEntity, w)
+		return
+	}
+
+	u, err := au.loggedInUser(r)
+	if err != nil {
+		utils.GetError(err, http.StatusBadRequest, w)
+		return
+	}
+
+	// a recovery code must not be able to mint more recovery codes
+	if err := au.verifySecondFactor(u, twoFactorCode{Code: body.Code}); err != nil {
+		utils.GetError(err, http.StatusBadRequest, w)
+		return
+	}
+
+	codes, hashes, err := newRecoveryCodes()
+	if err != nil {
+		utils.GetError(err, http.StatusInternalServerError, w)
+		return
+	}
+
+	update := bson.M{"$set": bson.M{"two_factor.recovery_codes": hashes}}
+	if _, err := utils.GetCollection(userCollection).UpdateOne(context.Background(), userIDFilter(u.ID), update); err != nil {
+		utils.GetError(err, http.StatusInternalServerError, w)
+		return
+	}
+
+	utils.GetSuccess("recovery codes regenerated", map[string]interface{}{"recovery_codes": codes}, w)
+}
+
+// LoginTwoFactor completes a login started at /auth/login for a user with 2FA.
+func (au *AuthHandler) LoginTwoFactor(w http.ResponseWriter, r *http.Request) {
+	w.Header().Add("content-type", "application/json")
+
+	var body struct {
+		ChallengeToken string `json:"challenge_token" validate:"required"`
+		twoFactorCode
+	}
+
+	if err := utils.ParseJSONFromRequest(r, &body); err != nil {
+		utils.GetError(err, http.StatusUnprocessableEntity, w)
+		return
+	}
+
+	if err := validate.Struct(body); err != nil {
+		utils.GetError(err, http.StatusBadRequest, w)
+		return
+	}
+
+	email, via, err := au.parseChallenge(body.ChallengeToken)
+	if err != nil {
+		utils.GetError(err, http.StatusUnauthorized, w)
+		return
+	}
+
+	email = strings.ToLower(email)
+	keys := []attemptKey{accountKey("login", email), ipKey("login", r)}
+
+	counts, ok := takeAttempts(w, keys...)
+	if !ok {
+		LogAuthEvent(r, AuthEventLoginBlocked, email)
+		return
+	}
+
+	u, err := FetchUserByEmail(bson.M{"email": email})
+	if err != nil {
+		utils.GetError(ErrUserNotFound, http.StatusBadRequest, w)
+		return
+	}
+
+	if err := au.verifySecondFactor(u, body.twoFactorCode); err != nil {
+		au.authFailed(r, AuthEventTwoFactorFailed, "login", email, u, keys, counts)
+		utils.GetError(err, http.StatusUnauthorized, w)
+
+		return
+	}
+
+	resp, err := au.startSession(w, r, u, via)
+	if err != nil {
+		utils.GetError(err, sessionErrorStatus(err), w)
+		return
+	}
+
+	attemptSucceeded(keys...)
+	LogAuthEvent(r, AuthEventLoginSucceeded, email)
+	utils.GetSuccess("login successful", resp, w)
+}
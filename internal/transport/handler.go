@@ -73,86 +73,167 @@ func (h *Handler) SetupRoutes() {
 	h.Router.HandleFunc("/posts/mail", blog.MailingList).Methods("POST")
 
 	// Authentication
-	h.Router.HandleFunc("/auth/login", au.LoginIn).Methods(http.MethodPost)
+	h.Router.HandleFunc("/auth/login", utils.Throttle(au.LoginIn)).Methods(http.MethodPost)
 	h.Router.HandleFunc("/auth/logout", au.LogOutUser).Methods(http.MethodPost)
 	h.Router.HandleFunc("/auth/logout/other-sessions", au.LogOutOtherSessions).Methods(http.MethodPost)
 	h.Router.HandleFunc("/auth/verify-token", au.IsAuthenticated(au.VerifyTokenHandler)).Methods(http.MethodGet, http.MethodPost)
 	h.Router.HandleFunc("/auth/confirm-password", au.IsAuthenticated(au.ConfirmUserPassword)).Methods(http.MethodPost)
 	h.Router.HandleFunc("/auth/social-login/{provider}/{access_token}", au.SocialAuth).Methods(http.MethodGet)
-
-	h.Router.HandleFunc("/account/verify-account", au.VerifyAccount).Methods(http.MethodPost)
-	h.Router.HandleFunc("/account/request-password-reset-code", au.RequestResetPasswordCode).Methods(http.MethodPost)
-	h.Router.HandleFunc("/account/verify-reset-password", au.VerifyPasswordResetCode).Methods(http.MethodPost)
-	h.Router.HandleFunc("/account/update-password/{verification_code:[0-9]+}", au.UpdatePassword).Methods(http.MethodPost)
+	h.Router.HandleFunc("/auth/login/2fa", utils.Throttle(au.LoginTwoFactor)).Methods(http.MethodPost)
+	h.Router.HandleFunc("/auth/magic-link", utils.Throttle(au.RequestMagicLink)).Methods(http.MethodPost)
+	h.Router.HandleFunc("/auth/magic-link/verify", utils.Throttle(au.VerifyMagicLink)).Methods(http.MethodPost)
+	h.Router.HandleFunc("/auth/oidc/{provider}/login", au.OIDCLogin).Methods(http.MethodGet)
+	h.Router.HandleFunc("/auth/oidc/{provider}/callback", au.OIDCCallback).Methods(http.MethodGet)
+	h.Router.HandleFunc("/auth/refresh", au.RefreshAccessToken).Methods(http.MethodPost)
+	h.Router.HandleFunc("/auth/refresh/revoke", au.RevokeRefreshToken).Methods(http.MethodPost)
+	h.Router.HandleFunc("/auth/sessions", au.IsAuthenticated(au.SessionOnly(au.ListSessions))).Methods(http.MethodGet)
+	h.Router.HandleFunc("/auth/sessions/{session_id}", au.IsAuthenticated(au.SessionOnly(au.RevokeSession))).Methods(http.MethodDelete)
+	h.Router.HandleFunc("/auth/tokens", au.IsAuthenticated(au.SessionOnly(au.ListPersonalAccessTokens))).Methods(http.MethodGet)
+	h.Router.HandleFunc("/auth/tokens", au.IsAuthenticated(au.SessionOnly(au.CreatePersonalAccessToken))).Methods(http.MethodPost)
+	h.Router.HandleFunc("/auth/tokens/{token_id}", au.IsAuthenticated(au.SessionOnly(au.RevokePersonalAccessToken))).Methods(http.MethodDelete)
+	h.Router.HandleFunc("/auth/2fa/enroll", au.IsAuthenticated(au.SessionOnly(au.EnrollTwoFactor))).Methods(http.MethodPost)
+	h.Router.HandleFunc("/auth/2fa/activate", au.IsAuthenticated(au.SessionOnly(au.ActivateTwoFactor))).Methods(http.MethodPost)
+	h.Router.HandleFunc("/auth/2fa/disable", au.IsAuthenticated(au.SessionOnly(au.DisableTwoFactor))).Methods(http.MethodPost)
+	h.Router.HandleFunc("/auth/2fa/recovery-codes", au.IsAuthenticated(au.SessionOnly(au.RegenerateRecoveryCodes))).Methods(http.MethodPost)
+
+	h.Router.HandleFunc("/account/verify-account", utils.Throttle(au.VerifyAccount)).Methods(http.MethodPost)
+	h.Router.HandleFunc("/account/resend-verification", utils.Throttle(au.ResendVerification)).Methods(http.MethodPost)
+	h.Router.HandleFunc("/account/email-change", au.IsAuthenticated(au.SessionOnly(au.RequestEmailChange))).Methods(http.MethodPost)
+	h.Router.HandleFunc("/account/email-change/confirm", utils.Throttle(au.ConfirmEmailChange)).Methods(http.MethodPost)
+	h.Router.HandleFunc("/account/email-change/cancel", utils.Throttle(au.CancelEmailChange)).Methods(http.MethodPost)
+	h.Router.HandleFunc("/account/request-password-reset-code", utils.Throttle(au.RequestResetPasswordCode)).Methods(http.MethodPost)
+	h.Router.HandleFunc("/account/verify-reset-password", utils.Throttle(au.VerifyPasswordResetCode)).Methods(http.MethodPost)
+	h.Router.HandleFunc("/account/update-password/{verification_code:[0-9]+}", utils.Throttle(au.UpdatePassword)).Methods(http.MethodPost)
 
 	// Organization
-	h.Router.HandleFunc("/organizations", au.IsAuthenticated(orgs.Create)).Methods("POST")                                              // works
-	h.Router.HandleFunc("/organizations", au.IsAuthenticated(orgs.GetOrganizations)).Methods("GET")                                     // works
-	h.Router.HandleFunc("/organizations/{id}", au.IsAuthenticated(orgs.GetOrganization)).Methods("GET")                                 // works
-	h.Router.HandleFunc("/organizations/{id}", au.IsAuthenticated(au.IsAuthorized(orgs.DeleteOrganization, "admin"))).Methods("DELETE") // worksxxx
-	h.Router.HandleFunc("/organizations/url/{url}", orgs.GetOrganizationByURL).Methods("GET")                                           // works
+	h.Router.HandleFunc("/organizations", au.IsAuthenticated(orgs.Create)).Methods("POST")                                                              // works
+	h.Router.HandleFunc("/organizations", au.IsAuthenticated(orgs.GetOrganizations)).Methods("GET")                                                     // works
+	h.Router.HandleFunc("/organizations/{id}", au.IsAuthenticated(au.OrgSession(orgs.GetOrganization))).Methods("GET")                                  // works
+	h.Router.HandleFunc("/organizations/{id}", au.IsAuthenticated(au.RequirePermission(orgs.DeleteOrganization, auth.PermOrgDelete))).Methods("DELETE") // worksxxx
+	h.Router.HandleFunc("/organizations/url/{url}", orgs.GetOrganizationByURL).Methods("GET")                                                           // works
+
+	h.Router.HandleFunc("/organizations/{id}/restore", au.IsAuthenticated(au.RequirePermission(orgs.RestoreOrganization, auth.PermOrgDelete))).Methods("POST")
 
-	h.Router.HandleFunc("/organizations/{id}/url", au.IsAuthenticated(orgs.UpdateURL)).Methods("PATCH")   // works
-	h.Router.HandleFunc("/organizations/{id}/name", au.IsAuthenticated(orgs.UpdateName)).Methods("PATCH") // works
-	h.Router.HandleFunc("/organizations/{id}/logo", au.IsAuthenticated(orgs.UpdateLogo)).Methods("PATCH") // works
+	h.Router.HandleFunc("/organizations/{id}/url", au.IsAuthenticated(au.RequirePermission(orgs.UpdateURL, auth.PermSettingsEdit))).Methods("PATCH")   // works
+	h.Router.HandleFunc("/organizations/{id}/name", au.IsAuthenticated(au.RequirePermission(orgs.UpdateName, auth.PermSettingsEdit))).Methods("PATCH") // works
+	h.Router.HandleFunc("/organizations/{id}/logo", au.IsAuthenticated(au.RequirePermission(orgs.UpdateLogo, auth.PermSettingsEdit))).Methods("PATCH") // works
 
-	h.Router.HandleFunc("/organizations/{id}/settings", au.IsAuthenticated(orgs.UpdateOrganizationSettings)).Methods("PATCH")     // works
-	h.Router.HandleFunc("/organizations/{id}/permission", au.IsAuthenticated(orgs.UpdateOrganizationPermission)).Methods("PATCH") //works
-	h.Router.HandleFunc("/organizations/{id}/auth", au.IsAuthenticated(orgs.UpdateOrganizationAuthentication)).Methods("PATCH")   // works
-	h.Router.HandleFunc("/organizations/{id}/change-owner", au.IsAuthenticated(au.IsAuthorized(orgs.TransferOwnership, "owner"))).Methods("PATCH")
+	h.Router.HandleFunc("/organizations/{id}/settings", au.IsAuthenticated(au.RequirePermission(orgs.UpdateOrganizationSettings, auth.PermSettingsEdit))).Methods("PATCH")     // works
+	h.Router.HandleFunc("/organizations/{id}/permission", au.IsAuthenticated(au.RequirePermission(orgs.UpdateOrganizationPermission, auth.PermSettingsEdit))).Methods("PATCH") //works
+	h.Router.HandleFunc("/organizations/{id}/auth", au.IsAuthenticated(au.RequirePermission(orgs.UpdateOrganizationAuthentication, auth.PermSettingsEdit))).Methods("PATCH")   // works
+	h.Router.HandleFunc("/organizations/{id}/change-owner", au.IsAuthenticated(au.RequirePermission(orgs.TransferOwnership, auth.PermOrgTransfer))).Methods("PATCH")
 
-	h.Router.HandleFunc("/organizations/{id}/prefixes", au.IsAuthenticated(orgs.UpdateOrganizationPrefixes)).Methods("PATCH")       // fixed
-	h.Router.HandleFunc("/organizations/{id}/slackbotresponses", au.IsAuthenticated(orgs.UpdateSlackBotResponses)).Methods("PATCH") // works
-	h.Router.HandleFunc("/organizations/{id}/customemoji", au.IsAuthenticated(orgs.AddSlackCustomEmoji)).Methods("PATCH")           // works
+	h.Router.HandleFunc("/organizations/{id}/prefixes", au.IsAuthenticated(au.RequirePermission(orgs.UpdateOrganizationPrefixes, auth.PermSettingsEdit))).Methods("PATCH")       // fixed
+	h.Router.HandleFunc("/organizations/{id}/slackbotresponses", au.IsAuthenticated(au.RequirePermission(orgs.UpdateSlackBotResponses, auth.PermSettingsEdit))).Methods("PATCH") // works
+	h.Router.HandleFunc("/organizations/{id}/customemoji", au.IsAuthenticated(au.RequirePermission(orgs.AddSlackCustomEmoji, auth.PermSettingsEdit))).Methods("PATCH")           // works
 
 	// Organization: Guest Invites
-	h.Router.HandleFunc("/organizations/{id}/send-invite", au.IsAuthenticated(au.IsAuthorized(orgs.SendInvite, "admin"))).Methods("POST")  //works
-	h.Router.HandleFunc("/organizations/{id}/invite-stats", au.IsAuthenticated(au.IsAuthorized(orgs.InviteStats, "admin"))).Methods("GET") // none
-	h.Router.HandleFunc("/organizations/invites/{uuid}", orgs.CheckGuestStatus).Methods(http.MethodGet)                                    // none
-	h.Router.HandleFunc("/organizations/guests/{uuid}", orgs.GuestToOrganization).Methods(http.MethodPost)                                 // test
-
-	h.Router.HandleFunc("/organizations/{id}/plugins", au.IsAuthenticated(orgs.AddOrganizationPlugin)).Methods("POST")                  //works
-	h.Router.HandleFunc("/organizations/{id}/plugins", au.IsAuthenticated(orgs.GetOrganizationPlugins)).Methods("GET")                  //works
-	h.Router.HandleFunc("/organizations/{id}/plugins/{plugin_id}", au.IsAuthenticated(orgs.GetOrganizationPlugin)).Methods("GET")       //works
-	h.Router.HandleFunc("/organizations/{id}/plugins/{plugin_id}", au.IsAuthenticated(orgs.RemoveOrganizationPlugin)).Methods("DELETE") //ask
-
-	h.Router.HandleFunc("/organizations/{id}/members", au.IsAuthenticated(au.IsAuthorized(orgs.CreateMember, "admin"))).Methods("POST") // done
-	h.Router.HandleFunc("/organizations/{id}/members", orgs.GetMembers).Methods("GET")                                                  // should work
-	h.Router.HandleFunc("/organizations/{id}/members/multiple", au.IsAuthenticated(orgs.GetmultipleMembers)).Methods("GET")
-	h.Router.HandleFunc("/organizations/{id}/members/{mem_id}", au.IsAuthenticated(orgs.GetMember)).Methods("GET")
-	h.Router.HandleFunc("/organizations/{id}/members/{mem_id}", au.IsAuthenticated(au.IsAuthorized(orgs.DeactivateMember, "admin"))).Methods("DELETE")
-	h.Router.HandleFunc("/organizations/{id}/members/{mem_id}/reactivate", au.IsAuthenticated(au.IsAuthorized(orgs.ReactivateMember, "admin"))).Methods("POST")
-
-	h.Router.HandleFunc("/organizations/{id}/members/{mem_id}/status", au.IsAuthenticated(orgs.UpdateMemberStatus)).Methods("PATCH")
-	h.Router.HandleFunc("/organizations/{id}/members/{mem_id}/status/remove-history/{history_index}", au.IsAuthenticated(orgs.RemoveStatusHistory)).Methods("PATCH")
-	h.Router.HandleFunc("/organizations/{id}/members/{mem_id}/photo/{action}", au.IsAuthenticated(orgs.UpdateProfilePicture)).Methods("PATCH")
-	h.Router.HandleFunc("/organizations/{id}/members/{mem_id}/profile", au.IsAuthenticated(orgs.UpdateProfile)).Methods("PATCH")
-	h.Router.HandleFunc("/organizations/{id}/members/{mem_id}/uploadfile", au.IsAuthenticated(orgs.UploadFile)).Methods("POST")
-	h.Router.HandleFunc("/organizations/{id}/members/{mem_id}/presence", au.IsAuthenticated(orgs.TogglePresence)).Methods("POST")
-	h.Router.HandleFunc("/organizations/{id}/members/{mem_id}/settings", au.IsAuthenticated(orgs.UpdateMemberSettings)).Methods("PATCH")
-	h.Router.HandleFunc("/organizations/{id}/members/{mem_id}/role", au.IsAuthenticated(au.IsAuthorized(orgs.UpdateMemberRole, "admin"))).Methods("PATCH")
-	h.Router.HandleFunc("/organizations/{id}/members/{mem_id}/settings/notification", au.IsAuthenticated(orgs.UpdateNotification)).Methods("PATCH")
-	h.Router.HandleFunc("/organizations/{id}/members/{mem_id}/settings/theme", au.IsAuthenticated(orgs.UpdateUserTheme)).Methods("PATCH")
-	h.Router.HandleFunc("/organizations/{id}/members/{mem_id}/settings/message-media", au.IsAuthenticated(orgs.UpdateMemberMessageAndMediaSettings)).Methods("PATCH")
-	h.Router.HandleFunc("/organizations/{id}/members/{mem_id}/settings/accessibility", au.IsAuthenticated(orgs.UpdateMemberAccessibilitySettings)).Methods("PATCH")
-	h.Router.HandleFunc("/organizations/{id}/members/{mem_id}/settings/languages-and-region", au.IsAuthenticated(orgs.UpdateLanguagesAndRegions)).Methods("PATCH")
-	h.Router.HandleFunc("/organizations/{id}/members/{mem_id}/settings/advanced", au.IsAuthenticated(orgs.UpdateMemberAdvancedSettings)).Methods("PATCH")
+	h.Router.HandleFunc("/organizations/{id}/send-invite", au.IsAuthenticated(au.RequirePermission(orgs.SendInvite, auth.PermMembersInvite))).Methods("POST")  //works
+	h.Router.HandleFunc("/organizations/{id}/invite-stats", au.IsAuthenticated(au.RequirePermission(orgs.ListInvites, auth.PermMembersInvite))).Methods("GET") // none
+	h.Router.HandleFunc("/organizations/invites/{uuid}", orgs.CheckGuestStatus).Methods(http.MethodGet)                                                        // none
+	h.Router.HandleFunc("/organizations/guests/{uuid}", orgs.GuestToOrganization).Methods(http.MethodPost)                                                     // test
+
+	h.Router.HandleFunc("/organizations/{id}/invites", au.IsAuthenticated(au.RequirePermission(orgs.ListInvites, auth.PermMembersInvite))).Methods("GET")
+	h.Router.HandleFunc("/organizations/{id}/invites/{invite_id}/resend", au.IsAuthenticated(au.RequirePermission(orgs.ResendInvite, auth.PermMembersInvite))).Methods("POST")
+	h.Router.HandleFunc("/organizations/{id}/invites/{invite_id}", au.IsAuthenticated(au.RequirePermission(orgs.RevokeInvite, auth.PermMembersInvite))).Methods("DELETE")
+
+	h.Router.HandleFunc("/organizations/{id}/member-imports", au.IsAuthenticated(au.RequirePermission(orgs.ImportMembers, auth.PermMembersInvite))).Methods("POST")
+	h.Router.HandleFunc("/organizations/{id}/member-imports", au.IsAuthenticated(au.RequirePermission(orgs.ListMemberImports, auth.PermMembersInvite))).Methods("GET")
+	h.Router.HandleFunc("/organizations/{id}/member-imports/{import_id}", au.IsAuthenticated(au.RequirePermission(orgs.GetMemberImport, auth.PermMembersInvite))).Methods("GET")
+	h.Router.HandleFunc("/organizations/{id}/member-imports/{import_id}/report", au.IsAuthenticated(au.RequirePermission(orgs.DownloadImportReport, auth.PermMembersInvite))).Methods("GET")
+
+	h.Router.HandleFunc("/organizations/{id}/domains", au.IsAuthenticated(au.RequirePermission(orgs.ListDomains, auth.PermSettingsEdit))).Methods("GET")
+	h.Router.HandleFunc("/organizations/{id}/domains", au.IsAuthenticated(au.RequirePermission(orgs.AddDomain, auth.PermSettingsEdit))).Methods("POST")
+	h.Router.HandleFunc("/organizations/{id}/domains/{domain}/verify", au.IsAuthenticated(au.RequirePermission(orgs.VerifyDomain, auth.PermSettingsEdit))).Methods("POST")
+	h.Router.HandleFunc("/organizations/{id}/domains/{domain}", au.IsAuthenticated(au.RequirePermission(orgs.RemoveDomain, auth.PermSettingsEdit))).Methods("DELETE")
+	h.Router.HandleFunc("/organizations/{id}/join", au.IsAuthenticated(orgs.JoinOrganization)).Methods("POST")
+
+	h.Router.HandleFunc("/organizations/{id}/groups", au.IsAuthenticated(au.IsAuthorized(orgs.ListGroups, "guest"))).Methods("GET")
+	h.Router.HandleFunc("/organizations/{id}/groups", au.IsAuthenticated(au.RequirePermission(orgs.CreateGroup, auth.PermGroupsManage))).Methods("POST")
+	h.Router.HandleFunc("/organizations/{id}/groups/{group_id}", au.IsAuthenticated(au.IsAuthorized(orgs.GetGroup, "guest"))).Methods("GET")
+	h.Router.HandleFunc("/organizations/{id}/groups/{group_id}", au.IsAuthenticated(au.IsAuthorized(orgs.UpdateGroup, "guest"))).Methods("PATCH")
+	h.Router.HandleFunc("/organizations/{id}/groups/{group_id}", au.IsAuthenticated(au.RequirePermission(orgs.DeleteGroup, auth.PermGroupsManage))).Methods("DELETE")
+	h.Router.HandleFunc("/organizations/{id}/groups/{group_id}/members", au.IsAuthenticated(au.IsAuthorized(orgs.AddGroupMembers, "guest"))).Methods("POST")
+	h.Router.HandleFunc("/organizations/{id}/groups/{group_id}/members/{member_id}", au.IsAuthenticated(au.IsAuthorized(orgs.RemoveGroupMember, "guest"))).Methods("DELETE")
+
+	h.Router.HandleFunc("/organizations/{id}/scim-tokens", au.IsAuthenticated(au.RequirePermission(orgs.CreateSCIMToken, auth.PermSCIMManage))).Methods("POST")
+	h.Router.HandleFunc("/organizations/{id}/scim-tokens", au.IsAuthenticated(au.RequirePermission(orgs.ListSCIMTokens, auth.PermSCIMManage))).Methods("GET")
+	h.Router.HandleFunc("/organizations/{id}/scim-tokens/{token_id}", au.IsAuthenticated(au.RequirePermission(orgs.RevokeSCIMToken, auth.PermSCIMManage))).Methods("DELETE")
+
+	h.Router.HandleFunc("/organizations/{id}/scim/v2/ServiceProviderConfig", orgs.SCIMAuth(orgs.SCIMServiceProviderConfig)).Methods("GET")
+	h.Router.HandleFunc("/organizations/{id}/scim/v2/ResourceTypes", orgs.SCIMAuth(orgs.SCIMResourceTypes)).Methods("GET")
+	h.Router.HandleFunc("/organizations/{id}/scim/v2/Users", orgs.SCIMAuth(orgs.SCIMListUsers)).Methods("GET")
+	h.Router.HandleFunc("/organizations/{id}/scim/v2/Users", orgs.SCIMAuth(orgs.SCIMCreateUser)).Methods("POST")
+	h.Router.HandleFunc("/organizations/{id}/scim/v2/Users/{user_id}", orgs.SCIMAuth(orgs.SCIMGetUser)).Methods("GET")
+	h.Router.HandleFunc("/organizations/{id}/scim/v2/Users/{user_id}", orgs.SCIMAuth(orgs.SCIMReplaceUser)).Methods("PUT")
+	h.Router.HandleFunc("/organizations/{id}/scim/v2/Users/{user_id}", orgs.SCIMAuth(orgs.SCIMPatchUser)).Methods("PATCH")
+	h.Router.HandleFunc("/organizations/{id}/scim/v2/Users/{user_id}", orgs.SCIMAuth(orgs.SCIMDeleteUser)).Methods("DELETE")
+	h.Router.HandleFunc("/organizations/{id}/scim/v2/Groups", orgs.SCIMAuth(orgs.SCIMListGroups)).Methods("GET")
+	h.Router.HandleFunc("/organizations/{id}/scim/v2/Groups/{group_id}", orgs.SCIMAuth(orgs.SCIMGetGroup)).Methods("GET")
+	h.Router.HandleFunc("/organizations/{id}/scim/v2/Groups/{group_id}", orgs.SCIMAuth(orgs.SCIMReplaceGroup)).Methods("PUT")
+	h.Router.HandleFunc("/organizations/{id}/scim/v2/Groups/{group_id}", orgs.SCIMAuth(orgs.SCIMPatchGroup)).Methods("PATCH")
+
+	h.Router.HandleFunc("/organizations/{id}/plugins", au.IsAuthenticated(au.RequirePermission(orgs.AddOrganizationPlugin, auth.PermPluginsInstall))).Methods("POST")                  //works
+	h.Router.HandleFunc("/organizations/{id}/plugins", au.IsAuthenticated(au.OrgSession(orgs.GetOrganizationPlugins))).Methods("GET")                                                  //works
+	h.Router.HandleFunc("/organizations/{id}/plugins/{plugin_id}", au.IsAuthenticated(au.OrgSession(orgs.GetOrganizationPlugin))).Methods("GET")                                       //works
+	h.Router.HandleFunc("/organizations/{id}/plugins/{plugin_id}", au.IsAuthenticated(au.RequirePermission(orgs.RemoveOrganizationPlugin, auth.PermPluginsInstall))).Methods("DELETE") //ask
+	h.Router.HandleFunc("/organizations/{id}/plugins/{plugin_id}/settings", au.IsAuthenticated(au.RequirePermission(orgs.GetPluginSettings, auth.PermPluginsManage))).Methods("GET")
+	h.Router.HandleFunc("/organizations/{id}/plugins/{plugin_id}/settings", au.IsAuthenticated(au.RequirePermission(orgs.UpdatePluginSettings, auth.PermPluginsManage))).Methods("PATCH")
+	h.Router.HandleFunc("/organizations/{id}/plugins/{plugin_id}/grants", au.IsAuthenticated(au.RequirePermission(orgs.UpdatePluginGrants, auth.PermPluginsManage))).Methods("PATCH")
+	h.Router.HandleFunc("/organizations/{id}/plugins/{plugin_id}/spend-cap", au.IsAuthenticated(au.RequirePermission(orgs.UpdatePluginSpendCap, auth.PermPluginsManage))).Methods("PATCH")
+	h.Router.HandleFunc("/organizations/{id}/plugins/{plugin_id}/usage", au.IsAuthenticated(au.RequirePermission(orgs.GetPluginUsage, auth.PermPluginsManage))).Methods("GET")
+
+	h.Router.HandleFunc("/organizations/{id}/exports", au.IsAuthenticated(au.RequirePermission(orgs.RequestExport, auth.PermDataExport))).Methods("POST")
+	h.Router.HandleFunc("/organizations/{id}/exports", au.IsAuthenticated(au.RequirePermission(orgs.ListExports, auth.PermDataExport))).Methods("GET")
+	h.Router.HandleFunc("/organizations/{id}/exports/{job_id}", au.IsAuthenticated(au.RequirePermission(orgs.GetExport, auth.PermDataExport))).Methods("GET")
+	h.Router.HandleFunc("/organizations/{id}/exports/{job_id}/download", au.IsAuthenticated(au.RequirePermission(orgs.DownloadExport, auth.PermDataExport))).Methods("GET")
+
+	h.Router.HandleFunc("/organizations/{id}/audit-logs", au.IsAuthenticated(au.RequirePermission(orgs.ListAuditLogs, auth.PermAuditRead))).Methods("GET")
+	h.Router.HandleFunc("/organizations/{id}/audit-logs/export", au.IsAuthenticated(au.RequirePermission(orgs.ExportAuditLogs, auth.PermAuditRead))).Methods("GET")
+
+	h.Router.HandleFunc("/organizations/{id}/roles", au.IsAuthenticated(au.IsAuthorized(orgs.ListRoles, "guest"))).Methods("GET")
+	h.Router.HandleFunc("/organizations/{id}/roles", au.IsAuthenticated(au.RequirePermission(orgs.CreateRole, auth.PermRolesManage))).Methods("POST")
+	h.Router.HandleFunc("/organizations/{id}/roles/{role_id}", au.IsAuthenticated(au.RequirePermission(orgs.UpdateRole, auth.PermRolesManage))).Methods("PATCH")
+	h.Router.HandleFunc("/organizations/{id}/roles/{role_id}", au.IsAuthenticated(au.RequirePermission(orgs.DeleteRole, auth.PermRolesManage))).Methods("DELETE")
+
+	h.Router.HandleFunc("/organizations/{id}/members", au.IsAuthenticated(au.RequirePermission(orgs.CreateMember, auth.PermMembersInvite))).Methods("POST") // done
+	h.Router.HandleFunc("/organizations/{id}/members", orgs.GetMembers).Methods("GET")                                                                      // should work
+	h.Router.HandleFunc("/organizations/{id}/members/multiple", au.IsAuthenticated(au.OrgSession(orgs.GetmultipleMembers))).Methods("GET")
+	h.Router.HandleFunc("/organizations/{id}/members/{mem_id}", au.IsAuthenticated(au.OrgSession(orgs.GetMember))).Methods("GET")
+	h.Router.HandleFunc("/organizations/{id}/members/{mem_id}", au.IsAuthenticated(au.RequirePermission(orgs.DeactivateMember, auth.PermMembersManage))).Methods("DELETE")
+	h.Router.HandleFunc("/organizations/{id}/members/{mem_id}/reactivate", au.IsAuthenticated(au.RequirePermission(orgs.ReactivateMember, auth.PermMembersManage))).Methods("POST")
+
+	h.Router.HandleFunc("/organizations/{id}/members/{mem_id}/status", au.IsAuthenticated(au.SelfOrPermission(orgs.UpdateMemberStatus, auth.PermMembersManage))).Methods("PATCH")
+	h.Router.HandleFunc("/organizations/{id}/members/{mem_id}/status/remove-history/{history_index}", au.IsAuthenticated(au.SelfOrPermission(orgs.RemoveStatusHistory, auth.PermMembersManage))).Methods("PATCH")
+	h.Router.HandleFunc("/organizations/{id}/members/{mem_id}/photo/{action}", au.IsAuthenticated(au.SelfOrPermission(orgs.UpdateProfilePicture, auth.PermMembersManage))).Methods("PATCH")
+	h.Router.HandleFunc("/organizations/{id}/members/{mem_id}/profile", au.IsAuthenticated(au.SelfOrPermission(orgs.UpdateProfile, auth.PermMembersManage))).Methods("PATCH")
+	h.Router.HandleFunc("/organizations/{id}/members/{mem_id}/uploadfile", au.IsAuthenticated(au.SelfOrPermission(orgs.UploadFile, auth.PermMembersManage))).Methods("POST")
+	h.Router.HandleFunc("/organizations/{id}/members/{mem_id}/presence", au.IsAuthenticated(au.SelfOrPermission(orgs.TogglePresence, auth.PermMembersManage))).Methods("POST")
+	h.Router.HandleFunc("/organizations/{id}/members/{mem_id}/settings", au.IsAuthenticated(au.SelfOrPermission(orgs.UpdateMemberSettings, auth.PermMembersManage))).Methods("PATCH")
+	h.Router.HandleFunc("/organizations/{id}/members/{mem_id}/role", au.IsAuthenticated(au.RequirePermission(orgs.UpdateMemberRole, auth.PermRolesManage))).Methods("PATCH")
+	h.Router.HandleFunc("/organizations/{id}/members/{mem_id}/force-logout", au.IsAuthenticated(au.IsAuthorized(orgs.ForceLogoutMember, "owner"))).Methods("POST")
+	h.Router.HandleFunc("/organizations/{id}/members/{mem_id}/settings/notification", au.IsAuthenticated(au.SelfOrPermission(orgs.UpdateNotification, auth.PermMembersManage))).Methods("PATCH")
+	h.Router.HandleFunc("/organizations/{id}/members/{mem_id}/settings/theme", au.IsAuthenticated(au.SelfOrPermission(orgs.UpdateUserTheme, auth.PermMembersManage))).Methods("PATCH")
+	h.Router.HandleFunc("/organizations/{id}/members/{mem_id}/settings/message-media", au.IsAuthenticated(au.SelfOrPermission(orgs.UpdateMemberMessageAndMediaSettings, auth.PermMembersManage))).Methods("PATCH")
+	h.Router.HandleFunc("/organizations/{id}/members/{mem_id}/settings/accessibility", au.IsAuthenticated(au.SelfOrPermission(orgs.UpdateMemberAccessibilitySettings, auth.PermMembersManage))).Methods("PATCH")
+	h.Router.HandleFunc("/organizations/{id}/members/{mem_id}/settings/languages-and-region", au.IsAuthenticated(au.SelfOrPermission(orgs.UpdateLanguagesAndRegions, auth.PermMembersManage))).Methods("PATCH")
+	h.Router.HandleFunc("/organizations/{id}/members/{mem_id}/settings/advanced", au.IsAuthenticated(au.SelfOrPermission(orgs.UpdateMemberAdvancedSettings, auth.PermMembersManage))).Methods("PATCH")
 
 	h.Router.HandleFunc("/organizations/{id}/reports", au.IsAuthenticated(reps.AddReport)).Methods("POST")
 	h.Router.HandleFunc("/organizations/{id}/reports", au.IsAuthenticated(reps.GetReports)).Methods("GET")
 	h.Router.HandleFunc("/organizations/{id}/reports/{report_id}", au.IsAuthenticated(reps.GetReport)).Methods("GET")
 
-	h.Router.HandleFunc("/organizations/{id}/billing/settings", au.IsAuthenticated(orgs.UpdateBillingSettings)).Methods("PATCH")
-	h.Router.HandleFunc("/organizations/{id}/billing/contact", au.IsAuthenticated(orgs.UpdateBillingContact)).Methods("PATCH")
+	h.Router.HandleFunc("/organizations/{id}/billing/settings", au.IsAuthenticated(au.RequirePermission(orgs.UpdateBillingSettings, auth.PermBillingManage))).Methods("PATCH")
+	h.Router.HandleFunc("/organizations/{id}/billing/contact", au.IsAuthenticated(au.RequirePermission(orgs.UpdateBillingContact, auth.PermBillingManage))).Methods("PATCH")
 
 	//organization: payment
-	h.Router.HandleFunc("/organizations/{id}/add-token", au.IsAuthenticated(orgs.AddToken)).Methods("POST") //works
-	h.Router.HandleFunc("/organizations/{id}/token-transactions", au.IsAuthenticated(orgs.GetTokenTransaction)).Methods("GET")
-	h.Router.HandleFunc("/organizations/{id}/upgrade-to-pro", au.IsAuthenticated(orgs.UpgradeToPro)).Methods("POST")                   //works
-	h.Router.HandleFunc("/organizations/{id}/charge-tokens", au.IsAuthenticated(orgs.ChargeTokens)).Methods("POST")                    //work
-	h.Router.HandleFunc("/organizations/{id}/checkout-session", au.IsAuthenticated(orgs.CreateCheckoutSession)).Methods("POST")        //work
-	h.Router.HandleFunc("/organizations/{id}/members/{mem_id}/cards", au.IsAuthenticated(orgs.AddCard)).Methods("POST")                //works
-	h.Router.HandleFunc("/organizations/{id}/members/{mem_id}/cards/{card_id}", au.IsAuthenticated(orgs.DeleteCard)).Methods("DELETE") //work
+	h.Router.HandleFunc("/organizations/{id}/add-token", au.IsAuthenticated(au.RequirePermission(orgs.AddToken, auth.PermBillingManage))).Methods("POST") //works
+	h.Router.HandleFunc("/organizations/{id}/token-transactions", au.IsAuthenticated(au.RequirePermission(orgs.GetTokenTransaction, auth.PermBillingManage))).Methods("GET")
+	h.Router.HandleFunc("/organizations/{id}/upgrade-to-pro", au.IsAuthenticated(au.RequirePermission(orgs.UpgradeToPro, auth.PermBillingManage))).Methods("POST") //works
+	h.Router.HandleFunc("/organizations/{id}/charge-tokens", au.IsAuthenticated(au.IsAuthorized(orgs.ChargeTokens, "zuri_admin"))).Methods("POST")
+	h.Router.HandleFunc("/organizations/{id}/checkout-session", au.IsAuthenticated(au.RequirePermission(orgs.CreateCheckoutSession, auth.PermBillingManage))).Methods("POST")       //work
+	h.Router.HandleFunc("/organizations/{id}/members/{mem_id}/cards", au.IsAuthenticated(au.SelfOrPermission(orgs.AddCard, auth.PermBillingManage))).Methods("POST")                //works
+	h.Router.HandleFunc("/organizations/{id}/members/{mem_id}/cards/{card_id}", au.IsAuthenticated(au.SelfOrPermission(orgs.DeleteCard, auth.PermBillingManage))).Methods("DELETE") //work
 
 	// Data
 	h.Router.HandleFunc("/data/write", data.WriteData)
@@ -162,19 +243,31 @@ func (h *Handler) SetupRoutes() {
 	h.Router.HandleFunc("/data/collections/info/{plugin_id}/{coll_name}/{org_id}", data.CollectionDetail).Methods("GET")
 
 	// Plugins
-	h.Router.HandleFunc("/plugins/register", ph.Register).Methods("POST")
-	h.Router.HandleFunc("/plugins/{id}", ph.Update).Methods("PATCH")
-	h.Router.HandleFunc("/plugins/{id}", ph.Delete).Methods("DELETE")
-	h.Router.HandleFunc("/plugins/{id}/sync", plugin.SyncUpdate).Methods("PATCH")
+	h.Router.HandleFunc("/plugins/register", au.IsAuthenticated(ph.Register)).Methods("POST")
+	h.Router.HandleFunc("/plugins/{id}", au.IsAuthenticated(ph.Update)).Methods("PATCH")
+	h.Router.HandleFunc("/plugins/{id}", au.IsAuthenticated(ph.Delete)).Methods("DELETE")
+	h.Router.HandleFunc("/plugins/{id}/sync", plugin.RequireAPIKey(plugin.SyncUpdate)).Methods("PATCH")
+	h.Router.HandleFunc("/plugins/{id}/api-key", au.IsAuthenticated(ph.RotateAPIKey)).Methods("POST")
+	h.Router.HandleFunc("/plugins/{id}/maintainers", au.IsAuthenticated(plugin.InviteMaintainer)).Methods("POST")
+	h.Router.HandleFunc("/plugins/{id}/maintainers/accept", au.IsAuthenticated(plugin.AcceptMaintainerInvite)).Methods("POST")
+	h.Router.HandleFunc("/plugins/{id}/maintainers/{email}", au.IsAuthenticated(plugin.RemoveMaintainer)).Methods("DELETE")
+	h.Router.HandleFunc("/developers/plugins", au.IsAuthenticated(plugin.GetDeveloperPlugins)).Methods("GET")
+	h.Router.HandleFunc("/plugins/{id}/organizations/{org_id}/settings", plugin.RequireAPIKey(orgs.GetPluginSettingsForPlugin)).Methods("GET")
+	h.Router.HandleFunc("/plugins/{id}/organizations/{org_id}/usage", plugin.RequireAPIKey(orgs.SubmitPluginUsage)).Methods("POST")
+	h.Router.HandleFunc("/plugins/{id}/organizations/{org_id}/groups", plugin.RequireAPIKey(orgs.GetGroupsForPlugin)).Methods("GET")
 
 	// Marketplace
-	h.Router.HandleFunc("/marketplace/plugins", marketplace.GetAllPlugins).Methods("GET")
+	h.Router.HandleFunc("/marketplace/plugins", au.IsAuthenticatedWhen("org_id", marketplace.GetAllPlugins)).Methods("GET")
 	h.Router.HandleFunc("/marketplace/plugins/popular", marketplace.GetPopularPlugins).Methods("GET")
-	h.Router.HandleFunc("/marketplace/plugins/recommended", marketplace.GetRecomendedPlugins).Methods("GET")
-	h.Router.HandleFunc("/marketplace/plugins/search", marketplace.Search).Methods("GET")
+	h.Router.HandleFunc("/marketplace/plugins/recommended", au.IsAuthenticatedWhen("org_id", marketplace.GetRecomendedPlugins)).Methods("GET")
+	h.Router.HandleFunc("/marketplace/plugins/search", au.IsAuthenticatedWhen("org_id", marketplace.Search)).Methods("GET")
 	h.Router.HandleFunc("/marketplace/plugins/{id}", marketplace.GetPlugin).Methods("GET")
 	h.Router.HandleFunc("/marketplace/plugins/urls/url", marketplace.GetPluginByURL).Methods("GET")
-	h.Router.HandleFunc("/marketplace/plugins/{id}", marketplace.RemovePlugin).Methods("DELETE")
+	h.Router.HandleFunc("/marketplace/plugins/{id}", au.IsAuthenticated(marketplace.RemovePlugin)).Methods("DELETE")
+	h.Router.HandleFunc("/marketplace/plugins/{id}/reviews", marketplace.GetReviews).Methods("GET")
+	h.Router.HandleFunc("/marketplace/plugins/{id}/reviews", au.IsAuthenticated(marketplace.AddReview)).Methods("POST")
+	h.Router.HandleFunc("/marketplace/plugins/{id}/reviews/{review_id}/reply", au.IsAuthenticated(marketplace.ReplyToReview)).Methods("POST")
+	h.Router.HandleFunc("/marketplace/plugins/{id}/reviews/{review_id}/visibility", au.IsAuthenticated(au.IsAuthorized(marketplace.SetReviewVisibility, "zuri_admin"))).Methods("PATCH")
 
 	// Users
 	h.Router.HandleFunc("/users", us.Create).Methods("POST")
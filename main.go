@@ -1,10 +1,14 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"log"
 	"net/http"
 	"os"
+	"os/signal"
+	"syscall"
 	"time"
 
 	socketio "github.com/googollee/go-socket.io"
@@ -13,6 +17,8 @@ import (
 	"github.com/stripe/stripe-go/v72"
 	transportHttp "zuri.chat/zccore/internal/transport"
 	"zuri.chat/zccore/logger"
+	"zuri.chat/zccore/organizations"
+	"zuri.chat/zccore/service"
 	"zuri.chat/zccore/utils"
 
 	sentry "github.com/getsentry/sentry-go"
@@ -24,12 +30,30 @@ type App struct {
 	Port string
 }
 
+// startBackgroundJobs runs the periodic workers until ctx is done.
+func startBackgroundJobs(ctx context.Context) {
+	configs := utils.NewConfigurations()
+	orgs := organizations.NewOrganizationHandler(configs, service.NewZcMailService(configs))
+
+	go organizations.RunOrganizationPurger(ctx, time.Hour)
+	go organizations.RunStatusExpiryScheduler(ctx, 30*time.Second)
+	go orgs.RunExportWorker(ctx, time.Minute)
+	go orgs.RunImportWorker(ctx, time.Minute)
+}
+
 func (app *App) Run() error {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
 	// Socket  events
 	var Server = socketio.NewServer(nil)
 
 	messaging.SocketEvents(Server)
 
+	if err := utils.NewConfigurations().CheckEncryptionKey(); err != nil {
+		return err
+	}
+
 	// Set Stripe api key
 	stripe.Key = os.Getenv("STRIPE_KEY")
 
@@ -54,6 +78,8 @@ func (app *App) Run() error {
 
 	sentry.CaptureMessage("It works!")
 
+	startBackgroundJobs(ctx)
+
 	// transporter
 	handler := transportHttp.NewHandler(Server)
 	handler.SetupRoutes()
@@ -76,7 +102,18 @@ func (app *App) Run() error {
 
 	logger.Info("Zuri Chat API running on port %s", app.Port)
 
-	if err := srv.ListenAndServe(); err != nil {
+	go func() {
+		<-ctx.Done()
+
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+		defer cancel()
+
+		if err := srv.Shutdown(shutdownCtx); err != nil {
+			logger.Error("could not shut down cleanly: %v", err)
+		}
+	}()
+
+	if err := srv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
 		return err
 	}
 
@@ -102,5 +139,7 @@ func main() {
 
 	app := App{Port: port}
 
-	log.Fatal(app.Run())
+	if err := app.Run(); err != nil {
+		log.Fatal(err)
+	}
 }
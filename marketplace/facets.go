@@ -0,0 +1,254 @@
+package marketplace
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+
+	"zuri.chat/zccore/auth"
+	"zuri.chat/zccore/organizations"
+	"zuri.chat/zccore/utils"
+)
+
+// minimum ratings reported in the rating facet.
+var ratingThresholds = []int{4, 3, 2, 1}
+
+var errOrgMembersOnly = errors.New("only members of the organization can see its plugins")
+
+type FacetValue struct {
+	Value interface{} `json:"value" bson:"_id"`
+	Count int64       `json:"count" bson:"count"`
+}
+
+type pluginFacetsResult struct {
+	Categories []FacetValue `bson:"categories"`
+	Tags       []FacetValue `bson:"tags"`
+	Developers []FacetValue `bson:"developers"`
+	Ratings    []FacetValue `bson:"ratings"`
+	Installed  []FacetValue `bson:"installed"`
+}
+
+// applyPluginFilters adds the marketplace's facet filters to filter:
+// category, tag (comma separated, a plugin must have all of them), developer, min_rating and
+// installed (true or false, requires org_id). It returns the ids of the plugins installed in
+// org_id, if one was given. Only members of org_id may give it.
+func applyPluginFilters(r *http.Request, filter bson.M) ([]string, error) {
+	query := r.URL.Query()
+
+	if category := query.Get("category"); category != "" {
+		filter["category"] = category
+	}
+
+	if tags := query.Get("tag"); tags != "" {
+		filter["tags"] = bson.M{"$all": strings.Split(tags, ",")}
+	}
+
+	if developer := query.Get("developer"); developer != "" {
+		filter["developer_name"] = developer
+	}
+
+	if minRating := query.Get("min_rating"); minRating != "" {
+		rating, err := strconv.ParseFloat(minRating, 64)
+		if err != nil || rating < 0 || rating > 5 {
+			return nil, errors.New("min_rating must be a number between 0 and 5")
+		}
+
+		filter["rating_average"] = bson.M{"$gte": rating}
+	}
+
+	orgID, installed := query.Get("org_id"), query.Get("installed")
+	if orgID == "" {
+		if installed != "" {
+			return nil, errors.New("the installed filter requires an org_id")
+		}
+
+		return nil, nil
+	}
+
+	if err := checkOrgMember(r, orgID); err != nil {
+		return nil, err
+	}
+
+	org, err := organizations.FetchOrganizationByID(orgID)
+	if err != nil {
+		return nil, err
+	}
+
+	ids := make([]string, 0, len(org.Plugins))
+	objIDs := make([]primitive.ObjectID, 0, len(org.Plugins))
+
+	for id := range org.Plugins {
+		ids = append(ids, id)
+
+		if objID, err := primitive.ObjectIDFromHex(id); err == nil {
+			objIDs = append(objIDs, objID)
+		}
+	}
+
+	switch installed {
+	case "":
+	case "true":
+		filter["_id"] = bson.M{"$in": objIDs}
+	case "false":
+		filter["_id"] = bson.M{"$nin": objIDs}
+	default:
+		return nil, errors.New("installed must be true or false")
+	}
+
+	return ids, nil
+}
+
+// checkOrgMember makes sure the logged in user is an active member of orgID.
+func checkOrgMember(r *http.Request, orgID string) error {
+	loggedIn, _ := r.Context().Value("user").(*auth.AuthUser)
+	if loggedIn == nil {
+		return errOrgMembersOnly
+	}
+
+	member, _ := utils.GetMongoDBDoc(organizations.MemberCollectionName, bson.M{
+		"org_id":  orgID,
+		"email":   strings.ToLower(loggedIn.Email),
+		"deleted": bson.M{"$ne": true},
+	})
+	if member == nil {
+		return errOrgMembersOnly
+	}
+
+	return nil
+}
+
+// filterErrorStatus is the status a failed applyPluginFilters is reported with.
+func filterErrorStatus(err error) int {
+	if errors.Is(err, errOrgMembersOnly) {
+		return http.StatusForbidden
+	}
+
+	return http.StatusBadRequest
+}
+
+// pluginFacets counts the plugins matching filter per category, tag, developer, minimum
+// rating and, when installed is not nil, whether they are installed in the organization.
+func pluginFacets(ctx context.Context, filter bson.M, installed []string) (utils.M, error) {
+	byCount := bson.D{{Key: "count", Value: -1}, {Key: "_id", Value: 1}}
+	count := bson.M{"$sum": 1}
+
+	facets := bson.M{
+		"categories": bson.A{
+			bson.M{"$group": bson.M{"_id": "$category", "count": count}},
+			bson.M{"$sort": byCount},
+		},
+		"tags": bson.A{
+			bson.M{"$unwind": "$tags"},
+			bson.M{"$group": bson.M{"_id": "$tags", "count": count}},
+			bson.M{"$sort": byCount},
+		},
+		"developers": bson.A{
+			bson.M{"$group": bson.M{"_id": "$developer_name", "count": count}},
+			bson.M{"$sort": byCount},
+		},
+		"ratings": bson.A{
+			bson.M{"$group": bson.M{"_id": bson.M{"$floor": bson.M{"$ifNull": bson.A{"$rating_average", 0}}}, "count": count}},
+		},
+	}
+
+	if installed != nil {
+		facets["installed"] = bson.A{
+			bson.M{"$group": bson.M{"_id": bson.M{"$in": bson.A{bson.M{"$toString": "$_id"}, installed}}, "count": count}},
+		}
+	}
+
+	pipeline := mongo.Pipeline{
+		{{Key: "$match", Value: filter}},
+		{{Key: "$facet", Value: facets}},
+	}
+
+	cursor, err := utils.GetCollection("plugins").Aggregate(ctx, pipeline)
+	if err != nil {
+		return nil, err
+	}
+
+	var results []pluginFacetsResult
+	if err = cursor.All(ctx, &results); err != nil {
+		return nil, err
+	}
+
+	var res pluginFacetsResult
+	if len(results) > 0 {
+		res = results[0]
+	}
+
+	resp := utils.M{
+		"categories": nonEmptyFacet(res.Categories),
+		"tags":       nonEmptyFacet(res.Tags),
+		"developers": nonEmptyFacet(res.Developers),
+		"min_rating": ratingFacet(res.Ratings),
+	}
+
+	if installed != nil {
+		resp["installed"] = installedFacet(res.Installed)
+	}
+
+	return resp, nil
+}
+
+func nonEmptyFacet(values []FacetValue) []FacetValue {
+	facet := []FacetValue{}
+
+	for _, v := range values {
+		if v.Value == nil || v.Value == "" {
+			continue
+		}
+
+		facet = append(facet, v)
+	}
+
+	return facet
+}
+
+// ratingFacet turns counts per whole star into counts of plugins rated at least n stars.
+func ratingFacet(values []FacetValue) []FacetValue {
+	perStar := map[int]int64{}
+
+	for _, v := range values {
+		if star, err := strconv.ParseFloat(fmt.Sprint(v.Value), 64); err == nil {
+			perStar[int(star)] += v.Count
+		}
+	}
+
+	facet := make([]FacetValue, 0, len(ratingThresholds))
+
+	for _, t := range ratingThresholds {
+		var n int64
+
+		for star, c := range perStar {
+			if star >= t {
+				n += c
+			}
+		}
+
+		facet = append(facet, FacetValue{Value: t, Count: n})
+	}
+
+	return facet
+}
+
+func installedFacet(values []FacetValue) []FacetValue {
+	facet := []FacetValue{{Value: true}, {Value: false}}
+
+	for _, v := range values {
+		if installed, _ := v.Value.(bool); installed {
+			facet[0].Count = v.Count
+		} else {
+			facet[1].Count = v.Count
+		}
+	}
+
+	return facet
+}
@@ -0,0 +1,54 @@
+package marketplace
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+func TestApplyPluginFilters(t *testing.T) {
+	tests := []struct {
+		query  string
+		want   bson.M
+		status int
+	}{
+		{"category=productivity&tag=chat,video", bson.M{"approved": true, "category": "productivity", "tags": bson.M{"$all": []string{"chat", "video"}}}, 0},
+		{"min_rating=4", bson.M{"approved": true, "rating_average": bson.M{"$gte": 4.0}}, 0},
+		{"min_rating=6", nil, http.StatusBadRequest},
+		{"installed=true", nil, http.StatusBadRequest},
+		{"org_id=6145d1e1a7f5a1f8a7d2b3c4", nil, http.StatusForbidden},
+		{"org_id=6145d1e1a7f5a1f8a7d2b3c4&installed=true", nil, http.StatusForbidden},
+	}
+
+	for _, tt := range tests {
+		r := httptest.NewRequest(http.MethodGet, "/marketplace/plugins?"+tt.query, nil)
+		filter := bson.M{"approved": true}
+
+		_, err := applyPluginFilters(r, filter)
+		if tt.status != 0 {
+			if err == nil || filterErrorStatus(err) != tt.status {
+				t.Errorf("applyPluginFilters(%q) = %v, want a %d error", tt.query, err, tt.status)
+			}
+
+			continue
+		}
+
+		if err != nil {
+			t.Errorf("applyPluginFilters(%q) = %v", tt.query, err)
+			continue
+		}
+
+		if !reflect.DeepEqual(filter, tt.want) {
+			t.Errorf("applyPluginFilters(%q) filter = %v, want %v", tt.query, filter, tt.want)
+		}
+	}
+
+	r := httptest.NewRequest(http.MethodGet, "/marketplace/plugins?org_id=6145d1e1a7f5a1f8a7d2b3c4", nil)
+	if err := checkOrgMember(r, "6145d1e1a7f5a1f8a7d2b3c4"); !errors.Is(err, errOrgMembersOnly) {
+		t.Errorf("checkOrgMember() without a logged in user = %v, want %v", err, errOrgMembersOnly)
+	}
+}
@@ -0,0 +1,124 @@
+package marketplace
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"zuri.chat/zccore/auth"
+	"zuri.chat/zccore/organizations"
+	"zuri.chat/zccore/plugin"
+)
+
+type testReviewStore struct {
+	plugins   []*plugin.Plugin
+	orgs      []*organizations.Organization
+	members   []*organizations.Member
+	reviews   []*Review
+	refreshed int
+}
+
+func (t *testReviewStore) findPlugin(ctx context.Context, id string) (*plugin.Plugin, error) {
+	for _, p := range t.plugins {
+		if p.ID.Hex() == id {
+			return p, nil
+		}
+	}
+
+	return nil, errors.New("record not found")
+}
+
+func (t *testReviewStore) findOrganization(id string) (*organizations.Organization, error) {
+	for _, o := range t.orgs {
+		if o.ID == id {
+			return o, nil
+		}
+	}
+
+	return nil, errors.New("organization not found")
+}
+
+func (t *testReviewStore) findMember(ctx context.Context, orgID, email string) (*organizations.Member, error) {
+	for _, m := range t.members {
+		if m.OrgID == orgID && m.Email == email {
+			return m, nil
+		}
+	}
+
+	return nil, errors.New("member not found")
+}
+
+func (t *testReviewStore) saveReview(ctx context.Context, rv *Review) (*Review, error) {
+	for _, v := range t.reviews {
+		if v.PluginID == rv.PluginID && v.OrgID == rv.OrgID && v.MemberID == rv.MemberID {
+			v.Rating, v.Body, v.UpdatedAt = rv.Rating, rv.Body, rv.UpdatedAt
+			return v, nil
+		}
+	}
+
+	rv.ID, rv.CreatedAt = primitive.NewObjectID(), rv.UpdatedAt
+	t.reviews = append(t.reviews, rv)
+
+	return rv, nil
+}
+
+func (t *testReviewStore) listReviews(ctx context.Context, pluginID string, limit, page int) ([]Review, int64, error) {
+	list := []Review{}
+
+	for _, v := range t.reviews {
+		if v.PluginID == pluginID && !v.Hidden {
+			list = append(list, *v)
+		}
+	}
+
+	return list, int64(len(list)), nil
+}
+
+func (t *testReviewStore) updateReview(ctx context.Context, pluginID string, reviewID primitive.ObjectID, set bson.M) error {
+	for _, v := range t.reviews {
+		if v.ID != reviewID || v.PluginID != pluginID {
+			continue
+		}
+
+		if reply, ok := set["reply"].(ReviewReply); ok {
+			v.Reply = &reply
+		}
+
+		if hidden, ok := set["hidden"].(bool); ok {
+			v.Hidden = hidden
+		}
+
+		return nil
+	}
+
+	return errReviewNotFound
+}
+
+func (t *testReviewStore) refreshRating(ctx context.Context, pluginID string) error {
+	t.refreshed++
+	return nil
+}
+
+// stubReviews makes the handlers use s, and returns a func restoring the store.
+func stubReviews(s reviewStore) func() {
+	orig := reviews
+	reviews = s
+
+	return func() { reviews = orig }
+}
+
+// withUser returns r as sent by the logged in user with the given email.
+func withUser(r *http.Request, email string) *http.Request {
+	return r.WithContext(context.WithValue(r.Context(), "user", &auth.AuthUser{Email: email}))
+}
+
+func assertStatusCode(tb testing.TB, want, got int) {
+	tb.Helper()
+	if got != want {
+		tb.Errorf("expected status code %d, but got %d", want, got)
+	}
+}
@@ -10,11 +10,22 @@ import (
 	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
+	"zuri.chat/zccore/auth"
 	"zuri.chat/zccore/plugin"
 	"zuri.chat/zccore/utils"
 )
 
+// sort orders accepted by GetAllPlugins.
+var pluginSorts = map[string]bson.D{
+	"rating":  {{Key: "rating_average", Value: -1}, {Key: "rating_count", Value: -1}},
+	"popular": {{Key: "install_count", Value: -1}},
+	"newest":  {{Key: "created_at", Value: -1}},
+	"name":    {{Key: "name", Value: 1}},
+}
+
 // GetAllPlugins returns all approved plugins available in the database.
+// The sort query parameter can be one of rating, popular, newest or name, see applyPluginFilters
+// for the supported filters. Counts per facet value are returned alongside the plugins.
 func GetAllPlugins(w http.ResponseWriter, r *http.Request) {
 	query := r.URL.Query()
 	opts := options.Find()
@@ -22,6 +33,22 @@ func GetAllPlugins(w http.ResponseWriter, r *http.Request) {
 	resp := utils.M{}
 	filter := bson.M{"approved": true}
 
+	installed, err := applyPluginFilters(r, filter)
+	if err != nil {
+		utils.GetError(err, filterErrorStatus(err), w)
+		return
+	}
+
+	if s := query.Get("sort"); s != "" {
+		sort, ok := pluginSorts[s]
+		if !ok {
+			utils.GetError(errors.New("invalid sort, use one of rating, popular, newest or name"), http.StatusBadRequest, w)
+			return
+		}
+
+		opts.SetSort(sort)
+	}
+
 	if limStr != "" || pgStr != "" {
 		limit, page := getLimitandPage(limStr, pgStr)
 		opts.SetLimit(int64(limit)).SetSkip(int64((limit * page) - limit))
@@ -39,6 +66,11 @@ func GetAllPlugins(w http.ResponseWriter, r *http.Request) {
 
 	resp["plugins"] = ps
 
+	if resp["facets"], err = pluginFacets(r.Context(), filter, installed); err != nil {
+		utils.GetError(err, http.StatusInternalServerError, w)
+		return
+	}
+
 	utils.GetSuccess("success", resp, w)
 }
 
@@ -100,6 +132,12 @@ func RemovePlugin(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	loggedInUser, _ := r.Context().Value("user").(*auth.AuthUser)
+	if loggedInUser == nil || !plugin.CanManage(r.Context(), pluginExists, loggedInUser.Email) {
+		utils.GetError(errors.New("only the plugin's owner, maintainers or a zuri admin can remove it"), http.StatusForbidden, w)
+		return
+	}
+
 	update := bson.M{"approved": false}
 
 	if _, err = utils.UpdateOneMongoDBDoc(plugin.PluginCollectionName, pluginID, update); err != nil {
@@ -128,24 +166,7 @@ func GetPopularPlugins(w http.ResponseWriter, r *http.Request) {
 	utils.GetSuccess("success", ps, w)
 }
 
-// GetPopularPlugins returns all approved plugins available in the database by popularity.
-func GetRecomendedPlugins(w http.ResponseWriter, r *http.Request) {
-	ps, err := plugin.SortPlugins(r.Context(), bson.M{"approved": true}, bson.D{primitive.E{Key: "category", Value: 1}})
-
-	if err != nil {
-		switch err {
-		case mongo.ErrNoDocuments:
-			utils.GetError(errors.New("no plugin available"), http.StatusNotFound, w)
-		default:
-			utils.GetError(err, http.StatusNotFound, w)
-		}
-
-		return
-	}
-
-	utils.GetSuccess("success", ps, w)
-}
-
+// Search does a text search over approved plugins, accepting the same filters as GetAllPlugins.
 func Search(w http.ResponseWriter, r *http.Request) {
 	query := r.URL.Query()
 	q := query.Get("q")
@@ -153,6 +174,12 @@ func Search(w http.ResponseWriter, r *http.Request) {
 	resp := utils.M{}
 	opts := options.Find()
 
+	installed, err := applyPluginFilters(r, filter)
+	if err != nil {
+		utils.GetError(err, filterErrorStatus(err), w)
+		return
+	}
+
 	if query.Get("limit") != "" || query.Get("page") != "" {
 		limit, page := getLimitandPage(query.Get("limit"), query.Get("page"))
 
@@ -165,14 +192,20 @@ func Search(w http.ResponseWriter, r *http.Request) {
 		resp["total"] = utils.CountCollection(r.Context(), "plugins", filter)
 	}
 
-	docs, err := utils.GetMongoDBDocs("plugins", filter, opts)
+	// decode into plugins so fields hidden from json, like the api key hash, never leak.
+	ps, err := plugin.FindPlugins(r.Context(), filter, opts)
 
 	if err != nil {
 		utils.GetError(err, http.StatusInternalServerError, w)
 		return
 	}
 
-	resp["plugins"] = docs
+	resp["plugins"] = ps
+
+	if resp["facets"], err = pluginFacets(r.Context(), filter, installed); err != nil {
+		utils.GetError(err, http.StatusInternalServerError, w)
+		return
+	}
 
 	utils.GetSuccess("success", resp, w)
 }
@@ -0,0 +1,195 @@
+package marketplace
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sort"
+	"strconv"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+
+	"zuri.chat/zccore/organizations"
+	"zuri.chat/zccore/plugin"
+	"zuri.chat/zccore/utils"
+)
+
+const defaultRecommendationLimit = 10
+
+// rankCoInstalled ranks plugins that are not in installed by how often they are installed
+// alongside the installed plugins in other organizations. Each organization contributes the
+// number of plugins it shares with installed to the score of every other plugin it has, so
+// organizations that look more like ours count for more. Ties, and the case where there is no
+// overlap at all, fall back to how many organizations have the plugin.
+func rankCoInstalled(installed []string, orgInstalls [][]string) []string {
+	mine := make(map[string]bool, len(installed))
+	for _, id := range installed {
+		mine[id] = true
+	}
+
+	scores := map[string]int{}
+	counts := map[string]int{}
+
+	for _, plugins := range orgInstalls {
+		overlap := 0
+
+		for _, id := range plugins {
+			if mine[id] {
+				overlap++
+			}
+		}
+
+		for _, id := range plugins {
+			if mine[id] {
+				continue
+			}
+
+			counts[id]++
+			scores[id] += overlap
+		}
+	}
+
+	ranked := make([]string, 0, len(counts))
+	for id := range counts {
+		ranked = append(ranked, id)
+	}
+
+	sort.Slice(ranked, func(i, j int) bool {
+		a, b := ranked[i], ranked[j]
+
+		if scores[a] != scores[b] {
+			return scores[a] > scores[b]
+		}
+
+		if counts[a] != counts[b] {
+			return counts[a] > counts[b]
+		}
+
+		return a < b
+	})
+
+	return ranked
+}
+
+// installedPluginIDs lists the keys of every organization's plugins map, except excludeOrgID's.
+func installedPluginIDs(ctx context.Context, excludeOrgID string) ([][]string, error) {
+	pipeline := mongo.Pipeline{
+		{{Key: "$match", Value: bson.M{"plugins": bson.M{"$exists": true, "$ne": nil}}}},
+		{{Key: "$project", Value: bson.M{
+			"plugin_ids": bson.M{"$map": bson.M{
+				"input": bson.M{"$objectToArray": "$plugins"},
+				"in":    "$$this.k",
+			}},
+		}}},
+	}
+
+	cursor, err := utils.GetCollection(organizations.OrganizationCollectionName).Aggregate(ctx, pipeline)
+	if err != nil {
+		return nil, err
+	}
+
+	var docs []struct {
+		ID        interface{} `bson:"_id"`
+		PluginIDs []string    `bson:"plugin_ids"`
+	}
+
+	if err = cursor.All(ctx, &docs); err != nil {
+		return nil, err
+	}
+
+	installs := make([][]string, 0, len(docs))
+
+	for _, d := range docs {
+		if id, ok := d.ID.(primitive.ObjectID); ok && id.Hex() == excludeOrgID {
+			continue
+		}
+
+		if id, ok := d.ID.(string); ok && id == excludeOrgID {
+			continue
+		}
+
+		installs = append(installs, d.PluginIDs)
+	}
+
+	return installs, nil
+}
+
+// GetRecomendedPlugins recommends approved plugins for an organization based on what
+// organizations with similar plugins have installed. Without an org_id it ranks plugins by
+// how many organizations have installed them. Only members of org_id may give it.
+func GetRecomendedPlugins(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	orgID := query.Get("org_id")
+
+	limit, _ := strconv.Atoi(query.Get("limit"))
+	if limit < 1 {
+		limit = defaultRecommendationLimit
+	}
+
+	var installed []string
+
+	if orgID != "" {
+		if err := checkOrgMember(r, orgID); err != nil {
+			utils.GetError(err, http.StatusForbidden, w)
+			return
+		}
+
+		org, err := organizations.FetchOrganizationByID(orgID)
+		if err != nil {
+			utils.GetError(err, http.StatusBadRequest, w)
+			return
+		}
+
+		for id := range org.Plugins {
+			installed = append(installed, id)
+		}
+	}
+
+	installs, err := installedPluginIDs(r.Context(), orgID)
+	if err != nil {
+		utils.GetError(err, http.StatusInternalServerError, w)
+		return
+	}
+
+	ranked := rankCoInstalled(installed, installs)
+
+	objIDs := make([]primitive.ObjectID, 0, len(ranked))
+
+	for _, id := range ranked {
+		if objID, err := primitive.ObjectIDFromHex(id); err == nil {
+			objIDs = append(objIDs, objID)
+		}
+	}
+
+	ps, err := plugin.FindPlugins(r.Context(), bson.M{"_id": bson.M{"$in": objIDs}, "approved": true})
+	if err != nil {
+		utils.GetError(err, http.StatusInternalServerError, w)
+		return
+	}
+
+	byID := make(map[string]*plugin.Plugin, len(ps))
+	for _, p := range ps {
+		byID[p.ID.Hex()] = p
+	}
+
+	recommended := []*plugin.Plugin{}
+
+	for _, id := range ranked {
+		if p, ok := byID[id]; ok {
+			recommended = append(recommended, p)
+		}
+
+		if len(recommended) == limit {
+			break
+		}
+	}
+
+	if len(recommended) == 0 {
+		utils.GetError(errors.New("no plugin available"), http.StatusNotFound, w)
+		return
+	}
+
+	utils.GetSuccess("success", recommended, w)
+}
@@ -0,0 +1,43 @@
+package marketplace
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestRankCoInstalled(t *testing.T) {
+	orgInstalls := [][]string{
+		{"chat", "music", "games"},
+		{"chat", "music", "todo"},
+		{"chat", "calendar"},
+		{"calendar", "todo"},
+		{"calendar", "weather"},
+		{"weather"},
+	}
+
+	t.Run("ranks by co-installation", func(t *testing.T) {
+		got := rankCoInstalled([]string{"chat", "music"}, orgInstalls)
+		want := []string{"todo", "games", "calendar", "weather"}
+
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("expected %v, but got %v", want, got)
+		}
+	})
+
+	t.Run("falls back to install counts without installed plugins", func(t *testing.T) {
+		got := rankCoInstalled(nil, orgInstalls)
+		want := []string{"calendar", "chat", "music", "todo", "weather", "games"}
+
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("expected %v, but got %v", want, got)
+		}
+	})
+
+	t.Run("never recommends installed plugins", func(t *testing.T) {
+		for _, id := range rankCoInstalled([]string{"calendar"}, orgInstalls) {
+			if id == "calendar" {
+				t.Fatal("installed plugin was recommended")
+			}
+		}
+	})
+}
@@ -0,0 +1,427 @@
+package marketplace
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-playground/validator/v10"
+	"github.com/gorilla/mux"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"zuri.chat/zccore/auth"
+	"zuri.chat/zccore/logger"
+	"zuri.chat/zccore/organizations"
+	"zuri.chat/zccore/plugin"
+	"zuri.chat/zccore/utils"
+)
+
+const ReviewCollectionName = "plugin_reviews"
+
+type Review struct {
+	ID           primitive.ObjectID `json:"_id,omitempty" bson:"_id,omitempty"`
+	PluginID     string             `json:"plugin_id" bson:"plugin_id"`
+	OrgID        string             `json:"organization_id" bson:"organization_id"`
+	MemberID     string             `json:"member_id" bson:"member_id"`
+	ReviewerName string             `json:"reviewer_name" bson:"reviewer_name"`
+	ReviewerMail string             `json:"-" bson:"reviewer_email"`
+	Rating       int                `json:"rating" bson:"rating"`
+	Body         string             `json:"review" bson:"review"`
+	Reply        *ReviewReply       `json:"reply,omitempty" bson:"reply,omitempty"`
+	Hidden       bool               `json:"hidden" bson:"hidden"`
+	HiddenReason string             `json:"hidden_reason,omitempty" bson:"hidden_reason,omitempty"`
+	HiddenBy     string             `json:"-" bson:"hidden_by,omitempty"`
+	CreatedAt    time.Time          `json:"created_at" bson:"created_at"`
+	UpdatedAt    time.Time          `json:"updated_at" bson:"updated_at"`
+}
+
+type ReviewReply struct {
+	Body      string    `json:"body" bson:"body"`
+	RepliedBy string    `json:"replied_by" bson:"replied_by"`
+	CreatedAt time.Time `json:"created_at" bson:"created_at"`
+}
+
+type reviewRequest struct {
+	OrgID  string `json:"organization_id" validate:"required"`
+	Rating int    `json:"rating" validate:"required,min=1,max=5"`
+	Body   string `json:"review" validate:"max=5000"`
+}
+
+var validate = validator.New()
+
+var errReviewNotFound = errors.New("review does not exist")
+
+var reviewIndexOnce sync.Once
+
+// reviewCollection returns the review collection. A member has one review
+// per plugin per organization, so two submissions racing can't both insert.
+func reviewCollection() *mongo.Collection {
+	coll := utils.GetCollection(ReviewCollectionName)
+
+	reviewIndexOnce.Do(func() {
+		indexModel := mongo.IndexModel{
+			Keys:    bson.D{{Key: "plugin_id", Value: 1}, {Key: "organization_id", Value: 1}, {Key: "member_id", Value: 1}},
+			Options: options.Index().SetUnique(true),
+		}
+
+		if _, err := coll.Indexes().CreateOne(context.Background(), indexModel); err != nil {
+			logger.Error("plugin reviews: could not create index: %v", err)
+		}
+	})
+
+	return coll
+}
+
+// reviewStore is where reviews, and what they are checked against, are read
+// from and written to.
+type reviewStore interface {
+	findPlugin(ctx context.Context, id string) (*plugin.Plugin, error)
+	findOrganization(id string) (*organizations.Organization, error)
+	// findMember returns the active member of orgID with the given email.
+	findMember(ctx context.Context, orgID, email string) (*organizations.Member, error)
+	// saveReview stores rv as its member's review of the plugin in the
+	// organization, replacing an earlier one but keeping when it was
+	// created and whether it is hidden.
+	saveReview(ctx context.Context, rv *Review) (*Review, error)
+	// listReviews returns a page of a plugin's visible reviews, newest first,
+	// and how many there are in all.
+	listReviews(ctx context.Context, pluginID string, limit, page int) ([]Review, int64, error)
+	// updateReview sets fields of one of a plugin's reviews, returning
+	// errReviewNotFound when there is no such review.
+	updateReview(ctx context.Context, pluginID string, reviewID primitive.ObjectID, set bson.M) error
+	// refreshRating recomputes the average rating and review count stored on
+	// a plugin.
+	refreshRating(ctx context.Context, pluginID string) error
+}
+
+var reviews reviewStore = mongoReviewStore{}
+
+// AddReview creates or updates the logged in member's review of a plugin installed in their organization.
+// A member has at most one review per plugin per organization.
+func AddReview(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	pluginID := mux.Vars(r)["id"]
+
+	var body reviewRequest
+	if err := utils.ParseJSONFromRequest(r, &body); err != nil {
+		utils.GetError(err, http.StatusUnprocessableEntity, w)
+		return
+	}
+
+	if err := validate.Struct(body); err != nil {
+		utils.GetError(err, http.StatusBadRequest, w)
+		return
+	}
+
+	loggedInUser, ok := r.Context().Value("user").(*auth.AuthUser)
+	if !ok {
+		utils.GetError(errors.New("invalid user"), http.StatusBadRequest, w)
+		return
+	}
+
+	if _, err := reviews.findPlugin(r.Context(), pluginID); err != nil {
+		utils.GetError(errors.New("plugin does not exist"), http.StatusNotFound, w)
+		return
+	}
+
+	org, err := reviews.findOrganization(body.OrgID)
+	if err != nil {
+		utils.GetError(err, http.StatusBadRequest, w)
+		return
+	}
+
+	if _, ok := org.Plugins[pluginID]; !ok {
+		utils.GetError(errors.New("plugin is not installed in this organization"), http.StatusForbidden, w)
+		return
+	}
+
+	member, err := reviews.findMember(r.Context(), body.OrgID, strings.ToLower(loggedInUser.Email))
+	if err != nil {
+		utils.GetError(errors.New("only members of the organization can review its plugins"), http.StatusForbidden, w)
+		return
+	}
+
+	reviewerName := member.DisplayName
+	if reviewerName == "" {
+		reviewerName = member.UserName
+	}
+
+	review, err := reviews.saveReview(r.Context(), &Review{
+		PluginID:     pluginID,
+		OrgID:        body.OrgID,
+		MemberID:     member.ID,
+		ReviewerName: reviewerName,
+		ReviewerMail: member.Email,
+		Rating:       body.Rating,
+		Body:         body.Body,
+		UpdatedAt:    time.Now(),
+	})
+	if err != nil {
+		utils.GetError(err, http.StatusInternalServerError, w)
+		return
+	}
+
+	if err = reviews.refreshRating(r.Context(), pluginID); err != nil {
+		utils.GetError(err, http.StatusInternalServerError, w)
+		return
+	}
+
+	utils.GetSuccess("review saved successfully", review, w)
+}
+
+// GetReviews returns a plugin's visible reviews, newest first.
+func GetReviews(w http.ResponseWriter, r *http.Request) {
+	pluginID := mux.Vars(r)["id"]
+	query := r.URL.Query()
+	limit, page := getLimitandPage(query.Get("limit"), query.Get("page"))
+
+	list, total, err := reviews.listReviews(r.Context(), pluginID, limit, page)
+	if err != nil {
+		utils.GetError(err, http.StatusInternalServerError, w)
+		return
+	}
+
+	resp := utils.M{
+		"reviews": list,
+		"page":    page,
+		"limit":   limit,
+		"total":   total,
+	}
+
+	utils.GetSuccess("success", resp, w)
+}
+
+// ReplyToReview lets the plugin's owner or maintainers publicly respond to a review.
+func ReplyToReview(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	pluginID, reviewID := mux.Vars(r)["id"], mux.Vars(r)["review_id"]
+
+	body := struct {
+		Body string `json:"body" validate:"required,max=5000"`
+	}{}
+
+	if err := utils.ParseJSONFromRequest(r, &body); err != nil {
+		utils.GetError(err, http.StatusUnprocessableEntity, w)
+		return
+	}
+
+	if err := validate.Struct(body); err != nil {
+		utils.GetError(err, http.StatusBadRequest, w)
+		return
+	}
+
+	loggedInUser, ok := r.Context().Value("user").(*auth.AuthUser)
+	if !ok {
+		utils.GetError(errors.New("invalid user"), http.StatusBadRequest, w)
+		return
+	}
+
+	p, err := reviews.findPlugin(r.Context(), pluginID)
+	if err != nil {
+		utils.GetError(errors.New("plugin does not exist"), http.StatusNotFound, w)
+		return
+	}
+
+	if !p.IsMaintainer(loggedInUser.Email) {
+		utils.GetError(errors.New("only the plugin's developers can reply to reviews"), http.StatusForbidden, w)
+		return
+	}
+
+	reply := ReviewReply{Body: body.Body, RepliedBy: p.DeveloperName, CreatedAt: time.Now()}
+
+	if err := setReview(w, r, pluginID, reviewID, bson.M{"reply": reply}); err != nil {
+		return
+	}
+
+	utils.GetSuccess("reply saved successfully", reply, w)
+}
+
+// SetReviewVisibility lets zuri admins hide abusive reviews, or restore them.
+// Hidden reviews are excluded from listings and from the plugin's rating.
+func SetReviewVisibility(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	pluginID, reviewID := mux.Vars(r)["id"], mux.Vars(r)["review_id"]
+
+	body := struct {
+		Hidden bool   `json:"hidden"`
+		Reason string `json:"reason"`
+	}{}
+
+	if err := utils.ParseJSONFromRequest(r, &body); err != nil {
+		utils.GetError(err, http.StatusUnprocessableEntity, w)
+		return
+	}
+
+	loggedInUser, ok := r.Context().Value("user").(*auth.AuthUser)
+	if !ok {
+		utils.GetError(errors.New("invalid user"), http.StatusBadRequest, w)
+		return
+	}
+
+	set := bson.M{"hidden": body.Hidden, "hidden_reason": body.Reason, "hidden_by": loggedInUser.Email}
+	if err := setReview(w, r, pluginID, reviewID, set); err != nil {
+		return
+	}
+
+	if err := reviews.refreshRating(r.Context(), pluginID); err != nil {
+		utils.GetError(err, http.StatusInternalServerError, w)
+		return
+	}
+
+	utils.GetSuccess("review updated successfully", nil, w)
+}
+
+// setReview sets fields of a review, answering the request itself when that
+// fails.
+func setReview(w http.ResponseWriter, r *http.Request, pluginID, reviewID string, set bson.M) error {
+	objID, err := primitive.ObjectIDFromHex(reviewID)
+	if err != nil {
+		err = errors.New("invalid review id")
+		utils.GetError(err, http.StatusBadRequest, w)
+
+		return err
+	}
+
+	set["updated_at"] = time.Now()
+
+	err = reviews.updateReview(r.Context(), pluginID, objID, set)
+
+	switch {
+	case errors.Is(err, errReviewNotFound):
+		utils.GetError(err, http.StatusNotFound, w)
+	case err != nil:
+		utils.GetError(err, http.StatusBadRequest, w)
+	}
+
+	return err
+}
+
+// mongoReviewStore is the reviewStore backed by the database.
+type mongoReviewStore struct{}
+
+func (mongoReviewStore) findPlugin(ctx context.Context, id string) (*plugin.Plugin, error) {
+	return plugin.FindPluginByID(ctx, id)
+}
+
+func (mongoReviewStore) findOrganization(id string) (*organizations.Organization, error) {
+	return organizations.FetchOrganizationByID(id)
+}
+
+func (mongoReviewStore) findMember(ctx context.Context, orgID, email string) (*organizations.Member, error) {
+	filter := bson.M{"org_id": orgID, "email": email, "deleted": bson.M{"$ne": true}}
+
+	var member organizations.Member
+	if err := utils.GetCollection(organizations.MemberCollectionName).FindOne(ctx, filter).Decode(&member); err != nil {
+		return nil, err
+	}
+
+	return &member, nil
+}
+
+func (mongoReviewStore) saveReview(ctx context.Context, rv *Review) (*Review, error) {
+	filter := bson.M{"plugin_id": rv.PluginID, "organization_id": rv.OrgID, "member_id": rv.MemberID}
+	update := bson.M{
+		"$set": bson.M{
+			"rating":         rv.Rating,
+			"review":         rv.Body,
+			"reviewer_name":  rv.ReviewerName,
+			"reviewer_email": rv.ReviewerMail,
+			"updated_at":     rv.UpdatedAt,
+		},
+		"$setOnInsert": bson.M{"hidden": false, "created_at": rv.UpdatedAt},
+	}
+
+	opts := options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.After)
+
+	var review Review
+
+	err := reviewCollection().FindOneAndUpdate(ctx, filter, update, opts).Decode(&review)
+	if mongo.IsDuplicateKeyError(err) {
+		// another submission inserted the review first, update that one.
+		err = reviewCollection().FindOneAndUpdate(ctx, filter, update, opts).Decode(&review)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &review, nil
+}
+
+func (mongoReviewStore) listReviews(ctx context.Context, pluginID string, limit, page int) ([]Review, int64, error) {
+	filter := bson.M{"plugin_id": pluginID, "hidden": false}
+
+	opts := options.Find().
+		SetSort(bson.D{{Key: "created_at", Value: -1}}).
+		SetLimit(int64(limit)).
+		SetSkip(int64((limit * page) - limit))
+
+	cursor, err := reviewCollection().Find(ctx, filter, opts)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	list := []Review{}
+	if err = cursor.All(ctx, &list); err != nil {
+		return nil, 0, err
+	}
+
+	return list, utils.CountCollection(ctx, ReviewCollectionName, filter), nil
+}
+
+func (mongoReviewStore) updateReview(ctx context.Context, pluginID string, reviewID primitive.ObjectID, set bson.M) error {
+	res, err := reviewCollection().UpdateOne(ctx,
+		bson.M{"_id": reviewID, "plugin_id": pluginID}, bson.M{"$set": set})
+	if err != nil {
+		return err
+	}
+
+	if res.MatchedCount == 0 {
+		return errReviewNotFound
+	}
+
+	return nil
+}
+
+func (mongoReviewStore) refreshRating(ctx context.Context, pluginID string) error {
+	pipeline := mongo.Pipeline{
+		{{Key: "$match", Value: bson.M{"plugin_id": pluginID, "hidden": false}}},
+		{{Key: "$group", Value: bson.M{
+			"_id":     nil,
+			"average": bson.M{"$avg": "$rating"},
+			"count":   bson.M{"$sum": 1},
+		}}},
+	}
+
+	cursor, err := reviewCollection().Aggregate(ctx, pipeline)
+	if err != nil {
+		return err
+	}
+
+	var result []struct {
+		Average float64 `bson:"average"`
+		Count   int64   `bson:"count"`
+	}
+
+	if err = cursor.All(ctx, &result); err != nil {
+		return err
+	}
+
+	rating := bson.M{"rating_average": 0.0, "rating_count": int64(0)}
+	if len(result) > 0 {
+		rating["rating_average"], rating["rating_count"] = result[0].Average, result[0].Count
+	}
+
+	_, err = utils.UpdateOneMongoDBDoc(plugin.PluginCollectionName, pluginID, rating)
+
+	return err
+}
@@ -0,0 +1,268 @@
+package marketplace
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"zuri.chat/zccore/organizations"
+	"zuri.chat/zccore/plugin"
+)
+
+const testOrgID = "6145d1e1a7f5a1f8a7d2b3c4"
+
+// newTestReviewStore returns a store holding a plugin installed in an organization with one member.
+func newTestReviewStore() *testReviewStore {
+	p := &plugin.Plugin{
+		ID:            primitive.NewObjectID(),
+		DeveloperName: "Acme",
+		OwnerEmail:    "owner@zuri.chat",
+		Maintainers:   []string{"maintainer@zuri.chat"},
+	}
+
+	return &testReviewStore{
+		plugins: []*plugin.Plugin{p},
+		orgs: []*organizations.Organization{
+			{ID: testOrgID, Plugins: map[string]interface{}{p.ID.Hex(): true}},
+		},
+		members: []*organizations.Member{
+			{ID: "member-1", OrgID: testOrgID, Email: "member@zuri.chat", UserName: "member"},
+		},
+	}
+}
+
+func TestAddReview(t *testing.T) {
+	newRequest := func(pluginID, body, email string) *http.Request {
+		r, _ := http.NewRequest("POST", fmt.Sprintf("/marketplace/plugins/%s/reviews", pluginID), strings.NewReader(body))
+		r = mux.SetURLVars(r, map[string]string{"id": pluginID})
+
+		if email == "" {
+			return r
+		}
+
+		return withUser(r, email)
+	}
+
+	valid := fmt.Sprintf(`{"organization_id": %q, "rating": 4, "review": "works well"}`, testOrgID)
+
+	tests := []struct {
+		name     string
+		pluginID string
+		body     string
+		email    string
+		code     int
+	}{
+		{"missing organization", "", `{"rating": 4}`, "member@zuri.chat", 400},
+		{"rating out of range", "", fmt.Sprintf(`{"organization_id": %q, "rating": 6}`, testOrgID), "member@zuri.chat", 400},
+		{"no logged in user", "", valid, "", 400},
+		{"unknown plugin", primitive.NewObjectID().Hex(), valid, "member@zuri.chat", 404},
+		{"unknown organization", "", `{"organization_id": "unknown", "rating": 4}`, "member@zuri.chat", 400},
+		{"not a member of the organization", "", valid, "stranger@zuri.chat", 403},
+		{"member of the organization", "", valid, "Member@zuri.chat", 200},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := newTestReviewStore()
+			defer stubReviews(store)()
+
+			pluginID := tt.pluginID
+			if pluginID == "" {
+				pluginID = store.plugins[0].ID.Hex()
+			}
+
+			w := httptest.NewRecorder()
+
+			AddReview(w, newRequest(pluginID, tt.body, tt.email))
+
+			assertStatusCode(t, tt.code, w.Code)
+
+			want := 0
+			if tt.code == 200 {
+				want = 1
+			}
+
+			if len(store.reviews) != want || store.refreshed != want {
+				t.Errorf("saved %d reviews and refreshed the rating %d times, want %d", len(store.reviews), store.refreshed, want)
+			}
+		})
+	}
+
+	t.Run("plugin not installed in the organization", func(t *testing.T) {
+		store := newTestReviewStore()
+		store.orgs[0].Plugins = map[string]interface{}{}
+		defer stubReviews(store)()
+
+		w := httptest.NewRecorder()
+
+		AddReview(w, newRequest(store.plugins[0].ID.Hex(), valid, "member@zuri.chat"))
+
+		assertStatusCode(t, 403, w.Code)
+	})
+
+	t.Run("reviewing again replaces the earlier review", func(t *testing.T) {
+		store := newTestReviewStore()
+		defer stubReviews(store)()
+
+		pluginID := store.plugins[0].ID.Hex()
+		AddReview(httptest.NewRecorder(), newRequest(pluginID, valid, "member@zuri.chat"))
+
+		w := httptest.NewRecorder()
+		AddReview(w, newRequest(pluginID, fmt.Sprintf(`{"organization_id": %q, "rating": 2}`, testOrgID), "member@zuri.chat"))
+
+		assertStatusCode(t, 200, w.Code)
+
+		if len(store.reviews) != 1 || store.reviews[0].Rating != 2 {
+			t.Errorf("expected a single review rated 2, but got %d reviews", len(store.reviews))
+		}
+	})
+}
+
+func TestGetReviews(t *testing.T) {
+	store := newTestReviewStore()
+	defer stubReviews(store)()
+
+	pluginID := store.plugins[0].ID.Hex()
+	store.reviews = []*Review{
+		{ID: primitive.NewObjectID(), PluginID: pluginID, Rating: 5},
+		{ID: primitive.NewObjectID(), PluginID: pluginID, Rating: 1, Hidden: true},
+	}
+
+	r, _ := http.NewRequest("GET", fmt.Sprintf("/marketplace/plugins/%s/reviews", pluginID), nil)
+	r = mux.SetURLVars(r, map[string]string{"id": pluginID})
+	w := httptest.NewRecorder()
+
+	GetReviews(w, r)
+
+	assertStatusCode(t, 200, w.Code)
+
+	var resp struct {
+		Data struct {
+			Reviews []Review `json:"reviews"`
+			Total   int64    `json:"total"`
+			Limit   int      `json:"limit"`
+			Page    int      `json:"page"`
+		} `json:"data"`
+	}
+
+	if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(resp.Data.Reviews) != 1 || resp.Data.Total != 1 {
+		t.Errorf("expected only the visible review, but got %d of %d", len(resp.Data.Reviews), resp.Data.Total)
+	}
+
+	if resp.Data.Limit != 10 || resp.Data.Page != 1 {
+		t.Errorf("expected limit 10 and page 1, but got %d and %d", resp.Data.Limit, resp.Data.Page)
+	}
+}
+
+func TestReplyToReview(t *testing.T) {
+	newRequest := func(pluginID, reviewID, email string) *http.Request {
+		url := fmt.Sprintf("/marketplace/plugins/%s/reviews/%s/reply", pluginID, reviewID)
+		r, _ := http.NewRequest("POST", url, strings.NewReader(`{"body": "thanks"}`))
+		r = mux.SetURLVars(r, map[string]string{"id": pluginID, "review_id": reviewID})
+
+		return withUser(r, email)
+	}
+
+	tests := []struct {
+		name     string
+		email    string
+		reviewID string
+		code     int
+	}{
+		{"owners can reply", "owner@zuri.chat", "", 200},
+		{"maintainers can reply", "Maintainer@zuri.chat", "", 200},
+		{"other users cannot reply", "member@zuri.chat", "", 403},
+		{"invalid review id", "owner@zuri.chat", "not-an-id", 400},
+		{"unknown review", "owner@zuri.chat", primitive.NewObjectID().Hex(), 404},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := newTestReviewStore()
+			defer stubReviews(store)()
+
+			pluginID := store.plugins[0].ID.Hex()
+			store.reviews = []*Review{{ID: primitive.NewObjectID(), PluginID: pluginID, Rating: 3}}
+
+			reviewID := tt.reviewID
+			if reviewID == "" {
+				reviewID = store.reviews[0].ID.Hex()
+			}
+
+			w := httptest.NewRecorder()
+
+			ReplyToReview(w, newRequest(pluginID, reviewID, tt.email))
+
+			assertStatusCode(t, tt.code, w.Code)
+
+			if replied := store.reviews[0].Reply != nil; replied != (tt.code == 200) {
+				t.Errorf("expected the review to be replied to: %v, but got %v", tt.code == 200, replied)
+			}
+		})
+	}
+
+	t.Run("a review of another plugin", func(t *testing.T) {
+		store := newTestReviewStore()
+		defer stubReviews(store)()
+
+		review := &Review{ID: primitive.NewObjectID(), PluginID: primitive.NewObjectID().Hex()}
+		store.reviews = []*Review{review}
+		w := httptest.NewRecorder()
+
+		ReplyToReview(w, newRequest(store.plugins[0].ID.Hex(), review.ID.Hex(), "owner@zuri.chat"))
+
+		assertStatusCode(t, 404, w.Code)
+	})
+}
+
+func TestSetReviewVisibility(t *testing.T) {
+	store := newTestReviewStore()
+	defer stubReviews(store)()
+
+	pluginID := store.plugins[0].ID.Hex()
+	review := &Review{ID: primitive.NewObjectID(), PluginID: pluginID, Rating: 1}
+	store.reviews = []*Review{review}
+
+	for _, tt := range []struct {
+		body   string
+		code   int
+		hidden bool
+	}{
+		{`{"hidden": true, "reason": "spam"}`, 200, true},
+		{`{"hidden": false}`, 200, false},
+	} {
+		r, _ := http.NewRequest("PATCH", fmt.Sprintf("/marketplace/plugins/%s/reviews/%s/visibility", pluginID, review.ID.Hex()), strings.NewReader(tt.body))
+		r = mux.SetURLVars(r, map[string]string{"id": pluginID, "review_id": review.ID.Hex()})
+		w := httptest.NewRecorder()
+
+		SetReviewVisibility(w, withUser(r, "admin@zuri.chat"))
+
+		assertStatusCode(t, tt.code, w.Code)
+
+		if review.Hidden != tt.hidden {
+			t.Errorf("expected hidden to be %v, but got %v", tt.hidden, review.Hidden)
+		}
+	}
+
+	if store.refreshed != 2 {
+		t.Errorf("expected the rating to be refreshed 2 times, but got %d", store.refreshed)
+	}
+
+	r, _ := http.NewRequest("PATCH", "/", strings.NewReader(`{"hidden": true}`))
+	r = mux.SetURLVars(r, map[string]string{"id": pluginID, "review_id": primitive.NewObjectID().Hex()})
+	w := httptest.NewRecorder()
+
+	SetReviewVisibility(w, withUser(r, "admin@zuri.chat"))
+
+	assertStatusCode(t, 404, w.Code)
+}
@@ -0,0 +1,252 @@
+package oidc
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt"
+)
+
+const (
+	testClientID = "zuri-test"
+	testCode     = "auth-code"
+)
+
+// mockProvider is a minimal OIDC provider: discovery, JWKS and a token
+// endpoint that checks the PKCE verifier before handing out an ID token.
+type mockProvider struct {
+	*httptest.Server
+	key       *rsa.PrivateKey
+	kid       string
+	challenge string
+	idToken   string
+	jwksHits  int
+}
+
+func newMockProvider(t *testing.T) *mockProvider {
+	t.Helper()
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	m := &mockProvider{key: key, kid: "key-1"}
+	mux := http.NewServeMux()
+
+	mux.HandleFunc(discoveryPath, func(w http.ResponseWriter, r *http.Request) {
+		//nolint:errcheck //test server
+		json.NewEncoder(w).Encode(Metadata{
+			Issuer:                m.URL,
+			AuthorizationEndpoint: m.URL + "/authorize",
+			TokenEndpoint:         m.URL + "/token",
+			JWKSURI:               m.URL + "/jwks",
+		})
+	})
+
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		m.jwksHits++
+		//nolint:errcheck //test server
+		json.NewEncoder(w).Encode(map[string]interface{}{"keys": []jwk{{
+			Kid: m.kid,
+			Kty: "RSA",
+			Use: "sig",
+			N:   base64.RawURLEncoding.EncodeToString(m.key.N.Bytes()),
+			E:   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(m.key.E)).Bytes()),
+		}}})
+	})
+
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		if err := r.ParseForm(); err != nil ||
+			r.PostForm.Get("code") != testCode ||
+			r.PostForm.Get("client_id") != testClientID ||
+			PKCEChallenge(r.PostForm.Get("code_verifier")) != m.challenge {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		//nolint:errcheck //test server
+		json.NewEncoder(w).Encode(TokenResponse{AccessToken: "access", TokenType: "Bearer", IDToken: m.idToken})
+	})
+
+	m.Server = httptest.NewServer(mux)
+	t.Cleanup(m.Close)
+
+	return m
+}
+
+func (m *mockProvider) sign(t *testing.T, key *rsa.PrivateKey, kid string, claims jwt.MapClaims) string {
+	t.Helper()
+
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+	token.Header["kid"] = kid
+
+	s, err := token.SignedString(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return s
+}
+
+func (m *mockProvider) claims(nonce string) jwt.MapClaims {
+	return jwt.MapClaims{
+		"iss":            m.URL,
+		"aud":            testClientID,
+		"sub":            "user-123",
+		"email":          "jane@example.com",
+		"email_verified": true,
+		"nonce":          nonce,
+		"iat":            time.Now().Unix(),
+		"exp":            time.Now().Add(time.Hour).Unix(),
+	}
+}
+
+func (m *mockProvider) provider() *Provider {
+	return NewProvider(Config{
+		Name:        "mock",
+		Issuer:      m.URL,
+		ClientID:    testClientID,
+		RedirectURL: "https://api.zuri.chat/auth/oidc/mock/callback",
+	}, m.Client())
+}
+
+func TestAuthorizationCodeFlow(t *testing.T) {
+	m := newMockProvider(t)
+	p := m.provider()
+	ctx := context.Background()
+
+	verifier, challenge, err := NewPKCE()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	authURL, err := p.AuthCodeURL(ctx, "state-1", "nonce-1", challenge)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	u, _ := url.Parse(authURL)
+	q := u.Query()
+
+	for k, want := range map[string]string{
+		"client_id": testClientID, "state": "state-1", "nonce": "nonce-1",
+		"code_challenge": challenge, "code_challenge_method": "S256", "response_type": "code",
+	} {
+		if got := q.Get(k); got != want {
+			t.Errorf("auth url %s = %q, want %q", k, got, want)
+		}
+	}
+
+	m.challenge = q.Get("code_challenge")
+	m.idToken = m.sign(t, m.key, m.kid, m.claims("nonce-1"))
+
+	if _, err := p.Exchange(ctx, testCode, "wrong-verifier"); err == nil {
+		t.Error("Exchange accepted a wrong PKCE verifier")
+	}
+
+	tokens, err := p.Exchange(ctx, testCode, verifier)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	claims, err := p.VerifyIDToken(ctx, tokens.IDToken, "nonce-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if claims.Subject != "user-123" || claims.Email != "jane@example.com" || !claims.EmailVerified {
+		t.Errorf("unexpected claims %+v", claims)
+	}
+}
+
+func TestVerifyIDTokenRejects(t *testing.T) {
+	m := newMockProvider(t)
+	p := m.provider()
+	ctx := context.Background()
+
+	other, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name   string
+		token  func() string
+		nonce  string
+		target error
+	}{
+		{"wrong nonce", func() string { return m.sign(t, m.key, m.kid, m.claims("nonce-1")) }, "nonce-2", ErrNonceMismatch},
+		{"wrong audience", func() string {
+			c := m.claims("n")
+			c["aud"] = "someone-else"
+			return m.sign(t, m.key, m.kid, c)
+		}, "n", ErrInvalidIDToken},
+		{"wrong issuer", func() string {
+			c := m.claims("n")
+			c["iss"] = "https://evil.example.com"
+			return m.sign(t, m.key, m.kid, c)
+		}, "n", ErrInvalidIDToken},
+		{"expired", func() string {
+			c := m.claims("n")
+			c["exp"] = time.Now().Add(-time.Minute).Unix()
+			return m.sign(t, m.key, m.kid, c)
+		}, "n", ErrInvalidIDToken},
+		{"forged signature", func() string { return m.sign(t, other, m.kid, m.claims("n")) }, "n", ErrInvalidIDToken},
+		{"hmac token", func() string {
+			s, _ := jwt.NewWithClaims(jwt.SigningMethodHS256, m.claims("n")).SignedString([]byte(testClientID))
+			return s
+		}, "n", ErrInvalidIDToken},
+	}
+
+	for _, tt := range tests {
+		if _, err := p.VerifyIDToken(ctx, tt.token(), tt.nonce); !errors.Is(err, tt.target) {
+			t.Errorf("%s: VerifyIDToken error = %v, want %v", tt.name, err, tt.target)
+		}
+	}
+}
+
+func TestUnknownKeyRefetchIsRateLimited(t *testing.T) {
+	m := newMockProvider(t)
+	p := m.provider()
+	ctx := context.Background()
+
+	if _, err := p.VerifyIDToken(ctx, m.sign(t, m.key, m.kid, m.claims("n")), "n"); err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 3; i++ {
+		if _, err := p.VerifyIDToken(ctx, m.sign(t, m.key, "rotated", m.claims("n")), "n"); err == nil {
+			t.Fatal("VerifyIDToken accepted an unknown key id")
+		}
+	}
+
+	if m.jwksHits != 1 {
+		t.Errorf("jwks fetched %d times, want 1", m.jwksHits)
+	}
+}
+
+func TestParseConfigs(t *testing.T) {
+	configs, err := ParseConfigs(`[{"name":"okta","issuer":"https://example.okta.com","client_id":"id","redirect_url":"https://x/cb"}]`)
+	if err != nil || len(configs) != 1 || configs[0].Name != "okta" {
+		t.Fatalf("ParseConfigs = %v, %v", configs, err)
+	}
+
+	if _, err := ParseConfigs(`[{"name":"okta"}]`); err == nil {
+		t.Error("ParseConfigs accepted a provider without an issuer")
+	}
+
+	if configs, err := ParseConfigs(""); err != nil || configs != nil {
+		t.Errorf("ParseConfigs(\"\") = %v, %v", configs, err)
+	}
+}
@@ -0,0 +1,359 @@
+package organizations
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"zuri.chat/zccore/auth"
+	"zuri.chat/zccore/utils"
+)
+
+// AuditLogCollectionName holds the organization audit trail. Entries are only
+// ever inserted, nothing in the API updates or deletes them.
+const AuditLogCollectionName = "organization_audit_logs"
+
+// audit actions.
+const (
+	AuditOrganizationUpdated  = "organization.updated"
+	AuditSettingsUpdated      = "organization.settings_updated"
+	AuditOwnershipTransferred = "organization.ownership_transferred"
+	AuditDeletionScheduled    = "organization.deletion_scheduled"
+	AuditOrganizationRestored = "organization.restored"
+	AuditOrganizationPurged   = "organization.purged"
+	AuditMemberDeactivated    = "member.deactivated"
+	AuditMemberRoleChanged    = "member.role_changed"
+	AuditMembersImported      = "member.imported"
+	AuditMemberJoined         = "member.joined"
+	AuditDomainAdded          = "domain.added"
+	AuditDomainVerified       = "domain.verified"
+	AuditDomainRemoved        = "domain.removed"
+	AuditSCIMTokenCreated     = "scim_token.created"
+	AuditSCIMTokenRevoked     = "scim_token.revoked"
+	AuditMemberProvisioned    = "member.provisioned"
+	AuditMemberUpdated        = "member.updated"
+	AuditMemberReactivated    = "member.reactivated"
+	AuditPluginInstalled      = "plugin.installed"
+	AuditRoleCreated          = "role.created"
+	AuditRoleUpdated          = "role.updated"
+	AuditRoleDeleted          = "role.deleted"
+	AuditGroupCreated         = "group.created"
+	AuditGroupUpdated         = "group.updated"
+	AuditGroupDeleted         = "group.deleted"
+	AuditGroupMembersChanged  = "group.members_changed"
+)
+
+const (
+	defaultAuditLogLimit = 50
+	maxAuditLogLimit     = 200
+)
+
+var auditIndexOnce sync.Once
+
+// AuditChange is one field an audited action changed.
+type AuditChange struct {
+	Field  string      `bson:"field" json:"field"`
+	Before interface{} `bson:"before" json:"before"`
+	After  interface{} `bson:"after" json:"after"`
+}
+
+type AuditEvent struct {
+	ID         primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	OrgID      string             `bson:"org_id" json:"org_id"`
+	Action     string             `bson:"action" json:"action"`
+	ActorID    string             `bson:"actor_id" json:"actor_id"`
+	ActorEmail string             `bson:"actor_email" json:"actor_email"`
+	TokenID    string             `bson:"token_id,omitempty" json:"token_id,omitempty"`
+	TargetType string             `bson:"target_type" json:"target_type"`
+	TargetID   string             `bson:"target_id" json:"target_id"`
+	Changes    []AuditChange      `bson:"changes" json:"changes"`
+	IPAddress  string             `bson:"ip_address" json:"ip_address"`
+	UserAgent  string             `bson:"user_agent" json:"user_agent"`
+	CreatedAt  time.Time          `bson:"created_at" json:"created_at"`
+}
+
+func auditLogs() *mongo.Collection {
+	coll := utils.GetCollection(AuditLogCollectionName)
+
+	auditIndexOnce.Do(func() {
+		indexModel := mongo.IndexModel{Keys: bson.D{{Key: "org_id", Value: 1}, {Key: "created_at", Value: -1}}}
+
+		if _, err := coll.Indexes().CreateOne(context.Background(), indexModel); err != nil {
+			log.Printf("audit logs: could not create index: %v", err)
+		}
+	})
+
+	return coll
+}
+
+// flattenDoc turns v into a map of dotted field paths to values, the way it
+// would be stored.
+func flattenDoc(v interface{}) map[string]interface{} {
+	out := make(map[string]interface{})
+	if v == nil {
+		return out
+	}
+
+	raw, err := bson.Marshal(v)
+	if err != nil {
+		return out
+	}
+
+	var doc bson.M
+	if err := bson.Unmarshal(raw, &doc); err != nil {
+		return out
+	}
+
+	flattenInto(out, "", doc)
+
+	return out
+}
+
+func flattenInto(out map[string]interface{}, prefix string, doc bson.M) {
+	for k, v := range doc {
+		key := k
+		if prefix != "" {
+			key = prefix + "." + k
+		}
+
+		if sub, ok := v.(bson.M); ok && len(sub) > 0 {
+			flattenInto(out, key, sub)
+			continue
+		}
+
+		out[key] = v
+	}
+}
+
+// auditDiff lists the fields whose values differ between before and after,
+// sorted by field. Either side may be nil.
+func auditDiff(before, after interface{}) []AuditChange {
+	b, a := flattenDoc(before), flattenDoc(after)
+	changes := []AuditChange{}
+
+	for field, bv := range b {
+		if av, ok := a[field]; !ok || !reflect.DeepEqual(av, bv) {
+			changes = append(changes, AuditChange{Field: field, Before: bv, After: a[field]})
+		}
+	}
+
+	for field, av := range a {
+		if _, ok := b[field]; !ok {
+			changes = append(changes, AuditChange{Field: field, After: av})
+		}
+	}
+
+	sort.Slice(changes, func(i, j int) bool { return changes[i].Field < changes[j].Field })
+
+	return changes
+}
+
+// auditActorID returns the id of the user behind loggedIn. Its own id is the
+// session's or the access token's, so the user is looked up by email.
+func auditActorID(loggedIn *auth.AuthUser) string {
+	u, err := auth.FetchUserByEmail(bson.M{"email": strings.ToLower(loggedIn.Email)})
+	if err != nil {
+		return ""
+	}
+
+	return u.ID
+}
+
+// logAudit records e, filling in the actor and request details, with the
+// fields that differ between before and after. A failure to write is logged
+// but never fails the audited request.
+func logAudit(r *http.Request, e AuditEvent, before, after interface{}) {
+	if e.OrgID == "" {
+		e.OrgID = mux.Vars(r)["id"]
+	}
+
+	if loggedIn, ok := r.Context().Value("user").(*auth.AuthUser); ok && loggedIn != nil {
+		e.ActorID, e.ActorEmail, e.TokenID = auditActorID(loggedIn), loggedIn.Email, loggedIn.TokenID
+	}
+
+	e.Changes = auditDiff(before, after)
+	e.IPAddress = utils.ClientIP(r)
+	e.UserAgent = r.UserAgent()
+	e.CreatedAt = time.Now()
+
+	if _, err := auditLogs().InsertOne(context.Background(), e); err != nil {
+		log.Printf("audit logs: could not record %s for %s: %v", e.Action, e.OrgID, err)
+	}
+}
+
+// auditFilter builds the query for the action, actor, target_id, from and to
+// query parameters. from and to are RFC 3339 times.
+func auditFilter(r *http.Request) (bson.M, error) {
+	query := r.URL.Query()
+	filter := bson.M{"org_id": mux.Vars(r)["id"]}
+
+	if action := query.Get("action"); action != "" {
+		filter["action"] = action
+	}
+
+	if actor := query.Get("actor"); actor != "" {
+		filter["$or"] = bson.A{bson.M{"actor_email": strings.ToLower(actor)}, bson.M{"actor_id": actor}}
+	}
+
+	if target := query.Get("target_id"); target != "" {
+		filter["target_id"] = target
+	}
+
+	created := bson.M{}
+
+	for param, op := range map[string]string{"from": "$gte", "to": "$lte"} {
+		v := query.Get(param)
+		if v == "" {
+			continue
+		}
+
+		t, err := time.Parse(time.RFC3339, v)
+		if err != nil {
+			return nil, fmt.Errorf("%s must be an RFC 3339 time", param)
+		}
+
+		created[op] = t
+	}
+
+	if len(created) > 0 {
+		filter["created_at"] = created
+	}
+
+	return filter, nil
+}
+
+// ListAuditLogs returns an organization's audit trail, newest first.
+func (oh *OrganizationHandler) ListAuditLogs(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	filter, err := auditFilter(r)
+	if err != nil {
+		utils.GetError(err, http.StatusBadRequest, w)
+		return
+	}
+
+	query := r.URL.Query()
+
+	page, _ := strconv.Atoi(query.Get("page"))
+	if page < 1 {
+		page = 1
+	}
+
+	limit, _ := strconv.Atoi(query.Get("limit"))
+	if limit < 1 {
+		limit = defaultAuditLogLimit
+	}
+
+	if limit > maxAuditLogLimit {
+		limit = maxAuditLogLimit
+	}
+
+	total, err := auditLogs().CountDocuments(r.Context(), filter)
+	if err != nil {
+		utils.GetError(err, http.StatusInternalServerError, w)
+		return
+	}
+
+	opts := options.Find().
+		SetSort(bson.D{{Key: "created_at", Value: -1}}).
+		SetSkip(int64((page - 1) * limit)).
+		SetLimit(int64(limit))
+
+	cursor, err := auditLogs().Find(r.Context(), filter, opts)
+	if err != nil {
+		utils.GetError(err, http.StatusInternalServerError, w)
+		return
+	}
+
+	logs := []AuditEvent{}
+	if err := cursor.All(r.Context(), &logs); err != nil {
+		utils.GetError(err, http.StatusInternalServerError, w)
+		return
+	}
+
+	utils.GetSuccess("audit logs retrieved successfully", map[string]interface{}{
+		"logs":  logs,
+		"total": total,
+		"page":  page,
+		"limit": limit,
+	}, w)
+}
+
+// csvSafe stops spreadsheet programs from reading a cell as a formula.
+func csvSafe(s string) string {
+	if s != "" && strings.ContainsRune("=+-@\t\r", rune(s[0])) {
+		return "'" + s
+	}
+
+	return s
+}
+
+// ExportAuditLogs streams the audit entries matching the same filters as
+// ListAuditLogs as a CSV file.
+func (oh *OrganizationHandler) ExportAuditLogs(w http.ResponseWriter, r *http.Request) {
+	filter, err := auditFilter(r)
+	if err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		utils.GetError(err, http.StatusBadRequest, w)
+
+		return
+	}
+
+	cursor, err := auditLogs().Find(r.Context(), filter, options.Find().SetSort(bson.D{{Key: "created_at", Value: -1}}))
+	if err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		utils.GetError(err, http.StatusInternalServerError, w)
+
+		return
+	}
+	defer cursor.Close(r.Context())
+
+	w.Header().Set("Content-Type", "text/csv")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", "audit-logs-"+mux.Vars(r)["id"]+".csv"))
+
+	out := csv.NewWriter(w)
+	header := []string{"created_at", "action", "actor_id", "actor_email", "target_type", "target_id", "changes", "ip_address", "user_agent"}
+	if err := out.Write(header); err != nil {
+		return
+	}
+
+	for cursor.Next(r.Context()) {
+		var e AuditEvent
+		if err := cursor.Decode(&e); err != nil {
+			log.Printf("audit logs: could not decode entry: %v", err)
+			continue
+		}
+
+		changes, _ := json.Marshal(e.Changes)
+
+		row := []string{
+			e.CreatedAt.UTC().Format(time.RFC3339), e.Action, e.ActorID, e.ActorEmail,
+			e.TargetType, e.TargetID, string(changes), e.IPAddress, e.UserAgent,
+		}
+
+		for i := range row {
+			row[i] = csvSafe(row[i])
+		}
+
+		if err := out.Write(row); err != nil {
+			return
+		}
+	}
+
+	out.Flush()
+}
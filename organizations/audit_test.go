@@ -0,0 +1,35 @@
+package organizations
+
+import (
+	"reflect"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+func TestAuditDiff(t *testing.T) {
+	before := bson.M{"name": "zuri", "settings": bson.M{"theme": "dark", "prefixes": []string{"a"}}}
+	after := bson.M{"name": "zuri", "settings": bson.M{"theme": "light", "prefixes": []string{"a"}}, "logo_url": "x.png"}
+
+	got := auditDiff(before, after)
+	want := []AuditChange{
+		{Field: "logo_url", After: "x.png"},
+		{Field: "settings.theme", Before: "dark", After: "light"},
+	}
+
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("auditDiff() = %+v, want %+v", got, want)
+	}
+
+	if got := auditDiff(nil, bson.M{"role": "admin"}); len(got) != 1 || got[0].Before != nil {
+		t.Errorf("auditDiff(nil, ...) = %+v, want one added field", got)
+	}
+}
+
+func TestCSVSafe(t *testing.T) {
+	for in, want := range map[string]string{"=SUM(A1)": "'=SUM(A1)", "admin": "admin", "": ""} {
+		if got := csvSafe(in); got != want {
+			t.Errorf("csvSafe(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
@@ -0,0 +1,273 @@
+package organizations
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"zuri.chat/zccore/auth"
+	"zuri.chat/zccore/report"
+	"zuri.chat/zccore/service"
+	"zuri.chat/zccore/utils"
+)
+
+// a purge that has not finished after this long is assumed to have died and
+// is picked up again.
+const purgeClaimTimeout = time.Hour
+
+var (
+	ErrOrgPendingDeletion    = errors.New("organization is already scheduled for deletion")
+	ErrOrgNotPendingDeletion = errors.New("organization is not scheduled for deletion")
+)
+
+// orgReferences lists every collection holding documents that belong to an
+// organization, with the field that holds its id. The audit trail is left out
+// on purpose, it outlives the organization.
+var orgReferences = []struct {
+	collection string
+	field      string
+}{
+	{MemberCollectionName, "org_id"},
+	{OrganizationInviteCollectionName, "org_id"},
+	{TokenTransactionCollectionName, "org_id"},
+	{CardCollectionName, "org_id"},
+	{InstalledPluginsCollectionName, "org_id"},
+	{PluginSettingsCollectionName, "org_id"},
+	{PluginUsageCollectionName, "org_id"},
+	{ExportJobCollectionName, "org_id"},
+	{MemberImportCollectionName, "org_id"},
+	{SCIMTokenCollectionName, "org_id"},
+	{auth.GroupCollectionName, "org_id"},
+	{auth.RoleCollectionName, "org_id"},
+	{report.ReportCollectionName, "organization_id"},
+	{"plugin_reviews", "organization_id"},
+}
+
+// plugin data lives in <plugin_id>__<collection> collections, see data.WriteData.
+const pluginDataCollections = "^[0-9a-f]{24}__"
+
+// DeleteOrganization schedules an organization for deletion. It stays fully
+// usable, and can be restored, until the grace period ends and the purger
+// removes it with everything that references it.
+func (oh *OrganizationHandler) DeleteOrganization(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	orgID := mux.Vars(r)["id"]
+
+	org, err := FetchOrganizationByID(orgID)
+	if err != nil {
+		utils.GetError(err, http.StatusNotFound, w)
+		return
+	}
+
+	if org.PurgeAfter != nil {
+		utils.GetError(ErrOrgPendingDeletion, http.StatusConflict, w)
+		return
+	}
+
+	loggedIn, _ := r.Context().Value("user").(*auth.AuthUser)
+	if loggedIn == nil {
+		utils.GetError(auth.ErrNotAuthorized, http.StatusUnauthorized, w)
+		return
+	}
+
+	now := time.Now()
+	purgeAfter := now.AddDate(0, 0, oh.configs.OrgDeleteGraceDays)
+
+	update, err := utils.UpdateOneMongoDBDoc(OrganizationCollectionName, orgID, bson.M{
+		"deletion_requested_at": now,
+		"deletion_requested_by": loggedIn.Email,
+		"purge_after":           purgeAfter,
+	})
+	if err != nil {
+		utils.GetError(err, http.StatusInternalServerError, w)
+		return
+	}
+
+	if update.ModifiedCount == 0 {
+		utils.GetError(errors.New("operation failed"), http.StatusInternalServerError, w)
+		return
+	}
+
+	logAudit(r, AuditEvent{Action: AuditDeletionScheduled, TargetType: "organization", TargetID: orgID},
+		nil, bson.M{"purge_after": purgeAfter})
+
+	oh.sendDeletionNotice(org, loggedIn.Email, purgeAfter)
+
+	utils.GetSuccess("organization scheduled for deletion", map[string]interface{}{"purge_after": purgeAfter}, w)
+}
+
+// sendDeletionNotice tells every owner and admin that org is going away.
+func (oh *OrganizationHandler) sendDeletionNotice(org *Organization, requestedBy string, purgeAfter time.Time) {
+	filter := bson.M{"org_id": org.ID, "role": bson.M{"$in": []string{OwnerRole, AdminRole}}, "deleted": bson.M{"$ne": true}}
+
+	emails, err := utils.GetCollection(MemberCollectionName).Distinct(context.Background(), "email", filter)
+	if err != nil {
+		log.Printf("organization deletion: could not list admins of %s: %v", org.ID, err)
+		return
+	}
+
+	for _, e := range emails {
+		email, ok := e.(string)
+		if !ok {
+			continue
+		}
+
+		mail := oh.mailService.NewMail([]string{email}, fmt.Sprintf("%s is scheduled for deletion", org.Name), service.OrgDeletion, map[string]interface{}{
+			"Username":         email,
+			"RequestedBy":      requestedBy,
+			"OrganizationName": org.Name,
+			"PurgeAfter":       purgeAfter.UTC().Format(time.RFC1123),
+		})
+
+		if err := oh.mailService.SendMail(mail); err != nil {
+			log.Printf("Error occurred while sending mail: %s", err.Error())
+		}
+	}
+}
+
+// RestoreOrganization cancels a scheduled deletion.
+func (oh *OrganizationHandler) RestoreOrganization(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	orgID := mux.Vars(r)["id"]
+
+	filter, err := orgIDFilter(orgID)
+	if err != nil {
+		utils.GetError(err, http.StatusBadRequest, w)
+		return
+	}
+
+	// an organization the purger has already claimed is past saving
+	filter["purge_after"] = bson.M{"$exists": true}
+	filter["purging_at"] = bson.M{"$exists": false}
+
+	var before Organization
+
+	update := bson.M{"$unset": bson.M{"deletion_requested_at": "", "deletion_requested_by": "", "purge_after": ""}}
+	if err := utils.GetCollection(OrganizationCollectionName).FindOneAndUpdate(r.Context(), filter, update).Decode(&before); err != nil {
+		utils.GetError(ErrOrgNotPendingDeletion, http.StatusBadRequest, w)
+		return
+	}
+
+	logAudit(r, AuditEvent{Action: AuditOrganizationRestored, TargetType: "organization", TargetID: orgID},
+		bson.M{"purge_after": before.PurgeAfter}, nil)
+
+	utils.GetSuccess("organization restored successfully", nil, w)
+}
+
+// claimDueOrganization marks one organization whose grace period is over as
+// being purged and returns its _id, or nil when there is none.
+func claimDueOrganization(ctx context.Context) (interface{}, error) {
+	now := time.Now()
+	filter := bson.M{
+		"purge_after": bson.M{"$lte": now},
+		"$or": bson.A{
+			bson.M{"purging_at": bson.M{"$exists": false}},
+			bson.M{"purging_at": bson.M{"$lt": now.Add(-purgeClaimTimeout)}},
+		},
+	}
+
+	var org struct {
+		ID interface{} `bson:"_id"`
+	}
+
+	opts := options.FindOneAndUpdate().SetProjection(bson.M{"_id": 1})
+
+	err := utils.GetCollection(OrganizationCollectionName).FindOneAndUpdate(ctx, filter, bson.M{"$set": bson.M{"purging_at": now}}, opts).Decode(&org)
+	if err != nil {
+		return nil, err
+	}
+
+	return org.ID, nil
+}
+
+// purgeOrganization deletes every document referencing the organization and
+// then the organization itself, so a failed purge is simply retried.
+func purgeOrganization(ctx context.Context, id interface{}) error {
+	orgID := fmt.Sprint(id)
+	if oid, ok := id.(primitive.ObjectID); ok {
+		orgID = oid.Hex()
+	}
+
+	if err := removeOrgExports(ctx, orgID); err != nil {
+		return err
+	}
+
+	for _, ref := range orgReferences {
+		if _, err := utils.GetCollection(ref.collection).DeleteMany(ctx, bson.M{ref.field: orgID}); err != nil {
+			return fmt.Errorf("%s: %w", ref.collection, err)
+		}
+	}
+
+	db := utils.GetCollection(OrganizationCollectionName).Database()
+
+	names, err := db.ListCollectionNames(ctx, bson.M{"name": bson.M{"$regex": pluginDataCollections}})
+	if err != nil {
+		return err
+	}
+
+	for _, name := range names {
+		if _, err := db.Collection(name).DeleteMany(ctx, bson.M{"organization_id": orgID}); err != nil {
+			return fmt.Errorf("%s: %w", name, err)
+		}
+	}
+
+	if _, err := utils.GetCollection(UserCollectionName).UpdateMany(ctx, bson.M{"workspaces": orgID}, bson.M{"$pull": bson.M{"workspaces": orgID}}); err != nil {
+		return err
+	}
+
+	if _, err := utils.GetCollection(OrganizationCollectionName).DeleteOne(ctx, bson.M{"_id": id}); err != nil {
+		return err
+	}
+
+	e := AuditEvent{OrgID: orgID, Action: AuditOrganizationPurged, TargetType: "organization", TargetID: orgID, CreatedAt: time.Now()}
+	if _, err := auditLogs().InsertOne(ctx, e); err != nil {
+		log.Printf("audit logs: could not record %s for %s: %v", e.Action, e.OrgID, err)
+	}
+
+	return nil
+}
+
+// PurgeDueOrganizations removes every organization whose deletion grace
+// period is over.
+func PurgeDueOrganizations(ctx context.Context) {
+	for {
+		id, err := claimDueOrganization(ctx)
+		if err != nil {
+			return
+		}
+
+		if err := purgeOrganization(ctx, id); err != nil {
+			log.Printf("organization deletion: could not purge %v: %v", id, err)
+			continue
+		}
+
+		log.Printf("organization deletion: purged %v", id)
+	}
+}
+
+// RunOrganizationPurger calls PurgeDueOrganizations every interval until ctx
+// is done.
+func RunOrganizationPurger(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		PurgeDueOrganizations(ctx)
+	}
+}
@@ -0,0 +1,497 @@
+package organizations
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"go.mongodb.org/mongo-driver/bson"
+
+	"zuri.chat/zccore/auth"
+	"zuri.chat/zccore/service"
+	"zuri.chat/zccore/utils"
+)
+
+// ways of proving an organization controls a domain.
+const (
+	DomainVerifyDNS   = "dns"
+	DomainVerifyEmail = "email"
+)
+
+const (
+	domainChallengePrefix = "_zuri-challenge."
+	domainTXTPrefix       = "zuri-verification="
+	domainCodeTTL         = time.Hour
+	maxDomainCodeAttempts = 5
+)
+
+var (
+	domainRegex = regexp.MustCompile(`^([a-z0-9]([a-z0-9-]{0,61}[a-z0-9])?\.)+[a-z]{2,63}$`)
+
+	// anyone can get an address at these, so they can never be allowed
+	publicEmailDomains = map[string]bool{
+		"gmail.com": true, "googlemail.com": true, "yahoo.com": true, "outlook.com": true,
+		"hotmail.com": true, "live.com": true, "icloud.com": true, "aol.com": true,
+		"proton.me": true, "protonmail.com": true, "gmx.com": true, "yandex.com": true,
+		"mail.com": true, "zoho.com": true,
+	}
+
+	// only whoever runs a domain gets mail at these, the same mailboxes
+	// certificate authorities accept as proof of control
+	domainAdminMailboxes = []string{"admin", "administrator", "hostmaster", "postmaster", "webmaster"}
+
+	ErrDomainInvalid     = errors.New("domain is not valid")
+	ErrDomainPublic      = errors.New("domains of public email providers cannot be allowed")
+	ErrDomainExists      = errors.New("domain is already on the organization's list")
+	ErrDomainNotFound    = errors.New("domain is not on the organization's list")
+	ErrDomainNotVerified = errors.New("domain ownership could not be verified")
+	ErrDomainCode        = errors.New("verification code is wrong or has expired, add the domain again for a new one")
+	ErrDomainNotJoinable = errors.New("your email address does not let you join this organization")
+)
+
+// TXTResolver looks up DNS TXT records. *net.Resolver satisfies it.
+type TXTResolver interface {
+	LookupTXT(ctx context.Context, name string) ([]string, error)
+}
+
+// AllowedDomain is an email domain whose verified users may join an
+// organization without an invite, once the organization has proved it
+// controls the domain.
+type AllowedDomain struct {
+	Domain     string     `json:"domain" bson:"domain"`
+	Role       string     `json:"role" bson:"role"`
+	Method     string     `json:"method" bson:"method"`
+	Verified   bool       `json:"verified" bson:"verified"`
+	TXTRecord  string     `json:"txt_record,omitempty" bson:"txt_record,omitempty"`
+	CodeEmail  string     `json:"code_email,omitempty" bson:"code_email,omitempty"`
+	CodeHash   string     `json:"-" bson:"code_hash,omitempty"`
+	Attempts   int        `json:"-" bson:"attempts,omitempty"`
+	ExpiresAt  *time.Time `json:"expires_at,omitempty" bson:"expires_at,omitempty"`
+	AddedBy    string     `json:"added_by" bson:"added_by"`
+	CreatedAt  time.Time  `json:"created_at" bson:"created_at"`
+	VerifiedAt *time.Time `json:"verified_at,omitempty" bson:"verified_at,omitempty"`
+}
+
+type domainBody struct {
+	Domain string `json:"domain"`
+	Method string `json:"method"`
+	Email  string `json:"email"`
+	Role   string `json:"role"`
+}
+
+// normalizeDomain lowercases domain and checks it is a domain an
+// organization may allow.
+func normalizeDomain(domain string) (string, error) {
+	domain = strings.TrimSuffix(strings.TrimPrefix(strings.ToLower(strings.TrimSpace(domain)), "@"), ".")
+
+	if len(domain) > 253 || !domainRegex.MatchString(domain) {
+		return "", ErrDomainInvalid
+	}
+
+	if publicEmailDomains[domain] {
+		return "", ErrDomainPublic
+	}
+
+	return domain, nil
+}
+
+func emailDomain(email string) string {
+	return strings.ToLower(email[strings.LastIndex(email, "@")+1:])
+}
+
+// isDomainAdminEmail reports whether email is one of the administrative
+// mailboxes of domain.
+func isDomainAdminEmail(email, domain string) bool {
+	for _, mailbox := range domainAdminMailboxes {
+		if strings.EqualFold(email, mailbox+"@"+domain) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// checkDomainTXT looks for the challenge TXT record of domain holding token.
+func checkDomainTXT(ctx context.Context, resolver TXTResolver, domain, token string) error {
+	records, err := resolver.LookupTXT(ctx, domainChallengePrefix+domain)
+	if err != nil {
+		return fmt.Errorf("%w: no TXT record found at %s%s", ErrDomainNotVerified, domainChallengePrefix, domain)
+	}
+
+	for _, record := range records {
+		if strings.TrimSpace(record) == token {
+			return nil
+		}
+	}
+
+	return fmt.Errorf("%w: %s%s does not hold %s", ErrDomainNotVerified, domainChallengePrefix, domain, token)
+}
+
+// joinableDomain returns the verified domain of org that email belongs to.
+func joinableDomain(org *Organization, email string) *AllowedDomain {
+	domain := emailDomain(email)
+
+	for i := range org.AllowedDomains {
+		if d := &org.AllowedDomains[i]; d.Verified && d.Domain == domain {
+			return d
+		}
+	}
+
+	return nil
+}
+
+func findDomain(org *Organization, domain string) *AllowedDomain {
+	for i := range org.AllowedDomains {
+		if org.AllowedDomains[i].Domain == domain {
+			return &org.AllowedDomains[i]
+		}
+	}
+
+	return nil
+}
+
+// domainFilter matches orgID when domain is on its list.
+func domainFilter(orgID, domain string) (bson.M, error) {
+	filter, err := orgIDFilter(orgID)
+	if err != nil {
+		return nil, err
+	}
+
+	filter["allowed_domains.domain"] = domain
+
+	return filter, nil
+}
+
+// ListDomains returns an organization's allowed email domains.
+func (oh *OrganizationHandler) ListDomains(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	org, err := FetchOrganizationByID(mux.Vars(r)["id"])
+	if err != nil {
+		utils.GetError(err, http.StatusNotFound, w)
+		return
+	}
+
+	domains := org.AllowedDomains
+	if domains == nil {
+		domains = []AllowedDomain{}
+	}
+
+	utils.GetSuccess("domains retrieved successfully", domains, w)
+}
+
+// AddDomain puts an email domain on an organization's list and starts
+// proving the organization controls it, either through a TXT record the
+// admin publishes or a code mailed to an address at the domain.
+func (oh *OrganizationHandler) AddDomain(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	orgID := mux.Vars(r)["id"]
+
+	var body domainBody
+	if err := utils.ParseJSONFromRequest(r, &body); err != nil {
+		utils.GetError(err, http.StatusUnprocessableEntity, w)
+		return
+	}
+
+	domain, err := normalizeDomain(body.Domain)
+	if err != nil {
+		utils.GetError(err, http.StatusBadRequest, w)
+		return
+	}
+
+	loggedIn, _ := r.Context().Value("user").(*auth.AuthUser)
+	if loggedIn == nil {
+		utils.GetError(auth.ErrNotAuthorized, http.StatusUnauthorized, w)
+		return
+	}
+
+	roleName := strings.ToLower(body.Role)
+	if roleName == "" {
+		roleName = MemberRole
+	}
+
+	role, err := auth.FetchRole(orgID, roleName)
+	if err != nil || roleName == OwnerRole {
+		utils.GetError(errors.New("role is not valid"), http.StatusBadRequest, w)
+		return
+	}
+
+	caller, err := callerRole(r, orgID)
+	if err != nil {
+		utils.GetError(err, http.StatusForbidden, w)
+		return
+	}
+
+	if err := checkGrantable(caller, role.Permissions); err != nil {
+		utils.GetError(err, http.StatusForbidden, w)
+		return
+	}
+
+	org, err := FetchOrganizationByID(orgID)
+	if err != nil {
+		utils.GetError(err, http.StatusNotFound, w)
+		return
+	}
+
+	entry := AllowedDomain{Domain: domain, Role: role.Name, Method: body.Method, AddedBy: loggedIn.Email, CreatedAt: time.Now()}
+
+	var code string
+
+	switch body.Method {
+	case "", DomainVerifyDNS:
+		token, err := utils.GenSecureToken(16)
+		if err != nil {
+			utils.GetError(err, http.StatusInternalServerError, w)
+			return
+		}
+
+		entry.Method, entry.TXTRecord = DomainVerifyDNS, domainTXTPrefix+token
+	case DomainVerifyEmail:
+		email := strings.ToLower(strings.TrimSpace(body.Email))
+		if !utils.IsValidEmail(email) || !isDomainAdminEmail(email, domain) {
+			err := fmt.Errorf("email must be one of the %s mailboxes at %s", strings.Join(domainAdminMailboxes, ", "), domain)
+			utils.GetError(err, http.StatusBadRequest, w)
+
+			return
+		}
+
+		if code, err = utils.GenNumericCode(6); err != nil {
+			utils.GetError(err, http.StatusInternalServerError, w)
+			return
+		}
+
+		expires := entry.CreatedAt.Add(domainCodeTTL)
+		entry.CodeEmail, entry.CodeHash, entry.ExpiresAt = email, utils.HashCode(code, oh.configs.SecretKey), &expires
+	default:
+		utils.GetError(errors.New("method must be dns or email"), http.StatusBadRequest, w)
+		return
+	}
+
+	filter, err := orgIDFilter(orgID)
+	if err != nil {
+		utils.GetError(err, http.StatusBadRequest, w)
+		return
+	}
+
+	// the domain check in the filter keeps two concurrent requests from both
+	// adding it
+	filter["allowed_domains.domain"] = bson.M{"$ne": domain}
+
+	res, err := utils.GetCollection(OrganizationCollectionName).UpdateOne(r.Context(), filter, bson.M{"$push": bson.M{"allowed_domains": entry}})
+	if err != nil {
+		utils.GetError(err, http.StatusInternalServerError, w)
+		return
+	}
+
+	if res.MatchedCount == 0 {
+		utils.GetError(ErrDomainExists, http.StatusConflict, w)
+		return
+	}
+
+	logAudit(r, AuditEvent{Action: AuditDomainAdded, TargetType: "domain", TargetID: domain},
+		nil, bson.M{"domain": domain, "role": entry.Role, "method": entry.Method})
+
+	if entry.Method == DomainVerifyEmail {
+		mail := oh.mailService.NewMail([]string{entry.CodeEmail}, fmt.Sprintf("Verify %s for %s on Zuri Chat", domain, org.Name), service.DomainVerification, map[string]interface{}{
+			"RequestedBy":      loggedIn.Email,
+			"OrganizationName": org.Name,
+			"Domain":           domain,
+			"Code":             code,
+		})
+
+		go func() {
+			if err := oh.mailService.SendMail(mail); err != nil {
+				log.Printf("Error occurred while sending mail: %s", err.Error())
+			}
+		}()
+
+		utils.GetSuccess("a verification code has been sent to "+entry.CodeEmail, entry, w)
+
+		return
+	}
+
+	utils.GetSuccess(fmt.Sprintf("publish a TXT record at %s%s holding %s, then verify the domain", domainChallengePrefix, domain, entry.TXTRecord), entry, w)
+}
+
+// VerifyDomain checks the challenge of a domain on an organization's list.
+// Email challenges take the mailed code in the body.
+func (oh *OrganizationHandler) VerifyDomain(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	vars := mux.Vars(r)
+	orgID := vars["id"]
+
+	org, err := FetchOrganizationByID(orgID)
+	if err != nil {
+		utils.GetError(err, http.StatusNotFound, w)
+		return
+	}
+
+	entry := findDomain(org, strings.ToLower(vars["domain"]))
+	if entry == nil {
+		utils.GetError(ErrDomainNotFound, http.StatusNotFound, w)
+		return
+	}
+
+	if entry.Verified {
+		utils.GetSuccess("domain is already verified", entry, w)
+		return
+	}
+
+	filter, err := domainFilter(orgID, entry.Domain)
+	if err != nil {
+		utils.GetError(err, http.StatusBadRequest, w)
+		return
+	}
+
+	coll := utils.GetCollection(OrganizationCollectionName)
+
+	if entry.Method == DomainVerifyEmail {
+		var body struct {
+			Code string `json:"code"`
+		}
+
+		if err := utils.ParseJSONFromRequest(r, &body); err != nil {
+			utils.GetError(err, http.StatusUnprocessableEntity, w)
+			return
+		}
+
+		if entry.ExpiresAt == nil || time.Now().After(*entry.ExpiresAt) || entry.Attempts >= maxDomainCodeAttempts {
+			utils.GetError(ErrDomainCode, http.StatusBadRequest, w)
+			return
+		}
+
+		if utils.HashCode(strings.TrimSpace(body.Code), oh.configs.SecretKey) != entry.CodeHash {
+			if _, err := coll.UpdateOne(r.Context(), filter, bson.M{"$inc": bson.M{"allowed_domains.$.attempts": 1}}); err != nil {
+				utils.GetError(err, http.StatusInternalServerError, w)
+				return
+			}
+
+			utils.GetError(ErrDomainCode, http.StatusBadRequest, w)
+
+			return
+		}
+	} else if err := checkDomainTXT(r.Context(), oh.resolver, entry.Domain, entry.TXTRecord); err != nil {
+		utils.GetError(err, http.StatusBadRequest, w)
+		return
+	}
+
+	now := time.Now()
+	update := bson.M{
+		"$set":   bson.M{"allowed_domains.$.verified": true, "allowed_domains.$.verified_at": now},
+		"$unset": bson.M{"allowed_domains.$.code_hash": "", "allowed_domains.$.attempts": "", "allowed_domains.$.expires_at": ""},
+	}
+
+	if _, err := coll.UpdateOne(r.Context(), filter, update); err != nil {
+		utils.GetError(err, http.StatusInternalServerError, w)
+		return
+	}
+
+	logAudit(r, AuditEvent{Action: AuditDomainVerified, TargetType: "domain", TargetID: entry.Domain},
+		bson.M{"verified": false}, bson.M{"verified": true})
+
+	entry.Verified, entry.VerifiedAt, entry.ExpiresAt = true, &now, nil
+
+	utils.GetSuccess("domain verified successfully", entry, w)
+}
+
+// RemoveDomain takes a domain off an organization's list. People who joined
+// through it stay members.
+func (oh *OrganizationHandler) RemoveDomain(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	vars := mux.Vars(r)
+	domain := strings.ToLower(vars["domain"])
+
+	filter, err := domainFilter(vars["id"], domain)
+	if err != nil {
+		utils.GetError(err, http.StatusBadRequest, w)
+		return
+	}
+
+	update := bson.M{"$pull": bson.M{"allowed_domains": bson.M{"domain": domain}}}
+
+	res, err := utils.GetCollection(OrganizationCollectionName).UpdateOne(r.Context(), filter, update)
+	if err != nil {
+		utils.GetError(err, http.StatusInternalServerError, w)
+		return
+	}
+
+	if res.ModifiedCount == 0 {
+		utils.GetError(ErrDomainNotFound, http.StatusNotFound, w)
+		return
+	}
+
+	logAudit(r, AuditEvent{Action: AuditDomainRemoved, TargetType: "domain", TargetID: domain}, bson.M{"domain": domain}, nil)
+
+	utils.GetSuccess("domain removed successfully", nil, w)
+}
+
+// JoinOrganization makes the logged in user a member of an organization
+// that allows their verified email's domain, with the role the domain gives.
+func (oh *OrganizationHandler) JoinOrganization(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	orgID := mux.Vars(r)["id"]
+
+	loggedIn, _ := r.Context().Value("user").(*auth.AuthUser)
+	if loggedIn == nil {
+		utils.GetError(auth.ErrNotAuthorized, http.StatusUnauthorized, w)
+		return
+	}
+
+	u, err := auth.FetchUserByEmail(bson.M{"email": strings.ToLower(loggedIn.Email)})
+	if err != nil {
+		utils.GetError(err, http.StatusNotFound, w)
+		return
+	}
+
+	if !u.IsVerified {
+		utils.GetError(errors.New("verify your email address before joining an organization"), http.StatusForbidden, w)
+		return
+	}
+
+	org, err := FetchOrganizationByID(orgID)
+	if err != nil || org.PurgeAfter != nil {
+		utils.GetError(fmt.Errorf("organization %s not found", orgID), http.StatusNotFound, w)
+		return
+	}
+
+	domain := joinableDomain(org, u.Email)
+	if domain == nil {
+		utils.GetError(ErrDomainNotJoinable, http.StatusForbidden, w)
+		return
+	}
+
+	if member, err := FetchMember(bson.M{"org_id": orgID, "email": u.Email}); err == nil {
+		if member.Deleted {
+			utils.GetError(errors.New("you were removed from this organization, ask an admin to invite you"), http.StatusForbidden, w)
+			return
+		}
+
+		utils.GetError(errors.New("user is already in this organization"), http.StatusBadRequest, w)
+
+		return
+	}
+
+	member := NewMember(u.Email, strings.Split(u.Email, "@")[0], orgID, domain.Role)
+	member.FirstName, member.LastName = u.FirstName, u.LastName
+
+	memberID, err := addMember(r.Context(), member)
+	if err != nil {
+		utils.GetError(err, http.StatusInternalServerError, w)
+		return
+	}
+
+	logAudit(r, AuditEvent{Action: AuditMemberJoined, TargetType: "member", TargetID: memberID.Hex()},
+		nil, bson.M{"email": u.Email, "role": domain.Role, "domain": domain.Domain})
+
+	utils.GetSuccess("Member created successfully", utils.M{"member_id": memberID, "organization_id": orgID}, w)
+}
@@ -0,0 +1,94 @@
+package organizations
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+type stubResolver map[string][]string
+
+func (s stubResolver) LookupTXT(_ context.Context, name string) ([]string, error) {
+	records, ok := s[name]
+	if !ok {
+		return nil, errors.New("no such host")
+	}
+
+	return records, nil
+}
+
+func TestNormalizeDomain(t *testing.T) {
+	tests := []struct {
+		in   string
+		want string
+		err  error
+	}{
+		{" @Zuri.Chat. ", "zuri.chat", nil},
+		{"mail.hng.tech", "mail.hng.tech", nil},
+		{"gmail.com", "", ErrDomainPublic},
+		{"localhost", "", ErrDomainInvalid},
+		{"-bad.com", "", ErrDomainInvalid},
+		{"zuri.chat/path", "", ErrDomainInvalid},
+	}
+
+	for _, tt := range tests {
+		got, err := normalizeDomain(tt.in)
+		if got != tt.want || !errors.Is(err, tt.err) {
+			t.Errorf("normalizeDomain(%q) = %q, %v, want %q, %v", tt.in, got, err, tt.want, tt.err)
+		}
+	}
+}
+
+func TestCheckDomainTXT(t *testing.T) {
+	resolver := stubResolver{
+		"_zuri-challenge.zuri.chat": {"v=spf1 -all", "zuri-verification=abc123"},
+		"_zuri-challenge.hng.tech":  {"zuri-verification=other"},
+	}
+
+	if err := checkDomainTXT(context.Background(), resolver, "zuri.chat", "zuri-verification=abc123"); err != nil {
+		t.Errorf("checkDomainTXT() with the record published = %v", err)
+	}
+
+	for _, domain := range []string{"hng.tech", "example.com"} {
+		if err := checkDomainTXT(context.Background(), resolver, domain, "zuri-verification=abc123"); !errors.Is(err, ErrDomainNotVerified) {
+			t.Errorf("checkDomainTXT(%s) = %v, want %v", domain, err, ErrDomainNotVerified)
+		}
+	}
+}
+
+func TestIsDomainAdminEmail(t *testing.T) {
+	tests := []struct {
+		email string
+		want  bool
+	}{
+		{"postmaster@zuri.chat", true},
+		{"Hostmaster@Zuri.Chat", true},
+		{"admin@zuri.chat", true},
+		{"ada@zuri.chat", false},
+		{"admin@mail.zuri.chat", false},
+		{"admin@zuri.chat.evil.com", false},
+	}
+
+	for _, tt := range tests {
+		if got := isDomainAdminEmail(tt.email, "zuri.chat"); got != tt.want {
+			t.Errorf("isDomainAdminEmail(%q) = %v, want %v", tt.email, got, tt.want)
+		}
+	}
+}
+
+func TestJoinableDomain(t *testing.T) {
+	org := &Organization{AllowedDomains: []AllowedDomain{
+		{Domain: "zuri.chat", Role: "admin", Verified: true},
+		{Domain: "hng.tech"},
+	}}
+
+	if d := joinableDomain(org, "Ada@ZURI.chat"); d == nil || d.Role != "admin" {
+		t.Errorf("joinableDomain() for a verified domain = %+v", d)
+	}
+
+	for _, email := range []string{"ada@hng.tech", "ada@sub.zuri.chat", "ada@example.com"} {
+		if d := joinableDomain(org, email); d != nil {
+			t.Errorf("joinableDomain(%s) = %+v, want nil", email, d)
+		}
+	}
+}
@@ -0,0 +1,739 @@
+package organizations
+
+import (
+	"archive/zip"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/gridfs"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"zuri.chat/zccore/auth"
+	"zuri.chat/zccore/report"
+	"zuri.chat/zccore/service"
+	"zuri.chat/zccore/utils"
+)
+
+const (
+	ExportJobCollectionName = "organization_exports"
+
+	// archives are kept in GridFS so every replica can serve them.
+	ExportArchiveBucketName = "organization_export_archives"
+)
+
+// export job statuses.
+const (
+	ExportPending   = "pending"
+	ExportRunning   = "running"
+	ExportCompleted = "completed"
+	ExportFailed    = "failed"
+	ExportExpired   = "expired"
+)
+
+// a worker building an export touches its claim every exportHeartbeat. A
+// running export whose claim has not been touched for exportClaimTimeout is
+// assumed to have died with its worker and is started again.
+const (
+	exportHeartbeat    = time.Minute
+	exportClaimTimeout = 5 * time.Minute
+)
+
+var (
+	ErrExportInProgress = errors.New("an export of this organization is already in progress")
+	ErrExportNotFound   = errors.New("export not found")
+	ErrExportNotReady   = errors.New("export is not ready for download")
+	errExportClaimLost  = errors.New("export was claimed by another worker")
+
+	// wakes the export worker up as soon as a job is queued
+	exportQueued = make(chan struct{}, 1)
+)
+
+type ExportJob struct {
+	ID          primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	OrgID       string             `bson:"org_id" json:"org_id"`
+	RequestedBy string             `bson:"requested_by" json:"requested_by"`
+	Status      string             `bson:"status" json:"status"`
+	Progress    int                `bson:"progress" json:"progress"`
+	Error       string             `bson:"error,omitempty" json:"error,omitempty"`
+	Size        int64              `bson:"size,omitempty" json:"size,omitempty"`
+	CreatedAt   time.Time          `bson:"created_at" json:"created_at"`
+	StartedAt   *time.Time         `bson:"started_at,omitempty" json:"started_at,omitempty"`
+	HeartbeatAt *time.Time         `bson:"heartbeat_at,omitempty" json:"-"`
+	CompletedAt *time.Time         `bson:"completed_at,omitempty" json:"completed_at,omitempty"`
+	ExpiresAt   *time.Time         `bson:"expires_at,omitempty" json:"expires_at,omitempty"`
+}
+
+// exportSet is one file pair, <Name>.json and <Name>.csv, in an export archive.
+// Its documents are either held in Docs or streamed from Coll with Filter.
+type exportSet struct {
+	Name   string
+	Docs   []bson.M
+	Coll   *mongo.Collection
+	Filter bson.M
+}
+
+// each calls fn with every document of the set in turn.
+func (s exportSet) each(ctx context.Context, fn func(bson.M) error) error {
+	if s.Coll == nil {
+		for _, doc := range s.Docs {
+			if err := fn(doc); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	}
+
+	cursor, err := s.Coll.Find(ctx, s.Filter)
+	if err != nil {
+		return err
+	}
+	defer cursor.Close(ctx)
+
+	for cursor.Next(ctx) {
+		var doc bson.M
+		if err := cursor.Decode(&doc); err != nil {
+			return err
+		}
+
+		if err := fn(doc); err != nil {
+			return err
+		}
+	}
+
+	return cursor.Err()
+}
+
+func exportJobs() *mongo.Collection {
+	return utils.GetCollection(ExportJobCollectionName)
+}
+
+func exportArchives() (*gridfs.Bucket, error) {
+	db := utils.GetCollection(ExportJobCollectionName).Database()
+	return gridfs.NewBucket(db, options.GridFSBucket().SetName(ExportArchiveBucketName))
+}
+
+func exportArchiveName(job *ExportJob) string {
+	return fmt.Sprintf("%s-%s.zip", job.OrgID, job.ID.Hex())
+}
+
+func fetchExportJob(ctx context.Context, orgID, jobID string) (*ExportJob, error) {
+	objID, err := primitive.ObjectIDFromHex(jobID)
+	if err != nil {
+		return nil, ErrExportNotFound
+	}
+
+	var job ExportJob
+	if err := exportJobs().FindOne(ctx, bson.M{"_id": objID, "org_id": orgID}).Decode(&job); err != nil {
+		return nil, ErrExportNotFound
+	}
+
+	return &job, nil
+}
+
+// RequestExport queues an export of everything an organization holds. The
+// requester gets an email with a download link once it is ready.
+func (oh *OrganizationHandler) RequestExport(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	orgID := mux.Vars(r)["id"]
+
+	if err := ValidateOrg(orgID); err != nil {
+		utils.GetError(err, http.StatusBadRequest, w)
+		return
+	}
+
+	loggedIn, _ := r.Context().Value("user").(*auth.AuthUser)
+	if loggedIn == nil {
+		utils.GetError(auth.ErrNotAuthorized, http.StatusUnauthorized, w)
+		return
+	}
+
+	active, err := exportJobs().CountDocuments(r.Context(), bson.M{"org_id": orgID, "status": bson.M{"$in": []string{ExportPending, ExportRunning}}})
+	if err != nil {
+		utils.GetError(err, http.StatusInternalServerError, w)
+		return
+	}
+
+	if active > 0 {
+		utils.GetError(ErrExportInProgress, http.StatusConflict, w)
+		return
+	}
+
+	job := ExportJob{OrgID: orgID, RequestedBy: loggedIn.Email, Status: ExportPending, CreatedAt: time.Now()}
+
+	res, err := exportJobs().InsertOne(r.Context(), job)
+	if err != nil {
+		utils.GetError(err, http.StatusInternalServerError, w)
+		return
+	}
+
+	job.ID, _ = res.InsertedID.(primitive.ObjectID)
+
+	select {
+	case exportQueued <- struct{}{}:
+	default:
+	}
+
+	utils.GetSuccess("export queued, you will get an email when it is ready", job, w)
+}
+
+// ListExports returns an organization's export jobs, newest first.
+func (oh *OrganizationHandler) ListExports(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	opts := options.Find().SetSort(bson.M{"created_at": -1})
+
+	cursor, err := exportJobs().Find(r.Context(), bson.M{"org_id": mux.Vars(r)["id"]}, opts)
+	if err != nil {
+		utils.GetError(err, http.StatusInternalServerError, w)
+		return
+	}
+
+	jobs := []ExportJob{}
+	if err := cursor.All(r.Context(), &jobs); err != nil {
+		utils.GetError(err, http.StatusInternalServerError, w)
+		return
+	}
+
+	utils.GetSuccess("exports retrieved successfully", jobs, w)
+}
+
+// GetExport returns the status and progress of one export job.
+func (oh *OrganizationHandler) GetExport(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	vars := mux.Vars(r)
+
+	job, err := fetchExportJob(r.Context(), vars["id"], vars["job_id"])
+	if err != nil {
+		utils.GetError(err, http.StatusNotFound, w)
+		return
+	}
+
+	utils.GetSuccess("export retrieved successfully", job, w)
+}
+
+// DownloadExport sends the archive of a completed export.
+func (oh *OrganizationHandler) DownloadExport(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+
+	job, err := fetchExportJob(r.Context(), vars["id"], vars["job_id"])
+	if err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		utils.GetError(err, http.StatusNotFound, w)
+
+		return
+	}
+
+	if job.Status != ExportCompleted || (job.ExpiresAt != nil && time.Now().After(*job.ExpiresAt)) {
+		w.Header().Set("Content-Type", "application/json")
+		utils.GetError(ErrExportNotReady, http.StatusGone, w)
+
+		return
+	}
+
+	bucket, err := exportArchives()
+	if err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		utils.GetError(err, http.StatusInternalServerError, w)
+
+		return
+	}
+
+	archive, err := bucket.OpenDownloadStream(job.ID)
+	if err != nil {
+		w.Header().Set("Content-Type", "application/json")
+
+		if errors.Is(err, gridfs.ErrFileNotFound) {
+			utils.GetError(ErrExportNotReady, http.StatusGone, w)
+		} else {
+			utils.GetError(err, http.StatusInternalServerError, w)
+		}
+
+		return
+	}
+	defer archive.Close()
+
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Content-Length", strconv.FormatInt(archive.GetFile().Length, 10))
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", exportArchiveName(job)))
+
+	if _, err := io.Copy(w, archive); err != nil {
+		log.Printf("organization export: could not send %s: %v", job.ID.Hex(), err)
+	}
+}
+
+// RunExportWorker builds queued exports as they come in and deletes expired
+// archives, checking at least every interval, until ctx is done.
+func (oh *OrganizationHandler) RunExportWorker(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		case <-exportQueued:
+		}
+
+		oh.processExports(ctx)
+		expireExports(ctx)
+	}
+}
+
+// claimExportJob marks the oldest queued job as running and returns it.
+func claimExportJob(ctx context.Context) (*ExportJob, error) {
+	now := time.Now()
+	filter := bson.M{"$or": bson.A{
+		bson.M{"status": ExportPending},
+		bson.M{"status": ExportRunning, "heartbeat_at": bson.M{"$lt": now.Add(-exportClaimTimeout)}},
+	}}
+
+	update := bson.M{"$set": bson.M{"status": ExportRunning, "started_at": now, "heartbeat_at": now, "progress": 0}}
+	opts := options.FindOneAndUpdate().SetSort(bson.M{"created_at": 1}).SetReturnDocument(options.After)
+
+	var job ExportJob
+	if err := exportJobs().FindOneAndUpdate(ctx, filter, update, opts).Decode(&job); err != nil {
+		return nil, err
+	}
+
+	return &job, nil
+}
+
+// claimFilter matches job only while this worker still holds its claim.
+func claimFilter(job *ExportJob) bson.M {
+	return bson.M{"_id": job.ID, "status": ExportRunning, "started_at": job.StartedAt}
+}
+
+// heartbeatExport keeps the claim on job fresh until ctx is done. If another
+// worker has taken the job over it calls stop and reports the claim lost.
+func heartbeatExport(ctx context.Context, job *ExportJob, stop func()) bool {
+	ticker := time.NewTicker(exportHeartbeat)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return false
+		case <-ticker.C:
+		}
+
+		res, err := exportJobs().UpdateOne(ctx, claimFilter(job), bson.M{"$set": bson.M{"heartbeat_at": time.Now()}})
+		if err != nil {
+			log.Printf("organization export: could not touch claim on %s: %v", job.ID.Hex(), err)
+			continue
+		}
+
+		if res.MatchedCount == 0 {
+			stop()
+			return true
+		}
+	}
+}
+
+func (oh *OrganizationHandler) processExports(ctx context.Context) {
+	for {
+		job, err := claimExportJob(ctx)
+		if err != nil {
+			return
+		}
+
+		oh.processExport(ctx, job)
+	}
+}
+
+func (oh *OrganizationHandler) processExport(ctx context.Context, job *ExportJob) {
+	buildCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	lost := make(chan bool, 1)
+	go func() { lost <- heartbeatExport(buildCtx, job, cancel) }()
+
+	size, err := oh.buildExport(buildCtx, job)
+	cancel()
+
+	if <-lost {
+		log.Printf("organization export: %s: %v", job.ID.Hex(), errExportClaimLost)
+		return
+	}
+
+	if err != nil {
+		log.Printf("organization export: %s failed: %v", job.ID.Hex(), err)
+
+		set := bson.M{"status": ExportFailed, "error": err.Error()}
+		if _, err := exportJobs().UpdateOne(ctx, claimFilter(job), bson.M{"$set": set}); err != nil {
+			log.Printf("organization export: could not mark %s failed: %v", job.ID.Hex(), err)
+		}
+
+		return
+	}
+
+	now := time.Now()
+	expires := now.Add(time.Duration(oh.configs.ExportTTL) * time.Second)
+	set := bson.M{"status": ExportCompleted, "progress": 100, "size": size, "completed_at": now, "expires_at": expires}
+
+	res, err := exportJobs().UpdateOne(ctx, claimFilter(job), bson.M{"$set": set})
+	if err != nil {
+		log.Printf("organization export: could not complete %s: %v", job.ID.Hex(), err)
+		return
+	}
+
+	if res.MatchedCount == 0 {
+		log.Printf("organization export: %s: %v", job.ID.Hex(), errExportClaimLost)
+		return
+	}
+
+	oh.sendExportReady(job, expires)
+}
+
+// countingWriter counts the bytes written through it.
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+
+	return n, err
+}
+
+// buildExport streams the organization's data into an archive stored under
+// the job's id, returning its size.
+func (oh *OrganizationHandler) buildExport(ctx context.Context, job *ExportJob) (int64, error) {
+	sets, err := exportSets(ctx, job)
+	if err != nil {
+		return 0, err
+	}
+
+	bucket, err := exportArchives()
+	if err != nil {
+		return 0, err
+	}
+
+	// drop whatever an earlier attempt at this job left behind
+	if err := removeExportArchive(bucket, job.ID); err != nil {
+		return 0, err
+	}
+
+	upload, err := bucket.OpenUploadStreamWithID(job.ID, exportArchiveName(job))
+	if err != nil {
+		return 0, err
+	}
+
+	out := &countingWriter{w: upload}
+
+	progress := func(done, total int) { setExportProgress(ctx, job, done, total) }
+	if err := writeExportArchive(ctx, out, sets, progress); err != nil {
+		if abortErr := upload.Abort(); abortErr != nil {
+			log.Printf("organization export: could not abort upload of %s: %v", job.ID.Hex(), abortErr)
+		}
+
+		return 0, err
+	}
+
+	return out.n, upload.Close()
+}
+
+// exportSets lists every set of the organization's data, one per collection
+// that holds it.
+func exportSets(ctx context.Context, job *ExportJob) ([]exportSet, error) {
+	filter, err := orgIDFilter(job.OrgID)
+	if err != nil {
+		return nil, err
+	}
+
+	var org bson.M
+	if err := utils.GetCollection(OrganizationCollectionName).FindOne(ctx, filter).Decode(&org); err != nil {
+		return nil, err
+	}
+
+	installed := []bson.M{}
+
+	if plugins, ok := org["plugins"].(bson.M); ok {
+		for _, p := range plugins {
+			if doc, ok := p.(bson.M); ok {
+				installed = append(installed, doc)
+			}
+		}
+	}
+
+	sets := []exportSet{{Name: "organization", Docs: []bson.M{org}}, {Name: "installed_plugins", Docs: installed}}
+
+	sources := []struct {
+		name       string
+		collection string
+		field      string
+	}{
+		{"members", MemberCollectionName, "org_id"},
+		{"invites", OrganizationInviteCollectionName, "org_id"},
+		{"reports", report.ReportCollectionName, "organization_id"},
+		{"token_transactions", TokenTransactionCollectionName, "org_id"},
+	}
+
+	for _, src := range sources {
+		sets = append(sets, exportSet{Name: src.name, Coll: utils.GetCollection(src.collection), Filter: bson.M{src.field: job.OrgID}})
+	}
+
+	db := utils.GetCollection(OrganizationCollectionName).Database()
+
+	pluginColls, err := db.ListCollectionNames(ctx, bson.M{"name": bson.M{"$regex": pluginDataCollections}})
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Strings(pluginColls)
+
+	for _, name := range pluginColls {
+		filter := bson.M{"organization_id": job.OrgID}
+
+		n, err := db.Collection(name).CountDocuments(ctx, filter, options.Count().SetLimit(1))
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", name, err)
+		}
+
+		if n > 0 {
+			sets = append(sets, exportSet{Name: "plugin_data/" + name, Coll: db.Collection(name), Filter: filter})
+		}
+	}
+
+	return sets, nil
+}
+
+// setExportProgress records done out of total sets, keeping the last few
+// percent for storing the archive.
+func setExportProgress(ctx context.Context, job *ExportJob, done, total int) {
+	progress := done * 90 / total
+
+	if _, err := exportJobs().UpdateOne(ctx, claimFilter(job), bson.M{"$set": bson.M{"progress": progress}}); err != nil {
+		log.Printf("organization export: could not update progress of %s: %v", job.ID.Hex(), err)
+	}
+}
+
+// writeExportArchive writes every set as both <name>.json and <name>.csv,
+// reading each set once per file so no set is held in memory. progress, if
+// set, is called after each set.
+func writeExportArchive(ctx context.Context, w io.Writer, sets []exportSet, progress func(done, total int)) error {
+	zw := zip.NewWriter(w)
+
+	for i, set := range sets {
+		f, err := zw.Create(set.Name + ".json")
+		if err != nil {
+			return err
+		}
+
+		header, err := writeExportJSON(ctx, f, set)
+		if err != nil {
+			return fmt.Errorf("%s: %w", set.Name, err)
+		}
+
+		f, err = zw.Create(set.Name + ".csv")
+		if err != nil {
+			return err
+		}
+
+		if err := writeExportCSV(ctx, f, set, header); err != nil {
+			return fmt.Errorf("%s: %w", set.Name, err)
+		}
+
+		if progress != nil {
+			progress(i+1, len(sets))
+		}
+	}
+
+	return zw.Close()
+}
+
+// writeExportJSON writes the set as an indented JSON array and returns every
+// field path found in its documents, in sorted order.
+func writeExportJSON(ctx context.Context, w io.Writer, set exportSet) ([]string, error) {
+	columns := map[string]bool{}
+	sep := "[\n  "
+
+	err := set.each(ctx, func(doc bson.M) error {
+		for field := range flattenDoc(doc) {
+			columns[field] = true
+		}
+
+		b, err := json.MarshalIndent(doc, "  ", "  ")
+		if err != nil {
+			return err
+		}
+
+		if _, err := io.WriteString(w, sep); err != nil {
+			return err
+		}
+
+		sep = ",\n  "
+		_, err = w.Write(b)
+
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	end := "\n]\n"
+	if sep == "[\n  " {
+		end = "[]\n"
+	}
+
+	if _, err := io.WriteString(w, end); err != nil {
+		return nil, err
+	}
+
+	header := make([]string, 0, len(columns))
+	for field := range columns {
+		header = append(header, field)
+	}
+
+	sort.Strings(header)
+
+	return header, nil
+}
+
+// writeExportCSV writes the set with one column per field in header.
+func writeExportCSV(ctx context.Context, w io.Writer, set exportSet, header []string) error {
+	out := csv.NewWriter(w)
+	if err := out.Write(header); err != nil {
+		return err
+	}
+
+	err := set.each(ctx, func(doc bson.M) error {
+		row := flattenDoc(doc)
+
+		record := make([]string, len(header))
+		for i, field := range header {
+			record[i] = csvSafe(exportCSVValue(row[field]))
+		}
+
+		return out.Write(record)
+	})
+	if err != nil {
+		return err
+	}
+
+	out.Flush()
+
+	return out.Error()
+}
+
+func exportCSVValue(v interface{}) string {
+	switch t := v.(type) {
+	case nil:
+		return ""
+	case string:
+		return t
+	case primitive.ObjectID:
+		return t.Hex()
+	case primitive.DateTime:
+		return t.Time().UTC().Format(time.RFC3339)
+	case bson.A, bson.M, bson.D:
+		b, _ := json.Marshal(t)
+		return string(b)
+	default:
+		return fmt.Sprint(t)
+	}
+}
+
+func (oh *OrganizationHandler) sendExportReady(job *ExportJob, expires time.Time) {
+	name := job.OrgID
+	if org, err := FetchOrganizationByID(job.OrgID); err == nil && org.Name != "" {
+		name = org.Name
+	}
+
+	link := fmt.Sprintf("%s?org=%s&job=%s", oh.configs.ExportURL, url.QueryEscape(job.OrgID), job.ID.Hex())
+
+	mail := oh.mailService.NewMail([]string{job.RequestedBy}, fmt.Sprintf("Your %s export is ready", name), service.OrgExportReady, map[string]interface{}{
+		"Username":         job.RequestedBy,
+		"OrganizationName": name,
+		"DownloadLink":     link,
+		"ExpiresAt":        expires.UTC().Format(time.RFC1123),
+	})
+
+	if err := oh.mailService.SendMail(mail); err != nil {
+		log.Printf("Error occurred while sending mail: %s", err.Error())
+	}
+}
+
+// expireExports deletes archives past their expiry.
+func expireExports(ctx context.Context) {
+	filter := bson.M{"status": ExportCompleted, "expires_at": bson.M{"$lte": time.Now()}}
+
+	cursor, err := exportJobs().Find(ctx, filter)
+	if err != nil {
+		return
+	}
+
+	var jobs []ExportJob
+	if err := cursor.All(ctx, &jobs); err != nil {
+		return
+	}
+
+	bucket, err := exportArchives()
+	if err != nil {
+		log.Printf("organization export: %v", err)
+		return
+	}
+
+	for _, job := range jobs {
+		if err := removeExportArchive(bucket, job.ID); err != nil {
+			log.Printf("organization export: could not delete archive of %s: %v", job.ID.Hex(), err)
+			continue
+		}
+
+		if _, err := exportJobs().UpdateOne(ctx, bson.M{"_id": job.ID}, bson.M{"$set": bson.M{"status": ExportExpired}}); err != nil {
+			log.Printf("organization export: could not expire %s: %v", job.ID.Hex(), err)
+		}
+	}
+}
+
+// removeExportArchive deletes the archive stored under id, along with any
+// chunks an interrupted upload left behind.
+func removeExportArchive(bucket *gridfs.Bucket, id primitive.ObjectID) error {
+	if err := bucket.Delete(id); err != nil && !errors.Is(err, gridfs.ErrFileNotFound) {
+		return err
+	}
+
+	return nil
+}
+
+// removeOrgExports deletes every export archive of orgID.
+func removeOrgExports(ctx context.Context, orgID string) error {
+	ids, err := exportJobs().Distinct(ctx, "_id", bson.M{"org_id": orgID})
+	if err != nil {
+		return err
+	}
+
+	bucket, err := exportArchives()
+	if err != nil {
+		return err
+	}
+
+	for _, id := range ids {
+		if oid, ok := id.(primitive.ObjectID); ok {
+			if err := removeExportArchive(bucket, oid); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
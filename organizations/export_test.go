@@ -0,0 +1,99 @@
+package organizations
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestWriteExportCSV(t *testing.T) {
+	created := primitive.NewDateTimeFromTime(time.Date(2021, 9, 1, 12, 0, 0, 0, time.UTC))
+	docs := []bson.M{
+		{"email": "a@zuri.chat", "role": "admin", "status": bson.M{"text": "away"}, "created_at": created},
+		{"email": "b@zuri.chat", "files": bson.A{"x.png"}},
+	}
+
+	set := exportSet{Name: "members", Docs: docs}
+
+	header, err := writeExportJSON(context.Background(), io.Discard, set)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	if err := writeExportCSV(context.Background(), &buf, set, header); err != nil {
+		t.Fatal(err)
+	}
+
+	want := "created_at,email,files,role,status.text\n" +
+		"2021-09-01T12:00:00Z,a@zuri.chat,,admin,away\n" +
+		",b@zuri.chat,\"[\"\"x.png\"\"]\",,\n"
+
+	if got := buf.String(); got != want {
+		t.Errorf("writeExportCSV() =\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestWriteExportArchive(t *testing.T) {
+	sets := []exportSet{
+		{Name: "members", Docs: []bson.M{{"email": "a@zuri.chat"}}},
+		{Name: "plugin_data/6145d1e1a7f5a1f8a7d2b3c4__tasks", Docs: []bson.M{{"title": "=cmd"}}},
+		{Name: "invites"},
+	}
+
+	var progress []int
+
+	var buf bytes.Buffer
+	if err := writeExportArchive(context.Background(), &buf, sets, func(done, total int) { progress = append(progress, done*100/total) }); err != nil {
+		t.Fatal(err)
+	}
+
+	if want := []int{33, 66, 100}; !reflect.DeepEqual(progress, want) {
+		t.Errorf("progress = %v, want %v", progress, want)
+	}
+
+	zr, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	files := map[string]string{}
+
+	for _, f := range zr.File {
+		rc, err := f.Open()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		b, _ := io.ReadAll(rc)
+		rc.Close()
+
+		files[f.Name] = string(b)
+	}
+
+	if files["invites.json"] != "[]\n" {
+		t.Errorf("invites.json = %q, want an empty array", files["invites.json"])
+	}
+
+	for _, name := range []string{"members.json", "members.csv", "plugin_data/6145d1e1a7f5a1f8a7d2b3c4__tasks.json", "plugin_data/6145d1e1a7f5a1f8a7d2b3c4__tasks.csv"} {
+		if _, ok := files[name]; !ok {
+			t.Errorf("archive is missing %s", name)
+		}
+	}
+
+	if !strings.Contains(files["members.json"], `"email": "a@zuri.chat"`) {
+		t.Errorf("members.json = %s", files["members.json"])
+	}
+
+	if !strings.Contains(files["plugin_data/6145d1e1a7f5a1f8a7d2b3c4__tasks.csv"], "'=cmd") {
+		t.Error("csv cells are not guarded against formulas")
+	}
+}
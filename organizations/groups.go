@@ -0,0 +1,747 @@
+package organizations
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-playground/validator/v10"
+	"github.com/gorilla/mux"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"zuri.chat/zccore/auth"
+	pluginp "zuri.chat/zccore/plugin"
+	"zuri.chat/zccore/utils"
+)
+
+var (
+	groupHandleRegex = regexp.MustCompile(`^[a-z0-9][a-z0-9._-]{1,31}$`)
+
+	// handles chat clients already give a meaning to
+	reservedGroupHandles = map[string]bool{"all": true, "channel": true, "everyone": true, "here": true}
+
+	errInvalidGroupHandle = errors.New("handle must be 2 to 32 lowercase letters, digits, ., - or _ and start with a letter or digit")
+	errReservedHandle     = errors.New("this handle is reserved")
+	errGroupExists        = errors.New("a group with this handle already exists")
+	errGroupNotFound      = errors.New("group not found")
+	errNotGroupAdmin      = errors.New("only group admins can change this group")
+	errGroupMembers       = errors.New("every member must be an active member of the organization")
+	errGroupAdmins        = errors.New("group admins must be members of the group")
+)
+
+var groupIndexOnce sync.Once
+
+// Group is a named set of members, addressed as @handle. The roles it is
+// given add to the role of each of its members.
+type Group struct {
+	ID          primitive.ObjectID `json:"id" bson:"_id,omitempty"`
+	OrgID       string             `json:"org_id" bson:"org_id"`
+	Name        string             `json:"name" bson:"name"`
+	Handle      string             `json:"handle" bson:"handle"`
+	Description string             `json:"description" bson:"description"`
+	Members     []string           `json:"members" bson:"members"`
+	Admins      []string           `json:"admins" bson:"admins"`
+	Roles       []string           `json:"roles" bson:"roles"`
+	CreatedBy   string             `json:"created_by" bson:"created_by"`
+	CreatedAt   time.Time          `json:"created_at" bson:"created_at"`
+	UpdatedAt   time.Time          `json:"updated_at" bson:"updated_at"`
+}
+
+type groupBody struct {
+	Name        *string   `json:"name" validate:"omitempty,min=1,max=80"`
+	Handle      *string   `json:"handle"`
+	Description *string   `json:"description" validate:"omitempty,max=200"`
+	Members     []string  `json:"members"`
+	Admins      *[]string `json:"admins"`
+	Roles       *[]string `json:"roles"`
+}
+
+// GroupMembersMessage is the sync message plugins get when group membership
+// changes.
+type GroupMembersMessage struct {
+	OrganizationID string   `json:"organization_id" bson:"organization_id"`
+	GroupID        string   `json:"group_id" bson:"group_id"`
+	Handle         string   `json:"handle" bson:"handle"`
+	MemberIDs      []string `json:"member_ids" bson:"member_ids"`
+}
+
+func groups() *mongo.Collection {
+	coll := utils.GetCollection(auth.GroupCollectionName)
+
+	groupIndexOnce.Do(func() {
+		indexModel := mongo.IndexModel{
+			Keys:    bson.D{{Key: "org_id", Value: 1}, {Key: "handle", Value: 1}},
+			Options: options.Index().SetUnique(true),
+		}
+
+		if _, err := coll.Indexes().CreateOne(context.Background(), indexModel); err != nil {
+			log.Printf("groups: could not create index: %v", err)
+		}
+	})
+
+	return coll
+}
+
+// normalizeHandle lowercases a handle and drops the @ it is mentioned with.
+func normalizeHandle(handle string) (string, error) {
+	handle = strings.ToLower(strings.TrimPrefix(strings.TrimSpace(handle), "@"))
+
+	if !groupHandleRegex.MatchString(handle) {
+		return "", errInvalidGroupHandle
+	}
+
+	if reservedGroupHandles[handle] {
+		return "", errReservedHandle
+	}
+
+	return handle, nil
+}
+
+// uniqueIDs returns ids without blanks or repeats, in order.
+func uniqueIDs(ids []string) []string {
+	seen := make(map[string]bool, len(ids))
+	out := make([]string, 0, len(ids))
+
+	for _, id := range ids {
+		id = strings.TrimSpace(id)
+		if id == "" || seen[id] {
+			continue
+		}
+
+		seen[id] = true
+
+		out = append(out, id)
+	}
+
+	return out
+}
+
+// subtractIDs returns the ids in a that are not in b.
+func subtractIDs(a, b []string) []string {
+	in := make(map[string]bool, len(b))
+	for _, id := range b {
+		in[id] = true
+	}
+
+	out := []string{}
+
+	for _, id := range a {
+		if !in[id] {
+			out = append(out, id)
+		}
+	}
+
+	return out
+}
+
+// checkActiveMembers makes sure every id is an active member of orgID.
+func checkActiveMembers(ctx context.Context, orgID string, ids []string) error {
+	if len(ids) == 0 {
+		return nil
+	}
+
+	objIDs := make([]primitive.ObjectID, len(ids))
+
+	for i, id := range ids {
+		objID, err := primitive.ObjectIDFromHex(id)
+		if err != nil {
+			return errGroupMembers
+		}
+
+		objIDs[i] = objID
+	}
+
+	filter := bson.M{"_id": bson.M{"$in": objIDs}, "org_id": orgID, "deleted": bson.M{"$ne": true}}
+
+	n, err := utils.GetCollection(MemberCollectionName).CountDocuments(ctx, filter)
+	if err != nil {
+		return err
+	}
+
+	if n != int64(len(ids)) {
+		return errGroupMembers
+	}
+
+	return nil
+}
+
+// checkGroupRoles makes sure roles exist and the caller could give every
+// permission they carry.
+func checkGroupRoles(r *http.Request, orgID string, roles []string) error {
+	caller, err := callerRole(r, orgID)
+	if err != nil {
+		return err
+	}
+
+	return checkRolesGrantable(caller, orgID, roles)
+}
+
+func checkRolesGrantable(caller *auth.Role, orgID string, roles []string) error {
+	for _, name := range roles {
+		role, err := auth.FetchRole(orgID, name)
+		if err != nil || role.Name == OwnerRole {
+			return fmt.Errorf("role %s is not valid", name)
+		}
+
+		if err := checkGrantable(caller, role.Permissions); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func fetchGroup(ctx context.Context, orgID, groupID string) (*Group, error) {
+	objID, err := primitive.ObjectIDFromHex(groupID)
+	if err != nil {
+		return nil, errGroupNotFound
+	}
+
+	var g Group
+	if err := groups().FindOne(ctx, bson.M{"_id": objID, "org_id": orgID}).Decode(&g); err != nil {
+		return nil, errGroupNotFound
+	}
+
+	return &g, nil
+}
+
+// checkMembershipGrant guards membership changes of a group carrying roles.
+// Adding or removing a member gives or takes those roles, so the caller needs
+// roles.manage and every permission the roles carry, group admin or not.
+func checkMembershipGrant(caller *auth.Role, orgID string, roles []string) error {
+	if len(roles) == 0 {
+		return nil
+	}
+
+	if !caller.Has(auth.PermRolesManage) {
+		return auth.ErrPermissionDenied
+	}
+
+	return checkRolesGrantable(caller, orgID, roles)
+}
+
+// checkGroupMembership makes sure the caller may change who is in g.
+func checkGroupMembership(r *http.Request, g *Group) error {
+	if ok, err := canChangeGroup(r, g); !ok {
+		if err == nil {
+			err = errNotGroupAdmin
+		}
+
+		return err
+	}
+
+	if len(g.Roles) == 0 {
+		return nil
+	}
+
+	caller, err := callerRole(r, g.OrgID)
+	if err != nil {
+		return err
+	}
+
+	return checkMembershipGrant(caller, g.OrgID, g.Roles)
+}
+
+// canChangeGroup reports whether the caller may change g's details and
+// members: group admins can, and so can anyone holding groups.manage.
+func canChangeGroup(r *http.Request, g *Group) (bool, error) {
+	member, err := callerMember(r, g.OrgID)
+	if err != nil {
+		return false, err
+	}
+
+	for _, id := range g.Admins {
+		if id == member.ID {
+			return true, nil
+		}
+	}
+
+	return auth.MemberHasPermission(g.OrgID, member.ID, member.Role, auth.PermGroupsManage), nil
+}
+
+// emitGroupMembers tells subscribers and installed plugins that members joined
+// or left a group.
+func emitGroupMembers(g *Group, memberIDs []string, added bool) {
+	if len(memberIDs) == 0 {
+		return
+	}
+
+	event, message := AddOrganizationGroupMembers, "group_members_added"
+	if !added {
+		event, message = RemoveOrganizationGroupMembers, "group_members_removed"
+	}
+
+	payload := GroupMembersMessage{OrganizationID: g.OrgID, GroupID: g.ID.Hex(), Handle: g.Handle, MemberIDs: memberIDs}
+
+	go utils.Emitter(utils.Event{
+		Identifier: g.ID.Hex(),
+		Type:       "Group",
+		Event:      event,
+		Channel:    fmt.Sprintf("organizations_%s", g.OrgID),
+		Payload:    utils.M{"group_id": payload.GroupID, "handle": payload.Handle, "member_ids": memberIDs},
+	})
+
+	if err := AddSyncMessage(g.OrgID, message, payload); err != nil {
+		log.Printf("sync error: %v", err)
+	}
+}
+
+func emitGroupEvent(g *Group, event string) {
+	go utils.Emitter(utils.Event{
+		Identifier: g.ID.Hex(),
+		Type:       "Group",
+		Event:      event,
+		Channel:    fmt.Sprintf("organizations_%s", g.OrgID),
+		Payload:    utils.M{"group_id": g.ID.Hex(), "handle": g.Handle, "name": g.Name},
+	})
+}
+
+// ListGroups returns an organization's groups. handle looks one up for a
+// mention, q matches the start of handles and names, and member_id lists the
+// groups a member is in.
+func (oh *OrganizationHandler) ListGroups(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	orgID := mux.Vars(r)["id"]
+	query := r.URL.Query()
+	filter := bson.M{"org_id": orgID}
+
+	if handle := query.Get("handle"); handle != "" {
+		filter["handle"] = strings.ToLower(strings.TrimPrefix(handle, "@"))
+	}
+
+	if q := strings.TrimPrefix(strings.TrimSpace(query.Get("q")), "@"); q != "" {
+		re := primitive.Regex{Pattern: "^" + regexp.QuoteMeta(q), Options: "i"}
+		filter["$or"] = bson.A{bson.M{"handle": re}, bson.M{"name": re}}
+	}
+
+	if memberID := query.Get("member_id"); memberID != "" {
+		filter["members"] = memberID
+	}
+
+	cursor, err := groups().Find(r.Context(), filter, options.Find().SetSort(bson.M{"handle": 1}))
+	if err != nil {
+		utils.GetError(err, http.StatusInternalServerError, w)
+		return
+	}
+
+	list := []Group{}
+	if err := cursor.All(r.Context(), &list); err != nil {
+		utils.GetError(err, http.StatusInternalServerError, w)
+		return
+	}
+
+	utils.GetSuccess("groups retrieved successfully", list, w)
+}
+
+// GetGroup returns one group.
+func (oh *OrganizationHandler) GetGroup(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	vars := mux.Vars(r)
+
+	g, err := fetchGroup(r.Context(), vars["id"], vars["group_id"])
+	if err != nil {
+		utils.GetError(err, http.StatusNotFound, w)
+		return
+	}
+
+	utils.GetSuccess("group retrieved successfully", g, w)
+}
+
+// CreateGroup adds a group. The creator becomes its first admin unless
+// admins are given.
+func (oh *OrganizationHandler) CreateGroup(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	orgID := mux.Vars(r)["id"]
+
+	var body groupBody
+	if err := utils.ParseJSONFromRequest(r, &body); err != nil {
+		utils.GetError(err, http.StatusUnprocessableEntity, w)
+		return
+	}
+
+	if err := validator.New().Struct(body); err != nil {
+		utils.GetError(err, http.StatusBadRequest, w)
+		return
+	}
+
+	if body.Name == nil || body.Handle == nil {
+		utils.GetError(errors.New("name and handle are required"), http.StatusBadRequest, w)
+		return
+	}
+
+	handle, err := normalizeHandle(*body.Handle)
+	if err != nil {
+		utils.GetError(err, http.StatusBadRequest, w)
+		return
+	}
+
+	creator, err := callerMember(r, orgID)
+	if err != nil {
+		utils.GetError(err, http.StatusForbidden, w)
+		return
+	}
+
+	now := time.Now()
+	g := Group{
+		OrgID:     orgID,
+		Name:      strings.TrimSpace(*body.Name),
+		Handle:    handle,
+		Members:   uniqueIDs(body.Members),
+		Admins:    []string{creator.ID},
+		Roles:     []string{},
+		CreatedBy: creator.ID,
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
+
+	if body.Description != nil {
+		g.Description = *body.Description
+	}
+
+	if body.Admins != nil {
+		g.Admins = uniqueIDs(*body.Admins)
+	}
+
+	if body.Roles != nil {
+		g.Roles = uniqueIDs(*body.Roles)
+	}
+
+	// admins are members of the group they run
+	g.Members = uniqueIDs(append(g.Members, g.Admins...))
+
+	if err := checkActiveMembers(r.Context(), orgID, g.Members); err != nil {
+		utils.GetError(err, http.StatusBadRequest, w)
+		return
+	}
+
+	if err := checkGroupRoles(r, orgID, g.Roles); err != nil {
+		utils.GetError(err, http.StatusForbidden, w)
+		return
+	}
+
+	res, err := groups().InsertOne(r.Context(), g)
+	if err != nil {
+		if mongo.IsDuplicateKeyError(err) {
+			utils.GetError(errGroupExists, http.StatusConflict, w)
+			return
+		}
+
+		utils.GetError(err, http.StatusInternalServerError, w)
+
+		return
+	}
+
+	g.ID, _ = res.InsertedID.(primitive.ObjectID)
+
+	logAudit(r, AuditEvent{Action: AuditGroupCreated, TargetType: "group", TargetID: g.ID.Hex()},
+		nil, bson.M{"handle": g.Handle, "members": g.Members, "admins": g.Admins, "roles": g.Roles})
+
+	emitGroupEvent(&g, CreateOrganizationGroup)
+	emitGroupMembers(&g, g.Members, true)
+
+	utils.GetSuccess("group created successfully", g, w)
+}
+
+// UpdateGroup changes a group's name, handle, description, admins or roles.
+// Group admins can change all but the roles, which need groups.manage.
+func (oh *OrganizationHandler) UpdateGroup(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	vars := mux.Vars(r)
+	orgID := vars["id"]
+
+	g, err := fetchGroup(r.Context(), orgID, vars["group_id"])
+	if err != nil {
+		utils.GetError(err, http.StatusNotFound, w)
+		return
+	}
+
+	if ok, err := canChangeGroup(r, g); !ok {
+		if err == nil {
+			err = errNotGroupAdmin
+		}
+
+		utils.GetError(err, http.StatusForbidden, w)
+
+		return
+	}
+
+	var body groupBody
+	if err := utils.ParseJSONFromRequest(r, &body); err != nil {
+		utils.GetError(err, http.StatusUnprocessableEntity, w)
+		return
+	}
+
+	if err := validator.New().Struct(body); err != nil {
+		utils.GetError(err, http.StatusBadRequest, w)
+		return
+	}
+
+	before := bson.M{"name": g.Name, "handle": g.Handle, "description": g.Description, "admins": g.Admins, "roles": g.Roles}
+	set := bson.M{}
+
+	if body.Name != nil {
+		set["name"] = strings.TrimSpace(*body.Name)
+	}
+
+	if body.Description != nil {
+		set["description"] = *body.Description
+	}
+
+	if body.Handle != nil {
+		handle, err := normalizeHandle(*body.Handle)
+		if err != nil {
+			utils.GetError(err, http.StatusBadRequest, w)
+			return
+		}
+
+		set["handle"] = handle
+	}
+
+	if body.Admins != nil {
+		admins := uniqueIDs(*body.Admins)
+		if len(subtractIDs(admins, g.Members)) > 0 {
+			utils.GetError(errGroupAdmins, http.StatusBadRequest, w)
+			return
+		}
+
+		set["admins"] = admins
+	}
+
+	if body.Roles != nil {
+		member, err := callerMember(r, orgID)
+		if err != nil || !auth.MemberHasPermission(orgID, member.ID, member.Role, auth.PermGroupsManage) {
+			utils.GetError(auth.ErrPermissionDenied, http.StatusForbidden, w)
+			return
+		}
+
+		roles := uniqueIDs(*body.Roles)
+
+		// as with UpdateRole, taking a role away needs as much reach as giving it
+		if err := checkGroupRoles(r, orgID, append(roles, g.Roles...)); err != nil {
+			utils.GetError(err, http.StatusForbidden, w)
+			return
+		}
+
+		set["roles"] = roles
+	}
+
+	if len(set) == 0 {
+		utils.GetSuccess("group updated successfully", g, w)
+		return
+	}
+
+	set["updated_at"] = time.Now()
+
+	if err := groups().FindOneAndUpdate(r.Context(), bson.M{"_id": g.ID}, bson.M{"$set": set},
+		options.FindOneAndUpdate().SetReturnDocument(options.After)).Decode(g); err != nil {
+		if mongo.IsDuplicateKeyError(err) {
+			utils.GetError(errGroupExists, http.StatusConflict, w)
+			return
+		}
+
+		utils.GetError(err, http.StatusInternalServerError, w)
+
+		return
+	}
+
+	logAudit(r, AuditEvent{Action: AuditGroupUpdated, TargetType: "group", TargetID: g.ID.Hex()},
+		before, bson.M{"name": g.Name, "handle": g.Handle, "description": g.Description, "admins": g.Admins, "roles": g.Roles})
+
+	emitGroupEvent(g, UpdateOrganizationGroup)
+
+	utils.GetSuccess("group updated successfully", g, w)
+}
+
+// DeleteGroup removes a group. Its members lose the roles it gave them, so
+// deleting a group carrying roles is checked like removing its members.
+func (oh *OrganizationHandler) DeleteGroup(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	vars := mux.Vars(r)
+
+	g, err := fetchGroup(r.Context(), vars["id"], vars["group_id"])
+	if err != nil {
+		utils.GetError(err, http.StatusNotFound, w)
+		return
+	}
+
+	if len(g.Roles) > 0 {
+		caller, err := callerRole(r, g.OrgID)
+		if err == nil {
+			err = checkMembershipGrant(caller, g.OrgID, g.Roles)
+		}
+
+		if err != nil {
+			utils.GetError(err, http.StatusForbidden, w)
+			return
+		}
+	}
+
+	if _, err := groups().DeleteOne(r.Context(), bson.M{"_id": g.ID}); err != nil {
+		utils.GetError(err, http.StatusInternalServerError, w)
+		return
+	}
+
+	logAudit(r, AuditEvent{Action: AuditGroupDeleted, TargetType: "group", TargetID: g.ID.Hex()},
+		bson.M{"handle": g.Handle, "members": g.Members, "roles": g.Roles}, nil)
+
+	emitGroupEvent(g, DeleteOrganizationGroup)
+	emitGroupMembers(g, g.Members, false)
+
+	utils.GetSuccess("group deleted successfully", nil, w)
+}
+
+// AddGroupMembers adds active organization members to a group. On a group
+// carrying roles this hands those roles out, so it is checked like one.
+func (oh *OrganizationHandler) AddGroupMembers(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	vars := mux.Vars(r)
+	orgID := vars["id"]
+
+	g, err := fetchGroup(r.Context(), orgID, vars["group_id"])
+	if err != nil {
+		utils.GetError(err, http.StatusNotFound, w)
+		return
+	}
+
+	if err := checkGroupMembership(r, g); err != nil {
+		utils.GetError(err, http.StatusForbidden, w)
+		return
+	}
+
+	body := struct {
+		MemberIDs []string `json:"member_ids"`
+	}{}
+
+	if err := utils.ParseJSONFromRequest(r, &body); err != nil {
+		utils.GetError(err, http.StatusUnprocessableEntity, w)
+		return
+	}
+
+	added := subtractIDs(uniqueIDs(body.MemberIDs), g.Members)
+
+	if err := checkActiveMembers(r.Context(), orgID, added); err != nil {
+		utils.GetError(err, http.StatusBadRequest, w)
+		return
+	}
+
+	if len(added) > 0 {
+		update := bson.M{"$addToSet": bson.M{"members": bson.M{"$each": added}}, "$set": bson.M{"updated_at": time.Now()}}
+		if _, err := groups().UpdateOne(r.Context(), bson.M{"_id": g.ID}, update); err != nil {
+			utils.GetError(err, http.StatusInternalServerError, w)
+			return
+		}
+
+		logAudit(r, AuditEvent{Action: AuditGroupMembersChanged, TargetType: "group", TargetID: g.ID.Hex()},
+			bson.M{"members": g.Members}, bson.M{"members": append(append([]string{}, g.Members...), added...)})
+
+		emitGroupMembers(g, added, true)
+
+		g.Members = append(g.Members, added...)
+	}
+
+	utils.GetSuccess("group members added successfully", g, w)
+}
+
+// RemoveGroupMember takes a member out of a group. Members can always leave a
+// group themselves, which only ever takes permissions away.
+func (oh *OrganizationHandler) RemoveGroupMember(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	vars := mux.Vars(r)
+	orgID, memberID := vars["id"], vars["member_id"]
+
+	g, err := fetchGroup(r.Context(), orgID, vars["group_id"])
+	if err != nil {
+		utils.GetError(err, http.StatusNotFound, w)
+		return
+	}
+
+	caller, err := callerMember(r, orgID)
+	if err != nil {
+		utils.GetError(err, http.StatusForbidden, w)
+		return
+	}
+
+	if caller.ID != memberID {
+		if err := checkGroupMembership(r, g); err != nil {
+			utils.GetError(err, http.StatusForbidden, w)
+			return
+		}
+	}
+
+	remaining := subtractIDs(g.Members, []string{memberID})
+	if len(remaining) == len(g.Members) {
+		utils.GetError(errors.New("member is not in this group"), http.StatusNotFound, w)
+		return
+	}
+
+	update := bson.M{"$pull": bson.M{"members": memberID, "admins": memberID}, "$set": bson.M{"updated_at": time.Now()}}
+	if _, err := groups().UpdateOne(r.Context(), bson.M{"_id": g.ID}, update); err != nil {
+		utils.GetError(err, http.StatusInternalServerError, w)
+		return
+	}
+
+	logAudit(r, AuditEvent{Action: AuditGroupMembersChanged, TargetType: "group", TargetID: g.ID.Hex()},
+		bson.M{"members": g.Members}, bson.M{"members": remaining})
+
+	emitGroupMembers(g, []string{memberID}, false)
+
+	g.Members, g.Admins = remaining, subtractIDs(g.Admins, []string{memberID})
+
+	utils.GetSuccess("group member removed successfully", g, w)
+}
+
+// GetGroupsForPlugin lets an installed plugin read the organization's groups,
+// optionally only those a member is in.
+func (oh *OrganizationHandler) GetGroupsForPlugin(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	orgID := mux.Vars(r)["org_id"]
+
+	plugin, ok := r.Context().Value(pluginp.PluginContext).(*pluginp.Plugin)
+	if !ok {
+		utils.GetError(errors.New("invalid plugin"), http.StatusUnauthorized, w)
+		return
+	}
+
+	if _, err := fetchInstalledPlugin(orgID, plugin.ID.Hex()); err != nil {
+		utils.GetError(err, http.StatusNotFound, w)
+		return
+	}
+
+	filter := bson.M{"org_id": orgID}
+	if memberID := r.URL.Query().Get("member_id"); memberID != "" {
+		filter["members"] = memberID
+	}
+
+	opts := options.Find().SetSort(bson.M{"handle": 1}).SetProjection(bson.M{"roles": 0, "created_by": 0})
+
+	cursor, err := groups().Find(r.Context(), filter, opts)
+	if err != nil {
+		utils.GetError(err, http.StatusInternalServerError, w)
+		return
+	}
+
+	list := []Group{}
+	if err := cursor.All(r.Context(), &list); err != nil {
+		utils.GetError(err, http.StatusInternalServerError, w)
+		return
+	}
+
+	utils.GetSuccess("groups retrieved successfully", list, w)
+}
@@ -0,0 +1,76 @@
+package organizations
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	"zuri.chat/zccore/auth"
+)
+
+func TestNormalizeHandle(t *testing.T) {
+	tests := []struct {
+		in   string
+		want string
+		err  error
+	}{
+		{" @Design ", "design", nil},
+		{"ios-team", "ios-team", nil},
+		{"team.2", "team.2", nil},
+		{"@here", "", errReservedHandle},
+		{"d", "", errInvalidGroupHandle},
+		{"_design", "", errInvalidGroupHandle},
+		{"design team", "", errInvalidGroupHandle},
+	}
+
+	for _, tt := range tests {
+		got, err := normalizeHandle(tt.in)
+		if got != tt.want || err != tt.err {
+			t.Errorf("normalizeHandle(%q) = %q, %v, want %q, %v", tt.in, got, err, tt.want, tt.err)
+		}
+	}
+}
+
+func TestGroupIDSets(t *testing.T) {
+	if got, want := uniqueIDs([]string{"a", " b", "", "a", "c", "b"}), []string{"a", "b", "c"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("uniqueIDs() = %v, want %v", got, want)
+	}
+
+	if got, want := subtractIDs([]string{"a", "b", "c"}, []string{"b", "d"}), []string{"a", "c"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("subtractIDs() = %v, want %v", got, want)
+	}
+
+	if got := subtractIDs([]string{"a"}, []string{"a"}); got == nil || len(got) != 0 {
+		t.Errorf("subtractIDs() of everything = %#v, want an empty slice", got)
+	}
+}
+
+func TestCheckMembershipGrant(t *testing.T) {
+	admin, _ := auth.FetchRole("org", AdminRole)
+	groupAdmin := &auth.Role{Name: MemberRole}
+	rolesOnly := &auth.Role{Name: "people-ops", Permissions: []string{auth.PermRolesManage}}
+
+	tests := []struct {
+		name   string
+		caller *auth.Role
+		roles  []string
+		ok     bool
+	}{
+		{"group without roles", groupAdmin, nil, true},
+		{"group admin without roles.manage", groupAdmin, []string{AdminRole}, false},
+		{"roles.manage without the role's permissions", rolesOnly, []string{AdminRole}, false},
+		{"admin", admin, []string{AdminRole, EditorRole}, true},
+		{"owner role", admin, []string{OwnerRole}, false},
+	}
+
+	for _, tt := range tests {
+		err := checkMembershipGrant(tt.caller, "org", tt.roles)
+		if (err == nil) != tt.ok {
+			t.Errorf("%s: checkMembershipGrant() = %v, want ok %v", tt.name, err, tt.ok)
+		}
+	}
+
+	if err := checkMembershipGrant(groupAdmin, "org", []string{AdminRole}); !errors.Is(err, auth.ErrPermissionDenied) {
+		t.Errorf("checkMembershipGrant() for a group admin = %v, want %v", err, auth.ErrPermissionDenied)
+	}
+}
@@ -0,0 +1,597 @@
+package organizations
+
+import (
+	"context"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"zuri.chat/zccore/auth"
+	"zuri.chat/zccore/service"
+	"zuri.chat/zccore/user"
+	"zuri.chat/zccore/utils"
+)
+
+const MemberImportCollectionName = "organization_member_imports"
+
+// member import job statuses.
+const (
+	ImportPending   = "pending"
+	ImportRunning   = "running"
+	ImportCompleted = "completed"
+	ImportFailed    = "failed"
+)
+
+// member import row results. A dry run only ever reports the first five.
+const (
+	ImportRowValid          = "valid"
+	ImportRowInvalid        = "invalid"
+	ImportRowDuplicate      = "duplicate"
+	ImportRowExistingMember = "existing_member"
+	ImportRowAlreadyInvited = "already_invited"
+	ImportRowInvited        = "invited"
+	ImportRowAdded          = "added"
+	ImportRowFailed         = "failed"
+)
+
+// what a committed import does with each valid row.
+const (
+	ImportActionInvite = "invite"
+	ImportActionAdd    = "add"
+)
+
+const (
+	maxImportSize = 2 << 20
+	maxImportRows = 5000
+
+	// a running import that has not finished after this long is assumed to
+	// have died with its worker and is started again.
+	importClaimTimeout = 30 * time.Minute
+)
+
+var (
+	ErrImportNotFound  = errors.New("member import not found")
+	ErrImportNoEmail   = errors.New("csv must have an email column")
+	ErrImportTooLarge  = fmt.Errorf("csv can have at most %d rows", maxImportRows)
+	ErrImportBadMode   = errors.New("mode must be dry_run or commit")
+	ErrImportBadAction = errors.New("action must be invite or add")
+
+	// wakes the import worker up as soon as a job is queued
+	importQueued = make(chan struct{}, 1)
+)
+
+// ImportRow is one line of an uploaded member CSV and what became of it.
+type ImportRow struct {
+	Line        int    `bson:"line" json:"line"`
+	Email       string `bson:"email" json:"email"`
+	Name        string `bson:"name" json:"name"`
+	Role        string `bson:"role" json:"role"`
+	DisplayName string `bson:"display_name" json:"display_name"`
+	Result      string `bson:"result" json:"result"`
+	Error       string `bson:"error,omitempty" json:"error,omitempty"`
+}
+
+type MemberImport struct {
+	ID          primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	OrgID       string             `bson:"org_id" json:"org_id"`
+	RequestedBy string             `bson:"requested_by" json:"requested_by"`
+	Action      string             `bson:"action" json:"action"`
+	Status      string             `bson:"status" json:"status"`
+	Summary     map[string]int     `bson:"summary" json:"summary"`
+	Rows        []ImportRow        `bson:"rows,omitempty" json:"rows,omitempty"`
+	Error       string             `bson:"error,omitempty" json:"error,omitempty"`
+	CreatedAt   time.Time          `bson:"created_at" json:"created_at"`
+	StartedAt   *time.Time         `bson:"started_at,omitempty" json:"started_at,omitempty"`
+	CompletedAt *time.Time         `bson:"completed_at,omitempty" json:"completed_at,omitempty"`
+}
+
+func memberImports() *mongo.Collection {
+	return utils.GetCollection(MemberImportCollectionName)
+}
+
+func fetchMemberImport(ctx context.Context, orgID, importID string) (*MemberImport, error) {
+	objID, err := primitive.ObjectIDFromHex(importID)
+	if err != nil {
+		return nil, ErrImportNotFound
+	}
+
+	var job MemberImport
+	if err := memberImports().FindOne(ctx, bson.M{"_id": objID, "org_id": orgID}).Decode(&job); err != nil {
+		return nil, ErrImportNotFound
+	}
+
+	return &job, nil
+}
+
+// parseImportCSV reads rows from a CSV with a header line naming its
+// columns. email is required, name, role and display_name are optional and
+// columns may come in any order.
+func parseImportCSV(in io.Reader) ([]ImportRow, error) {
+	reader := csv.NewReader(in)
+	reader.FieldsPerRecord = -1
+	reader.TrimLeadingSpace = true
+
+	header, err := reader.Read()
+	if err != nil {
+		return nil, fmt.Errorf("could not read csv header: %w", err)
+	}
+
+	cols := make(map[string]int)
+
+	for i, name := range header {
+		name = strings.TrimPrefix(name, "\ufeff")
+		name = strings.ReplaceAll(strings.ToLower(strings.TrimSpace(name)), " ", "_")
+		cols[name] = i
+	}
+
+	if _, ok := cols["email"]; !ok {
+		return nil, ErrImportNoEmail
+	}
+
+	field := func(record []string, name string) string {
+		if i, ok := cols[name]; ok && i < len(record) {
+			return strings.TrimSpace(record[i])
+		}
+
+		return ""
+	}
+
+	rows := []ImportRow{}
+
+	for line := 2; ; line++ {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+
+		if err != nil {
+			return nil, err
+		}
+
+		if strings.TrimSpace(strings.Join(record, "")) == "" {
+			continue
+		}
+
+		if len(rows) == maxImportRows {
+			return nil, ErrImportTooLarge
+		}
+
+		rows = append(rows, ImportRow{
+			Line:        line,
+			Email:       strings.ToLower(field(record, "email")),
+			Name:        field(record, "name"),
+			Role:        strings.ToLower(field(record, "role")),
+			DisplayName: field(record, "display_name"),
+		})
+	}
+
+	return rows, nil
+}
+
+// checkImportRows marks rows with an invalid email or role, or whose email
+// appeared earlier in the file. checkRole says whether a role may be given.
+// Every other row is marked valid.
+func checkImportRows(rows []ImportRow, checkRole func(role string) error) {
+	seen := make(map[string]int)
+
+	for i := range rows {
+		row := &rows[i]
+
+		if row.Role == "" {
+			row.Role = MemberRole
+		}
+
+		switch {
+		case !utils.IsValidEmail(row.Email):
+			row.Result, row.Error = ImportRowInvalid, "invalid email address"
+		case seen[row.Email] > 0:
+			row.Result, row.Error = ImportRowDuplicate, fmt.Sprintf("same email as line %d", seen[row.Email])
+		default:
+			seen[row.Email] = row.Line
+
+			if err := checkRole(row.Role); err != nil {
+				row.Result, row.Error = ImportRowInvalid, err.Error()
+			} else {
+				row.Result = ImportRowValid
+			}
+		}
+	}
+}
+
+// markExistingImportRows marks valid rows for people who already are members
+// of orgID or hold a pending invite to it.
+func markExistingImportRows(ctx context.Context, orgID string, rows []ImportRow) error {
+	var emails []string
+
+	for _, row := range rows {
+		if row.Result == ImportRowValid {
+			emails = append(emails, row.Email)
+		}
+	}
+
+	if len(emails) == 0 {
+		return nil
+	}
+
+	members, err := utils.GetCollection(MemberCollectionName).Distinct(ctx, "email",
+		bson.M{"org_id": orgID, "email": bson.M{"$in": emails}, "deleted": bson.M{"$ne": true}})
+	if err != nil {
+		return err
+	}
+
+	invited, err := utils.GetCollection(OrganizationInviteCollectionName).Distinct(ctx, "email",
+		bson.M{"org_id": orgID, "email": bson.M{"$in": emails}, "status": InvitePending, "expires_at": bson.M{"$gt": time.Now()}})
+	if err != nil {
+		return err
+	}
+
+	found := make(map[string]string)
+
+	for _, e := range invited {
+		found[fmt.Sprint(e)] = ImportRowAlreadyInvited
+	}
+
+	for _, e := range members {
+		found[fmt.Sprint(e)] = ImportRowExistingMember
+	}
+
+	for i := range rows {
+		if result, ok := found[rows[i].Email]; ok && rows[i].Result == ImportRowValid {
+			rows[i].Result = result
+		}
+	}
+
+	return nil
+}
+
+func importSummary(rows []ImportRow) map[string]int {
+	summary := map[string]int{"total": len(rows)}
+
+	for _, row := range rows {
+		summary[row.Result]++
+	}
+
+	return summary
+}
+
+// splitName splits a full name into a first name and the rest.
+func splitName(name string) (first, last string) {
+	parts := strings.Fields(name)
+	if len(parts) == 0 {
+		return "", ""
+	}
+
+	return parts[0], strings.Join(parts[1:], " ")
+}
+
+// ImportMembers takes a CSV upload of people to bring into an organization.
+// In dry_run mode, the default, it only reports what would happen to each
+// row. In commit mode it queues a job that invites, or with action=add adds,
+// everyone on a valid row.
+func (oh *OrganizationHandler) ImportMembers(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	orgID := mux.Vars(r)["id"]
+
+	if err := ValidateOrg(orgID); err != nil {
+		utils.GetError(err, http.StatusBadRequest, w)
+		return
+	}
+
+	loggedIn, _ := r.Context().Value("user").(*auth.AuthUser)
+	if loggedIn == nil {
+		utils.GetError(auth.ErrNotAuthorized, http.StatusUnauthorized, w)
+		return
+	}
+
+	r.Body = http.MaxBytesReader(w, r.Body, maxImportSize)
+
+	if err := r.ParseMultipartForm(maxImportSize); err != nil {
+		utils.GetError(fmt.Errorf("could not read upload: %w", err), http.StatusBadRequest, w)
+		return
+	}
+
+	mode := r.FormValue("mode")
+	if mode == "" {
+		mode = "dry_run"
+	}
+
+	if mode != "dry_run" && mode != "commit" {
+		utils.GetError(ErrImportBadMode, http.StatusBadRequest, w)
+		return
+	}
+
+	action := r.FormValue("action")
+	if action == "" {
+		action = ImportActionInvite
+	}
+
+	if action != ImportActionInvite && action != ImportActionAdd {
+		utils.GetError(ErrImportBadAction, http.StatusBadRequest, w)
+		return
+	}
+
+	file, _, err := r.FormFile("file")
+	if err != nil {
+		utils.GetError(errors.New("a csv file is required"), http.StatusBadRequest, w)
+		return
+	}
+	defer file.Close()
+
+	rows, err := parseImportCSV(file)
+	if err != nil {
+		utils.GetError(err, http.StatusBadRequest, w)
+		return
+	}
+
+	caller, err := callerRole(r, orgID)
+	if err != nil {
+		utils.GetError(err, http.StatusForbidden, w)
+		return
+	}
+
+	checkImportRows(rows, func(name string) error {
+		role, err := auth.FetchRole(orgID, name)
+		if err != nil || name == OwnerRole {
+			return fmt.Errorf("role %s is not valid", name)
+		}
+
+		return checkGrantable(caller, role.Permissions)
+	})
+
+	if err := markExistingImportRows(r.Context(), orgID, rows); err != nil {
+		utils.GetError(err, http.StatusInternalServerError, w)
+		return
+	}
+
+	if mode == "dry_run" {
+		utils.GetSuccess("dry run completed, nothing was imported", map[string]interface{}{
+			"rows":    rows,
+			"summary": importSummary(rows),
+		}, w)
+
+		return
+	}
+
+	job := MemberImport{
+		OrgID:       orgID,
+		RequestedBy: loggedIn.Email,
+		Action:      action,
+		Status:      ImportPending,
+		Summary:     importSummary(rows),
+		Rows:        rows,
+		CreatedAt:   time.Now(),
+	}
+
+	res, err := memberImports().InsertOne(r.Context(), job)
+	if err != nil {
+		utils.GetError(err, http.StatusInternalServerError, w)
+		return
+	}
+
+	job.ID, _ = res.InsertedID.(primitive.ObjectID)
+
+	logAudit(r, AuditEvent{Action: AuditMembersImported, TargetType: "member_import", TargetID: job.ID.Hex()},
+		nil, bson.M{"action": action, "rows": len(rows)})
+
+	select {
+	case importQueued <- struct{}{}:
+	default:
+	}
+
+	utils.GetSuccess("member import queued", job, w)
+}
+
+// ListMemberImports returns an organization's member imports, newest first,
+// without their rows.
+func (oh *OrganizationHandler) ListMemberImports(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	opts := options.Find().SetSort(bson.M{"created_at": -1}).SetProjection(bson.M{"rows": 0})
+
+	cursor, err := memberImports().Find(r.Context(), bson.M{"org_id": mux.Vars(r)["id"]}, opts)
+	if err != nil {
+		utils.GetError(err, http.StatusInternalServerError, w)
+		return
+	}
+
+	jobs := []MemberImport{}
+	if err := cursor.All(r.Context(), &jobs); err != nil {
+		utils.GetError(err, http.StatusInternalServerError, w)
+		return
+	}
+
+	utils.GetSuccess("member imports retrieved successfully", jobs, w)
+}
+
+// GetMemberImport returns one member import with the result of every row.
+func (oh *OrganizationHandler) GetMemberImport(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	vars := mux.Vars(r)
+
+	job, err := fetchMemberImport(r.Context(), vars["id"], vars["import_id"])
+	if err != nil {
+		utils.GetError(err, http.StatusNotFound, w)
+		return
+	}
+
+	utils.GetSuccess("member import retrieved successfully", job, w)
+}
+
+// writeImportReport writes rows as CSV, one line per uploaded row.
+func writeImportReport(w io.Writer, rows []ImportRow) error {
+	out := csv.NewWriter(w)
+
+	if err := out.Write([]string{"line", "email", "name", "role", "display_name", "result", "error"}); err != nil {
+		return err
+	}
+
+	for _, row := range rows {
+		record := []string{fmt.Sprint(row.Line), row.Email, row.Name, row.Role, row.DisplayName, row.Result, row.Error}
+
+		for i := range record {
+			record[i] = csvSafe(record[i])
+		}
+
+		if err := out.Write(record); err != nil {
+			return err
+		}
+	}
+
+	out.Flush()
+
+	return out.Error()
+}
+
+// DownloadImportReport sends the result of every row of a member import as
+// a CSV file.
+func (oh *OrganizationHandler) DownloadImportReport(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+
+	job, err := fetchMemberImport(r.Context(), vars["id"], vars["import_id"])
+	if err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		utils.GetError(err, http.StatusNotFound, w)
+
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/csv")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", "member-import-"+job.ID.Hex()+".csv"))
+
+	if err := writeImportReport(w, job.Rows); err != nil {
+		log.Printf("member import: could not write report %s: %v", job.ID.Hex(), err)
+	}
+}
+
+// RunImportWorker carries out queued member imports as they come in,
+// checking at least every interval, until ctx is done.
+func (oh *OrganizationHandler) RunImportWorker(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		case <-importQueued:
+		}
+
+		oh.processImports(ctx)
+	}
+}
+
+// claimMemberImport marks the oldest queued import as running and returns it.
+func claimMemberImport(ctx context.Context) (*MemberImport, error) {
+	now := time.Now()
+	filter := bson.M{"$or": bson.A{
+		bson.M{"status": ImportPending},
+		bson.M{"status": ImportRunning, "started_at": bson.M{"$lt": now.Add(-importClaimTimeout)}},
+	}}
+
+	update := bson.M{"$set": bson.M{"status": ImportRunning, "started_at": now}}
+	opts := options.FindOneAndUpdate().SetSort(bson.M{"created_at": 1}).SetReturnDocument(options.After)
+
+	var job MemberImport
+	if err := memberImports().FindOneAndUpdate(ctx, filter, update, opts).Decode(&job); err != nil {
+		return nil, err
+	}
+
+	return &job, nil
+}
+
+func (oh *OrganizationHandler) processImports(ctx context.Context) {
+	for {
+		job, err := claimMemberImport(ctx)
+		if err != nil {
+			return
+		}
+
+		set := bson.M{"status": ImportCompleted}
+
+		if err := oh.runMemberImport(ctx, job); err != nil {
+			log.Printf("member import: %s failed: %v", job.ID.Hex(), err)
+			set = bson.M{"status": ImportFailed, "error": err.Error()}
+		}
+
+		now := time.Now()
+		set["rows"], set["summary"], set["completed_at"] = job.Rows, importSummary(job.Rows), now
+
+		if _, err := memberImports().UpdateOne(ctx, bson.M{"_id": job.ID}, bson.M{"$set": set}); err != nil {
+			log.Printf("member import: could not complete %s: %v", job.ID.Hex(), err)
+		}
+	}
+}
+
+// runMemberImport invites or adds the person on every valid row of job,
+// recording the outcome on the row. Members and pending invites are checked
+// again first, so a job picked up after its worker died is not applied twice.
+func (oh *OrganizationHandler) runMemberImport(ctx context.Context, job *MemberImport) error {
+	org, err := FetchOrganizationByID(job.OrgID)
+	if err != nil {
+		return err
+	}
+
+	if err := markExistingImportRows(ctx, job.OrgID, job.Rows); err != nil {
+		return err
+	}
+
+	var mails []*service.Mail
+
+	for i := range job.Rows {
+		row := &job.Rows[i]
+		if row.Result != ImportRowValid {
+			continue
+		}
+
+		if job.Action == ImportActionAdd {
+			if u, err := auth.FetchUserByEmail(bson.M{"email": row.Email}); err == nil {
+				if err := addImportedMember(ctx, job.OrgID, u, row); err != nil {
+					row.Result, row.Error = ImportRowFailed, err.Error()
+				} else {
+					row.Result = ImportRowAdded
+				}
+
+				continue
+			}
+		}
+
+		// people without an account, or on an invite import, are invited
+		invite := oh.newInvite(job.OrgID, row.Email, row.Role, job.RequestedBy)
+		if _, err := utils.GetCollection(OrganizationInviteCollectionName).InsertOne(ctx, invite); err != nil {
+			row.Result, row.Error = ImportRowFailed, err.Error()
+			continue
+		}
+
+		row.Result = ImportRowInvited
+		mails = append(mails, oh.inviteMail(row.Email, job.RequestedBy, org.Name, invite.UUID))
+	}
+
+	oh.sendInviteMails(mails)
+
+	return nil
+}
+
+// addImportedMember makes u a member of orgID with the role and names on row.
+func addImportedMember(ctx context.Context, orgID string, u *user.User, row *ImportRow) error {
+	member := NewMember(u.Email, strings.Split(u.Email, "@")[0], orgID, row.Role)
+	member.FirstName, member.LastName = splitName(row.Name)
+	member.DisplayName = row.DisplayName
+
+	_, err := addMember(ctx, member)
+
+	return err
+}
@@ -0,0 +1,91 @@
+package organizations
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestParseImportCSV(t *testing.T) {
+	in := "\ufeffDisplay Name,Email,Role,Name\n" +
+		"ada,Ada@Zuri.Chat,Admin,Ada Lovelace\n" +
+		",,,\n" +
+		"bob,bob@zuri.chat\n"
+
+	rows, err := parseImportCSV(strings.NewReader(in))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []ImportRow{
+		{Line: 2, Email: "ada@zuri.chat", Name: "Ada Lovelace", Role: "admin", DisplayName: "ada"},
+		{Line: 4, Email: "bob@zuri.chat", DisplayName: "bob"},
+	}
+
+	if len(rows) != len(want) {
+		t.Fatalf("parseImportCSV() returned %d rows, want %d", len(rows), len(want))
+	}
+
+	for i := range want {
+		if rows[i] != want[i] {
+			t.Errorf("row %d = %+v, want %+v", i, rows[i], want[i])
+		}
+	}
+
+	if _, err := parseImportCSV(strings.NewReader("name,role\nada,admin\n")); err != ErrImportNoEmail {
+		t.Errorf("parseImportCSV() without an email column = %v, want %v", err, ErrImportNoEmail)
+	}
+}
+
+func TestCheckImportRows(t *testing.T) {
+	rows := []ImportRow{
+		{Line: 2, Email: "ada@zuri.chat"},
+		{Line: 3, Email: "not-an-email"},
+		{Line: 4, Email: "ada@zuri.chat", Role: "admin"},
+		{Line: 5, Email: "bob@zuri.chat", Role: "owner"},
+		{Line: 6, Email: "eve@zuri.chat", Role: "admin"},
+	}
+
+	checkImportRows(rows, func(role string) error {
+		if role == OwnerRole {
+			return errors.New("role owner is not valid")
+		}
+
+		return nil
+	})
+
+	want := []string{ImportRowValid, ImportRowInvalid, ImportRowDuplicate, ImportRowInvalid, ImportRowValid}
+
+	for i, row := range rows {
+		if row.Result != want[i] {
+			t.Errorf("line %d: result = %s (%s), want %s", row.Line, row.Result, row.Error, want[i])
+		}
+	}
+
+	if rows[0].Role != MemberRole {
+		t.Errorf("default role = %q, want %q", rows[0].Role, MemberRole)
+	}
+
+	if rows[2].Error != "same email as line 2" {
+		t.Errorf("duplicate error = %q", rows[2].Error)
+	}
+}
+
+func TestWriteImportReport(t *testing.T) {
+	rows := []ImportRow{
+		{Line: 2, Email: "ada@zuri.chat", Name: "=HYPERLINK()", Role: "member", Result: ImportRowInvited},
+	}
+
+	var buf bytes.Buffer
+	if err := writeImportReport(&buf, rows); err != nil {
+		t.Fatal(err)
+	}
+
+	want := "line,email,name,role,display_name,result,error\n" +
+		"2,ada@zuri.chat,'=HYPERLINK(),member,,invited,\n"
+
+	if got := buf.String(); got != want {
+		t.Errorf("writeImportReport() =\n%s\nwant\n%s", got, want)
+	}
+}
@@ -0,0 +1,435 @@
+package organizations
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"zuri.chat/zccore/auth"
+	"zuri.chat/zccore/logger"
+	"zuri.chat/zccore/service"
+	"zuri.chat/zccore/utils"
+)
+
+// invite statuses.
+const (
+	InvitePending  = "pending"
+	InviteAccepted = "accepted"
+	InviteRevoked  = "revoked"
+	InviteExpired  = "expired"
+)
+
+const (
+	defaultInviteLimit = 20
+	maxInviteLimit     = 100
+)
+
+var (
+	ErrInviteNotFound = errors.New("invite not found")
+	ErrInviteNotValid = errors.New("invite has been revoked, accepted or has expired")
+)
+
+// inviteStatus returns the status of an invite as of now. Invites from before
+// statuses existed only have has_accepted.
+func inviteStatus(inv *Invite) string {
+	status := inv.Status
+
+	if status == "" {
+		status = InvitePending
+		if inv.HasAccepted {
+			status = InviteAccepted
+		}
+	}
+
+	if status == InvitePending && !inv.ExpiresAt.IsZero() && time.Now().After(inv.ExpiresAt) {
+		return InviteExpired
+	}
+
+	return status
+}
+
+// normalizeInvites gives an organization's invites the status they have now,
+// so they can be filtered and counted by it.
+func normalizeInvites(ctx context.Context, orgID string) error {
+	coll := utils.GetCollection(OrganizationInviteCollectionName)
+	updates := []struct {
+		filter bson.M
+		status string
+	}{
+		{bson.M{"org_id": orgID, "status": bson.M{"$exists": false}, "has_accepted": true}, InviteAccepted},
+		{bson.M{"org_id": orgID, "status": bson.M{"$exists": false}}, InvitePending},
+		{bson.M{"org_id": orgID, "status": InvitePending, "expires_at": bson.M{"$gt": time.Time{}, "$lte": time.Now()}}, InviteExpired},
+	}
+
+	for _, u := range updates {
+		if _, err := coll.UpdateMany(ctx, u.filter, bson.M{"$set": bson.M{"status": u.status}}); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func fetchInvite(ctx context.Context, orgID, inviteID string) (*Invite, error) {
+	objID, err := primitive.ObjectIDFromHex(inviteID)
+	if err != nil {
+		return nil, ErrInviteNotFound
+	}
+
+	var inv Invite
+
+	filter := bson.M{"_id": objID, "org_id": orgID}
+	if err := utils.GetCollection(OrganizationInviteCollectionName).FindOne(ctx, filter).Decode(&inv); err != nil {
+		return nil, ErrInviteNotFound
+	}
+
+	return &inv, nil
+}
+
+// fetchUsableInvite returns the invite with uuid if it can still be accepted.
+func fetchUsableInvite(ctx context.Context, uuid string) (*Invite, error) {
+	var inv Invite
+
+	if err := utils.GetCollection(OrganizationInviteCollectionName).FindOne(ctx, bson.M{"uuid": uuid}).Decode(&inv); err != nil {
+		return nil, ErrInviteNotFound
+	}
+
+	if inviteStatus(&inv) != InvitePending {
+		return nil, ErrInviteNotValid
+	}
+
+	return &inv, nil
+}
+
+// newInvite returns a pending invite to orgID that expires after the
+// configured invite TTL.
+func (oh *OrganizationHandler) newInvite(orgID, email, role, invitedBy string) Invite {
+	now := time.Now()
+
+	return Invite{
+		OrgID:     orgID,
+		UUID:      utils.GenUUID(),
+		Email:     email,
+		Role:      role,
+		InvitedBy: invitedBy,
+		Status:    InvitePending,
+		CreatedAt: now,
+		SentAt:    now,
+		ExpiresAt: now.Add(time.Duration(oh.configs.InviteTTL) * time.Second),
+	}
+}
+
+func inviteLink(uuid string) string {
+	return fmt.Sprintf("%s/%s", os.Getenv("INVITE_DOMAIN"), uuid)
+}
+
+// sendInviteMails sends every mail, logging the ones that fail.
+func (oh *OrganizationHandler) sendInviteMails(mails []*service.Mail) {
+	for _, m := range mails {
+		if err := oh.mailService.SendMail(m); err != nil {
+			logger.Error("Error occurred while sending mail: %s", err.Error())
+		}
+	}
+}
+
+func (oh *OrganizationHandler) inviteMail(email, inviter, orgName, uuid string) *service.Mail {
+	return oh.mailService.NewMail(
+		[]string{email}, "Zuri Chat Workspace Invite", service.WorkSpaceInvite, map[string]interface{}{
+			"Username":   inviter,
+			"OrgName":    orgName,
+			"InviteLink": inviteLink(uuid),
+		})
+}
+
+// Send invite to a list of emails.
+func (oh *OrganizationHandler) SendInvite(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	loggedInUser, ok := r.Context().Value("user").(*auth.AuthUser)
+	if !ok {
+		utils.GetError(errors.New("invalid user"), http.StatusBadRequest, w)
+		return
+	}
+
+	sOrgID := mux.Vars(r)["id"]
+
+	var guests SendInviteBody
+
+	if err := utils.ParseJSONFromRequest(r, &guests); err != nil {
+		utils.GetError(err, http.StatusUnprocessableEntity, w)
+		return
+	}
+
+	org, err := FetchOrganizationByID(sOrgID)
+	if err != nil {
+		utils.GetError(fmt.Errorf("organization %s not found", sOrgID), http.StatusNotFound, w)
+		return
+	}
+
+	roleName := strings.ToLower(guests.Role)
+	if roleName == "" {
+		roleName = MemberRole
+	}
+
+	role, err := auth.FetchRole(sOrgID, roleName)
+	if err != nil || roleName == OwnerRole {
+		utils.GetError(errors.New("role is not valid"), http.StatusBadRequest, w)
+		return
+	}
+
+	caller, err := callerRole(r, sOrgID)
+	if err != nil {
+		utils.GetError(err, http.StatusForbidden, w)
+		return
+	}
+
+	if err := checkGrantable(caller, role.Permissions); err != nil {
+		utils.GetError(err, http.StatusForbidden, w)
+		return
+	}
+
+	now := time.Now()
+	response := SendInviteResponse{InviteIDs: []interface{}{}}
+	seen := map[string]bool{}
+
+	var mails []*service.Mail
+
+	for _, email := range guests.Emails {
+		email = strings.ToLower(strings.TrimSpace(email))
+
+		// Check the validity of email send
+		if !utils.IsValidEmail(email) {
+			// If Email is invalid append to list to invalid emails
+			response.InvalidEmails = append(response.InvalidEmails, email)
+			continue
+		}
+
+		if seen[email] {
+			continue
+		}
+
+		seen[email] = true
+
+		if _, err := FetchMember(bson.M{"org_id": sOrgID, "email": email, "deleted": bson.M{"$ne": true}}); err == nil {
+			response.AlreadyMembers = append(response.AlreadyMembers, email)
+			continue
+		}
+
+		pending := bson.M{"org_id": sOrgID, "email": email, "status": InvitePending, "expires_at": bson.M{"$gt": now}}
+		if n, _ := utils.GetCollection(OrganizationInviteCollectionName).CountDocuments(r.Context(), pending); n > 0 {
+			response.AlreadyInvited = append(response.AlreadyInvited, email)
+			continue
+		}
+
+		newInvite := oh.newInvite(sOrgID, email, role.Name, loggedInUser.Email)
+
+		// Save newly generated uuid and associated info in the database
+		save, err := utils.GetCollection(OrganizationInviteCollectionName).InsertOne(r.Context(), newInvite)
+		if err != nil {
+			utils.GetError(err, http.StatusInternalServerError, w)
+			return
+		}
+
+		// Append new invite to array of generated invites
+		response.InviteIDs = append(response.InviteIDs, save.InsertedID)
+		mails = append(mails, oh.inviteMail(email, loggedInUser.Email, org.Name, newInvite.UUID))
+	}
+
+	go oh.sendInviteMails(mails)
+
+	utils.GetSuccess("Organization invite operation result", response, w)
+}
+
+// ListInvites returns an organization's invites, newest first, optionally
+// filtered by status, with the number of invites in each status.
+func (oh *OrganizationHandler) ListInvites(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	orgID := mux.Vars(r)["id"]
+	query := r.URL.Query()
+
+	if err := normalizeInvites(r.Context(), orgID); err != nil {
+		utils.GetError(err, http.StatusInternalServerError, w)
+		return
+	}
+
+	page, _ := strconv.Atoi(query.Get("page"))
+	if page < 1 {
+		page = 1
+	}
+
+	limit, _ := strconv.Atoi(query.Get("limit"))
+	if limit < 1 {
+		limit = defaultInviteLimit
+	}
+
+	if limit > maxInviteLimit {
+		limit = maxInviteLimit
+	}
+
+	coll := utils.GetCollection(OrganizationInviteCollectionName)
+	filter := bson.M{"org_id": orgID}
+
+	if status := query.Get("status"); status != "" {
+		filter["status"] = status
+	}
+
+	total, err := coll.CountDocuments(r.Context(), filter)
+	if err != nil {
+		utils.GetError(err, http.StatusInternalServerError, w)
+		return
+	}
+
+	opts := options.Find().
+		SetSort(bson.D{{Key: "created_at", Value: -1}}).
+		SetSkip(int64((page - 1) * limit)).
+		SetLimit(int64(limit))
+
+	cursor, err := coll.Find(r.Context(), filter, opts)
+	if err != nil {
+		utils.GetError(err, http.StatusInternalServerError, w)
+		return
+	}
+
+	invites := []Invite{}
+	if err := cursor.All(r.Context(), &invites); err != nil {
+		utils.GetError(err, http.StatusInternalServerError, w)
+		return
+	}
+
+	pipeline := bson.A{
+		bson.M{"$match": bson.M{"org_id": orgID}},
+		bson.M{"$group": bson.M{"_id": "$status", "count": bson.M{"$sum": 1}}},
+	}
+
+	groups, err := coll.Aggregate(r.Context(), pipeline)
+	if err != nil {
+		utils.GetError(err, http.StatusInternalServerError, w)
+		return
+	}
+
+	var rows []struct {
+		Status string `bson:"_id"`
+		Count  int64  `bson:"count"`
+	}
+
+	if err := groups.All(r.Context(), &rows); err != nil {
+		utils.GetError(err, http.StatusInternalServerError, w)
+		return
+	}
+
+	counts := map[string]int64{InvitePending: 0, InviteAccepted: 0, InviteRevoked: 0, InviteExpired: 0}
+	for _, row := range rows {
+		counts[row.Status] = row.Count
+	}
+
+	utils.GetSuccess("successful", map[string]interface{}{
+		"invites": invites,
+		"counts":  counts,
+		"total":   total,
+		"page":    page,
+		"limit":   limit,
+	}, w)
+}
+
+// ResendInvite mails a pending or expired invite again with a new link and
+// a fresh expiry. The old link stops working.
+func (oh *OrganizationHandler) ResendInvite(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	vars := mux.Vars(r)
+	orgID := vars["id"]
+
+	inv, err := fetchInvite(r.Context(), orgID, vars["invite_id"])
+	if err != nil {
+		utils.GetError(err, http.StatusNotFound, w)
+		return
+	}
+
+	if status := inviteStatus(inv); status != InvitePending && status != InviteExpired {
+		utils.GetError(fmt.Errorf("an invite that is %s cannot be resent", status), http.StatusBadRequest, w)
+		return
+	}
+
+	if time.Since(inv.SentAt) < auth.ResendCooldown {
+		utils.GetError(errors.New("invite was sent moments ago, try again shortly"), http.StatusTooManyRequests, w)
+		return
+	}
+
+	if _, err := FetchMember(bson.M{"org_id": orgID, "email": inv.Email, "deleted": bson.M{"$ne": true}}); err == nil {
+		utils.GetError(errors.New("this email already belongs to a member"), http.StatusBadRequest, w)
+		return
+	}
+
+	org, err := FetchOrganizationByID(orgID)
+	if err != nil {
+		utils.GetError(err, http.StatusNotFound, w)
+		return
+	}
+
+	loggedIn, _ := r.Context().Value("user").(*auth.AuthUser)
+	if loggedIn == nil {
+		utils.GetError(auth.ErrNotAuthorized, http.StatusUnauthorized, w)
+		return
+	}
+
+	now := time.Now()
+	uuid := utils.GenUUID()
+	update := bson.M{
+		"$set": bson.M{
+			"uuid":       uuid,
+			"status":     InvitePending,
+			"sent_at":    now,
+			"expires_at": now.Add(time.Duration(oh.configs.InviteTTL) * time.Second),
+		},
+		"$inc": bson.M{"resend_count": 1},
+	}
+
+	objID, _ := primitive.ObjectIDFromHex(inv.ID)
+	if _, err := utils.GetCollection(OrganizationInviteCollectionName).UpdateOne(r.Context(), bson.M{"_id": objID}, update); err != nil {
+		utils.GetError(err, http.StatusInternalServerError, w)
+		return
+	}
+
+	go oh.sendInviteMails([]*service.Mail{oh.inviteMail(inv.Email, loggedIn.Email, org.Name, uuid)})
+
+	utils.GetSuccess("invite resent successfully", nil, w)
+}
+
+// RevokeInvite cancels a pending invite so its link can no longer be used.
+func (oh *OrganizationHandler) RevokeInvite(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	vars := mux.Vars(r)
+
+	inv, err := fetchInvite(r.Context(), vars["id"], vars["invite_id"])
+	if err != nil {
+		utils.GetError(err, http.StatusNotFound, w)
+		return
+	}
+
+	if status := inviteStatus(inv); status != InvitePending && status != InviteExpired {
+		utils.GetError(fmt.Errorf("an invite that is %s cannot be revoked", status), http.StatusBadRequest, w)
+		return
+	}
+
+	objID, _ := primitive.ObjectIDFromHex(inv.ID)
+	update := bson.M{"$set": bson.M{"status": InviteRevoked}}
+
+	if _, err := utils.GetCollection(OrganizationInviteCollectionName).UpdateOne(r.Context(), bson.M{"_id": objID}, update); err != nil {
+		utils.GetError(err, http.StatusInternalServerError, w)
+		return
+	}
+
+	utils.GetSuccess("invite revoked successfully", nil, w)
+}
@@ -0,0 +1,29 @@
+package organizations
+
+import (
+	"testing"
+	"time"
+)
+
+func TestInviteStatus(t *testing.T) {
+	past, future := time.Now().Add(-time.Hour), time.Now().Add(time.Hour)
+
+	tests := []struct {
+		name   string
+		invite Invite
+		want   string
+	}{
+		{"legacy pending", Invite{}, InvitePending},
+		{"legacy accepted", Invite{HasAccepted: true}, InviteAccepted},
+		{"pending", Invite{Status: InvitePending, ExpiresAt: future}, InvitePending},
+		{"pending past expiry", Invite{Status: InvitePending, ExpiresAt: past}, InviteExpired},
+		{"revoked past expiry", Invite{Status: InviteRevoked, ExpiresAt: past}, InviteRevoked},
+		{"accepted past expiry", Invite{Status: InviteAccepted, ExpiresAt: past}, InviteAccepted},
+	}
+
+	for _, tt := range tests {
+		if got := inviteStatus(&tt.invite); got != tt.want {
+			t.Errorf("%s: inviteStatus() = %s, want %s", tt.name, got, tt.want)
+		}
+	}
+}
@@ -5,6 +5,7 @@ import (
 
 	"go.mongodb.org/mongo-driver/bson/primitive"
 	"zuri.chat/zccore/service"
+	"zuri.chat/zccore/user"
 	"zuri.chat/zccore/utils"
 )
 
@@ -17,6 +18,8 @@ const (
 	CardCollectionName               = "cards"
 	UserCollectionName               = "users"
 	PluginCollectionName             = "plugins"
+	PluginSettingsCollectionName     = "organization_plugin_settings"
+	PluginUsageCollectionName        = "plugin_usage_records"
 )
 
 const (
@@ -35,6 +38,12 @@ const (
 	UpdateOrganizationMemberStatusCleared = "UpdateOrganizationMemberStatusCleared"
 	UpdateOrganizationBillingSettings     = "UpdateOrganizationBillingSettings"
 	UpdateOrganizationMemberFiles         = "UpdateOrganizationMemberFiles"
+	UpdateOrganizationPluginSettings      = "UpdateOrganizationPluginSettings"
+	CreateOrganizationGroup               = "CreateOrganizationGroup"
+	UpdateOrganizationGroup               = "UpdateOrganizationGroup"
+	DeleteOrganizationGroup               = "DeleteOrganizationGroup"
+	AddOrganizationGroupMembers           = "AddOrganizationGroupMembers"
+	RemoveOrganizationGroupMembers        = "RemoveOrganizationGroupMembers"
 )
 
 const (
@@ -62,9 +71,6 @@ const (
 const ProSubscriptionRate = 10
 const StatusHistoryLimit = 6
 
-var ExpiryTime = make(chan int64, 1)
-var ClearOld = make(chan bool, 1)
-
 var RequestData = make(map[string]string)
 
 const (
@@ -96,6 +102,14 @@ type Organization struct {
 	Tokens       float64                `json:"tokens" bson:"tokens"`
 	Version      string                 `json:"version" bson:"version"`
 	Billing      Billing                `json:"billing" bson:"billing"`
+
+	// set while the organization waits out its deletion grace period
+	DeletionRequestedAt *time.Time `json:"deletion_requested_at,omitempty" bson:"deletion_requested_at,omitempty"`
+	DeletionRequestedBy string     `json:"deletion_requested_by,omitempty" bson:"deletion_requested_by,omitempty"`
+	PurgeAfter          *time.Time `json:"purge_after,omitempty" bson:"purge_after,omitempty"`
+
+	// email domains whose verified users may join without an invite
+	AllowedDomains []AllowedDomain `json:"allowed_domains,omitempty" bson:"allowed_domains,omitempty"`
 }
 
 type Billing struct {
@@ -132,6 +146,7 @@ type TokenTransaction struct {
 	Amount        float64   `json:"amount" bson:"amount"`
 	Time          time.Time `json:"time" bson:"time"`
 	TransactionID string    `json:"transaction_id" bson:"transaction_id"`
+	PluginID      string    `json:"plugin_id,omitempty" bson:"plugin_id,omitempty"`
 }
 
 type Invite struct {
@@ -140,15 +155,27 @@ type Invite struct {
 	UUID        string `json:"uuid" bson:"uuid"`
 	Email       string `json:"email" bson:"email"`
 	HasAccepted bool   `json:"has_accepted" bson:"has_accepted"`
+
+	Role        string     `json:"role" bson:"role"`
+	InvitedBy   string     `json:"invited_by" bson:"invited_by"`
+	Status      string     `json:"status" bson:"status"`
+	ResendCount int        `json:"resend_count" bson:"resend_count"`
+	CreatedAt   time.Time  `json:"created_at" bson:"created_at"`
+	SentAt      time.Time  `json:"sent_at" bson:"sent_at"`
+	ExpiresAt   time.Time  `json:"expires_at" bson:"expires_at"`
+	AcceptedAt  *time.Time `json:"accepted_at,omitempty" bson:"accepted_at,omitempty"`
 }
 type SendInviteResponse struct {
-	InvalidEmails []interface{}
-	InviteIDs     []interface{}
+	InvalidEmails  []interface{}
+	InviteIDs      []interface{}
+	AlreadyMembers []string
+	AlreadyInvited []string
 }
 
 type OrgPluginBody struct {
-	PluginID string `json:"plugin_id"`
-	UserID   string `json:"user_id"`
+	PluginID string   `json:"plugin_id"`
+	UserID   string   `json:"user_id"`
+	Grants   []string `json:"grants"`
 }
 
 type InstalledPlugin struct {
@@ -159,10 +186,42 @@ type InstalledPlugin struct {
 	ApprovedBy  string                 `json:"approved_by" bson:"approved_by"`
 	InstalledAt time.Time              `json:"installed_at" bson:"installed_at"`
 	UpdatedAt   time.Time              `json:"updated_at" bson:"updated_at"`
+	Grants      []string               `json:"grants" bson:"grants"`
+	SpendCap    *PluginSpendCap        `json:"spend_cap,omitempty" bson:"spend_cap,omitempty"`
+	// tokens charged for usage, by month formatted as 2006-01
+	MonthlySpend map[string]float64 `json:"monthly_spend,omitempty" bson:"monthly_spend,omitempty"`
+}
+
+// PluginSpendCap limits how many tokens a plugin can charge an organization in a calendar month.
+type PluginSpendCap struct {
+	MonthlyCap float64 `json:"monthly_cap" bson:"monthly_cap" validate:"gte=0"`
+	// fraction of the cap at which admins are alerted, e.g 0.8
+	AlertThreshold float64 `json:"alert_threshold" bson:"alert_threshold" validate:"gte=0,lte=1"`
+	// the month, formatted as 2006-01, admins were last alerted for
+	AlertedPeriod string `json:"alerted_period,omitempty" bson:"alerted_period,omitempty"`
+}
+
+type UsageRecord struct {
+	Type           string  `json:"type" bson:"type" validate:"required,oneof=per_call per_seat"`
+	Quantity       float64 `json:"quantity" bson:"quantity" validate:"gt=0"`
+	UnitPrice      float64 `json:"unit_price" bson:"unit_price" validate:"gte=0"`
+	Description    string  `json:"description" bson:"description"`
+	IdempotencyKey string  `json:"idempotency_key,omitempty" bson:"idempotency_key,omitempty"`
+}
+
+type PluginUsageRecord struct {
+	ID            primitive.ObjectID `json:"id" bson:"_id"`
+	UsageRecord   `bson:",inline"`
+	OrgID         string    `json:"org_id" bson:"org_id"`
+	PluginID      string    `json:"plugin_id" bson:"plugin_id"`
+	Amount        float64   `json:"amount" bson:"amount"`
+	TransactionID string    `json:"transaction_id" bson:"transaction_id"`
+	CreatedAt     time.Time `json:"created_at" bson:"created_at"`
 }
 
 type SendInviteBody struct {
 	Emails []string `json:"emails" bson:"emails"`
+	Role   string   `json:"role" bson:"role"`
 }
 
 type OrganizationAdmin struct {
@@ -201,6 +260,8 @@ type Status struct {
 	Text          string          `json:"text" bson:"text"`
 	ExpiryTime    string          `json:"expiry_time" bson:"expiry_time"`
 	StatusHistory []StatusHistory `json:"status_history" bson:"status_history"`
+	// set from ExpiryTime, nil when the status does not expire
+	ExpiresAt *time.Time `json:"expires_at,omitempty" bson:"expires_at,omitempty"`
 }
 
 type StatusHistory struct {
@@ -232,6 +293,13 @@ type Member struct {
 	DeletedAt   time.Time `json:"deleted_at" bson:"deleted_at"`
 	Socials     []Social  `json:"socials" bson:"socials"`
 	Language    string    `json:"language" bson:"language"`
+	// id the organization's identity provider knows the member by, set over SCIM
+	ExternalID string `json:"external_id,omitempty" bson:"external_id,omitempty"`
+	// set once the identity provider deletes the member over SCIM, the User is
+	// gone from SCIM from then on
+	SCIMDeprovisioned bool `json:"-" bson:"scim_deprovisioned,omitempty"`
+	// sessions and access tokens issued before it no longer reach the organization
+	SessionsRevokedAt time.Time `json:"-" bson:"sessions_revoked_at,omitempty"`
 }
 
 type Profile struct {
@@ -295,6 +363,10 @@ type OrgAuthentication struct {
 	SessionDuration                      string                 `json:"sessionduration" bson:"sessionduration"`
 	ForcedPasswordReset                  map[string]interface{} `json:"forcedpasswordreset" bson:"forcedpasswordreset"`
 	AutomaticallyOpen                    map[string]interface{} `json:"automaticallyopen" bson:"automaticallyopen"`
+	// name of the OIDC provider members must sign in with, empty for any
+	RequiredIdentityProvider string `json:"requiredidentityprovider" bson:"requiredidentityprovider"`
+	// stricter password rules for members, on top of the deployment's
+	PasswordPolicy *user.PasswordPolicy `json:"passwordpolicy,omitempty" bson:"passwordpolicy,omitempty"`
 }
 
 type OrgSettings struct {
@@ -502,6 +574,7 @@ type PluginSettings struct {
 type OrganizationHandler struct {
 	configs     *utils.Configurations
 	mailService service.MailService
+	resolver    TXTResolver
 }
 
 type updateParam struct {
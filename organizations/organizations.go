@@ -4,9 +4,7 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
-	"log"
 	"net/http"
-	"os"
 	"strings"
 	"time"
 	"zuri.chat/zccore/SuidService"
@@ -246,39 +244,6 @@ func (oh *OrganizationHandler) GetOrganizations(w http.ResponseWriter, r *http.R
 	utils.GetSuccess("organizations retrieved successfully", save, w)
 }
 
-// Delete an organization record.
-func (oh *OrganizationHandler) DeleteOrganization(w http.ResponseWriter, r *http.Request) {
-	w.Header().Set("Content-Type", "application/json")
-	log.Println("in here 1")
-	orgID := mux.Vars(r)["id"]
-
-	response, err := utils.DeleteOneMongoDBDoc(OrganizationCollectionName, orgID)
-
-	if err != nil {
-		utils.GetError(err, http.StatusInternalServerError, w)
-		return
-	}
-
-	if response.DeletedCount == 0 {
-		utils.GetError(errors.New("operation failed"), http.StatusInternalServerError, w)
-		return
-	}
-	filter := bson.M{"org_id": orgID}
-	response1, err2 := utils.DeleteManyMongoDBDoc(MemberCollectionName,filter)
-
-	if err2 != nil {
-		utils.GetError(err, http.StatusInternalServerError, w)
-		return
-	}
-
-	if response1.DeletedCount == 0 {
-		utils.GetError(errors.New("operation failed"), http.StatusInternalServerError, w)
-		return
-	}
-
-	utils.GetSuccess("organization deleted successfully", nil, w)
-}
-
 // Update an organization workspace url.
 func (oh *OrganizationHandler) UpdateURL(w http.ResponseWriter, r *http.Request) {
 	OrganizationUpdate(w, r, updateParam{
@@ -374,7 +339,11 @@ func (oh *OrganizationHandler) TransferOwnership(w http.ResponseWriter, r *http.
 		return
 	}
 
-	formerOwner, _ := FetchMember(bson.M{"org_id": orgID, "email": loggedInUser.Email})
+	formerOwner, err := FetchMember(bson.M{"org_id": orgID, "email": loggedInUser.Email})
+	if err != nil {
+		utils.GetError(errors.New("operation failed"), http.StatusInternalServerError, w)
+		return
+	}
 
 	// ID of former owner
 	formerOwnerID := formerOwner.ID
@@ -393,6 +362,9 @@ func (oh *OrganizationHandler) TransferOwnership(w http.ResponseWriter, r *http.
 	}
 
 	// and we are done!!!
+	logAudit(r, AuditEvent{Action: AuditOwnershipTransferred, TargetType: "member", TargetID: memberID},
+		bson.M{"owner": formerOwner.Email}, bson.M{"owner": orgMember.Email})
+
 	utils.GetSuccess("workspace owner changed successfully", nil, w)
 }
 
@@ -437,122 +409,6 @@ func (oh *OrganizationHandler) UpdateLogo(w http.ResponseWriter, r *http.Request
 	utils.GetSuccess("Logo updated successfully", imgURL, w)
 }
 
-// Send invite to a list of emails.
-func (oh *OrganizationHandler) SendInvite(w http.ResponseWriter, r *http.Request) {
-	w.Header().Set("Content-Type", "application/json")
-
-	loggedInUser, ok := r.Context().Value("user").(*auth.AuthUser)
-	if !ok {
-		utils.GetError(errors.New("invalid user"), http.StatusBadRequest, w)
-		return
-	}
-
-	sOrgID := mux.Vars(r)["id"]
-
-	var guests SendInviteBody
-	var org bson.M
-
-	err := utils.ParseJSONFromRequest(r, &guests)
-	if err != nil {
-		utils.GetError(err, http.StatusUnprocessableEntity, w)
-		return
-	}
-
-	if strings.Contains(sOrgID, "-org") {
-		// get previous settings
-		org, _ = utils.GetMongoDBDoc(OrganizationCollectionName, bson.M{"_id": sOrgID})
-		if org == nil {
-			utils.GetError(fmt.Errorf("organization %s not found", sOrgID), http.StatusNotFound, w)
-			return
-		}
-	} else {
-		// get previous settings 2
-		objID, err := primitive.ObjectIDFromHex(sOrgID)
-		if err != nil {
-			utils.GetError(err, http.StatusUnprocessableEntity, w)
-			return
-		}
-
-		org, _ = utils.GetMongoDBDoc(OrganizationCollectionName, bson.M{"_id": objID})
-		if org == nil {
-			utils.GetError(fmt.Errorf("organization %s not found", sOrgID), http.StatusNotFound, w)
-			return
-		}
-	}
-
-	var invalidEmails []interface{}
-
-	inviteIDs := make([]interface{}, len(guests.Emails))
-
-	for _, email := range guests.Emails {
-		// Check the validity of email send
-		if !utils.IsValidEmail(email) {
-			// If Email is invalid append to list to invalid emails
-			invalidEmails = append(invalidEmails, email)
-			continue
-		}
-		// Generate new UUI for invite and
-		uuid := utils.GenUUID()
-
-		newInvite := Invite{OrgID: sOrgID, UUID: uuid, Email: email, HasAccepted: false}
-
-		var invInterface map[string]interface{}
-
-		inrec, _ := json.Marshal(newInvite)
-		err = json.Unmarshal(inrec, &invInterface)
-
-		if err != nil {
-			utils.GetError(err, http.StatusInternalServerError, w)
-			return
-		}
-
-		// Save newly generated uuid and associated info in the database
-		save, err := utils.CreateMongoDBDoc(OrganizationInviteCollectionName, invInterface)
-		if err != nil {
-			utils.GetError(err, http.StatusInternalServerError, w)
-
-			return
-		}
-
-		// Append new invite to array of generated invites
-		inviteIDs = append(inviteIDs, save.InsertedID)
-
-		// Parse data for customising email template
-
-		inviteLink := fmt.Sprintf("%s/%s", os.Getenv("INVITE_DOMAIN"), uuid)
-		orgName := fmt.Sprintf("%v", org["name"])
-
-		msger := oh.mailService.NewMail(
-			[]string{email}, "Zuri Chat Workspace Invite", service.WorkSpaceInvite, map[string]interface{}{
-				"Username":   loggedInUser.Email,
-				"OrgName":    orgName,
-				"InviteLink": inviteLink,
-			})
-		// error with sending main
-		if err := oh.mailService.SendMail(msger); err != nil {
-			logger.Error("Error occurred while sending mail: %s", err.Error())
-		}
-	}
-
-	response := SendInviteResponse{InvalidEmails: invalidEmails, InviteIDs: inviteIDs}
-
-	utils.GetSuccess("Organization invite operation result", response, w)
-}
-
-// Get invite records of an organization.
-func (oh *OrganizationHandler) InviteStats(w http.ResponseWriter, r *http.Request) {
-	w.Header().Set("Content-Type", "application/json")
-
-	orgID := mux.Vars(r)["id"]
-
-	invites, err := utils.GetMongoDBDocs(OrganizationInviteCollectionName, bson.M{"org_id": orgID})
-	if err != nil {
-		utils.GetError(err, http.StatusNotFound, w)
-	}
-
-	utils.GetSuccess("successful", invites, w)
-}
-
 // Upgrade services to Pro.
 func (oh *OrganizationHandler) UpgradeToPro(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
@@ -717,6 +573,9 @@ func (oh *OrganizationHandler) UpdateOrganizationSettings(w http.ResponseWriter,
 		return
 	}
 
+	logAudit(r, AuditEvent{Action: AuditSettingsUpdated, TargetType: "organization", TargetID: orgID},
+		bson.M{"settings": org.Settings}, bson.M{"settings": orgPref})
+
 	utils.GetSuccess("organization settings updated successfully", nil, w)
 }
 
@@ -797,6 +656,9 @@ func (oh *OrganizationHandler) UpdateOrganizationPermission(w http.ResponseWrite
 		return
 	}
 
+	logAudit(r, AuditEvent{Action: AuditSettingsUpdated, TargetType: "organization", TargetID: orgID},
+		bson.M{"settings": org.Settings}, bson.M{"settings": orgPref})
+
 	utils.GetSuccess("organization settings updated successfully", nil, w)
 }
 
@@ -815,6 +677,23 @@ func (oh *OrganizationHandler) UpdateOrganizationAuthentication(w http.ResponseW
 		return
 	}
 
+	if idp := orgAuthentication.RequiredIdentityProvider; idp != "" {
+		if !oidcProviderConfigured(oh.configs.OIDCProviders, idp) {
+			utils.GetError(fmt.Errorf("identity provider %s is not configured", idp), http.StatusBadRequest, w)
+			return
+		}
+
+		// sessions carry the provider name lowercased.
+		orgAuthentication.RequiredIdentityProvider = strings.ToLower(idp)
+	}
+
+	if p := orgAuthentication.PasswordPolicy; p != nil {
+		if err := p.Validate(); err != nil {
+			utils.GetError(err, http.StatusBadRequest, w)
+			return
+		}
+	}
+
 	if strings.Contains(orgID, "-org") {
 		// get previous settings
 		save, _ = utils.GetMongoDBDoc(OrganizationCollectionName, bson.M{"_id": orgID})
@@ -875,6 +754,17 @@ func (oh *OrganizationHandler) UpdateOrganizationAuthentication(w http.ResponseW
 		return
 	}
 
+	// turning forced reset on flags every member, saving it again does not
+	if settingEnabled(orgAuthentication.ForcedPasswordReset) && !settingEnabled(org.Settings.Authentication.ForcedPasswordReset) {
+		if err := forcePasswordReset(orgID); err != nil {
+			utils.GetError(err, http.StatusInternalServerError, w)
+			return
+		}
+	}
+
+	logAudit(r, AuditEvent{Action: AuditSettingsUpdated, TargetType: "organization", TargetID: orgID},
+		bson.M{"settings": org.Settings}, bson.M{"settings": orgPref})
+
 	utils.GetSuccess("organization settings updated successfully", nil, w)
 }
 
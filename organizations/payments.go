@@ -1,6 +1,7 @@
 package organizations
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"net/http"
@@ -80,47 +81,55 @@ func IncrementToken(orgID, description string, tokenAmount float64) error {
 	return nil
 }
 
+var ErrInsufficientTokens = errors.New("insufficient token balance")
+
 // Takes as input org id and token amount and decreases token by that amount if available, else returns error.
 func DeductToken(orgID, description string, tokenAmount float64) error {
+	_, err := chargeTokens(orgID, "", "Charge", description, tokenAmount)
+	return err
+}
 
-	if strings.Contains(orgID, "-org") {
-		organization, err := FetchOrganization(bson.M{"_id": orgID})
-		if err != nil {
-			return err
-		}
-		if organization.Tokens < tokenAmount {
-			return errors.New("insufficient token balance")
-		}
-
-		organization.Tokens -= tokenAmount
-
-		updateData := make(map[string]interface{})
-		updateData["tokens"] = organization.Tokens
+// chargeTokens deducts tokenAmount from an organization's tokens and records the charge in the
+// token ledger. The balance check and the deduction happen in one update so concurrent charges
+// cannot overdraw the organization.
+func chargeTokens(orgID, pluginID, txType, description string, tokenAmount float64) (*TokenTransaction, error) {
+	filter, err := orgIDFilter(orgID)
+	if err != nil {
+		return nil, err
+	}
 
-		return err
+	filter["tokens"] = bson.M{"$gte": tokenAmount}
 
-	} else {
-		OrgIDFromHex, err := primitive.ObjectIDFromHex(orgID)
-		if err != nil {
-			return err
-		}
-		organization, err := FetchOrganization(bson.M{"_id": OrgIDFromHex})
-		if err != nil {
-			return err
-		}
+	res, err := utils.GetCollection(OrganizationCollectionName).UpdateOne(context.Background(), filter,
+		bson.M{"$inc": bson.M{"tokens": -tokenAmount}})
+	if err != nil {
+		return nil, err
+	}
 
-		if organization.Tokens < tokenAmount {
-			return errors.New("insufficient token balance")
+	if res.MatchedCount == 0 {
+		if err := ValidateOrg(orgID); err != nil {
+			return nil, err
 		}
 
-		organization.Tokens -= tokenAmount
+		return nil, ErrInsufficientTokens
+	}
 
-		updateData := make(map[string]interface{})
-		updateData["tokens"] = organization.Tokens
+	transaction := TokenTransaction{
+		OrgID:         orgID,
+		PluginID:      pluginID,
+		Token:         tokenAmount,
+		Type:          txType,
+		Description:   description,
+		Time:          time.Now(),
+		TransactionID: utils.GenUUID(),
+	}
 
-		return err
+	// inserted as is, not through json, so the time is a date monthlyPluginSpend can match.
+	if _, err := utils.GetCollection(TokenTransactionCollectionName).InsertOne(context.Background(), transaction); err != nil {
+		return nil, err
 	}
 
+	return &transaction, nil
 }
 
 func SubscriptionBilling(orgID string, proVersionRate float64) error {
@@ -0,0 +1,571 @@
+package organizations
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/go-playground/validator/v10"
+	"github.com/gorilla/mux"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"zuri.chat/zccore/logger"
+	pluginp "zuri.chat/zccore/plugin"
+	"zuri.chat/zccore/service"
+	"zuri.chat/zccore/utils"
+)
+
+const (
+	PluginUsageTransaction = "PluginUsage"
+
+	defaultSpendAlertThreshold = 0.8
+	maxUsageRecordsPerRequest  = 100
+)
+
+var ErrSpendCapReached = errors.New("plugin monthly spend cap reached")
+
+var usageIndexOnce sync.Once
+
+// usageRecords returns the usage record collection. Idempotency keys are
+// unique per organization and plugin, so a retried batch can't be recorded,
+// or charged, twice.
+func usageRecords() *mongo.Collection {
+	coll := utils.GetCollection(PluginUsageCollectionName)
+
+	usageIndexOnce.Do(func() {
+		indexModel := mongo.IndexModel{
+			Keys: bson.D{{Key: "org_id", Value: 1}, {Key: "plugin_id", Value: 1}, {Key: "idempotency_key", Value: 1}},
+			Options: options.Index().SetUnique(true).
+				SetPartialFilterExpression(bson.M{"idempotency_key": bson.M{"$exists": true}}),
+		}
+
+		if _, err := coll.Indexes().CreateOne(context.Background(), indexModel); err != nil {
+			logger.Error("plugin usage: could not create index: %v", err)
+		}
+	})
+
+	return coll
+}
+
+// insertUsageRecords saves records and returns the ones that were new.
+// Records whose idempotency key was already used are left out.
+func insertUsageRecords(ctx context.Context, records []PluginUsageRecord) ([]PluginUsageRecord, error) {
+	docs := make([]interface{}, len(records))
+	for i := range records {
+		records[i].ID = primitive.NewObjectID()
+		docs[i] = records[i]
+	}
+
+	_, err := usageRecords().InsertMany(ctx, docs, options.InsertMany().SetOrdered(false))
+
+	var bulkErr mongo.BulkWriteException
+	if err != nil && !errors.As(err, &bulkErr) {
+		return nil, err
+	}
+
+	seen := make(map[int]bool, len(bulkErr.WriteErrors))
+
+	for _, we := range bulkErr.WriteErrors {
+		if we.Code != 11000 {
+			return nil, we
+		}
+
+		seen[we.Index] = true
+	}
+
+	inserted := make([]PluginUsageRecord, 0, len(records))
+
+	for i := range records {
+		if !seen[i] {
+			inserted = append(inserted, records[i])
+		}
+	}
+
+	return inserted, nil
+}
+
+// reserveSpend adds amount to a plugin's spend for period, refusing to go
+// over monthlyCap when it is set, and returns the new spend. The check and
+// the increment are one update so concurrent batches can't overshoot the cap.
+func reserveSpend(ctx context.Context, orgID, pluginID, period string, amount, monthlyCap float64) (float64, error) {
+	filter, err := orgIDFilter(orgID)
+	if err != nil {
+		return 0, err
+	}
+
+	key := fmt.Sprintf("plugins.%s.monthly_spend.%s", pluginID, period)
+
+	if monthlyCap > 0 {
+		if amount > monthlyCap {
+			return 0, ErrSpendCapReached
+		}
+
+		filter["$or"] = bson.A{
+			bson.M{key: bson.M{"$lte": monthlyCap - amount}},
+			bson.M{key: bson.M{"$exists": false}},
+		}
+	}
+
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After).SetProjection(bson.M{key: 1})
+
+	var doc struct {
+		Plugins map[string]InstalledPlugin `bson:"plugins"`
+	}
+
+	err = utils.GetCollection(OrganizationCollectionName).FindOneAndUpdate(ctx, filter, bson.M{"$inc": bson.M{key: amount}}, opts).Decode(&doc)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return 0, ErrSpendCapReached
+	}
+
+	if err != nil {
+		return 0, err
+	}
+
+	return doc.Plugins[pluginID].MonthlySpend[period], nil
+}
+
+// releaseSpend gives back spend reserved for a batch that was not charged.
+func releaseSpend(orgID, pluginID, period string, amount float64) {
+	filter, err := orgIDFilter(orgID)
+	if err != nil {
+		return
+	}
+
+	key := fmt.Sprintf("plugins.%s.monthly_spend.%s", pluginID, period)
+	if _, err := utils.GetCollection(OrganizationCollectionName).UpdateOne(context.Background(), filter, bson.M{"$inc": bson.M{key: -amount}}); err != nil {
+		logger.Error("could not release plugin %s spend in org %s: %v", pluginID, orgID, err)
+	}
+}
+
+// checkGrants makes sure an organization only grants scopes the plugin asked for.
+func checkGrants(p *pluginp.Plugin, grants []string) error {
+	for _, g := range grants {
+		if !p.HasScope(g) {
+			return fmt.Errorf("plugin does not request the %s scope", g)
+		}
+	}
+
+	return nil
+}
+
+// billingPeriod is the calendar month spend caps apply to.
+func billingPeriod(t time.Time) (string, time.Time) {
+	t = t.UTC()
+	return t.Format("2006-01"), time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, time.UTC)
+}
+
+// installedPluginDetails decodes an organization's record of an installed plugin.
+func installedPluginDetails(org *Organization, pluginID string) (*InstalledPlugin, error) {
+	entry, ok := org.Plugins[pluginID]
+	if !ok {
+		return nil, errors.New("plugin is not installed in this organization")
+	}
+
+	// installed plugins are saved through json, see AddOrganizationPlugin.
+	b, err := json.Marshal(entry)
+	if err != nil {
+		return nil, err
+	}
+
+	var installed InstalledPlugin
+	if err := json.Unmarshal(b, &installed); err != nil {
+		return nil, err
+	}
+
+	return &installed, nil
+}
+
+func updateInstalledPlugin(orgID, pluginID string, set bson.M) error {
+	filter, err := orgIDFilter(orgID)
+	if err != nil {
+		return err
+	}
+
+	prefixed := bson.M{fmt.Sprintf("plugins.%s.updated_at", pluginID): time.Now()}
+	for k, v := range set {
+		prefixed[fmt.Sprintf("plugins.%s.%s", pluginID, k)] = v
+	}
+
+	_, err = utils.GenericUpdateOneMongoDBDoc(OrganizationCollectionName, filter["_id"], bson.M{"$set": prefixed})
+
+	return err
+}
+
+// monthlyPluginSpend sums the tokens a plugin charged an organization since from.
+func monthlyPluginSpend(ctx context.Context, orgID, pluginID string, from time.Time) (float64, error) {
+	pipeline := mongo.Pipeline{
+		{{Key: "$match", Value: bson.M{
+			"org_id":    orgID,
+			"plugin_id": pluginID,
+			"type":      PluginUsageTransaction,
+			"time":      bson.M{"$gte": from},
+		}}},
+		{{Key: "$group", Value: bson.M{"_id": nil, "total": bson.M{"$sum": "$token"}}}},
+	}
+
+	cursor, err := utils.GetCollection(TokenTransactionCollectionName).Aggregate(ctx, pipeline)
+	if err != nil {
+		return 0, err
+	}
+
+	var result []struct {
+		Total float64 `bson:"total"`
+	}
+
+	if err := cursor.All(ctx, &result); err != nil {
+		return 0, err
+	}
+
+	if len(result) == 0 {
+		return 0, nil
+	}
+
+	return result[0].Total, nil
+}
+
+// Used by a plugin with the billing:charge grant to charge an organization for metered usage.
+// The records are charged together as one ledger transaction, records whose idempotency key
+// was seen before are skipped. Records are saved before the charge so a retry after a
+// failure half way can't charge twice.
+func (oh *OrganizationHandler) SubmitPluginUsage(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	orgID := mux.Vars(r)["org_id"]
+
+	plugin, ok := r.Context().Value(pluginp.PluginContext).(*pluginp.Plugin)
+	if !ok {
+		utils.GetError(errors.New("invalid plugin"), http.StatusUnauthorized, w)
+		return
+	}
+
+	pluginID := plugin.ID.Hex()
+
+	body := struct {
+		Records []UsageRecord `json:"records" validate:"required,min=1,dive"`
+	}{}
+
+	if err := utils.ParseJSONFromRequest(r, &body); err != nil {
+		utils.GetError(err, http.StatusUnprocessableEntity, w)
+		return
+	}
+
+	if err := validator.New().Struct(body); err != nil {
+		utils.GetError(err, http.StatusBadRequest, w)
+		return
+	}
+
+	if len(body.Records) > maxUsageRecordsPerRequest {
+		utils.GetError(fmt.Errorf("at most %d records can be submitted at once", maxUsageRecordsPerRequest), http.StatusBadRequest, w)
+		return
+	}
+
+	org, err := FetchOrganizationByID(orgID)
+	if err != nil {
+		utils.GetError(err, http.StatusNotFound, w)
+		return
+	}
+
+	installed, err := installedPluginDetails(org, pluginID)
+	if err != nil {
+		utils.GetError(err, http.StatusNotFound, w)
+		return
+	}
+
+	if !hasGrant(installed.Grants, pluginp.ScopeBillingCharge) {
+		utils.GetError(errors.New("organization has not granted this plugin billing:charge"), http.StatusForbidden, w)
+		return
+	}
+
+	records := make([]PluginUsageRecord, 0, len(body.Records))
+	keys := make(map[string]bool, len(body.Records))
+	now := time.Now()
+
+	for _, rec := range body.Records {
+		if rec.IdempotencyKey != "" {
+			if keys[rec.IdempotencyKey] {
+				continue
+			}
+
+			keys[rec.IdempotencyKey] = true
+		}
+
+		amount := math.Round(rec.Quantity*rec.UnitPrice*100) / 100
+
+		records = append(records, PluginUsageRecord{UsageRecord: rec, OrgID: orgID, PluginID: pluginID, Amount: amount, CreatedAt: now})
+	}
+
+	records, err = insertUsageRecords(r.Context(), records)
+	if err != nil {
+		utils.GetError(err, http.StatusInternalServerError, w)
+		return
+	}
+
+	if len(records) == 0 {
+		utils.GetSuccess("usage already recorded", nil, w)
+		return
+	}
+
+	ids := make([]primitive.ObjectID, len(records))
+
+	var total float64
+
+	for i, rec := range records {
+		ids[i] = rec.ID
+		total += rec.Amount
+	}
+
+	// drops the records again when they could not be charged, so they can be retried.
+	discard := func() {
+		if _, err := usageRecords().DeleteMany(context.Background(), bson.M{"_id": bson.M{"$in": ids}}); err != nil {
+			logger.Error("could not drop uncharged usage records of plugin %s in org %s: %v", pluginID, orgID, err)
+		}
+	}
+
+	var monthlyCap float64
+	if installed.SpendCap != nil {
+		monthlyCap = installed.SpendCap.MonthlyCap
+	}
+
+	period, _ := billingPeriod(now)
+
+	spent, err := reserveSpend(r.Context(), orgID, pluginID, period, total, monthlyCap)
+	if err != nil {
+		discard()
+
+		status := http.StatusInternalServerError
+		if errors.Is(err, ErrSpendCapReached) {
+			status = http.StatusPaymentRequired
+		}
+
+		utils.GetError(err, status, w)
+
+		return
+	}
+
+	description := fmt.Sprintf("Usage charges from %s (%d record(s))", plugin.Name, len(records))
+
+	transaction, err := chargeTokens(orgID, pluginID, PluginUsageTransaction, description, total)
+	if err != nil {
+		releaseSpend(orgID, pluginID, period, total)
+		discard()
+
+		status := http.StatusInternalServerError
+		if errors.Is(err, ErrInsufficientTokens) {
+			status = http.StatusPaymentRequired
+		}
+
+		utils.GetError(err, status, w)
+
+		return
+	}
+
+	for i := range records {
+		records[i].TransactionID = transaction.TransactionID
+	}
+
+	update := bson.M{"$set": bson.M{"transaction_id": transaction.TransactionID}}
+	if _, err := usageRecords().UpdateMany(r.Context(), bson.M{"_id": bson.M{"$in": ids}}, update); err != nil {
+		logger.Error("could not link usage records to transaction %s: %v", transaction.TransactionID, err)
+	}
+
+	if installed.SpendCap != nil {
+		go oh.alertSpendCap(org, plugin, installed.SpendCap, period, spent)
+	}
+
+	utils.GetSuccess("usage charged successfully", map[string]interface{}{
+		"transaction": transaction,
+		"records":     records,
+	}, w)
+}
+
+func hasGrant(grants []string, scope string) bool {
+	for _, g := range grants {
+		if g == scope {
+			return true
+		}
+	}
+
+	return false
+}
+
+// alertSpendCap emails the organization's owners and admins, once per month, when a plugin's
+// spend crosses the alert threshold of its cap.
+func (oh *OrganizationHandler) alertSpendCap(org *Organization, plugin *pluginp.Plugin, spendCap *PluginSpendCap, period string, spent float64) {
+	threshold := spendCap.AlertThreshold
+	if threshold == 0 {
+		threshold = defaultSpendAlertThreshold
+	}
+
+	if spendCap.MonthlyCap <= 0 || spent < spendCap.MonthlyCap*threshold || spendCap.AlertedPeriod == period {
+		return
+	}
+
+	// claim the alert for this month so concurrent charges don't send it twice.
+	filter, _ := orgIDFilter(org.ID)
+	alertedKey := fmt.Sprintf("plugins.%s.spend_cap.alerted_period", plugin.ID.Hex())
+	filter[alertedKey] = bson.M{"$ne": period}
+
+	res, err := utils.GetCollection(OrganizationCollectionName).UpdateOne(context.Background(), filter,
+		bson.M{"$set": bson.M{alertedKey: period}})
+	if err != nil || res.ModifiedCount == 0 {
+		return
+	}
+
+	admins, _ := utils.GetMongoDBDocs(MemberCollectionName, bson.M{
+		"org_id":  org.ID,
+		"role":    bson.M{"$in": []string{OwnerRole, AdminRole}},
+		"deleted": bson.M{"$ne": true},
+	})
+
+	var to []string
+
+	for _, a := range admins {
+		if email, ok := a["email"].(string); ok {
+			to = append(to, email)
+		}
+	}
+
+	if len(to) == 0 {
+		return
+	}
+
+	mail := oh.mailService.NewMail(to, fmt.Sprintf("%s is close to its monthly spend cap", plugin.Name), service.PluginSpendAlert, map[string]interface{}{
+		"Name":       org.Name,
+		"PluginName": plugin.Name,
+		"Spent":      spent,
+		"Cap":        spendCap.MonthlyCap,
+		"Percent":    math.Round(spent / spendCap.MonthlyCap * 100),
+	})
+
+	if err := oh.mailService.SendMail(mail); err != nil {
+		logger.Error("could not send spend cap alert for org %s: %v", org.ID, err)
+	}
+}
+
+// Update the scopes an organization grants an installed plugin.
+func (oh *OrganizationHandler) UpdatePluginGrants(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	orgID, pluginID := mux.Vars(r)["id"], mux.Vars(r)["plugin_id"]
+
+	body := struct {
+		Grants []string `json:"grants"`
+	}{}
+
+	if err := utils.ParseJSONFromRequest(r, &body); err != nil {
+		utils.GetError(err, http.StatusUnprocessableEntity, w)
+		return
+	}
+
+	plugin, err := fetchInstalledPlugin(orgID, pluginID)
+	if err != nil {
+		utils.GetError(err, http.StatusNotFound, w)
+		return
+	}
+
+	if body.Grants == nil {
+		body.Grants = []string{}
+	}
+
+	if err = checkGrants(plugin, body.Grants); err != nil {
+		utils.GetError(err, http.StatusBadRequest, w)
+		return
+	}
+
+	if err = updateInstalledPlugin(orgID, pluginID, bson.M{"grants": body.Grants}); err != nil {
+		utils.GetError(err, http.StatusInternalServerError, w)
+		return
+	}
+
+	utils.GetSuccess("plugin grants updated successfully", body.Grants, w)
+}
+
+// Set the monthly spend cap of a plugin, a cap of 0 removes the limit.
+func (oh *OrganizationHandler) UpdatePluginSpendCap(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	orgID, pluginID := mux.Vars(r)["id"], mux.Vars(r)["plugin_id"]
+
+	var spendCap PluginSpendCap
+	if err := utils.ParseJSONFromRequest(r, &spendCap); err != nil {
+		utils.GetError(err, http.StatusUnprocessableEntity, w)
+		return
+	}
+
+	if err := validator.New().Struct(spendCap); err != nil {
+		utils.GetError(err, http.StatusBadRequest, w)
+		return
+	}
+
+	if spendCap.AlertThreshold == 0 {
+		spendCap.AlertThreshold = defaultSpendAlertThreshold
+	}
+
+	// a new cap gets a new alert.
+	spendCap.AlertedPeriod = ""
+
+	if _, err := fetchInstalledPlugin(orgID, pluginID); err != nil {
+		utils.GetError(err, http.StatusNotFound, w)
+		return
+	}
+
+	if err := updateInstalledPlugin(orgID, pluginID, bson.M{"spend_cap": spendCap}); err != nil {
+		utils.GetError(err, http.StatusInternalServerError, w)
+		return
+	}
+
+	utils.GetSuccess("plugin spend cap updated successfully", spendCap, w)
+}
+
+// Get a plugin's usage in an organization for the current month.
+func (oh *OrganizationHandler) GetPluginUsage(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	orgID, pluginID := mux.Vars(r)["id"], mux.Vars(r)["plugin_id"]
+
+	org, err := FetchOrganizationByID(orgID)
+	if err != nil {
+		utils.GetError(err, http.StatusNotFound, w)
+		return
+	}
+
+	installed, err := installedPluginDetails(org, pluginID)
+	if err != nil {
+		utils.GetError(err, http.StatusNotFound, w)
+		return
+	}
+
+	period, periodStart := billingPeriod(time.Now())
+
+	spent, err := monthlyPluginSpend(r.Context(), orgID, pluginID, periodStart)
+	if err != nil {
+		utils.GetError(err, http.StatusInternalServerError, w)
+		return
+	}
+
+	opts := options.Find().SetSort(bson.D{{Key: "created_at", Value: -1}}).SetLimit(maxUsageRecordsPerRequest)
+
+	records, err := utils.GetMongoDBDocs(PluginUsageCollectionName, bson.M{
+		"org_id": orgID, "plugin_id": pluginID, "created_at": bson.M{"$gte": periodStart},
+	}, opts)
+	if err != nil {
+		utils.GetError(err, http.StatusInternalServerError, w)
+		return
+	}
+
+	utils.GetSuccess("plugin usage retrieved successfully", map[string]interface{}{
+		"period":    period,
+		"spent":     spent,
+		"spend_cap": installed.SpendCap,
+		"grants":    installed.Grants,
+		"records":   records,
+	}, w)
+}
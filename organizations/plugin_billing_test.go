@@ -0,0 +1,52 @@
+package organizations
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"zuri.chat/zccore/utils"
+)
+
+func TestGetPluginUsage(t *testing.T) {
+	pluginID := primitive.NewObjectID().Hex()
+
+	filter, err := orgIDFilter(defaultOrgID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// saved through json, like AddOrganizationPlugin does.
+	installed, _ := utils.StructToMap(InstalledPlugin{PluginID: pluginID, InstalledAt: time.Now()})
+
+	_, err = utils.GetCollection(OrganizationCollectionName).UpdateOne(context.Background(), filter, bson.M{"$set": bson.M{
+		"tokens":                            100.0,
+		fmt.Sprintf("plugins.%s", pluginID): installed,
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, amount := range []float64{2.5, 4} {
+		if _, err := chargeTokens(defaultOrgID, pluginID, PluginUsageTransaction, "usage", amount); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	r := getRouter()
+	r.HandleFunc("/organizations/{id}/plugins/{plugin_id}/usage", orgs.GetPluginUsage).Methods("GET")
+	req, _ := http.NewRequest("GET", fmt.Sprintf("/organizations/%s/plugins/%s/usage", defaultOrgID, pluginID), nil)
+
+	response := getHTTPResponse(t, r, req)
+	assertStatusCode(t, response.Code, http.StatusOK)
+
+	data, _ := parseResponse(response)["data"].(map[string]interface{})
+	if spent, _ := data["spent"].(float64); spent != 6.5 {
+		t.Errorf("got spent %v expected %v", data["spent"], 6.5)
+	}
+}
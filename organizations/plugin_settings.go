@@ -0,0 +1,277 @@
+package organizations
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"zuri.chat/zccore/auth"
+	pluginp "zuri.chat/zccore/plugin"
+	"zuri.chat/zccore/utils"
+)
+
+// value returned in place of secret settings to anyone but the plugin itself.
+const maskedSecret = "********"
+
+type PluginSettingsRecord struct {
+	OrgID     string                 `json:"org_id" bson:"org_id"`
+	PluginID  string                 `json:"plugin_id" bson:"plugin_id"`
+	Values    map[string]interface{} `json:"values" bson:"values"`
+	UpdatedBy string                 `json:"updated_by" bson:"updated_by"`
+	UpdatedAt time.Time              `json:"updated_at" bson:"updated_at"`
+}
+
+type pluginSettingsBody struct {
+	Values map[string]interface{} `json:"values" validate:"required"`
+}
+
+// fetchPlugin returns a registered plugin by its id.
+func fetchPlugin(pluginID string) (*pluginp.Plugin, error) {
+	objID, err := primitive.ObjectIDFromHex(pluginID)
+	if err != nil {
+		return nil, errors.New("invalid plugin id")
+	}
+
+	doc, _ := utils.GetMongoDBDoc(PluginCollectionName, bson.M{"_id": objID})
+	if doc == nil {
+		return nil, errors.New("plugin does not exist")
+	}
+
+	var p pluginp.Plugin
+	if err := utils.BsonToStruct(doc, &p); err != nil {
+		return nil, err
+	}
+
+	return &p, nil
+}
+
+// fetchInstalledPlugin returns a plugin after confirming it is installed in the organization.
+func fetchInstalledPlugin(orgID, pluginID string) (*pluginp.Plugin, error) {
+	org, err := FetchOrganizationByID(orgID)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, ok := org.Plugins[pluginID]; !ok {
+		return nil, errors.New("plugin is not installed in this organization")
+	}
+
+	return fetchPlugin(pluginID)
+}
+
+func fetchPluginSettings(orgID, pluginID string) (*PluginSettingsRecord, error) {
+	record := &PluginSettingsRecord{OrgID: orgID, PluginID: pluginID, Values: map[string]interface{}{}}
+
+	doc, _ := utils.GetMongoDBDoc(PluginSettingsCollectionName, bson.M{"org_id": orgID, "plugin_id": pluginID})
+	if doc == nil {
+		return record, nil
+	}
+
+	if err := utils.BsonToStruct(doc, record); err != nil {
+		return nil, err
+	}
+
+	if record.Values == nil {
+		record.Values = map[string]interface{}{}
+	}
+
+	return record, nil
+}
+
+// resolvePluginSettings fills in schema defaults and either decrypts or masks secret values.
+func resolvePluginSettings(schema pluginp.SettingsSchema, stored map[string]interface{}, key string, reveal bool) (map[string]interface{}, error) {
+	values := make(map[string]interface{}, len(schema))
+
+	for _, f := range schema {
+		val, ok := stored[f.Key]
+		if !ok {
+			if f.Default != nil {
+				values[f.Key] = f.Default
+			}
+
+			continue
+		}
+
+		if f.Type != pluginp.SettingSecret {
+			values[f.Key] = val
+			continue
+		}
+
+		if !reveal {
+			values[f.Key] = maskedSecret
+			continue
+		}
+
+		cipherText, _ := val.(string)
+
+		plain, err := utils.DecryptString(cipherText, key)
+		if err != nil {
+			return nil, fmt.Errorf("could not decrypt setting %s", f.Key)
+		}
+
+		values[f.Key] = plain
+	}
+
+	return values, nil
+}
+
+// Get an organization's settings for an installed plugin, secrets are masked.
+func (oh *OrganizationHandler) GetPluginSettings(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	orgID, pluginID := mux.Vars(r)["id"], mux.Vars(r)["plugin_id"]
+
+	plugin, err := fetchInstalledPlugin(orgID, pluginID)
+	if err != nil {
+		utils.GetError(err, http.StatusNotFound, w)
+		return
+	}
+
+	record, err := fetchPluginSettings(orgID, pluginID)
+	if err != nil {
+		utils.GetError(err, http.StatusInternalServerError, w)
+		return
+	}
+
+	values, err := resolvePluginSettings(plugin.SettingsSchema, record.Values, oh.configs.EncryptionKey, false)
+	if err != nil {
+		utils.GetError(err, http.StatusInternalServerError, w)
+		return
+	}
+
+	utils.GetSuccess("plugin settings retrieved successfully", map[string]interface{}{
+		"schema":     plugin.SettingsSchema,
+		"values":     values,
+		"updated_by": record.UpdatedBy,
+		"updated_at": record.UpdatedAt,
+	}, w)
+}
+
+// Update an organization's settings for an installed plugin.
+// Values are validated against the plugin's schema, a null value unsets a setting.
+func (oh *OrganizationHandler) UpdatePluginSettings(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	orgID, pluginID := mux.Vars(r)["id"], mux.Vars(r)["plugin_id"]
+
+	var body pluginSettingsBody
+	if err := utils.ParseJSONFromRequest(r, &body); err != nil {
+		utils.GetError(err, http.StatusUnprocessableEntity, w)
+		return
+	}
+
+	if body.Values == nil {
+		utils.GetError(errors.New("values are required"), http.StatusBadRequest, w)
+		return
+	}
+
+	loggedInUser, ok := r.Context().Value("user").(*auth.AuthUser)
+	if !ok {
+		utils.GetError(errors.New("invalid user"), http.StatusBadRequest, w)
+		return
+	}
+
+	plugin, err := fetchInstalledPlugin(orgID, pluginID)
+	if err != nil {
+		utils.GetError(err, http.StatusNotFound, w)
+		return
+	}
+
+	record, err := fetchPluginSettings(orgID, pluginID)
+	if err != nil {
+		utils.GetError(err, http.StatusInternalServerError, w)
+		return
+	}
+
+	if err = plugin.SettingsSchema.Validate(body.Values, record.Values); err != nil {
+		utils.GetError(errors.New(pluginp.ErrorMessage(err)), http.StatusBadRequest, w)
+		return
+	}
+
+	for key, val := range body.Values {
+		if val == nil {
+			delete(record.Values, key)
+			continue
+		}
+
+		f, _ := plugin.SettingsSchema.Field(key)
+
+		if f.Type == pluginp.SettingSecret {
+			// the masked placeholder is sent back by clients that did not touch the secret.
+			if val == maskedSecret {
+				continue
+			}
+
+			val = utils.EncryptString(val.(string), oh.configs.EncryptionKey)
+		}
+
+		record.Values[key] = val
+	}
+
+	update := bson.M{"$set": bson.M{
+		"values":     record.Values,
+		"updated_by": loggedInUser.Email,
+		"updated_at": time.Now(),
+	}}
+
+	_, err = utils.GetCollection(PluginSettingsCollectionName).UpdateOne(context.Background(),
+		bson.M{"org_id": orgID, "plugin_id": pluginID}, update, options.Update().SetUpsert(true))
+	if err != nil {
+		utils.GetError(err, http.StatusInternalServerError, w)
+		return
+	}
+
+	eventChannel := fmt.Sprintf("organizations_%s", orgID)
+	event := utils.Event{Identifier: pluginID, Type: "Organization", Event: UpdateOrganizationPluginSettings, Channel: eventChannel, Payload: make(map[string]interface{})}
+
+	go utils.Emitter(event)
+
+	values, err := resolvePluginSettings(plugin.SettingsSchema, record.Values, oh.configs.EncryptionKey, false)
+	if err != nil {
+		utils.GetError(err, http.StatusInternalServerError, w)
+		return
+	}
+
+	utils.GetSuccess("plugin settings updated successfully", values, w)
+}
+
+// Used by a plugin, authenticated with its api key, to read an organization's settings including secrets.
+func (oh *OrganizationHandler) GetPluginSettingsForPlugin(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	orgID := mux.Vars(r)["org_id"]
+
+	plugin, ok := r.Context().Value(pluginp.PluginContext).(*pluginp.Plugin)
+	if !ok {
+		utils.GetError(errors.New("invalid plugin"), http.StatusUnauthorized, w)
+		return
+	}
+
+	pluginID := plugin.ID.Hex()
+
+	if _, err := fetchInstalledPlugin(orgID, pluginID); err != nil {
+		utils.GetError(err, http.StatusNotFound, w)
+		return
+	}
+
+	record, err := fetchPluginSettings(orgID, pluginID)
+	if err != nil {
+		utils.GetError(err, http.StatusInternalServerError, w)
+		return
+	}
+
+	values, err := resolvePluginSettings(plugin.SettingsSchema, record.Values, oh.configs.EncryptionKey, true)
+	if err != nil {
+		utils.GetError(err, http.StatusInternalServerError, w)
+		return
+	}
+
+	utils.GetSuccess("plugin settings retrieved successfully", values, w)
+}
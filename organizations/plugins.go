@@ -15,7 +15,9 @@ import (
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
 
+	"zuri.chat/zccore/auth"
 	"zuri.chat/zccore/logger"
+	pluginp "zuri.chat/zccore/plugin"
 	"zuri.chat/zccore/utils"
 )
 
@@ -43,7 +45,8 @@ func (oh *OrganizationHandler) AddOrganizationPlugin(w http.ResponseWriter, r *h
 		return
 	}
 
-	plugin, _ := utils.GetMongoDBDoc(PluginCollectionName, bson.M{"_id": pluginID})
+	plugin, _ := utils.GetMongoDBDoc(PluginCollectionName, bson.M{"_id": pluginID},
+		options.FindOne().SetProjection(bson.M{"api_key_hash": 0}))
 
 	if plugin == nil {
 		utils.GetError(errors.New("operation failed"), http.StatusBadRequest, w)
@@ -70,7 +73,7 @@ func (oh *OrganizationHandler) AddOrganizationPlugin(w http.ResponseWriter, r *h
 		return
 	}
 
-	if member.Role != OwnerRole && member.Role != AdminRole {
+	if !auth.MemberHasPermission(OrgID, member.ID, member.Role, auth.PermPluginsInstall) {
 		utils.GetError(errors.New("access denied"), http.StatusForbidden, w)
 		return
 	}
@@ -103,6 +106,17 @@ func (oh *OrganizationHandler) AddOrganizationPlugin(w http.ResponseWriter, r *h
 		return
 	}
 
+	var pluginDetails pluginp.Plugin
+	if err = utils.BsonToStruct(plugin, &pluginDetails); err != nil {
+		utils.GetError(err, http.StatusInternalServerError, w)
+		return
+	}
+
+	if err = checkGrants(&pluginDetails, orgPlugin.Grants); err != nil {
+		utils.GetError(err, http.StatusBadRequest, w)
+		return
+	}
+
 	userName := member.UserName
 
 	installedPlugin := InstalledPlugin{
@@ -111,6 +125,7 @@ func (oh *OrganizationHandler) AddOrganizationPlugin(w http.ResponseWriter, r *h
 		AddedBy:     userName,
 		ApprovedBy:  userName,
 		InstalledAt: time.Now(),
+		Grants:      orgPlugin.Grants,
 	}
 
 	var pluginMap map[string]interface{}
@@ -164,6 +179,9 @@ func (oh *OrganizationHandler) AddOrganizationPlugin(w http.ResponseWriter, r *h
 		return
 	}
 
+	logAudit(r, AuditEvent{Action: AuditPluginInstalled, TargetType: "plugin", TargetID: orgPlugin.PluginID},
+		nil, bson.M{"plugin_id": orgPlugin.PluginID, "grants": orgPlugin.Grants, "added_by": userName})
+
 	data := map[string]interface{}{
 		"plugin_id": orgPlugin.PluginID,
 	}
@@ -292,7 +310,7 @@ func (oh *OrganizationHandler) RemoveOrganizationPlugin(w http.ResponseWriter, r
 		return
 	}
 
-	if member.Role != OwnerRole && member.Role != AdminRole {
+	if !auth.MemberHasPermission(orgID, member.ID, member.Role, auth.PermPluginsInstall) {
 		utils.GetError(errors.New("access denied"), http.StatusForbidden, w)
 		return
 	}
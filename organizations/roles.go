@@ -0,0 +1,308 @@
+package organizations
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/go-playground/validator/v10"
+	"github.com/gorilla/mux"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"zuri.chat/zccore/auth"
+	"zuri.chat/zccore/utils"
+)
+
+var (
+	roleNameRegex = regexp.MustCompile(`^[a-z][a-z0-9_-]{1,49}$`)
+
+	errInvalidRoleName = errors.New("role name must be 2 to 50 lowercase letters, digits, - or _ and start with a letter")
+	errBuiltinRole     = errors.New("built in roles cannot be created, changed or deleted")
+	errRoleNotFound    = errors.New("role not found")
+	errRoleExists      = errors.New("a role with this name already exists")
+)
+
+type roleBody struct {
+	Name        string   `json:"name"`
+	Description string   `json:"description" validate:"max=200"`
+	Permissions []string `json:"permissions"`
+}
+
+// callerMember returns the logged in user's active membership of orgID.
+func callerMember(r *http.Request, orgID string) (*Member, error) {
+	loggedIn, _ := r.Context().Value("user").(*auth.AuthUser)
+	if loggedIn == nil {
+		return nil, auth.ErrNotAuthorized
+	}
+
+	member, err := FetchMember(bson.M{"org_id": orgID, "email": strings.ToLower(loggedIn.Email), "deleted": bson.M{"$ne": true}})
+	if err != nil || member.Role == "" {
+		return nil, auth.ErrNotMember
+	}
+
+	return member, nil
+}
+
+// callerRole returns the logged in user's role in orgID, with the permissions
+// their groups give them.
+func callerRole(r *http.Request, orgID string) (*auth.Role, error) {
+	member, err := callerMember(r, orgID)
+	if err != nil {
+		return nil, err
+	}
+
+	return auth.EffectiveRole(orgID, member.ID, member.Role)
+}
+
+// checkGrantable makes sure perms are known and held by the caller, so no one
+// can hand out more than they have.
+func checkGrantable(caller *auth.Role, perms []string) error {
+	for _, p := range perms {
+		if !auth.ValidPermission(p) {
+			return fmt.Errorf("unknown permission %s", p)
+		}
+
+		if !caller.Has(p) {
+			return fmt.Errorf("you cannot grant %s, you do not have it", p)
+		}
+	}
+
+	return nil
+}
+
+func fetchCustomRole(orgID, roleID string) (*auth.Role, error) {
+	objID, err := primitive.ObjectIDFromHex(roleID)
+	if err != nil {
+		return nil, errRoleNotFound
+	}
+
+	var role auth.Role
+
+	filter := bson.M{"_id": objID, "org_id": orgID}
+	if err := utils.GetCollection(auth.RoleCollectionName).FindOne(context.Background(), filter).Decode(&role); err != nil {
+		return nil, errRoleNotFound
+	}
+
+	return &role, nil
+}
+
+// ListRoles returns the built in and custom roles of an organization, with
+// every permission a role can be given.
+func (oh *OrganizationHandler) ListRoles(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	orgID := mux.Vars(r)["id"]
+	opts := options.Find().SetSort(bson.M{"name": 1})
+
+	cursor, err := utils.GetCollection(auth.RoleCollectionName).Find(r.Context(), bson.M{"org_id": orgID}, opts)
+	if err != nil {
+		utils.GetError(err, http.StatusInternalServerError, w)
+		return
+	}
+
+	var custom []auth.Role
+	if err := cursor.All(r.Context(), &custom); err != nil {
+		utils.GetError(err, http.StatusInternalServerError, w)
+		return
+	}
+
+	utils.GetSuccess("roles retrieved successfully", map[string]interface{}{
+		"roles":       append(auth.BuiltinRoles(), custom...),
+		"permissions": auth.Permissions,
+	}, w)
+}
+
+// CreateRole defines a custom role as a named set of permissions.
+func (oh *OrganizationHandler) CreateRole(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	orgID := mux.Vars(r)["id"]
+
+	var body roleBody
+	if err := utils.ParseJSONFromRequest(r, &body); err != nil {
+		utils.GetError(err, http.StatusUnprocessableEntity, w)
+		return
+	}
+
+	if err := validator.New().Struct(body); err != nil {
+		utils.GetError(err, http.StatusBadRequest, w)
+		return
+	}
+
+	name := strings.ToLower(strings.TrimSpace(body.Name))
+	if !roleNameRegex.MatchString(name) {
+		utils.GetError(errInvalidRoleName, http.StatusBadRequest, w)
+		return
+	}
+
+	if auth.IsBuiltinRole(name) {
+		utils.GetError(errBuiltinRole, http.StatusBadRequest, w)
+		return
+	}
+
+	caller, err := callerRole(r, orgID)
+	if err != nil {
+		utils.GetError(err, http.StatusForbidden, w)
+		return
+	}
+
+	if err := checkGrantable(caller, body.Permissions); err != nil {
+		utils.GetError(err, http.StatusForbidden, w)
+		return
+	}
+
+	now := time.Now()
+	role := auth.Role{
+		OrgID:       orgID,
+		Name:        name,
+		Description: body.Description,
+		Permissions: body.Permissions,
+		CreatedAt:   now,
+		UpdatedAt:   now,
+	}
+
+	if role.Permissions == nil {
+		role.Permissions = []string{}
+	}
+
+	coll := utils.GetCollection(auth.RoleCollectionName)
+
+	if n, _ := coll.CountDocuments(r.Context(), bson.M{"org_id": orgID, "name": name}); n > 0 {
+		utils.GetError(errRoleExists, http.StatusConflict, w)
+		return
+	}
+
+	res, err := coll.InsertOne(r.Context(), role)
+	if err != nil {
+		if mongo.IsDuplicateKeyError(err) {
+			utils.GetError(errRoleExists, http.StatusConflict, w)
+			return
+		}
+
+		utils.GetError(err, http.StatusInternalServerError, w)
+
+		return
+	}
+
+	role.ID, _ = res.InsertedID.(primitive.ObjectID)
+
+	logAudit(r, AuditEvent{Action: AuditRoleCreated, TargetType: "role", TargetID: role.ID.Hex()},
+		nil, bson.M{"name": role.Name, "permissions": role.Permissions})
+
+	utils.GetSuccess("role created successfully", role, w)
+}
+
+// UpdateRole replaces a custom role's description and permissions. Members
+// holding it get the new permissions on their next request.
+func (oh *OrganizationHandler) UpdateRole(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	vars := mux.Vars(r)
+	orgID := vars["id"]
+
+	role, err := fetchCustomRole(orgID, vars["role_id"])
+	if err != nil {
+		utils.GetError(err, http.StatusNotFound, w)
+		return
+	}
+
+	var body roleBody
+	if err := utils.ParseJSONFromRequest(r, &body); err != nil {
+		utils.GetError(err, http.StatusUnprocessableEntity, w)
+		return
+	}
+
+	if err := validator.New().Struct(body); err != nil {
+		utils.GetError(err, http.StatusBadRequest, w)
+		return
+	}
+
+	caller, err := callerRole(r, orgID)
+	if err != nil {
+		utils.GetError(err, http.StatusForbidden, w)
+		return
+	}
+
+	// removing a permission the caller lacks would be as much an escalation
+	// as adding one, so both the old and the new set must be within reach
+	if err := checkGrantable(caller, append(body.Permissions, role.Permissions...)); err != nil {
+		utils.GetError(err, http.StatusForbidden, w)
+		return
+	}
+
+	if body.Permissions == nil {
+		body.Permissions = []string{}
+	}
+
+	update := bson.M{"$set": bson.M{
+		"description": body.Description,
+		"permissions": body.Permissions,
+		"updated_at":  time.Now(),
+	}}
+
+	if _, err := utils.GetCollection(auth.RoleCollectionName).UpdateOne(r.Context(), bson.M{"_id": role.ID}, update); err != nil {
+		utils.GetError(err, http.StatusInternalServerError, w)
+		return
+	}
+
+	logAudit(r, AuditEvent{Action: AuditRoleUpdated, TargetType: "role", TargetID: role.ID.Hex()},
+		bson.M{"description": role.Description, "permissions": role.Permissions},
+		bson.M{"description": body.Description, "permissions": body.Permissions})
+
+	role.Description, role.Permissions = body.Description, body.Permissions
+
+	utils.GetSuccess("role updated successfully", role, w)
+}
+
+// DeleteRole removes a custom role no member or group holds any more.
+func (oh *OrganizationHandler) DeleteRole(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	vars := mux.Vars(r)
+	orgID := vars["id"]
+
+	role, err := fetchCustomRole(orgID, vars["role_id"])
+	if err != nil {
+		utils.GetError(err, http.StatusNotFound, w)
+		return
+	}
+
+	holders, err := utils.GetCollection(MemberCollectionName).CountDocuments(r.Context(), bson.M{"org_id": orgID, "role": role.Name, "deleted": bson.M{"$ne": true}})
+	if err != nil {
+		utils.GetError(err, http.StatusInternalServerError, w)
+		return
+	}
+
+	if holders > 0 {
+		utils.GetError(fmt.Errorf("role is held by %d members, give them another role first", holders), http.StatusBadRequest, w)
+		return
+	}
+
+	groups, err := utils.GetCollection(auth.GroupCollectionName).CountDocuments(r.Context(), bson.M{"org_id": orgID, "roles": role.Name})
+	if err != nil {
+		utils.GetError(err, http.StatusInternalServerError, w)
+		return
+	}
+
+	if groups > 0 {
+		utils.GetError(fmt.Errorf("role is given to %d groups, take it from them first", groups), http.StatusBadRequest, w)
+		return
+	}
+
+	if _, err := utils.GetCollection(auth.RoleCollectionName).DeleteOne(r.Context(), bson.M{"_id": role.ID}); err != nil {
+		utils.GetError(err, http.StatusInternalServerError, w)
+		return
+	}
+
+	logAudit(r, AuditEvent{Action: AuditRoleDeleted, TargetType: "role", TargetID: role.ID.Hex()},
+		bson.M{"name": role.Name, "permissions": role.Permissions}, nil)
+
+	utils.GetSuccess("role deleted successfully", nil, w)
+}
@@ -0,0 +1,383 @@
+package organizations
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"zuri.chat/zccore/auth"
+	"zuri.chat/zccore/utils"
+)
+
+// SCIMTokenCollectionName holds the bearer tokens identity providers use to
+// provision an organization's members over SCIM.
+const SCIMTokenCollectionName = "organization_scim_tokens"
+
+const (
+	scimTokenPrefix = "zcs_"
+	scimTokenBytes  = 32
+	maxSCIMTokens   = 10
+
+	scimContentType = "application/scim+json"
+
+	defaultSCIMCount = 100
+	maxSCIMCount     = 200
+)
+
+// SCIM 2.0 schema URNs, RFC 7643 and RFC 7644.
+const (
+	scimUserSchema      = "urn:ietf:params:scim:schemas:core:2.0:User"
+	scimGroupSchema     = "urn:ietf:params:scim:schemas:core:2.0:Group"
+	scimListSchema      = "urn:ietf:params:scim:api:messages:2.0:ListResponse"
+	scimPatchSchema     = "urn:ietf:params:scim:api:messages:2.0:PatchOp"
+	scimErrorSchema     = "urn:ietf:params:scim:api:messages:2.0:Error"
+	scimSPConfigSchema  = "urn:ietf:params:scim:schemas:core:2.0:ServiceProviderConfig"
+	scimResourceTypeURN = "urn:ietf:params:scim:schemas:core:2.0:ResourceType"
+)
+
+// SCIM error types, RFC 7644 section 3.12.
+const (
+	scimInvalidFilter = "invalidFilter"
+	scimInvalidSyntax = "invalidSyntax"
+	scimInvalidPath   = "invalidPath"
+	scimInvalidValue  = "invalidValue"
+	scimMutability    = "mutability"
+	scimUniqueness    = "uniqueness"
+	scimNoTarget      = "noTarget"
+)
+
+var (
+	ErrSCIMTokenNotFound = errors.New("scim token not found")
+	ErrTooManySCIMTokens = fmt.Errorf("an organization can have at most %d scim tokens", maxSCIMTokens)
+
+	scimTokenIndexOnce sync.Once
+)
+
+// SCIMToken authenticates an identity provider to one organization's SCIM
+// API. Only the hash of the token is kept.
+type SCIMToken struct {
+	ID         primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	OrgID      string             `bson:"org_id" json:"org_id"`
+	Name       string             `bson:"name" json:"name"`
+	TokenHash  string             `bson:"token_hash" json:"-"`
+	Prefix     string             `bson:"prefix" json:"prefix"`
+	CreatedBy  string             `bson:"created_by" json:"created_by"`
+	CreatedAt  time.Time          `bson:"created_at" json:"created_at"`
+	LastUsedAt *time.Time         `bson:"last_used_at,omitempty" json:"last_used_at,omitempty"`
+}
+
+// scimError is the body of every failed SCIM response.
+type scimError struct {
+	Schemas  []string `json:"schemas"`
+	Status   string   `json:"status"`
+	SCIMType string   `json:"scimType,omitempty"`
+	Detail   string   `json:"detail"`
+}
+
+type scimListResponse struct {
+	Schemas      []string    `json:"schemas"`
+	TotalResults int         `json:"totalResults"`
+	StartIndex   int         `json:"startIndex"`
+	ItemsPerPage int         `json:"itemsPerPage"`
+	Resources    interface{} `json:"Resources"`
+}
+
+type scimMeta struct {
+	ResourceType string     `json:"resourceType"`
+	Created      *time.Time `json:"created,omitempty"`
+	Location     string     `json:"location"`
+}
+
+func scimTokens() *mongo.Collection {
+	coll := utils.GetCollection(SCIMTokenCollectionName)
+
+	scimTokenIndexOnce.Do(func() {
+		indexModel := mongo.IndexModel{Keys: bson.M{"token_hash": 1}, Options: options.Index().SetUnique(true)}
+
+		if _, err := coll.Indexes().CreateOne(context.Background(), indexModel); err != nil {
+			log.Printf("scim tokens: could not create index: %v", err)
+		}
+	})
+
+	return coll
+}
+
+func writeSCIM(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", scimContentType)
+	w.WriteHeader(status)
+
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Printf("scim: could not write response: %v", err)
+	}
+}
+
+func writeSCIMError(w http.ResponseWriter, status int, scimType, detail string) {
+	writeSCIM(w, status, scimError{
+		Schemas:  []string{scimErrorSchema},
+		Status:   strconv.Itoa(status),
+		SCIMType: scimType,
+		Detail:   detail,
+	})
+}
+
+// scimBaseURL is the root of an organization's SCIM API, used for the
+// location of resources.
+func scimBaseURL(r *http.Request, orgID string) string {
+	scheme := "https"
+	if r.TLS == nil && r.Header.Get("X-Forwarded-Proto") != "https" {
+		scheme = "http"
+	}
+
+	return fmt.Sprintf("%s://%s/organizations/%s/scim/v2", scheme, r.Host, orgID)
+}
+
+// scimPage reads the 1-based startIndex and count query parameters.
+func scimPage(r *http.Request) (startIndex, count int) {
+	query := r.URL.Query()
+
+	startIndex, err := strconv.Atoi(query.Get("startIndex"))
+	if err != nil || startIndex < 1 {
+		startIndex = 1
+	}
+
+	count, err = strconv.Atoi(query.Get("count"))
+	if err != nil {
+		count = defaultSCIMCount
+	}
+
+	if count < 0 {
+		count = 0
+	}
+
+	if count > maxSCIMCount {
+		count = maxSCIMCount
+	}
+
+	return startIndex, count
+}
+
+// scimTokenKey is the context key SCIMAuth stores the request's token under.
+type scimTokenKey struct{}
+
+// scimAuditEvent returns an audit event whose actor is the SCIM token the
+// request came in with.
+func scimAuditEvent(r *http.Request, action, targetType, targetID string) AuditEvent {
+	e := AuditEvent{Action: action, TargetType: targetType, TargetID: targetID}
+
+	if token, ok := r.Context().Value(scimTokenKey{}).(*SCIMToken); ok {
+		e.ActorID, e.ActorEmail = token.ID.Hex(), "scim:"+token.Name
+	}
+
+	return e
+}
+
+// SCIMAuth lets through requests bearing a SCIM token of the organization in
+// the path, answering everything else with a SCIM error.
+func (oh *OrganizationHandler) SCIMAuth(nextHandler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !strings.HasPrefix(token, scimTokenPrefix) {
+			writeSCIMError(w, http.StatusUnauthorized, "", "a scim bearer token is required")
+			return
+		}
+
+		var st SCIMToken
+
+		filter := bson.M{"token_hash": utils.HashToken(token), "org_id": mux.Vars(r)["id"]}
+		if err := scimTokens().FindOne(r.Context(), filter).Decode(&st); err != nil {
+			writeSCIMError(w, http.StatusUnauthorized, "", "scim token invalid or revoked")
+			return
+		}
+
+		now := time.Now()
+		if st.LastUsedAt == nil || now.Sub(*st.LastUsedAt) > time.Minute {
+			//nolint:errcheck //CODEI8: best effort, the token is already authenticated
+			scimTokens().UpdateOne(context.Background(), bson.M{"_id": st.ID}, bson.M{"$set": bson.M{"last_used_at": now}})
+		}
+
+		nextHandler.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), scimTokenKey{}, &st)))
+	}
+}
+
+// CreateSCIMToken mints a token for an identity provider to provision the
+// organization with. The token is only ever shown in this response.
+func (oh *OrganizationHandler) CreateSCIMToken(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	orgID := mux.Vars(r)["id"]
+
+	loggedIn, _ := r.Context().Value("user").(*auth.AuthUser)
+	if loggedIn == nil {
+		utils.GetError(auth.ErrNotAuthorized, http.StatusUnauthorized, w)
+		return
+	}
+
+	var body struct {
+		Name string `json:"name"`
+	}
+
+	if err := utils.ParseJSONFromRequest(r, &body); err != nil {
+		utils.GetError(err, http.StatusUnprocessableEntity, w)
+		return
+	}
+
+	body.Name = strings.TrimSpace(body.Name)
+	if body.Name == "" || len(body.Name) > 100 {
+		utils.GetError(errors.New("name must be 1 to 100 characters"), http.StatusBadRequest, w)
+		return
+	}
+
+	count, err := scimTokens().CountDocuments(r.Context(), bson.M{"org_id": orgID})
+	if err != nil {
+		utils.GetError(err, http.StatusInternalServerError, w)
+		return
+	}
+
+	if count >= maxSCIMTokens {
+		utils.GetError(ErrTooManySCIMTokens, http.StatusBadRequest, w)
+		return
+	}
+
+	secret, err := utils.GenSecureToken(scimTokenBytes)
+	if err != nil {
+		utils.GetError(err, http.StatusInternalServerError, w)
+		return
+	}
+
+	token := scimTokenPrefix + secret
+	st := SCIMToken{
+		OrgID:     orgID,
+		Name:      body.Name,
+		TokenHash: utils.HashToken(token),
+		Prefix:    token[:len(scimTokenPrefix)+6],
+		CreatedBy: loggedIn.Email,
+		CreatedAt: time.Now(),
+	}
+
+	res, err := scimTokens().InsertOne(r.Context(), st)
+	if err != nil {
+		utils.GetError(err, http.StatusInternalServerError, w)
+		return
+	}
+
+	st.ID, _ = res.InsertedID.(primitive.ObjectID)
+
+	logAudit(r, AuditEvent{Action: AuditSCIMTokenCreated, TargetType: "scim_token", TargetID: st.ID.Hex()},
+		nil, bson.M{"name": st.Name})
+
+	utils.GetSuccess("scim token created, copy it now as it will not be shown again", map[string]interface{}{
+		"token":    token,
+		"details":  st,
+		"base_url": scimBaseURL(r, orgID),
+	}, w)
+}
+
+// ListSCIMTokens returns an organization's SCIM tokens, without the secrets.
+func (oh *OrganizationHandler) ListSCIMTokens(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	opts := options.Find().SetSort(bson.M{"created_at": -1})
+
+	cursor, err := scimTokens().Find(r.Context(), bson.M{"org_id": mux.Vars(r)["id"]}, opts)
+	if err != nil {
+		utils.GetError(err, http.StatusInternalServerError, w)
+		return
+	}
+
+	tokens := []SCIMToken{}
+	if err := cursor.All(r.Context(), &tokens); err != nil {
+		utils.GetError(err, http.StatusInternalServerError, w)
+		return
+	}
+
+	utils.GetSuccess("scim tokens retrieved successfully", tokens, w)
+}
+
+// RevokeSCIMToken deletes a SCIM token, cutting off the identity provider
+// using it.
+func (oh *OrganizationHandler) RevokeSCIMToken(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	vars := mux.Vars(r)
+
+	objID, err := primitive.ObjectIDFromHex(vars["token_id"])
+	if err != nil {
+		utils.GetError(ErrSCIMTokenNotFound, http.StatusNotFound, w)
+		return
+	}
+
+	res, err := scimTokens().DeleteOne(r.Context(), bson.M{"_id": objID, "org_id": vars["id"]})
+	if err != nil {
+		utils.GetError(err, http.StatusInternalServerError, w)
+		return
+	}
+
+	if res.DeletedCount == 0 {
+		utils.GetError(ErrSCIMTokenNotFound, http.StatusNotFound, w)
+		return
+	}
+
+	logAudit(r, AuditEvent{Action: AuditSCIMTokenRevoked, TargetType: "scim_token", TargetID: vars["token_id"]}, nil, nil)
+
+	utils.GetSuccess("scim token revoked successfully", nil, w)
+}
+
+// SCIMServiceProviderConfig describes which parts of SCIM are supported.
+func (oh *OrganizationHandler) SCIMServiceProviderConfig(w http.ResponseWriter, r *http.Request) {
+	supported := func(ok bool) map[string]interface{} { return map[string]interface{}{"supported": ok} }
+
+	writeSCIM(w, http.StatusOK, map[string]interface{}{
+		"schemas":        []string{scimSPConfigSchema},
+		"patch":          supported(true),
+		"bulk":           map[string]interface{}{"supported": false, "maxOperations": 0, "maxPayloadSize": 0},
+		"filter":         map[string]interface{}{"supported": true, "maxResults": maxSCIMCount},
+		"changePassword": supported(false),
+		"sort":           supported(false),
+		"etag":           supported(false),
+		"authenticationSchemes": []map[string]interface{}{{
+			"type":        "oauthbearertoken",
+			"name":        "OAuth Bearer Token",
+			"description": "Authentication with an organization scoped scim token",
+			"primary":     true,
+		}},
+		"meta": scimMeta{ResourceType: "ServiceProviderConfig", Location: scimBaseURL(r, mux.Vars(r)["id"]) + "/ServiceProviderConfig"},
+	})
+}
+
+// SCIMResourceTypes lists the resources the SCIM API serves.
+func (oh *OrganizationHandler) SCIMResourceTypes(w http.ResponseWriter, r *http.Request) {
+	base := scimBaseURL(r, mux.Vars(r)["id"])
+	types := []map[string]interface{}{
+		{
+			"schemas": []string{scimResourceTypeURN}, "id": "User", "name": "User",
+			"endpoint": "/Users", "schema": scimUserSchema,
+			"meta": scimMeta{ResourceType: "ResourceType", Location: base + "/ResourceTypes/User"},
+		},
+		{
+			"schemas": []string{scimResourceTypeURN}, "id": "Group", "name": "Group",
+			"endpoint": "/Groups", "schema": scimGroupSchema,
+			"meta": scimMeta{ResourceType: "ResourceType", Location: base + "/ResourceTypes/Group"},
+		},
+	}
+
+	writeSCIM(w, http.StatusOK, scimListResponse{
+		Schemas:      []string{scimListSchema},
+		TotalResults: len(types),
+		StartIndex:   1,
+		ItemsPerPage: len(types),
+		Resources:    types,
+	})
+}
@@ -0,0 +1,492 @@
+package organizations
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+var (
+	errSCIMFilter = errors.New("invalid filter")
+
+	scimMongoOps = map[string]string{"ne": "$ne", "gt": "$gt", "ge": "$gte", "lt": "$lt", "le": "$lte"}
+)
+
+// scimExpr is a parsed SCIM filter, RFC 7644 section 3.4.2.2. Leaves compare
+// an attribute, branches combine them with and, or or not.
+type scimExpr struct {
+	op          string // and, or, not or cmp
+	left, right *scimExpr
+
+	attr  string // lowercased attribute path, such as name.givenname
+	cmp   string // eq, ne, co, sw, ew, gt, ge, lt, le or pr
+	value interface{}
+}
+
+// how an attribute of a stored document is compared.
+const (
+	scimExact    = iota // case sensitive string
+	scimCaseless        // string stored lowercased
+	scimText            // case insensitive string
+	scimObjectID        // the document _id
+	scimActive          // the inverse of the deleted flag
+	scimTime
+)
+
+type scimAttr struct {
+	field string
+	kind  int
+}
+
+type scimLexeme struct {
+	text   string
+	quoted bool
+}
+
+func lexSCIMFilter(s string) ([]scimLexeme, error) {
+	var tokens []scimLexeme
+
+	for i := 0; i < len(s); {
+		switch c := s[i]; {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			i++
+		case strings.ContainsRune("()[]", rune(c)):
+			tokens = append(tokens, scimLexeme{text: string(c)})
+			i++
+		case c == '"':
+			j := i + 1
+			for j < len(s) && s[j] != '"' {
+				if s[j] == '\\' {
+					j++
+				}
+				j++
+			}
+
+			if j >= len(s) {
+				return nil, fmt.Errorf("%w: unterminated string", errSCIMFilter)
+			}
+
+			var text string
+			if err := json.Unmarshal([]byte(s[i:j+1]), &text); err != nil {
+				return nil, fmt.Errorf("%w: bad string %s", errSCIMFilter, s[i:j+1])
+			}
+
+			tokens = append(tokens, scimLexeme{text: text, quoted: true})
+			i = j + 1
+		default:
+			j := i
+			for j < len(s) && !strings.ContainsRune(" \t\n\r()[]\"", rune(s[j])) {
+				j++
+			}
+
+			tokens = append(tokens, scimLexeme{text: s[i:j]})
+			i = j
+		}
+	}
+
+	return tokens, nil
+}
+
+type scimParser struct {
+	tokens []scimLexeme
+	pos    int
+	prefix string
+}
+
+func (p *scimParser) peek() (scimLexeme, bool) {
+	if p.pos >= len(p.tokens) {
+		return scimLexeme{}, false
+	}
+
+	return p.tokens[p.pos], true
+}
+
+// peekWord reports whether the next token is the unquoted word w, in any case.
+func (p *scimParser) peekWord(w string) bool {
+	t, ok := p.peek()
+	return ok && !t.quoted && strings.EqualFold(t.text, w)
+}
+
+func (p *scimParser) expect(w string) error {
+	if !p.peekWord(w) {
+		return fmt.Errorf("%w: expected %s", errSCIMFilter, w)
+	}
+
+	p.pos++
+
+	return nil
+}
+
+func (p *scimParser) parseOr() (*scimExpr, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+
+	for p.peekWord("or") {
+		p.pos++
+
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+
+		left = &scimExpr{op: "or", left: left, right: right}
+	}
+
+	return left, nil
+}
+
+func (p *scimParser) parseAnd() (*scimExpr, error) {
+	left, err := p.parseFactor()
+	if err != nil {
+		return nil, err
+	}
+
+	for p.peekWord("and") {
+		p.pos++
+
+		right, err := p.parseFactor()
+		if err != nil {
+			return nil, err
+		}
+
+		left = &scimExpr{op: "and", left: left, right: right}
+	}
+
+	return left, nil
+}
+
+func (p *scimParser) parseGroup() (*scimExpr, error) {
+	if err := p.expect("("); err != nil {
+		return nil, err
+	}
+
+	e, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+
+	return e, p.expect(")")
+}
+
+func (p *scimParser) parseFactor() (*scimExpr, error) {
+	if p.peekWord("(") {
+		return p.parseGroup()
+	}
+
+	if p.peekWord("not") {
+		p.pos++
+
+		e, err := p.parseGroup()
+		if err != nil {
+			return nil, err
+		}
+
+		return &scimExpr{op: "not", left: e}, nil
+	}
+
+	t, ok := p.peek()
+	if !ok || t.quoted {
+		return nil, fmt.Errorf("%w: expected an attribute", errSCIMFilter)
+	}
+
+	p.pos++
+	attr := p.prefix + scimAttrPath(t.text)
+
+	// a value path, emails[type eq "work"], filters the sub attributes
+	if p.peekWord("[") {
+		p.pos++
+
+		outer := p.prefix
+		p.prefix = attr + "."
+
+		e, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+
+		p.prefix = outer
+
+		return e, p.expect("]")
+	}
+
+	opToken, ok := p.peek()
+	if !ok || opToken.quoted {
+		return nil, fmt.Errorf("%w: expected an operator after %s", errSCIMFilter, t.text)
+	}
+
+	p.pos++
+	e := &scimExpr{op: "cmp", attr: attr, cmp: strings.ToLower(opToken.text)}
+
+	switch e.cmp {
+	case "pr":
+		return e, nil
+	case "eq", "ne", "co", "sw", "ew", "gt", "ge", "lt", "le":
+	default:
+		return nil, fmt.Errorf("%w: unknown operator %s", errSCIMFilter, opToken.text)
+	}
+
+	v, ok := p.peek()
+	if !ok {
+		return nil, fmt.Errorf("%w: expected a value after %s", errSCIMFilter, opToken.text)
+	}
+
+	p.pos++
+
+	switch lower := strings.ToLower(v.text); {
+	case v.quoted:
+		e.value = v.text
+	case lower == "true" || lower == "false":
+		e.value = lower == "true"
+	case lower == "null":
+		e.value = nil
+	default:
+		n, err := strconv.ParseFloat(v.text, 64)
+		if err != nil {
+			return nil, fmt.Errorf("%w: bad value %s", errSCIMFilter, v.text)
+		}
+
+		e.value = n
+	}
+
+	return e, nil
+}
+
+// scimAttrPath lowercases an attribute path and drops any schema URN in
+// front of it.
+func scimAttrPath(path string) string {
+	path = strings.ToLower(path)
+	if strings.HasPrefix(path, "urn:") {
+		path = path[strings.LastIndex(path, ":")+1:]
+	}
+
+	return path
+}
+
+// parseSCIMFilter parses a SCIM filter expression.
+func parseSCIMFilter(filter string) (*scimExpr, error) {
+	tokens, err := lexSCIMFilter(filter)
+	if err != nil {
+		return nil, err
+	}
+
+	p := &scimParser{tokens: tokens}
+
+	e, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+
+	if p.pos != len(p.tokens) {
+		return nil, fmt.Errorf("%w: unexpected %s", errSCIMFilter, p.tokens[p.pos].text)
+	}
+
+	return e, nil
+}
+
+// toBSON turns e into a query on documents whose attributes are described
+// by attrs.
+func (e *scimExpr) toBSON(attrs map[string]scimAttr) (bson.M, error) {
+	switch e.op {
+	case "and", "or":
+		left, err := e.left.toBSON(attrs)
+		if err != nil {
+			return nil, err
+		}
+
+		right, err := e.right.toBSON(attrs)
+		if err != nil {
+			return nil, err
+		}
+
+		return bson.M{"$" + e.op: bson.A{left, right}}, nil
+	case "not":
+		inner, err := e.left.toBSON(attrs)
+		if err != nil {
+			return nil, err
+		}
+
+		return bson.M{"$nor": bson.A{inner}}, nil
+	}
+
+	attr, ok := attrs[e.attr]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s cannot be filtered on", errSCIMFilter, e.attr)
+	}
+
+	switch attr.kind {
+	case scimActive:
+		return e.activeBSON(attr.field)
+	case scimObjectID:
+		return e.objectIDBSON(attr.field)
+	case scimTime:
+		return e.timeBSON(attr.field)
+	}
+
+	if e.cmp == "pr" {
+		return bson.M{attr.field: bson.M{"$exists": true, "$nin": bson.A{nil, ""}}}, nil
+	}
+
+	s, ok := e.value.(string)
+	if !ok {
+		return nil, fmt.Errorf("%w: %s takes a string", errSCIMFilter, e.attr)
+	}
+
+	if attr.kind == scimCaseless {
+		s = strings.ToLower(s)
+	}
+
+	if attr.kind == scimText || e.cmp == "co" || e.cmp == "sw" || e.cmp == "ew" {
+		pattern := map[string]string{"eq": "^%s$", "ne": "^%s$", "co": "%s", "sw": "^%s", "ew": "%s$"}[e.cmp]
+		if pattern != "" {
+			re := primitive.Regex{Pattern: fmt.Sprintf(pattern, regexp.QuoteMeta(s)), Options: "i"}
+			if e.cmp == "ne" {
+				return bson.M{attr.field: bson.M{"$not": re}}, nil
+			}
+
+			return bson.M{attr.field: re}, nil
+		}
+	}
+
+	if e.cmp == "eq" {
+		return bson.M{attr.field: s}, nil
+	}
+
+	return bson.M{attr.field: bson.M{scimMongoOps[e.cmp]: s}}, nil
+}
+
+func (e *scimExpr) activeBSON(field string) (bson.M, error) {
+	if e.cmp == "pr" {
+		return bson.M{}, nil
+	}
+
+	active, ok := e.value.(bool)
+	if !ok || (e.cmp != "eq" && e.cmp != "ne") {
+		return nil, fmt.Errorf("%w: active only compares with eq or ne to true or false", errSCIMFilter)
+	}
+
+	if e.cmp == "ne" {
+		active = !active
+	}
+
+	if active {
+		return bson.M{field: bson.M{"$ne": true}}, nil
+	}
+
+	return bson.M{field: true}, nil
+}
+
+func (e *scimExpr) objectIDBSON(field string) (bson.M, error) {
+	s, ok := e.value.(string)
+	if !ok || (e.cmp != "eq" && e.cmp != "ne") {
+		return nil, fmt.Errorf("%w: id only compares with eq or ne to a string", errSCIMFilter)
+	}
+
+	// an id that is not an ObjectID matches no document
+	id, err := primitive.ObjectIDFromHex(s)
+	if err != nil {
+		id = primitive.NilObjectID
+	}
+
+	if e.cmp == "ne" {
+		return bson.M{field: bson.M{"$ne": id}}, nil
+	}
+
+	return bson.M{field: id}, nil
+}
+
+func (e *scimExpr) timeBSON(field string) (bson.M, error) {
+	if e.cmp == "pr" {
+		return bson.M{field: bson.M{"$exists": true}}, nil
+	}
+
+	s, _ := e.value.(string)
+
+	t, err := time.Parse(time.RFC3339, s)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s takes an RFC 3339 time", errSCIMFilter, e.attr)
+	}
+
+	if e.cmp == "eq" {
+		return bson.M{field: t}, nil
+	}
+
+	op, ok := scimMongoOps[e.cmp]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s cannot be compared with %s", errSCIMFilter, e.attr, e.cmp)
+	}
+
+	return bson.M{field: bson.M{op: t}}, nil
+}
+
+// match reports whether a resource with the given attribute values, keyed by
+// lowercased path, satisfies e. Values compare as case insensitive strings.
+func (e *scimExpr) match(values map[string][]string) (bool, error) {
+	switch e.op {
+	case "and", "or":
+		left, err := e.left.match(values)
+		if err != nil {
+			return false, err
+		}
+
+		right, err := e.right.match(values)
+		if err != nil {
+			return false, err
+		}
+
+		if e.op == "and" {
+			return left && right, nil
+		}
+
+		return left || right, nil
+	case "not":
+		inner, err := e.left.match(values)
+		return !inner, err
+	}
+
+	got, ok := values[e.attr]
+	if !ok {
+		return false, fmt.Errorf("%w: %s cannot be filtered on", errSCIMFilter, e.attr)
+	}
+
+	if e.cmp == "pr" {
+		for _, v := range got {
+			if v != "" {
+				return true, nil
+			}
+		}
+
+		return false, nil
+	}
+
+	if e.value == nil {
+		return false, fmt.Errorf("%w: %s cannot be compared with null", errSCIMFilter, e.attr)
+	}
+
+	// booleans and numbers compare in their JSON form, as the values do
+	want := strings.ToLower(fmt.Sprint(e.value))
+
+	for _, v := range got {
+		v = strings.ToLower(v)
+
+		hit := map[string]bool{
+			"eq": v == want, "ne": v == want,
+			"co": strings.Contains(v, want), "sw": strings.HasPrefix(v, want), "ew": strings.HasSuffix(v, want),
+			"gt": v > want, "ge": v >= want, "lt": v < want, "le": v <= want,
+		}[e.cmp]
+
+		if hit {
+			return e.cmp != "ne", nil
+		}
+	}
+
+	return e.cmp == "ne", nil
+}
@@ -0,0 +1,112 @@
+package organizations
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestParseSCIMFilterToBSON(t *testing.T) {
+	id := primitive.NewObjectID()
+
+	tests := []struct {
+		filter string
+		want   bson.M
+	}{
+		{`userName eq "Ada@Zuri.Chat"`, bson.M{"email": "ada@zuri.chat"}},
+		{`externalId eq "00u1"`, bson.M{"external_id": "00u1"}},
+		{`active eq false`, bson.M{"deleted": true}},
+		{`id eq "` + id.Hex() + `"`, bson.M{"_id": id}},
+		{`id eq "nope"`, bson.M{"_id": primitive.NilObjectID}},
+		{`urn:ietf:params:scim:schemas:core:2.0:User:userName sw "ada"`, bson.M{"email": primitive.Regex{Pattern: "^ada", Options: "i"}}},
+		{`emails[value co "@zuri"]`, bson.M{"email": primitive.Regex{Pattern: "@zuri", Options: "i"}}},
+		{`displayName eq "Ada" or not (active eq true)`, bson.M{"$or": bson.A{
+			bson.M{"display_name": primitive.Regex{Pattern: "^Ada$", Options: "i"}},
+			bson.M{"$nor": bson.A{bson.M{"deleted": bson.M{"$ne": true}}}},
+		}}},
+	}
+
+	for _, tt := range tests {
+		expr, err := parseSCIMFilter(tt.filter)
+		if err != nil {
+			t.Errorf("parseSCIMFilter(%q) = %v", tt.filter, err)
+			continue
+		}
+
+		got, err := expr.toBSON(scimUserAttrs)
+		if err != nil {
+			t.Errorf("toBSON(%q) = %v", tt.filter, err)
+			continue
+		}
+
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("toBSON(%q) = %v, want %v", tt.filter, got, tt.want)
+		}
+	}
+}
+
+func TestParseSCIMFilterErrors(t *testing.T) {
+	for _, filter := range []string{
+		`userName`,
+		`userName eq`,
+		`userName like "ada"`,
+		`(userName eq "ada"`,
+		`userName eq "ada`,
+		`userName eq "ada" and`,
+	} {
+		if _, err := parseSCIMFilter(filter); !errors.Is(err, errSCIMFilter) {
+			t.Errorf("parseSCIMFilter(%q) = %v, want %v", filter, err, errSCIMFilter)
+		}
+	}
+
+	expr, err := parseSCIMFilter(`password eq "x"`)
+	if err != nil {
+		t.Fatalf("parseSCIMFilter() = %v", err)
+	}
+
+	if _, err := expr.toBSON(scimUserAttrs); !errors.Is(err, errSCIMFilter) {
+		t.Errorf("toBSON() on an unknown attribute = %v, want %v", err, errSCIMFilter)
+	}
+}
+
+func TestSCIMFilterMatch(t *testing.T) {
+	values := map[string][]string{
+		"id":            {"admin"},
+		"displayname":   {"Admin"},
+		"members.value": {"61a", "61b"},
+	}
+
+	tests := []struct {
+		filter string
+		want   bool
+	}{
+		{`displayName eq "admin"`, true},
+		{`displayName ne "admin"`, false},
+		{`members[value eq "61b"]`, true},
+		{`members.value eq "61c"`, false},
+		{`id sw "ad" and not (members.value eq "61a")`, false},
+		{`id eq "guest" or displayName co "dmi"`, true},
+		{`members.value pr`, true},
+	}
+
+	for _, tt := range tests {
+		expr, err := parseSCIMFilter(tt.filter)
+		if err != nil {
+			t.Errorf("parseSCIMFilter(%q) = %v", tt.filter, err)
+			continue
+		}
+
+		got, err := expr.match(values)
+		if err != nil || got != tt.want {
+			t.Errorf("match(%q) = %v, %v, want %v", tt.filter, got, err, tt.want)
+		}
+	}
+
+	expr, _ := parseSCIMFilter(`externalId eq "x"`)
+	if _, err := expr.match(values); !errors.Is(err, errSCIMFilter) {
+		t.Errorf("match() on an unknown attribute = %v, want %v", err, errSCIMFilter)
+	}
+}
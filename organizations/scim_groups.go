@@ -0,0 +1,390 @@
+package organizations
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/gorilla/mux"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"zuri.chat/zccore/auth"
+	"zuri.chat/zccore/utils"
+)
+
+// scimGroup is a role seen as a SCIM Group. A member belongs to exactly one
+// group, their role, so adding a member to a group moves them out of the one
+// they were in and removing them puts them back in the member group.
+type scimGroup struct {
+	Schemas     []string    `json:"schemas"`
+	ID          string      `json:"id"`
+	DisplayName string      `json:"displayName"`
+	Members     []scimValue `json:"members,omitempty"`
+	Meta        *scimMeta   `json:"meta,omitempty"`
+}
+
+// orgRoles returns the built in and custom roles of an organization.
+func orgRoles(ctx context.Context, orgID string) ([]auth.Role, error) {
+	cursor, err := utils.GetCollection(auth.RoleCollectionName).Find(ctx, bson.M{"org_id": orgID}, options.Find().SetSort(bson.M{"name": 1}))
+	if err != nil {
+		return nil, err
+	}
+
+	var custom []auth.Role
+	if err := cursor.All(ctx, &custom); err != nil {
+		return nil, err
+	}
+
+	return append(auth.BuiltinRoles(), custom...), nil
+}
+
+// activeMembersByRole returns the organization's active members grouped by
+// role.
+func activeMembersByRole(ctx context.Context, orgID string, filter bson.M) (map[string][]Member, error) {
+	query := bson.M{"org_id": orgID, "deleted": bson.M{"$ne": true}}
+	for k, v := range filter {
+		query[k] = v
+	}
+
+	opts := options.Find().SetProjection(bson.M{"email": 1, "role": 1}).SetSort(bson.M{"_id": 1})
+
+	cursor, err := utils.GetCollection(MemberCollectionName).Find(ctx, query, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	var members []Member
+	if err := cursor.All(ctx, &members); err != nil {
+		return nil, err
+	}
+
+	byRole := make(map[string][]Member)
+	for _, m := range members {
+		byRole[m.Role] = append(byRole[m.Role], m)
+	}
+
+	return byRole, nil
+}
+
+func roleToSCIM(role *auth.Role, members []Member, base string, withMembers bool) scimGroup {
+	g := scimGroup{
+		Schemas:     []string{scimGroupSchema},
+		ID:          role.Name,
+		DisplayName: role.Name,
+		Meta:        &scimMeta{ResourceType: "Group", Location: base + "/Groups/" + role.Name},
+	}
+
+	if !role.CreatedAt.IsZero() {
+		created := role.CreatedAt
+		g.Meta.Created = &created
+	}
+
+	if withMembers {
+		for _, m := range members {
+			g.Members = append(g.Members, scimValue{Value: m.ID, Display: m.Email, Ref: base + "/Users/" + m.ID})
+		}
+	}
+
+	return g
+}
+
+// groupValues lists the attributes a Groups filter can compare.
+func groupValues(g scimGroup) map[string][]string {
+	values := map[string][]string{
+		"id":          {g.ID},
+		"displayname": {g.DisplayName},
+	}
+
+	for _, m := range g.Members {
+		values["members"] = append(values["members"], m.Value)
+		values["members.value"] = append(values["members.value"], m.Value)
+		values["members.display"] = append(values["members.display"], m.Display)
+	}
+
+	return values
+}
+
+func excludesMembers(r *http.Request) bool {
+	for _, attr := range strings.Split(r.URL.Query().Get("excludedAttributes"), ",") {
+		if strings.EqualFold(strings.TrimSpace(attr), "members") {
+			return true
+		}
+	}
+
+	return false
+}
+
+// setGroupMembers makes ids the active members holding role. Members left out
+// fall back to the member role.
+func setGroupMembers(r *http.Request, orgID, role string, ids []string) error {
+	byRole, err := activeMembersByRole(r.Context(), orgID, bson.M{"role": role})
+	if err != nil {
+		return err
+	}
+
+	want := make(map[string]bool, len(ids))
+	for _, id := range ids {
+		want[id] = true
+	}
+
+	current := make(map[string]bool)
+	for _, m := range byRole[role] {
+		current[m.ID] = true
+	}
+
+	if role == OwnerRole && len(want) != len(current) {
+		return scimFail(http.StatusBadRequest, scimMutability, "ownership can only be transferred from zuri chat")
+	}
+
+	for id := range current {
+		if !want[id] && role == MemberRole {
+			return scimFail(http.StatusBadRequest, scimMutability, "members leave the member group by joining another group")
+		}
+	}
+
+	for id := range want {
+		if current[id] {
+			continue
+		}
+
+		m, err := fetchSCIMMember(r.Context(), orgID, id)
+		if err != nil {
+			return scimFail(http.StatusBadRequest, scimInvalidValue, "user %s not found", id)
+		}
+
+		if m.Role == OwnerRole {
+			return scimFail(http.StatusBadRequest, scimMutability, "ownership can only be transferred from zuri chat")
+		}
+
+		if err := setMemberRole(r, orgID, m, role); err != nil {
+			return err
+		}
+	}
+
+	for id := range current {
+		if want[id] {
+			continue
+		}
+
+		m, err := fetchSCIMMember(r.Context(), orgID, id)
+		if err != nil {
+			return err
+		}
+
+		if err := setMemberRole(r, orgID, m, MemberRole); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// memberIDs reads the member ids out of a decoded members attribute.
+func memberIDs(members []scimValue) []string {
+	ids := make([]string, 0, len(members))
+	for _, m := range members {
+		ids = append(ids, m.Value)
+	}
+
+	return ids
+}
+
+func fetchSCIMGroup(r *http.Request) (*auth.Role, []Member, error) {
+	vars := mux.Vars(r)
+
+	role, err := auth.FetchRole(vars["id"], vars["group_id"])
+	if err != nil {
+		return nil, nil, scimFail(http.StatusNotFound, "", "group %s not found", vars["group_id"])
+	}
+
+	byRole, err := activeMembersByRole(r.Context(), vars["id"], bson.M{"role": role.Name})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return role, byRole[role.Name], nil
+}
+
+// SCIMListGroups returns the organization's roles as SCIM Groups.
+func (oh *OrganizationHandler) SCIMListGroups(w http.ResponseWriter, r *http.Request) {
+	orgID := mux.Vars(r)["id"]
+	base := scimBaseURL(r, orgID)
+
+	var filter *scimExpr
+
+	if f := r.URL.Query().Get("filter"); f != "" {
+		expr, err := parseSCIMFilter(f)
+		if err != nil {
+			writeSCIMError(w, http.StatusBadRequest, scimInvalidFilter, err.Error())
+			return
+		}
+
+		filter = expr
+	}
+
+	roles, err := orgRoles(r.Context(), orgID)
+	if err != nil {
+		writeSCIMFailure(w, err)
+		return
+	}
+
+	byRole, err := activeMembersByRole(r.Context(), orgID, nil)
+	if err != nil {
+		writeSCIMFailure(w, err)
+		return
+	}
+
+	sort.SliceStable(roles, func(i, j int) bool { return roles[i].Name < roles[j].Name })
+
+	groups := []scimGroup{}
+
+	for i := range roles {
+		g := roleToSCIM(&roles[i], byRole[roles[i].Name], base, true)
+
+		if filter != nil {
+			ok, err := filter.match(groupValues(g))
+			if err != nil {
+				writeSCIMError(w, http.StatusBadRequest, scimInvalidFilter, err.Error())
+				return
+			}
+
+			if !ok {
+				continue
+			}
+		}
+
+		if excludesMembers(r) {
+			g.Members = nil
+		}
+
+		groups = append(groups, g)
+	}
+
+	startIndex, count := scimPage(r)
+	total := len(groups)
+
+	if startIndex > total {
+		groups = groups[:0]
+	} else {
+		groups = groups[startIndex-1:]
+	}
+
+	if len(groups) > count {
+		groups = groups[:count]
+	}
+
+	writeSCIM(w, http.StatusOK, scimListResponse{
+		Schemas:      []string{scimListSchema},
+		TotalResults: total,
+		StartIndex:   startIndex,
+		ItemsPerPage: len(groups),
+		Resources:    groups,
+	})
+}
+
+// SCIMGetGroup returns one role as a SCIM Group.
+func (oh *OrganizationHandler) SCIMGetGroup(w http.ResponseWriter, r *http.Request) {
+	role, members, err := fetchSCIMGroup(r)
+	if err != nil {
+		writeSCIMFailure(w, err)
+		return
+	}
+
+	writeSCIM(w, http.StatusOK, roleToSCIM(role, members, scimBaseURL(r, mux.Vars(r)["id"]), !excludesMembers(r)))
+}
+
+// SCIMReplaceGroup sets the members of a role to the ones sent.
+func (oh *OrganizationHandler) SCIMReplaceGroup(w http.ResponseWriter, r *http.Request) {
+	orgID := mux.Vars(r)["id"]
+
+	role, _, err := fetchSCIMGroup(r)
+	if err != nil {
+		writeSCIMFailure(w, err)
+		return
+	}
+
+	var g scimGroup
+	if err := utils.ParseJSONFromRequest(r, &g); err != nil {
+		writeSCIMError(w, http.StatusBadRequest, scimInvalidSyntax, err.Error())
+		return
+	}
+
+	if g.DisplayName != "" && !strings.EqualFold(g.DisplayName, role.Name) {
+		writeSCIMError(w, http.StatusBadRequest, scimMutability, "groups are renamed by renaming the role from zuri chat")
+		return
+	}
+
+	if err := setGroupMembers(r, orgID, role.Name, memberIDs(g.Members)); err != nil {
+		writeSCIMFailure(w, err)
+		return
+	}
+
+	role, members, err := fetchSCIMGroup(r)
+	if err != nil {
+		writeSCIMFailure(w, err)
+		return
+	}
+
+	writeSCIM(w, http.StatusOK, roleToSCIM(role, members, scimBaseURL(r, orgID), true))
+}
+
+// SCIMPatchGroup applies PATCH operations to a role's members.
+func (oh *OrganizationHandler) SCIMPatchGroup(w http.ResponseWriter, r *http.Request) {
+	orgID := mux.Vars(r)["id"]
+
+	role, members, err := fetchSCIMGroup(r)
+	if err != nil {
+		writeSCIMFailure(w, err)
+		return
+	}
+
+	var body scimPatchRequest
+	if err := utils.ParseJSONFromRequest(r, &body); err != nil {
+		writeSCIMError(w, http.StatusBadRequest, scimInvalidSyntax, err.Error())
+		return
+	}
+
+	raw, err := json.Marshal(roleToSCIM(role, members, "", true))
+	if err != nil {
+		writeSCIMFailure(w, err)
+		return
+	}
+
+	var doc map[string]interface{}
+	if err := json.Unmarshal(raw, &doc); err != nil {
+		writeSCIMFailure(w, err)
+		return
+	}
+
+	if err := applySCIMPatch(doc, body.Operations); err != nil {
+		writeSCIMFailure(w, patchFailure(err))
+		return
+	}
+
+	raw, err = json.Marshal(doc)
+	if err != nil {
+		writeSCIMFailure(w, err)
+		return
+	}
+
+	var g scimGroup
+	if err := json.Unmarshal(raw, &g); err != nil {
+		writeSCIMError(w, http.StatusBadRequest, scimInvalidValue, err.Error())
+		return
+	}
+
+	if !strings.EqualFold(g.DisplayName, role.Name) {
+		writeSCIMError(w, http.StatusBadRequest, scimMutability, "groups are renamed by renaming the role from zuri chat")
+		return
+	}
+
+	if err := setGroupMembers(r, orgID, role.Name, memberIDs(g.Members)); err != nil {
+		writeSCIMFailure(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,306 @@
+package organizations
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+var (
+	errSCIMPath     = errors.New("invalid path")
+	errSCIMValue    = errors.New("invalid value")
+	errSCIMNoTarget = errors.New("no target")
+)
+
+type scimPatchOp struct {
+	Op    string      `json:"op"`
+	Path  string      `json:"path"`
+	Value interface{} `json:"value"`
+}
+
+type scimPatchRequest struct {
+	Schemas    []string      `json:"schemas"`
+	Operations []scimPatchOp `json:"Operations"`
+}
+
+// scimPath is a parsed PATCH path: attr, attr.sub, attr[filter] or
+// attr[filter].sub, RFC 7644 section 3.5.2.
+type scimPath struct {
+	attr   string
+	filter *scimExpr
+	sub    string
+}
+
+func parseSCIMPath(path string) (*scimPath, error) {
+	head, rest := path, ""
+	if i := strings.Index(path, "["); i >= 0 {
+		head, rest = path[:i], path[i:]
+	}
+
+	head = scimAttrPath(head)
+	p := &scimPath{attr: head}
+
+	if i := strings.Index(head, "."); i >= 0 {
+		p.attr, p.sub = head[:i], head[i+1:]
+	}
+
+	if p.attr == "" {
+		return nil, fmt.Errorf("%w: %s", errSCIMPath, path)
+	}
+
+	if rest == "" {
+		return p, nil
+	}
+
+	end := strings.LastIndex(rest, "]")
+	if p.sub != "" || end < 0 || (end+1 < len(rest) && rest[end+1] != '.') {
+		return nil, fmt.Errorf("%w: %s", errSCIMPath, path)
+	}
+
+	filter, err := parseSCIMFilter(rest[1:end])
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", errSCIMPath, path)
+	}
+
+	p.filter = filter
+
+	if end+1 < len(rest) {
+		p.sub = strings.ToLower(rest[end+2:])
+	}
+
+	return p, nil
+}
+
+// scimKey returns the key of doc matching name case insensitively, or name
+// when there is none. SCIM attribute names are case insensitive.
+func scimKey(doc map[string]interface{}, name string) string {
+	for k := range doc {
+		if strings.EqualFold(k, name) {
+			return k
+		}
+	}
+
+	return name
+}
+
+// attrs lists the attribute paths e compares.
+func (e *scimExpr) attrs() []string {
+	if e.op == "cmp" {
+		return []string{e.attr}
+	}
+
+	out := e.left.attrs()
+	if e.right != nil {
+		out = append(out, e.right.attrs()...)
+	}
+
+	return out
+}
+
+// matchElement reports whether one element of a multi valued attribute
+// satisfies filter. Attributes the element lacks are empty.
+func matchElement(filter *scimExpr, elem map[string]interface{}) (bool, error) {
+	values := make(map[string][]string)
+
+	for _, attr := range filter.attrs() {
+		values[attr] = nil
+	}
+
+	for k, v := range elem {
+		values[strings.ToLower(k)] = []string{fmt.Sprint(v)}
+	}
+
+	return filter.match(values)
+}
+
+// eqPairs returns the attribute values a filter made only of eq comparisons
+// joined by and requires, or nil for any other filter.
+func eqPairs(e *scimExpr) map[string]interface{} {
+	switch {
+	case e.op == "cmp" && e.cmp == "eq":
+		return map[string]interface{}{e.attr: e.value}
+	case e.op == "and":
+		left, right := eqPairs(e.left), eqPairs(e.right)
+		if left == nil || right == nil {
+			return nil
+		}
+
+		for k, v := range right {
+			left[k] = v
+		}
+
+		return left
+	}
+
+	return nil
+}
+
+// applySCIMPatch applies ops to doc, the JSON form of a resource.
+func applySCIMPatch(doc map[string]interface{}, ops []scimPatchOp) error {
+	for _, op := range ops {
+		if err := applySCIMOp(doc, strings.ToLower(op.Op), op.Path, op.Value); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func applySCIMOp(doc map[string]interface{}, op, path string, value interface{}) error {
+	if op != "add" && op != "replace" && op != "remove" {
+		return fmt.Errorf("%w: unknown op %s", errSCIMValue, op)
+	}
+
+	if path == "" {
+		if op == "remove" {
+			return fmt.Errorf("%w: remove needs a path", errSCIMNoTarget)
+		}
+
+		values, ok := value.(map[string]interface{})
+		if !ok {
+			return fmt.Errorf("%w: %s without a path takes an object", errSCIMValue, op)
+		}
+
+		for k, v := range values {
+			if err := applySCIMOp(doc, op, k, v); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	}
+
+	p, err := parseSCIMPath(path)
+	if err != nil {
+		return err
+	}
+
+	key := scimKey(doc, p.attr)
+
+	if p.filter != nil {
+		return applySCIMFiltered(doc, key, p, op, value)
+	}
+
+	if p.sub == "" {
+		switch op {
+		case "remove":
+			delete(doc, key)
+		case "add":
+			existing, isList := doc[key].([]interface{})
+			if added, ok := value.([]interface{}); ok && isList {
+				doc[key] = append(existing, added...)
+			} else {
+				doc[key] = value
+			}
+		default:
+			doc[key] = value
+		}
+
+		return nil
+	}
+
+	switch parent := doc[key].(type) {
+	case map[string]interface{}:
+		setSCIMSub(parent, p.sub, op, value)
+	case []interface{}:
+		for _, e := range parent {
+			if elem, ok := e.(map[string]interface{}); ok {
+				setSCIMSub(elem, p.sub, op, value)
+			}
+		}
+	case nil:
+		if op != "remove" {
+			doc[key] = map[string]interface{}{p.sub: value}
+		}
+	default:
+		return fmt.Errorf("%w: %s has no sub attributes", errSCIMPath, p.attr)
+	}
+
+	return nil
+}
+
+func setSCIMSub(elem map[string]interface{}, sub, op string, value interface{}) {
+	if op == "remove" {
+		delete(elem, scimKey(elem, sub))
+		return
+	}
+
+	elem[scimKey(elem, sub)] = value
+}
+
+// applySCIMFiltered applies op to the elements of a multi valued attribute
+// matching the path's filter. An add or replace matching nothing creates the
+// element when the filter pins it down, as in emails[type eq "work"].value.
+func applySCIMFiltered(doc map[string]interface{}, key string, p *scimPath, op string, value interface{}) error {
+	var list []interface{}
+
+	if existing, ok := doc[key]; ok && existing != nil {
+		if list, ok = existing.([]interface{}); !ok {
+			return fmt.Errorf("%w: %s is not multi valued", errSCIMPath, p.attr)
+		}
+	}
+
+	kept := make([]interface{}, 0, len(list))
+	matched := false
+
+	for _, e := range list {
+		elem, ok := e.(map[string]interface{})
+		if !ok {
+			kept = append(kept, e)
+			continue
+		}
+
+		hit, err := matchElement(p.filter, elem)
+		if err != nil {
+			return fmt.Errorf("%w: %v", errSCIMPath, err)
+		}
+
+		if !hit {
+			kept = append(kept, e)
+			continue
+		}
+
+		matched = true
+
+		switch {
+		case p.sub != "":
+			setSCIMSub(elem, p.sub, op, value)
+			kept = append(kept, elem)
+		case op == "remove":
+		default:
+			replacement, ok := value.(map[string]interface{})
+			if !ok {
+				return fmt.Errorf("%w: %s takes an object", errSCIMValue, p.attr)
+			}
+
+			kept = append(kept, replacement)
+		}
+	}
+
+	if !matched {
+		pairs := eqPairs(p.filter)
+		if op == "remove" || pairs == nil {
+			return fmt.Errorf("%w: nothing matches %s", errSCIMNoTarget, p.attr)
+		}
+
+		elem := make(map[string]interface{})
+
+		for attr, v := range pairs {
+			elem[strings.TrimPrefix(attr, p.attr+".")] = v
+		}
+
+		if p.sub != "" {
+			elem[p.sub] = value
+		} else if extra, ok := value.(map[string]interface{}); ok {
+			for k, v := range extra {
+				elem[k] = v
+			}
+		}
+
+		kept = append(kept, elem)
+	}
+
+	doc[key] = kept
+
+	return nil
+}
@@ -0,0 +1,98 @@
+package organizations
+
+import (
+	"encoding/json"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func decodeDoc(t *testing.T, s string) map[string]interface{} {
+	t.Helper()
+
+	var doc map[string]interface{}
+	if err := json.Unmarshal([]byte(s), &doc); err != nil {
+		t.Fatalf("bad test document %s: %v", s, err)
+	}
+
+	return doc
+}
+
+func TestApplySCIMPatch(t *testing.T) {
+	user := `{
+		"userName": "ada@zuri.chat",
+		"name": {"givenName": "Ada"},
+		"active": true,
+		"emails": [{"value": "ada@zuri.chat", "type": "work", "primary": true}],
+		"members": [{"value": "61a"}, {"value": "61b"}]
+	}`
+
+	tests := []struct {
+		name string
+		ops  string
+		want string
+	}{
+		{
+			"replace without a path",
+			`[{"op": "Replace", "value": {"active": false, "name.familyName": "Lovelace"}}]`,
+			`{"userName": "ada@zuri.chat", "name": {"givenName": "Ada", "familyname": "Lovelace"}, "active": false,
+			  "emails": [{"value": "ada@zuri.chat", "type": "work", "primary": true}], "members": [{"value": "61a"}, {"value": "61b"}]}`,
+		},
+		{
+			"sub attribute and case insensitive names",
+			`[{"op": "replace", "path": "NAME.GIVENNAME", "value": "Augusta"}, {"op": "remove", "path": "emails"}]`,
+			`{"userName": "ada@zuri.chat", "name": {"givenName": "Augusta"}, "active": true, "members": [{"value": "61a"}, {"value": "61b"}]}`,
+		},
+		{
+			"filtered remove and add to a list",
+			`[{"op": "remove", "path": "members[value eq \"61a\"]"}, {"op": "add", "path": "members", "value": [{"value": "61c"}]}]`,
+			`{"userName": "ada@zuri.chat", "name": {"givenName": "Ada"}, "active": true,
+			  "emails": [{"value": "ada@zuri.chat", "type": "work", "primary": true}], "members": [{"value": "61b"}, {"value": "61c"}]}`,
+		},
+		{
+			"filtered replace creates the element",
+			`[{"op": "replace", "path": "phoneNumbers[type eq \"work\"].value", "value": "+234"},
+			  {"op": "replace", "path": "emails[primary eq true].value", "value": "ada@hng.tech"}]`,
+			`{"userName": "ada@zuri.chat", "name": {"givenName": "Ada"}, "active": true, "phonenumbers": [{"type": "work", "value": "+234"}],
+			  "emails": [{"value": "ada@hng.tech", "type": "work", "primary": true}], "members": [{"value": "61a"}, {"value": "61b"}]}`,
+		},
+	}
+
+	for _, tt := range tests {
+		var ops []scimPatchOp
+		if err := json.Unmarshal([]byte(tt.ops), &ops); err != nil {
+			t.Fatalf("%s: bad ops: %v", tt.name, err)
+		}
+
+		doc := decodeDoc(t, user)
+		if err := applySCIMPatch(doc, ops); err != nil {
+			t.Errorf("%s: applySCIMPatch() = %v", tt.name, err)
+			continue
+		}
+
+		if want := decodeDoc(t, tt.want); !reflect.DeepEqual(doc, want) {
+			t.Errorf("%s: applySCIMPatch() = %v, want %v", tt.name, doc, want)
+		}
+	}
+}
+
+func TestApplySCIMPatchErrors(t *testing.T) {
+	tests := []struct {
+		op   scimPatchOp
+		want error
+	}{
+		{scimPatchOp{Op: "move", Path: "active"}, errSCIMValue},
+		{scimPatchOp{Op: "remove"}, errSCIMNoTarget},
+		{scimPatchOp{Op: "replace", Value: "x"}, errSCIMValue},
+		{scimPatchOp{Op: "remove", Path: `members[value eq "61z"]`}, errSCIMNoTarget},
+		{scimPatchOp{Op: "add", Path: `members[value eq`}, errSCIMPath},
+		{scimPatchOp{Op: "add", Path: "userName.first", Value: "x"}, errSCIMPath},
+	}
+
+	for _, tt := range tests {
+		doc := decodeDoc(t, `{"userName": "ada@zuri.chat", "members": [{"value": "61a"}]}`)
+		if err := applySCIMPatch(doc, []scimPatchOp{tt.op}); !errors.Is(err, tt.want) {
+			t.Errorf("applySCIMPatch(%+v) = %v, want %v", tt.op, err, tt.want)
+		}
+	}
+}
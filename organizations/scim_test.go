@@ -0,0 +1,28 @@
+package organizations
+
+import (
+	"context"
+	"net/http/httptest"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestSCIMAuditEvent(t *testing.T) {
+	token := &SCIMToken{ID: primitive.NewObjectID(), Name: "okta"}
+
+	r := httptest.NewRequest("DELETE", "/organizations/1/scim/v2/Users/2", nil)
+	r = r.WithContext(context.WithValue(r.Context(), scimTokenKey{}, token))
+
+	e := scimAuditEvent(r, AuditMemberDeactivated, "member", "2")
+	if e.ActorID != token.ID.Hex() || e.ActorEmail != "scim:okta" {
+		t.Errorf("scimAuditEvent() actor = %q, %q, want %q, %q", e.ActorID, e.ActorEmail, token.ID.Hex(), "scim:okta")
+	}
+
+	//nolint:staticcheck //a plain string key must not be mistaken for the token
+	r = r.WithContext(context.WithValue(context.Background(), "scim_token", token))
+
+	if e := scimAuditEvent(r, AuditMemberDeactivated, "member", "2"); e.ActorID != "" {
+		t.Errorf("scimAuditEvent() read the token from a string key, actor = %q", e.ActorID)
+	}
+}
@@ -0,0 +1,606 @@
+package organizations
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"zuri.chat/zccore/auth"
+	"zuri.chat/zccore/utils"
+)
+
+// how SCIM User attributes map onto member documents.
+var scimUserAttrs = map[string]scimAttr{
+	"id":                 {"_id", scimObjectID},
+	"username":           {"email", scimCaseless},
+	"emails":             {"email", scimCaseless},
+	"emails.value":       {"email", scimCaseless},
+	"externalid":         {"external_id", scimExact},
+	"displayname":        {"display_name", scimText},
+	"name.givenname":     {"first_name", scimText},
+	"name.familyname":    {"last_name", scimText},
+	"active":             {"deleted", scimActive},
+	"roles":              {"role", scimCaseless},
+	"roles.value":        {"role", scimCaseless},
+	"groups":             {"role", scimCaseless},
+	"groups.value":       {"role", scimCaseless},
+	"timezone":           {"time_zone", scimExact},
+	"phonenumbers":       {"phone", scimExact},
+	"phonenumbers.value": {"phone", scimExact},
+	"meta.created":       {"joined_at", scimTime},
+}
+
+// scimFailure is a SCIM request that cannot be carried out, with the status
+// and error type to answer it with.
+type scimFailure struct {
+	status   int
+	scimType string
+	detail   string
+}
+
+func (f *scimFailure) Error() string { return f.detail }
+
+func scimFail(status int, scimType, format string, args ...interface{}) error {
+	return &scimFailure{status: status, scimType: scimType, detail: fmt.Sprintf(format, args...)}
+}
+
+// writeSCIMFailure answers with err's status and type, or a 500 for errors
+// that are not SCIM failures.
+func writeSCIMFailure(w http.ResponseWriter, err error) {
+	var f *scimFailure
+	if errors.As(err, &f) {
+		writeSCIMError(w, f.status, f.scimType, f.detail)
+		return
+	}
+
+	writeSCIMError(w, http.StatusInternalServerError, "", err.Error())
+}
+
+type scimName struct {
+	Formatted  string `json:"formatted,omitempty"`
+	GivenName  string `json:"givenName,omitempty"`
+	FamilyName string `json:"familyName,omitempty"`
+}
+
+type scimValue struct {
+	Value   string `json:"value"`
+	Display string `json:"display,omitempty"`
+	Type    string `json:"type,omitempty"`
+	Primary bool   `json:"primary,omitempty"`
+	Ref     string `json:"$ref,omitempty"`
+}
+
+type scimUser struct {
+	Schemas      []string    `json:"schemas"`
+	ID           string      `json:"id,omitempty"`
+	ExternalID   string      `json:"externalId,omitempty"`
+	UserName     string      `json:"userName"`
+	Name         *scimName   `json:"name,omitempty"`
+	DisplayName  string      `json:"displayName,omitempty"`
+	Emails       []scimValue `json:"emails,omitempty"`
+	PhoneNumbers []scimValue `json:"phoneNumbers,omitempty"`
+	Timezone     string      `json:"timezone,omitempty"`
+	Active       *bool       `json:"active,omitempty"`
+	Roles        []scimValue `json:"roles,omitempty"`
+	Groups       []scimValue `json:"groups,omitempty"`
+	Meta         *scimMeta   `json:"meta,omitempty"`
+}
+
+// primaryValue returns the primary value of a multi valued attribute, or its
+// first one.
+func primaryValue(values []scimValue) string {
+	for _, v := range values {
+		if v.Primary {
+			return v.Value
+		}
+	}
+
+	if len(values) > 0 {
+		return values[0].Value
+	}
+
+	return ""
+}
+
+func memberToSCIM(m *Member, base string) scimUser {
+	active := !m.Deleted
+	joined := m.JoinedAt
+	u := scimUser{
+		Schemas:     []string{scimUserSchema},
+		ID:          m.ID,
+		ExternalID:  m.ExternalID,
+		UserName:    m.Email,
+		DisplayName: m.DisplayName,
+		Emails:      []scimValue{{Value: m.Email, Type: "work", Primary: true}},
+		Timezone:    m.TimeZone,
+		Active:      &active,
+		Roles:       []scimValue{{Value: m.Role, Primary: true}},
+		Groups:      []scimValue{{Value: m.Role, Display: m.Role, Ref: base + "/Groups/" + m.Role}},
+		Meta:        &scimMeta{ResourceType: "User", Created: &joined, Location: base + "/Users/" + m.ID},
+	}
+
+	if m.FirstName != "" || m.LastName != "" {
+		u.Name = &scimName{
+			Formatted:  strings.TrimSpace(m.FirstName + " " + m.LastName),
+			GivenName:  m.FirstName,
+			FamilyName: m.LastName,
+		}
+	}
+
+	if m.Phone != "" {
+		u.PhoneNumbers = []scimValue{{Value: m.Phone, Type: "work"}}
+	}
+
+	return u
+}
+
+// decodeSCIMUser reads a SCIM User from doc, the JSON form of one, taking
+// the string booleans some identity providers send for active.
+func decodeSCIMUser(doc map[string]interface{}) (scimUser, error) {
+	var u scimUser
+
+	key := scimKey(doc, "active")
+	if s, ok := doc[key].(string); ok {
+		active, err := strconv.ParseBool(s)
+		if err != nil {
+			return u, scimFail(http.StatusBadRequest, scimInvalidValue, "active must be true or false")
+		}
+
+		doc[key] = active
+	}
+
+	raw, err := json.Marshal(doc)
+	if err != nil {
+		return u, err
+	}
+
+	if err := json.Unmarshal(raw, &u); err != nil {
+		return u, scimFail(http.StatusBadRequest, scimInvalidValue, "%s", err.Error())
+	}
+
+	return u, nil
+}
+
+func readSCIMUser(r *http.Request) (scimUser, error) {
+	var doc map[string]interface{}
+	if err := utils.ParseJSONFromRequest(r, &doc); err != nil {
+		return scimUser{}, scimFail(http.StatusBadRequest, scimInvalidSyntax, "%s", err.Error())
+	}
+
+	return decodeSCIMUser(doc)
+}
+
+func fetchSCIMMember(ctx context.Context, orgID, memberID string) (*Member, error) {
+	objID, err := primitive.ObjectIDFromHex(memberID)
+	if err != nil {
+		return nil, scimFail(http.StatusNotFound, "", "user %s not found", memberID)
+	}
+
+	filter := bson.M{"_id": objID, "org_id": orgID, "scim_deprovisioned": bson.M{"$ne": true}}
+
+	var m Member
+	if err := utils.GetCollection(MemberCollectionName).FindOne(ctx, filter).Decode(&m); err != nil {
+		return nil, scimFail(http.StatusNotFound, "", "user %s not found", memberID)
+	}
+
+	return &m, nil
+}
+
+// scimRole returns the role a SCIM User asks for, checking it may be given.
+func scimRole(orgID string, u scimUser, current string) (string, error) {
+	role := strings.ToLower(primaryValue(u.Roles))
+	if role == "" || role == current {
+		return current, nil
+	}
+
+	if role == OwnerRole || current == OwnerRole {
+		return "", scimFail(http.StatusBadRequest, scimMutability, "ownership can only be transferred from zuri chat")
+	}
+
+	if _, err := auth.FetchRole(orgID, role); err != nil {
+		return "", scimFail(http.StatusBadRequest, scimInvalidValue, "role %s does not exist", role)
+	}
+
+	return role, nil
+}
+
+// setMemberRole gives a member a new role, telling subscribers about it.
+func setMemberRole(r *http.Request, orgID string, m *Member, role string) error {
+	if _, err := utils.UpdateOneMongoDBDoc(MemberCollectionName, m.ID, bson.M{"role": role}); err != nil {
+		return err
+	}
+
+	event := utils.Event{Identifier: m.ID, Type: "User", Event: UpdateOrganizationMemberRole, Channel: fmt.Sprintf("organizations_%s", orgID), Payload: make(map[string]interface{})}
+	go utils.Emitter(event)
+
+	logAudit(r, scimAuditEvent(r, AuditMemberRoleChanged, "member", m.ID), bson.M{"role": m.Role}, bson.M{"role": role})
+
+	m.Role = role
+
+	return nil
+}
+
+// setMemberActive deactivates or reactivates a member the way
+// DeactivateMember and ReactivateMember do.
+func setMemberActive(r *http.Request, orgID string, m *Member, active bool) error {
+	update, event, message, action := bson.M{"deleted": false, "deleted_at": time.Time{}}, ReactivateOrganizationMember, "enter_organization", AuditMemberReactivated
+	if !active {
+		update, event, message, action = bson.M{"deleted": true, "deleted_at": time.Now()}, DeactivateOrganizationMember, "leave_organization", AuditMemberDeactivated
+	}
+
+	if _, err := utils.UpdateOneMongoDBDoc(MemberCollectionName, m.ID, update); err != nil {
+		return err
+	}
+
+	go utils.Emitter(utils.Event{Identifier: m.ID, Type: "User", Event: event, Channel: fmt.Sprintf("organizations_%s", orgID), Payload: make(map[string]interface{})})
+
+	if err := AddSyncMessage(orgID, message, EnterLeaveMessage{OrganizationID: orgID, MemberID: m.ID}); err != nil {
+		log.Printf("sync error: %v", err)
+	}
+
+	logAudit(r, scimAuditEvent(r, action, "member", m.ID), bson.M{"deleted": m.Deleted}, bson.M{"deleted": !active})
+
+	m.Deleted = !active
+
+	return nil
+}
+
+// replaceMember makes m match u, which holds every attribute m should have.
+// Attributes u leaves out are cleared, except the role and active state,
+// which are kept.
+func replaceMember(r *http.Request, orgID string, m *Member, u scimUser) error {
+	if !strings.EqualFold(u.UserName, m.Email) {
+		return scimFail(http.StatusBadRequest, scimMutability, "userName cannot be changed, the member must change their email from zuri chat")
+	}
+
+	role, err := scimRole(orgID, u, m.Role)
+	if err != nil {
+		return err
+	}
+
+	if u.Active != nil && !*u.Active && m.Role == OwnerRole {
+		return scimFail(http.StatusBadRequest, scimMutability, "the organization owner cannot be deactivated")
+	}
+
+	if u.Name == nil {
+		u.Name = &scimName{}
+	}
+
+	before := bson.M{"first_name": m.FirstName, "last_name": m.LastName, "display_name": m.DisplayName, "external_id": m.ExternalID, "phone": m.Phone, "time_zone": m.TimeZone}
+	after := bson.M{
+		"first_name":   u.Name.GivenName,
+		"last_name":    u.Name.FamilyName,
+		"display_name": u.DisplayName,
+		"external_id":  u.ExternalID,
+		"phone":        primaryValue(u.PhoneNumbers),
+		"time_zone":    u.Timezone,
+	}
+
+	if changes := auditDiff(before, after); len(changes) > 0 {
+		if _, err := utils.UpdateOneMongoDBDoc(MemberCollectionName, m.ID, after); err != nil {
+			return err
+		}
+
+		event := utils.Event{Identifier: m.ID, Type: "User", Event: UpdateOrganizationMemberProfile, Channel: fmt.Sprintf("organizations_%s", orgID), Payload: utils.M(after)}
+		go utils.Emitter(event)
+
+		logAudit(r, scimAuditEvent(r, AuditMemberUpdated, "member", m.ID), before, after)
+
+		m.FirstName, m.LastName, m.DisplayName = u.Name.GivenName, u.Name.FamilyName, u.DisplayName
+		m.ExternalID, m.Phone, m.TimeZone = u.ExternalID, primaryValue(u.PhoneNumbers), u.Timezone
+	}
+
+	if role != m.Role {
+		if err := setMemberRole(r, orgID, m, role); err != nil {
+			return err
+		}
+	}
+
+	if u.Active != nil && *u.Active == m.Deleted {
+		return setMemberActive(r, orgID, m, *u.Active)
+	}
+
+	return nil
+}
+
+// SCIMListUsers returns the organization's members as SCIM Users, filtered
+// and paged as the query asks.
+func (oh *OrganizationHandler) SCIMListUsers(w http.ResponseWriter, r *http.Request) {
+	orgID := mux.Vars(r)["id"]
+	filter := bson.M{"org_id": orgID, "scim_deprovisioned": bson.M{"$ne": true}}
+
+	if f := r.URL.Query().Get("filter"); f != "" {
+		expr, err := parseSCIMFilter(f)
+		if err != nil {
+			writeSCIMError(w, http.StatusBadRequest, scimInvalidFilter, err.Error())
+			return
+		}
+
+		query, err := expr.toBSON(scimUserAttrs)
+		if err != nil {
+			writeSCIMError(w, http.StatusBadRequest, scimInvalidFilter, err.Error())
+			return
+		}
+
+		filter = bson.M{"$and": bson.A{filter, query}}
+	}
+
+	startIndex, count := scimPage(r)
+	coll := utils.GetCollection(MemberCollectionName)
+
+	total, err := coll.CountDocuments(r.Context(), filter)
+	if err != nil {
+		writeSCIMFailure(w, err)
+		return
+	}
+
+	members := []Member{}
+
+	if count > 0 {
+		opts := options.Find().
+			SetSort(bson.D{{Key: "joined_at", Value: 1}, {Key: "_id", Value: 1}}).
+			SetSkip(int64(startIndex - 1)).
+			SetLimit(int64(count))
+
+		cursor, err := coll.Find(r.Context(), filter, opts)
+		if err != nil {
+			writeSCIMFailure(w, err)
+			return
+		}
+
+		if err := cursor.All(r.Context(), &members); err != nil {
+			writeSCIMFailure(w, err)
+			return
+		}
+	}
+
+	base := scimBaseURL(r, orgID)
+	users := make([]scimUser, len(members))
+
+	for i := range members {
+		users[i] = memberToSCIM(&members[i], base)
+	}
+
+	writeSCIM(w, http.StatusOK, scimListResponse{
+		Schemas:      []string{scimListSchema},
+		TotalResults: int(total),
+		StartIndex:   startIndex,
+		ItemsPerPage: len(users),
+		Resources:    users,
+	})
+}
+
+// SCIMGetUser returns one member as a SCIM User.
+func (oh *OrganizationHandler) SCIMGetUser(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+
+	m, err := fetchSCIMMember(r.Context(), vars["id"], vars["user_id"])
+	if err != nil {
+		writeSCIMFailure(w, err)
+		return
+	}
+
+	writeSCIM(w, http.StatusOK, memberToSCIM(m, scimBaseURL(r, vars["id"])))
+}
+
+// SCIMCreateUser provisions a member. The person does not need a zuri chat
+// account yet, the membership is there when they sign up with the email.
+func (oh *OrganizationHandler) SCIMCreateUser(w http.ResponseWriter, r *http.Request) {
+	orgID := mux.Vars(r)["id"]
+
+	u, err := readSCIMUser(r)
+	if err != nil {
+		writeSCIMFailure(w, err)
+		return
+	}
+
+	email := strings.ToLower(strings.TrimSpace(u.UserName))
+	if !utils.IsValidEmail(email) {
+		writeSCIMError(w, http.StatusBadRequest, scimInvalidValue, "userName must be an email address")
+		return
+	}
+
+	if existing, err := FetchMember(bson.M{"org_id": orgID, "email": email}); err == nil {
+		if !existing.SCIMDeprovisioned {
+			writeSCIMError(w, http.StatusConflict, scimUniqueness, "a member with this userName already exists")
+			return
+		}
+
+		oh.reprovisionSCIMUser(w, r, existing, u)
+
+		return
+	}
+
+	role, err := scimRole(orgID, u, MemberRole)
+	if err != nil {
+		writeSCIMFailure(w, err)
+		return
+	}
+
+	member := NewMember(email, strings.Split(email, "@")[0], orgID, role)
+	member.ExternalID, member.DisplayName, member.TimeZone, member.Phone = u.ExternalID, u.DisplayName, u.Timezone, primaryValue(u.PhoneNumbers)
+
+	if u.Name != nil {
+		member.FirstName, member.LastName = u.Name.GivenName, u.Name.FamilyName
+	}
+
+	memberID, err := addMember(r.Context(), member)
+	if err != nil {
+		writeSCIMFailure(w, err)
+		return
+	}
+
+	member.ID = memberID.Hex()
+
+	logAudit(r, scimAuditEvent(r, AuditMemberProvisioned, "member", member.ID), nil, bson.M{"email": email, "role": role})
+
+	if u.Active != nil && !*u.Active {
+		if err := setMemberActive(r, orgID, &member, false); err != nil {
+			writeSCIMFailure(w, err)
+			return
+		}
+	}
+
+	created := memberToSCIM(&member, scimBaseURL(r, orgID))
+	w.Header().Set("Location", created.Meta.Location)
+	writeSCIM(w, http.StatusCreated, created)
+}
+
+// reprovisionSCIMUser brings back a member the identity provider deleted
+// earlier, as the User it is now creating.
+func (oh *OrganizationHandler) reprovisionSCIMUser(w http.ResponseWriter, r *http.Request, m *Member, u scimUser) {
+	orgID := mux.Vars(r)["id"]
+
+	if _, err := utils.UpdateOneMongoDBDoc(MemberCollectionName, m.ID, bson.M{"scim_deprovisioned": false}); err != nil {
+		writeSCIMFailure(w, err)
+		return
+	}
+
+	m.SCIMDeprovisioned = false
+
+	if u.Active == nil {
+		active := true
+		u.Active = &active
+	}
+
+	logAudit(r, scimAuditEvent(r, AuditMemberProvisioned, "member", m.ID), nil, bson.M{"email": m.Email})
+
+	if err := replaceMember(r, orgID, m, u); err != nil {
+		writeSCIMFailure(w, err)
+		return
+	}
+
+	created := memberToSCIM(m, scimBaseURL(r, orgID))
+	w.Header().Set("Location", created.Meta.Location)
+	writeSCIM(w, http.StatusCreated, created)
+}
+
+// SCIMReplaceUser replaces a member's attributes with the SCIM User sent.
+func (oh *OrganizationHandler) SCIMReplaceUser(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+
+	m, err := fetchSCIMMember(r.Context(), vars["id"], vars["user_id"])
+	if err != nil {
+		writeSCIMFailure(w, err)
+		return
+	}
+
+	u, err := readSCIMUser(r)
+	if err != nil {
+		writeSCIMFailure(w, err)
+		return
+	}
+
+	if err := replaceMember(r, vars["id"], m, u); err != nil {
+		writeSCIMFailure(w, err)
+		return
+	}
+
+	writeSCIM(w, http.StatusOK, memberToSCIM(m, scimBaseURL(r, vars["id"])))
+}
+
+// SCIMPatchUser applies PATCH operations to a member's SCIM User.
+func (oh *OrganizationHandler) SCIMPatchUser(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	base := scimBaseURL(r, vars["id"])
+
+	m, err := fetchSCIMMember(r.Context(), vars["id"], vars["user_id"])
+	if err != nil {
+		writeSCIMFailure(w, err)
+		return
+	}
+
+	var body scimPatchRequest
+	if err := utils.ParseJSONFromRequest(r, &body); err != nil {
+		writeSCIMError(w, http.StatusBadRequest, scimInvalidSyntax, err.Error())
+		return
+	}
+
+	raw, err := json.Marshal(memberToSCIM(m, base))
+	if err != nil {
+		writeSCIMFailure(w, err)
+		return
+	}
+
+	var doc map[string]interface{}
+	if err := json.Unmarshal(raw, &doc); err != nil {
+		writeSCIMFailure(w, err)
+		return
+	}
+
+	if err := applySCIMPatch(doc, body.Operations); err != nil {
+		writeSCIMFailure(w, patchFailure(err))
+		return
+	}
+
+	u, err := decodeSCIMUser(doc)
+	if err != nil {
+		writeSCIMFailure(w, err)
+		return
+	}
+
+	if err := replaceMember(r, vars["id"], m, u); err != nil {
+		writeSCIMFailure(w, err)
+		return
+	}
+
+	writeSCIM(w, http.StatusOK, memberToSCIM(m, base))
+}
+
+// SCIMDeleteUser deactivates a member and removes the User from SCIM, later
+// requests for it get a 404 as RFC 7644 section 3.6 asks. The membership
+// itself is kept, deactivated, so an admin can still see and restore it.
+func (oh *OrganizationHandler) SCIMDeleteUser(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+
+	m, err := fetchSCIMMember(r.Context(), vars["id"], vars["user_id"])
+	if err != nil {
+		writeSCIMFailure(w, err)
+		return
+	}
+
+	if m.Role == OwnerRole {
+		writeSCIMError(w, http.StatusBadRequest, scimMutability, "the organization owner cannot be deactivated")
+		return
+	}
+
+	if !m.Deleted {
+		if err := setMemberActive(r, vars["id"], m, false); err != nil {
+			writeSCIMFailure(w, err)
+			return
+		}
+	}
+
+	if _, err := utils.UpdateOneMongoDBDoc(MemberCollectionName, m.ID, bson.M{"scim_deprovisioned": true}); err != nil {
+		writeSCIMFailure(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// patchFailure turns an error applying PATCH operations into the SCIM
+// failure to answer with.
+func patchFailure(err error) error {
+	switch {
+	case errors.Is(err, errSCIMPath):
+		return scimFail(http.StatusBadRequest, scimInvalidPath, "%s", err.Error())
+	case errors.Is(err, errSCIMNoTarget):
+		return scimFail(http.StatusBadRequest, scimNoTarget, "%s", err.Error())
+	case errors.Is(err, errSCIMValue):
+		return scimFail(http.StatusBadRequest, scimInvalidValue, "%s", err.Error())
+	}
+
+	return err
+}
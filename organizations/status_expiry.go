@@ -0,0 +1,148 @@
+package organizations
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"zuri.chat/zccore/utils"
+)
+
+var (
+	errStatusExpiry = errors.New("invalid selection of expiry time")
+	errStatusPast   = errors.New("status expiry time must be in the future")
+
+	statusExpiryIndexOnce sync.Once
+)
+
+// memberLocation returns the location of a member's stored time zone, or UTC
+// when it is unset or unknown, so a day never ends where the server runs.
+func memberLocation(tz string) *time.Location {
+	if tz == "" {
+		return time.UTC
+	}
+
+	loc, err := time.LoadLocation(tz)
+	if err != nil {
+		return time.UTC
+	}
+
+	return loc
+}
+
+// statusExpiresAt returns when a status set at now with the given expiry
+// choice should be cleared, or nil for one that stays until changed. Today
+// and ThisWeek end at midnight in now's location. Anything other than the
+// named choices must be an RFC 3339 time.
+func statusExpiresAt(choice string, now time.Time) (*time.Time, error) {
+	year, month, day := now.Date()
+
+	var at time.Time
+
+	switch choice {
+	case DontClear:
+		return nil, nil
+	case ThirtyMins:
+		at = now.Add(30 * time.Minute)
+	case OneHr:
+		at = now.Add(time.Hour)
+	case FourHrs:
+		at = now.Add(4 * time.Hour)
+	case Today:
+		at = time.Date(year, month, day+1, 0, 0, 0, 0, now.Location())
+	case ThisWeek:
+		// weeks end on Saturday night
+		at = time.Date(year, month, day+7-int(now.Weekday()), 0, 0, 0, 0, now.Location())
+	default:
+		chosen, err := time.Parse(time.RFC3339, choice)
+		if err != nil {
+			return nil, errStatusExpiry
+		}
+
+		if !chosen.After(now) {
+			return nil, errStatusPast
+		}
+
+		at = chosen
+	}
+
+	at = at.UTC()
+
+	return &at, nil
+}
+
+func ensureStatusExpiryIndex(coll *mongo.Collection) {
+	statusExpiryIndexOnce.Do(func() {
+		indexModel := mongo.IndexModel{Keys: bson.M{"status.expires_at": 1}, Options: options.Index().SetSparse(true)}
+
+		if _, err := coll.Indexes().CreateOne(context.Background(), indexModel); err != nil {
+			log.Printf("status expiry: could not create index: %v", err)
+		}
+	})
+}
+
+// clearExpiredStatus clears one status whose expiry has passed, keeping its
+// history, and returns the member it belonged to. Finding and clearing is one
+// update, so when several replicas run the scheduler each status is cleared,
+// and announced, once.
+func clearExpiredStatus(ctx context.Context, now time.Time) (*Member, error) {
+	filter := bson.M{"status.expires_at": bson.M{"$lte": now}}
+	update := bson.M{
+		"$set":   bson.M{"status.tag": "", "status.text": "", "status.expiry_time": ""},
+		"$unset": bson.M{"status.expires_at": ""},
+	}
+	opts := options.FindOneAndUpdate().SetProjection(bson.M{"_id": 1, "org_id": 1})
+
+	var member Member
+	if err := utils.GetCollection(MemberCollectionName).FindOneAndUpdate(ctx, filter, update, opts).Decode(&member); err != nil {
+		return nil, err
+	}
+
+	return &member, nil
+}
+
+// ClearExpiredStatuses clears every status whose expiry has passed and tells
+// subscribers about each.
+func ClearExpiredStatuses(ctx context.Context) {
+	ensureStatusExpiryIndex(utils.GetCollection(MemberCollectionName))
+
+	now := time.Now()
+
+	for {
+		member, err := clearExpiredStatus(ctx, now)
+		if err != nil {
+			if !errors.Is(err, mongo.ErrNoDocuments) {
+				log.Printf("status expiry: %v", err)
+			}
+
+			return
+		}
+
+		event := utils.Event{Identifier: member.ID, Type: "User", Event: UpdateOrganizationMemberStatusCleared, Channel: fmt.Sprintf("organizations_%s", member.OrgID), Payload: make(map[string]interface{})}
+		go utils.Emitter(event)
+	}
+}
+
+// RunStatusExpiryScheduler calls ClearExpiredStatuses every interval until ctx
+// is done.
+func RunStatusExpiryScheduler(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		ClearExpiredStatuses(ctx)
+	}
+}
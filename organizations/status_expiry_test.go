@@ -0,0 +1,70 @@
+package organizations
+
+import (
+	"testing"
+	"time"
+)
+
+func TestStatusExpiresAt(t *testing.T) {
+	// a Wednesday afternoon
+	now := time.Date(2021, time.September, 15, 14, 20, 0, 0, time.UTC)
+
+	tests := []struct {
+		choice string
+		want   time.Time
+	}{
+		{ThirtyMins, now.Add(30 * time.Minute)},
+		{OneHr, now.Add(time.Hour)},
+		{FourHrs, now.Add(4 * time.Hour)},
+		{Today, time.Date(2021, time.September, 16, 0, 0, 0, 0, time.UTC)},
+		{ThisWeek, time.Date(2021, time.September, 19, 0, 0, 0, 0, time.UTC)},
+		{"2021-09-20T08:00:00+01:00", time.Date(2021, time.September, 20, 7, 0, 0, 0, time.UTC)},
+	}
+
+	for _, tt := range tests {
+		got, err := statusExpiresAt(tt.choice, now)
+		if err != nil || got == nil || !got.Equal(tt.want) {
+			t.Errorf("statusExpiresAt(%q) = %v, %v, want %v", tt.choice, got, err, tt.want)
+		}
+	}
+
+	if got, err := statusExpiresAt(DontClear, now); got != nil || err != nil {
+		t.Errorf("statusExpiresAt(%q) = %v, %v, want no expiry", DontClear, got, err)
+	}
+
+	if _, err := statusExpiresAt("next tuesday", now); err != errStatusExpiry {
+		t.Errorf("statusExpiresAt() of an unknown choice = %v, want %v", err, errStatusExpiry)
+	}
+
+	if _, err := statusExpiresAt("2021-09-15T14:00:00Z", now); err != errStatusPast {
+		t.Errorf("statusExpiresAt() of a past time = %v, want %v", err, errStatusPast)
+	}
+
+	// late on Saturday in Lagos, already Sunday in Tokyo
+	lagos := time.Date(2021, time.September, 18, 22, 30, 0, 0, time.UTC).In(memberLocation("Africa/Lagos"))
+	tokyo := lagos.In(memberLocation("Asia/Tokyo"))
+
+	for _, tt := range []struct {
+		now    time.Time
+		choice string
+		want   time.Time
+	}{
+		{lagos, Today, time.Date(2021, time.September, 18, 23, 0, 0, 0, time.UTC)},
+		{lagos, ThisWeek, time.Date(2021, time.September, 18, 23, 0, 0, 0, time.UTC)},
+		{tokyo, Today, time.Date(2021, time.September, 19, 15, 0, 0, 0, time.UTC)},
+		{tokyo, ThisWeek, time.Date(2021, time.September, 25, 15, 0, 0, 0, time.UTC)},
+	} {
+		got, err := statusExpiresAt(tt.choice, tt.now)
+		if err != nil || got == nil || !got.Equal(tt.want) {
+			t.Errorf("statusExpiresAt(%q) at %v = %v, %v, want %v", tt.choice, tt.now, got, err, tt.want)
+		}
+	}
+}
+
+func TestMemberLocation(t *testing.T) {
+	for tz, want := range map[string]string{"": "UTC", "Mars/Olympus": "UTC", "Africa/Lagos": "Africa/Lagos"} {
+		if got := memberLocation(tz).String(); got != want {
+			t.Errorf("memberLocation(%q) = %s, want %s", tz, got, want)
+		}
+	}
+}
@@ -491,54 +491,6 @@ func (oh *OrganizationHandler) UpdateMemberStatus(w http.ResponseWriter, r *http
 		return
 	}
 
-	// check the value in expiry field
-	var choosenTime time.Time
-	if _, ok := StatusExpiryTime[status.ExpiryTime]; !ok {
-		choosenTime, err = time.Parse(time.RFC3339, status.ExpiryTime)
-
-		if err != nil {
-			utils.GetError(errors.New("invalid selection of expiry time"), http.StatusBadRequest, w)
-			return
-		}
-	}
-
-	currentTime := time.Now().Local()
-
-	var period int
-
-	switch set := status.ExpiryTime; set {
-	case DontClear:
-		period = 1
-
-	case ThirtyMins:
-		period = 30
-
-	case OneHr:
-		period = 60
-
-	case FourHrs:
-		period = 240
-
-	case Today:
-		minutesPerHr := 60
-		hrsPerDay := 24
-		period = minutesPerHr * (hrsPerDay - currentTime.Hour())
-
-	case ThisWeek:
-		minutesPerHr := 60
-		hrsPerDay := 24
-		daysPerWeek := 7
-
-		day := int(time.Now().Weekday())
-		weekday := daysPerWeek - day
-
-		period = weekday * hrsPerDay * minutesPerHr
-
-	default:
-		diff := choosenTime.Local().Sub(currentTime)
-		period = int(diff.Minutes())
-	}
-
 	pmemberID, err := primitive.ObjectIDFromHex(memberID)
 	if err != nil {
 		utils.GetError(errors.New("invalid id"), http.StatusBadRequest, w)
@@ -551,6 +503,15 @@ func (oh *OrganizationHandler) UpdateMemberStatus(w http.ResponseWriter, r *http
 		return
 	}
 
+	// the status is cleared by the expiry scheduler once expires_at passes
+	tz, _ := memberRec["time_zone"].(string)
+
+	status.ExpiresAt, err = statusExpiresAt(status.ExpiryTime, time.Now().In(memberLocation(tz)))
+	if err != nil {
+		utils.GetError(err, http.StatusBadRequest, w)
+		return
+	}
+
 	var prevStatus Status
 
 	// convert bson to struct
@@ -585,17 +546,8 @@ func (oh *OrganizationHandler) UpdateMemberStatus(w http.ResponseWriter, r *http
 
 	status.StatusHistory = prevStatus.StatusHistory
 
-	statusUpdate, err := utils.StructToMap(status)
-	if err != nil {
-		utils.GetError(err, http.StatusUnprocessableEntity, w)
-		return
-	}
-
-	memberStatus := make(map[string]interface{})
-	memberStatus["status"] = statusUpdate
-
-	// updates member status
-	result, err := utils.UpdateOneMongoDBDoc(MemberCollectionName, memberID, memberStatus)
+	// updates member status, stored as bson so expires_at stays a date
+	result, err := utils.UpdateOneMongoDBDoc(MemberCollectionName, memberID, bson.M{"status": status})
 	if err != nil {
 		utils.GetError(err, http.StatusUnprocessableEntity, w)
 		return
@@ -606,12 +558,6 @@ func (oh *OrganizationHandler) UpdateMemberStatus(w http.ResponseWriter, r *http
 		return
 	}
 
-	// pass period to chan so it can be received by the routine
-	ExpiryTime <- int64(period)
-	ClearOld <- true
-
-	go ClearStatusRoutine(orgID, memberID, ExpiryTime, ClearOld)
-
 	// publish update to subscriber
 	eventChannel := fmt.Sprintf("organizations_%s", orgID)
 	event := utils.Event{Identifier: memberID, Type: "User", Event: UpdateOrganizationMemberStatus, Channel: eventChannel, Payload: make(map[string]interface{})}
@@ -678,17 +624,8 @@ func (oh *OrganizationHandler) RemoveStatusHistory(w http.ResponseWriter, r *htt
 
 	status.StatusHistory = RemoveHistoryAtIndex(status.StatusHistory, historyID)
 
-	statusUpdate, err := utils.StructToMap(status)
-	if err != nil {
-		utils.GetError(err, http.StatusUnprocessableEntity, w)
-		return
-	}
-
-	memberStatus := make(map[string]interface{})
-	memberStatus["status"] = statusUpdate
-
-	// updates member status
-	result, err := utils.UpdateOneMongoDBDoc(MemberCollectionName, memberID, memberStatus)
+	// updates member status history, leaving the current status and its expiry
+	result, err := utils.UpdateOneMongoDBDoc(MemberCollectionName, memberID, bson.M{"status.status_history": status.StatusHistory})
 	if err != nil {
 		utils.GetError(err, http.StatusUnprocessableEntity, w)
 		return
@@ -728,6 +665,7 @@ func (oh *OrganizationHandler) DeactivateMember(w http.ResponseWriter, r *http.R
 
 	if err != nil {
 		utils.GetError(fmt.Errorf("an error occurred: %s", err), http.StatusInternalServerError, w)
+		return
 	}
 
 	if res.ModifiedCount != 1 {
@@ -741,6 +679,9 @@ func (oh *OrganizationHandler) DeactivateMember(w http.ResponseWriter, r *http.R
 
 	go utils.Emitter(event)
 
+	logAudit(r, AuditEvent{Action: AuditMemberDeactivated, TargetType: "member", TargetID: memberID},
+		bson.M{"deleted": false}, bson.M{"deleted": true})
+
 	utils.GetSuccess("successfully deactivated member", nil, w)
 
 	enterOrgMessage := EnterLeaveMessage{
@@ -1019,15 +960,14 @@ func (oh *OrganizationHandler) CheckGuestStatus(w http.ResponseWriter, r *http.R
 	}
 
 	// 1. Query organization invites collection for uuid
-	res, err := utils.GetMongoDBDoc(OrganizationInviteCollectionName, bson.M{"uuid": guestUUID})
+	invite, err := fetchUsableInvite(r.Context(), guestUUID)
 	if err != nil {
 		utils.GetError(err, http.StatusBadRequest, w)
 		return
 	}
 
 	// 2. Check if email already is registered in zurichat (return 403 user already exist)
-	guestEmail := res["email"]
-	_, err = utils.GetMongoDBDoc(UserCollectionName, bson.M{"email": guestEmail})
+	_, err = utils.GetMongoDBDoc(UserCollectionName, bson.M{"email": invite.Email})
 
 	if err != nil {
 		utils.GetError(
@@ -1055,18 +995,14 @@ func (oh *OrganizationHandler) GuestToOrganization(w http.ResponseWriter, r *htt
 		return
 	}
 
-	res, err := utils.GetMongoDBDoc(OrganizationInviteCollectionName, bson.M{"uuid": gUUID})
+	invite, err := fetchUsableInvite(r.Context(), gUUID)
 	if err != nil {
 		utils.GetError(err, http.StatusBadRequest, w)
 		return
 	}
 
 	// // TODO 0: Check that organization exists
-	orgID, ok := res["org_id"].(string)
-	if !ok {
-		utils.GetError(errors.New("invalid email address"), http.StatusBadRequest, w)
-		return
-	}
+	orgID := invite.OrgID
 
 	var orgDoc bson.M
 
@@ -1092,11 +1028,7 @@ func (oh *OrganizationHandler) GuestToOrganization(w http.ResponseWriter, r *htt
 		}
 	}
 
-	email, ok := res["email"].(string)
-	if !ok {
-		utils.GetError(errors.New("invalid email address"), http.StatusBadRequest, w)
-		return
-	}
+	email := invite.Email
 
 	// TODO 2: Verify guest email
 	if !utils.IsValidEmail(email) {
@@ -1122,11 +1054,16 @@ func (oh *OrganizationHandler) GuestToOrganization(w http.ResponseWriter, r *htt
 	setting := new(Settings)
 	username := strings.Split(user.Email, "@")[0]
 
+	role := invite.Role
+	if role == "" {
+		role = MemberRole
+	}
+
 	memberStruct := Member{
 		Email:    user.Email,
 		UserName: username,
 		OrgID:    orgID,
-		Role:     "member",
+		Role:     role,
 		Presence: "true",
 		JoinedAt: time.Now(),
 		Settings: setting,
@@ -1167,9 +1104,11 @@ func (oh *OrganizationHandler) GuestToOrganization(w http.ResponseWriter, r *htt
 		return
 	}
 	// update invite status
-	inviteID := res["_id"].(primitive.ObjectID).Hex()
-
-	_, err = utils.UpdateOneMongoDBDoc(OrganizationInviteCollectionName, inviteID, bson.M{"has_accepted": true})
+	_, err = utils.UpdateOneMongoDBDoc(OrganizationInviteCollectionName, invite.ID, bson.M{
+		"has_accepted": true,
+		"status":       InviteAccepted,
+		"accepted_at":  time.Now(),
+	})
 	if err != nil {
 		utils.GetError(errors.New("invite update failed"), http.StatusInternalServerError, w)
 		return
@@ -1205,11 +1144,17 @@ func (oh *OrganizationHandler) UpdateMemberRole(w http.ResponseWriter, r *http.R
 
 	role := strings.ToLower(RequestData["role"])
 
-	if _, ok := Roles[role]; !ok {
+	newRole, err := auth.FetchRole(orgID, role)
+	if err != nil {
 		utils.GetError(errors.New("role is not valid"), http.StatusBadRequest, w)
 		return
 	}
 
+	if role == OwnerRole {
+		utils.GetError(errors.New("use change-owner to transfer ownership"), http.StatusBadRequest, w)
+		return
+	}
+
 	memID, _ := primitive.ObjectIDFromHex(memberID)
 
 	orgMember, err := FetchMember(bson.M{"org_id": orgID, "_id": memID})
@@ -1219,6 +1164,29 @@ func (oh *OrganizationHandler) UpdateMemberRole(w http.ResponseWriter, r *http.R
 		return
 	}
 
+	if orgMember.Role == OwnerRole {
+		utils.GetError(errors.New("the owner's role can only change through change-owner"), http.StatusForbidden, w)
+		return
+	}
+
+	caller, err := callerRole(r, orgID)
+	if err != nil {
+		utils.GetError(err, http.StatusForbidden, w)
+		return
+	}
+
+	// taking the current role away needs as much reach as giving the new one,
+	// or a custom role with roles.manage could demote those above it
+	currentPerms := []string{}
+	if currentRole, err := auth.FetchRole(orgID, orgMember.Role); err == nil {
+		currentPerms = currentRole.Permissions
+	}
+
+	if err := checkGrantable(caller, append(currentPerms, newRole.Permissions...)); err != nil {
+		utils.GetError(err, http.StatusForbidden, w)
+		return
+	}
+
 	if orgMember.Role == role {
 		errorMessage := fmt.Sprintf("member role is already %s", role)
 		utils.GetError(errors.New(errorMessage), http.StatusBadRequest, w)
@@ -1247,6 +1215,9 @@ func (oh *OrganizationHandler) UpdateMemberRole(w http.ResponseWriter, r *http.R
 
 	go utils.Emitter(event)
 
+	logAudit(r, AuditEvent{Action: AuditMemberRoleChanged, TargetType: "member", TargetID: memberID},
+		bson.M{"role": orgMember.Role}, bson.M{"role": role})
+
 	utils.GetSuccess("member role updated successfully", nil, w)
 }
 
@@ -1294,3 +1265,32 @@ func (oh *OrganizationHandler) UpdateLanguagesAndRegions(w http.ResponseWriter,
 
 	updateMemberSettings(w, r, payload)
 }
+
+// ForceLogoutMember signs a member out of the organization. Their current
+// sessions and access tokens are refused here until they sign in again, and
+// keep working in their other organizations.
+func (oh *OrganizationHandler) ForceLogoutMember(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	vars := mux.Vars(r)
+	orgID, memberID := vars["id"], vars["mem_id"]
+
+	memID, err := primitive.ObjectIDFromHex(memberID)
+	if err != nil {
+		utils.GetError(errors.New("invalid Member id"), http.StatusBadRequest, w)
+		return
+	}
+
+	memberDoc, _ := utils.GetMongoDBDoc(MemberCollectionName, bson.M{"_id": memID, "org_id": orgID})
+	if memberDoc == nil {
+		utils.GetError(errors.New("member does not exist"), http.StatusNotFound, w)
+		return
+	}
+
+	if _, err := utils.UpdateOneMongoDBDoc(MemberCollectionName, memberID, bson.M{"sessions_revoked_at": time.Now()}); err != nil {
+		utils.GetError(err, http.StatusInternalServerError, w)
+		return
+	}
+
+	utils.GetSuccess("member signed out of the organization", nil, w)
+}
@@ -5,6 +5,7 @@ import (
 	"errors"
 	"fmt"
 	"log"
+	"net"
 	"net/http"
 	"os"
 	"strings"
@@ -13,17 +14,17 @@ import (
 
 	"github.com/go-playground/validator/v10"
 	"github.com/gorilla/mux"
-	"github.com/mitchellh/mapstructure"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 
 	"zuri.chat/zccore/auth"
+	"zuri.chat/zccore/oidc"
 	"zuri.chat/zccore/service"
 	"zuri.chat/zccore/utils"
 )
 
 func NewOrganizationHandler(c *utils.Configurations, mail service.MailService) *OrganizationHandler {
-	return &OrganizationHandler{configs: c, mailService: mail}
+	return &OrganizationHandler{configs: c, mailService: mail, resolver: net.DefaultResolver}
 }
 
 // gets the details of a member in a workspace using parameters such as email, username etc
@@ -36,11 +37,7 @@ func FetchMember(filter map[string]interface{}) (*Member, error) {
 		return nil, err
 	}
 
-	result := memberCollection.FindOne(context.TODO(), filter)
-
-	err = mapstructure.Decode(result, &member)
-
-	if err != nil {
+	if err := memberCollection.FindOne(context.TODO(), filter).Decode(member); err != nil {
 		return nil, err
 	}
 
@@ -76,6 +73,99 @@ func ValidateOrg(orgID string) error {
 	return nil
 }
 
+// orgIDFilter builds the _id filter for an organization id, which can either be a suid or an object id.
+func orgIDFilter(orgID string) (bson.M, error) {
+	if strings.Contains(orgID, "-org") {
+		return bson.M{"_id": orgID}, nil
+	}
+
+	objID, err := primitive.ObjectIDFromHex(orgID)
+	if err != nil {
+		return nil, errors.New("invalid organization id")
+	}
+
+	return bson.M{"_id": objID}, nil
+}
+
+// FetchOrganizationByID fetches an organization by its id.
+func FetchOrganizationByID(orgID string) (*Organization, error) {
+	filter, err := orgIDFilter(orgID)
+	if err != nil {
+		return nil, err
+	}
+
+	orgDoc, _ := utils.GetMongoDBDoc(OrganizationCollectionName, filter)
+	if orgDoc == nil {
+		return nil, errors.New("organization does not exist")
+	}
+
+	var org Organization
+	if err := utils.BsonToStruct(orgDoc, &org); err != nil {
+		return nil, err
+	}
+
+	return &org, nil
+}
+
+// oidcProviderConfigured reports whether name is one of the deployment's OIDC providers.
+func oidcProviderConfigured(raw, name string) bool {
+	configs, err := oidc.ParseConfigs(raw)
+	if err != nil {
+		return false
+	}
+
+	for _, c := range configs {
+		if strings.EqualFold(c.Name, name) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// settingEnabled reports whether a toggle setting, stored as {"enabled": true}, is on.
+func settingEnabled(setting map[string]interface{}) bool {
+	enabled, _ := setting["enabled"].(bool)
+	return enabled
+}
+
+// forcePasswordReset makes every member of orgID reset their password before
+// their next login and signs them out everywhere.
+func forcePasswordReset(orgID string) error {
+	ctx := context.Background()
+
+	emails, err := utils.GetCollection(MemberCollectionName).Distinct(ctx, "email", bson.M{"org_id": orgID, "deleted": bson.M{"$ne": true}})
+	if err != nil {
+		return err
+	}
+
+	if len(emails) == 0 {
+		return nil
+	}
+
+	filter := bson.M{"email": bson.M{"$in": emails}}
+
+	update := bson.M{"$set": bson.M{"must_reset_password": true}}
+	if _, err := utils.GetCollection(UserCollectionName).UpdateMany(ctx, filter, update); err != nil {
+		return err
+	}
+
+	ids, err := utils.GetCollection(UserCollectionName).Distinct(ctx, "_id", filter)
+	if err != nil {
+		return err
+	}
+
+	for _, id := range ids {
+		if oid, ok := id.(primitive.ObjectID); ok {
+			if err := auth.RevokeUserSessions(oid.Hex()); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
 // check that a member belongs in the an organization.
 func ValidateMember(orgID, memberID string) error {
 	// check that org_id is valid
@@ -108,91 +198,29 @@ func NewMember(email, userName, orgID, role string) Member {
 	}
 }
 
-// clear a member's status after a duration.
-func ClearStatusRoutine(orgID, memberID string, ch chan int64, clearOld chan bool) {
-	// get period from channel
-	period := <-ch
-
-	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(period)*time.Minute)
-	defer cancel()
-
-	d := time.Duration(period) * time.Minute
-	t := time.NewTimer(d)
-
-	go func() {
-		for {
-			select {
-			case <-clearOld:
-				// force timer to stop when new time is available
-				// this occures everytime a new time is set so that old times
-				// running can be interrupted
-				if !t.Stop() {
-					<-t.C
-				}
-
-				// restart timer because a condition occurred
-				newD := time.Duration(period) * time.Minute
-				t.Reset(newD)
-
-			case <-t.C:
-				// clear status when the timer completes!
-				ClearStatus(memberID, period)
-
-			case <-ctx.Done():
-				return
-			}
-		}
-	}()
-	<-ctx.Done()
-
-	// publish update to subscriber
-	eventChannel := fmt.Sprintf("organizations_%s", orgID)
-	event := utils.Event{Identifier: memberID, Type: "User", Event: UpdateOrganizationMemberStatusCleared, Channel: eventChannel, Payload: make(map[string]interface{})}
-
-	go utils.Emitter(event)
-}
-
-func ClearStatus(memberID string, duration int64) {
-	// duration 1 represents dont_clear time
-	if duration == 1 {
-		return
-	}
-
-	pmemberID, _ := primitive.ObjectIDFromHex(memberID)
-
-	memberRec, err := utils.GetMongoDBDoc(MemberCollectionName, bson.M{"_id": pmemberID})
+// addMember inserts member, adds the organization to the user's workspaces
+// and tells subscribers and installed plugins about the new member.
+func addMember(ctx context.Context, member Member) (primitive.ObjectID, error) {
+	res, err := utils.GetCollection(MemberCollectionName).InsertOne(ctx, member)
 	if err != nil {
-		log.Println("error while trying to get member")
-		return
+		return primitive.NilObjectID, err
 	}
 
-	var prevStatus Status
-
-	// convert bson to struct
-	bsonBytes, _ := bson.Marshal(memberRec["status"])
+	memberID, _ := res.InsertedID.(primitive.ObjectID)
 
-	if err = bson.Unmarshal(bsonBytes, &prevStatus); err != nil {
-		log.Println(err)
-		return
+	update := bson.M{"$addToSet": bson.M{"workspaces": member.OrgID}}
+	if _, err := utils.GetCollection(UserCollectionName).UpdateOne(ctx, bson.M{"email": member.Email}, update); err != nil {
+		return memberID, err
 	}
 
-	update, _ := utils.StructToMap(Status{StatusHistory: prevStatus.StatusHistory})
-
-	memberStatus := make(map[string]interface{})
-	memberStatus["status"] = update
-
-	result, err := utils.UpdateOneMongoDBDoc(MemberCollectionName, memberID, memberStatus)
-	if err != nil {
-		log.Println(err)
-		return
-	}
+	event := utils.Event{Identifier: memberID, Type: "User", Event: CreateOrganizationMember, Channel: fmt.Sprintf("organizations_%s", member.OrgID), Payload: make(map[string]interface{})}
+	go utils.Emitter(event)
 
-	if result.ModifiedCount == 0 {
-		log.Println(err)
-		return
+	if err := AddSyncMessage(member.OrgID, "enter_organization", EnterLeaveMessage{OrganizationID: member.OrgID, MemberID: memberID.Hex()}); err != nil {
+		log.Printf("sync error: %v", err)
 	}
 
-	log.Printf("%s status cleared successfully. Duration: %d", memberID, duration)
+	return memberID, nil
 }
 
 func FetchOrganization(filter map[string]interface{}) (*Organization, error) {
@@ -231,6 +259,14 @@ func OrganizationUpdate(w http.ResponseWriter, r *http.Request, updateParam upda
 		return
 	}
 
+	before := bson.M{}
+
+	if filter, err := orgIDFilter(orgID); err == nil {
+		if doc, _ := utils.GetMongoDBDoc(OrganizationCollectionName, filter); doc != nil {
+			before[updateParam.orgFilterKey] = doc[updateParam.orgFilterKey]
+		}
+	}
+
 	orgFilter := make(map[string]interface{})
 	orgFilter[updateParam.orgFilterKey] = RequestData[updateParam.requestDataKey]
 	update, err := utils.UpdateOneMongoDBDoc(OrganizationCollectionName, orgID, orgFilter)
@@ -250,6 +286,8 @@ func OrganizationUpdate(w http.ResponseWriter, r *http.Request, updateParam upda
 
 	go utils.Emitter(event)
 
+	logAudit(r, AuditEvent{Action: AuditOrganizationUpdated, TargetType: "organization", TargetID: orgID}, before, orgFilter)
+
 	utils.GetSuccess(fmt.Sprintf("%s updated successfully", updateParam.successMessage), nil, w)
 }
 
@@ -0,0 +1,415 @@
+package plugin
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/gorilla/mux"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"zuri.chat/zccore/utils"
+)
+
+const (
+	MaintainerInviteCollectionName = "plugin_maintainer_invites"
+
+	InvitePending  = "pending"
+	InviteAccepted = "accepted"
+	InviteRevoked  = "revoked"
+)
+
+const (
+	healthCheckTimeout = 3 * time.Second
+	// a plugin that leaves this many sync messages unacknowledged is not keeping up.
+	maxHealthyQueueLength = 50
+)
+
+var errBlockedAddress = errors.New("plugin url does not resolve to a public address")
+
+// blockedNetworks are the ranges health checks never connect to: private,
+// loopback and link local ones, where cloud metadata endpoints live, and
+// other addresses that aren't on the public internet.
+var blockedNetworks = func() []*net.IPNet {
+	var nets []*net.IPNet
+
+	for _, cidr := range []string{
+		"0.0.0.0/8", "10.0.0.0/8", "100.64.0.0/10", "127.0.0.0/8", "169.254.0.0/16", "172.16.0.0/12",
+		"192.0.0.0/24", "192.168.0.0/16", "198.18.0.0/15", "224.0.0.0/4", "240.0.0.0/4",
+		"::/128", "::1/128", "fc00::/7", "fe80::/10", "ff00::/8",
+	} {
+		_, n, _ := net.ParseCIDR(cidr)
+		nets = append(nets, n)
+	}
+
+	return nets
+}()
+
+func publicAddress(ip net.IP) bool {
+	if ip == nil {
+		return false
+	}
+
+	if v4 := ip.To4(); v4 != nil {
+		ip = v4
+	}
+
+	for _, n := range blockedNetworks {
+		if n.Contains(ip) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// healthClient requests plugin urls for health checks. It only connects to public
+// addresses, checked on the address being dialled so the DNS answer can't change in
+// between, and never follows redirects.
+var healthClient = &http.Client{
+	Transport: &http.Transport{
+		DialContext: (&net.Dialer{
+			Timeout: healthCheckTimeout,
+			Control: func(network, address string, _ syscall.RawConn) error {
+				host, _, err := net.SplitHostPort(address)
+				if err != nil {
+					return err
+				}
+
+				if !publicAddress(net.ParseIP(host)) {
+					return errBlockedAddress
+				}
+
+				return nil
+			},
+		}).DialContext,
+	},
+	CheckRedirect: func(*http.Request, []*http.Request) error {
+		return http.ErrUseLastResponse
+	},
+}
+
+type MaintainerInvite struct {
+	ID        primitive.ObjectID `json:"id" bson:"_id,omitempty"`
+	PluginID  string             `json:"plugin_id" bson:"plugin_id"`
+	Email     string             `json:"email" bson:"email"`
+	InvitedBy string             `json:"invited_by" bson:"invited_by"`
+	Status    string             `json:"status" bson:"status"`
+	CreatedAt time.Time          `json:"created_at" bson:"created_at"`
+	UpdatedAt time.Time          `json:"updated_at" bson:"updated_at"`
+}
+
+type PluginHealth struct {
+	Status       string `json:"status"`
+	Reachable    bool   `json:"reachable"`
+	ResponseTime int64  `json:"response_time_ms"`
+	QueueLength  int    `json:"queue_length"`
+}
+
+type DeveloperPlugin struct {
+	*Plugin
+	Role         string       `json:"role"`
+	InstallCount int64        `json:"install_count"`
+	Health       PluginHealth `json:"health"`
+}
+
+// isZuriAdmin reports whether the user with the given email is a zuri admin.
+// It is a variable so tests don't need a database.
+var isZuriAdmin = func(ctx context.Context, email string) bool {
+	doc, _ := utils.GetMongoDBDoc("users", bson.M{"email": strings.ToLower(email), "role": "admin"})
+	return doc != nil
+}
+
+// Owner returns the email of the plugin's owner, recorded from the account that registered
+// it. The developer email is whatever the registrant typed, so it never grants ownership and
+// plugins registered before developer accounts existed are left to zuri admins.
+func (p *Plugin) Owner() string {
+	return p.OwnerEmail
+}
+
+// IsOwner reports whether email belongs to the plugin's owner.
+func (p *Plugin) IsOwner(email string) bool {
+	return email != "" && strings.EqualFold(p.Owner(), email)
+}
+
+// IsMaintainer reports whether email belongs to the plugin's owner or one of its maintainers.
+func (p *Plugin) IsMaintainer(email string) bool {
+	if p.IsOwner(email) {
+		return true
+	}
+
+	for _, m := range p.Maintainers {
+		if strings.EqualFold(m, email) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// CanManage reports whether the user may modify the plugin: its owner, a maintainer or a zuri admin.
+func CanManage(ctx context.Context, p *Plugin, email string) bool {
+	return p.IsMaintainer(email) || isZuriAdmin(ctx, email)
+}
+
+// contextUser is implemented by auth.AuthUser, plugin cannot import auth without an import cycle.
+type contextUser interface {
+	GetEmail() string
+}
+
+func loggedInEmail(r *http.Request) string {
+	u, ok := r.Context().Value("user").(contextUser)
+	if !ok || u == nil {
+		return ""
+	}
+
+	return strings.ToLower(u.GetEmail())
+}
+
+func findPlugin(id string) (*Plugin, error) {
+	objID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return nil, Errorf(EINVALID, "invalid plugin id")
+	}
+
+	doc, _ := utils.GetMongoDBDoc(PluginCollectionName, bson.M{"_id": objID})
+	if doc == nil {
+		return nil, Errorf(ENOENT, "plugin with id %s not found", id)
+	}
+
+	var p Plugin
+	if err := utils.BsonToStruct(doc, &p); err != nil {
+		return nil, err
+	}
+
+	return &p, nil
+}
+
+// InviteMaintainer invites a developer to co-maintain a plugin. Only the owner or a zuri admin can invite.
+func InviteMaintainer(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	pluginID := mux.Vars(r)["id"]
+	email := loggedInEmail(r)
+
+	body := struct {
+		Email string `json:"email"`
+	}{}
+
+	if err := utils.ParseJSONFromRequest(r, &body); err != nil {
+		utils.GetError(err, http.StatusUnprocessableEntity, w)
+		return
+	}
+
+	invitee := strings.ToLower(strings.TrimSpace(body.Email))
+	if !utils.IsValidEmail(invitee) {
+		utils.GetError(errors.New("invalid email address"), http.StatusBadRequest, w)
+		return
+	}
+
+	p, err := findPlugin(pluginID)
+	if err != nil {
+		utils.GetError(errors.New(ErrorMessage(err)), http.StatusNotFound, w)
+		return
+	}
+
+	if !p.IsOwner(email) && !isZuriAdmin(r.Context(), email) {
+		utils.GetError(errors.New("only the plugin owner can invite maintainers"), http.StatusForbidden, w)
+		return
+	}
+
+	if p.IsMaintainer(invitee) {
+		utils.GetError(errors.New("user already maintains this plugin"), http.StatusBadRequest, w)
+		return
+	}
+
+	now := time.Now()
+	filter := bson.M{"plugin_id": pluginID, "email": invitee, "status": InvitePending}
+	update := bson.M{
+		"$set":         bson.M{"invited_by": email, "updated_at": now},
+		"$setOnInsert": bson.M{"created_at": now},
+	}
+	opts := options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.After)
+
+	var invite MaintainerInvite
+	if err := utils.GetCollection(MaintainerInviteCollectionName).FindOneAndUpdate(r.Context(), filter, update, opts).Decode(&invite); err != nil {
+		utils.GetError(err, http.StatusInternalServerError, w)
+		return
+	}
+
+	utils.GetSuccess("maintainer invited", invite, w)
+}
+
+// AcceptMaintainerInvite adds the logged in developer to a plugin's maintainers if they were invited.
+func AcceptMaintainerInvite(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	pluginID := mux.Vars(r)["id"]
+	email := loggedInEmail(r)
+
+	res, err := utils.UpdateManyMongoDBDocs(MaintainerInviteCollectionName,
+		bson.M{"plugin_id": pluginID, "email": email, "status": InvitePending},
+		bson.M{"status": InviteAccepted, "updated_at": time.Now()})
+	if err != nil {
+		utils.GetError(err, http.StatusInternalServerError, w)
+		return
+	}
+
+	if res.ModifiedCount == 0 {
+		utils.GetError(errors.New("no pending invite for this plugin"), http.StatusNotFound, w)
+		return
+	}
+
+	if err := updateMaintainers(r.Context(), pluginID, bson.M{"$addToSet": bson.M{"maintainers": email}}); err != nil {
+		utils.GetError(err, http.StatusInternalServerError, w)
+		return
+	}
+
+	utils.GetSuccess("you are now a maintainer of this plugin", nil, w)
+}
+
+// RemoveMaintainer removes a maintainer or revokes their pending invite. Maintainers can remove themselves.
+func RemoveMaintainer(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	pluginID, target := mux.Vars(r)["id"], strings.ToLower(mux.Vars(r)["email"])
+	email := loggedInEmail(r)
+
+	p, err := findPlugin(pluginID)
+	if err != nil {
+		utils.GetError(errors.New(ErrorMessage(err)), http.StatusNotFound, w)
+		return
+	}
+
+	if target != email && !p.IsOwner(email) && !isZuriAdmin(r.Context(), email) {
+		utils.GetError(errors.New("only the plugin owner can remove maintainers"), http.StatusForbidden, w)
+		return
+	}
+
+	if p.IsOwner(target) {
+		utils.GetError(errors.New("the plugin owner cannot be removed"), http.StatusBadRequest, w)
+		return
+	}
+
+	if _, err := utils.UpdateManyMongoDBDocs(MaintainerInviteCollectionName,
+		bson.M{"plugin_id": pluginID, "email": target, "status": InvitePending},
+		bson.M{"status": InviteRevoked, "updated_at": time.Now()}); err != nil {
+		utils.GetError(err, http.StatusInternalServerError, w)
+		return
+	}
+
+	if err := updateMaintainers(r.Context(), pluginID, bson.M{"$pull": bson.M{"maintainers": target}}); err != nil {
+		utils.GetError(err, http.StatusInternalServerError, w)
+		return
+	}
+
+	utils.GetSuccess("maintainer removed", nil, w)
+}
+
+func updateMaintainers(ctx context.Context, pluginID string, update bson.M) error {
+	objID, err := primitive.ObjectIDFromHex(pluginID)
+	if err != nil {
+		return err
+	}
+
+	_, err = utils.GenericUpdateOneMongoDBDoc(PluginCollectionName, objID, update)
+
+	return err
+}
+
+// GetDeveloperPlugins is the developer dashboard: the plugins the logged in developer owns or
+// maintains with their install counts and health, and any pending maintainer invites.
+func GetDeveloperPlugins(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	email := loggedInEmail(r)
+
+	ps, err := FindPlugins(r.Context(), bson.M{"$or": bson.A{
+		bson.M{"owner_email": email},
+		bson.M{"maintainers": email},
+	}})
+	if err != nil {
+		utils.GetError(err, http.StatusInternalServerError, w)
+		return
+	}
+
+	dps := make([]DeveloperPlugin, len(ps))
+
+	var wg sync.WaitGroup
+
+	for i, p := range ps {
+		role := "maintainer"
+		if p.IsOwner(email) {
+			role = "owner"
+		}
+
+		dps[i] = DeveloperPlugin{Plugin: p, Role: role}
+
+		wg.Add(1)
+
+		go func(dp *DeveloperPlugin) {
+			defer wg.Done()
+
+			dp.InstallCount = utils.CountCollection(r.Context(), "organizations",
+				bson.M{"plugins." + dp.ID.Hex(): bson.M{"$exists": true}})
+			dp.Health = checkHealth(r.Context(), dp.Plugin)
+		}(&dps[i])
+	}
+
+	wg.Wait()
+
+	var invites []MaintainerInvite
+
+	docs, _ := utils.GetMongoDBDocs(MaintainerInviteCollectionName, bson.M{"email": email, "status": InvitePending})
+	for _, doc := range docs {
+		var invite MaintainerInvite
+		if err := utils.BsonToStruct(doc, &invite); err == nil {
+			invites = append(invites, invite)
+		}
+	}
+
+	utils.GetSuccess("success", utils.M{"plugins": dps, "invites": invites}, w)
+}
+
+// checkHealth probes the plugin's template url and looks at its backlog of sync messages.
+func checkHealth(ctx context.Context, p *Plugin) PluginHealth {
+	health := PluginHealth{Status: "healthy", QueueLength: len(p.Queue)}
+
+	ctx, cancel := context.WithTimeout(ctx, healthCheckTimeout)
+	defer cancel()
+
+	start := time.Now()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, p.TemplateURL, nil)
+	if err == nil && req.URL.Scheme != "http" && req.URL.Scheme != "https" {
+		err = errBlockedAddress
+	}
+
+	if err == nil {
+		var resp *http.Response
+
+		resp, err = healthClient.Do(req)
+		if err == nil {
+			resp.Body.Close()
+			health.Reachable = resp.StatusCode < http.StatusInternalServerError
+		}
+	}
+
+	health.ResponseTime = time.Since(start).Milliseconds()
+
+	switch {
+	case !health.Reachable:
+		health.Status = "unreachable"
+	case health.QueueLength > maxHealthyQueueLength:
+		health.Status = "degraded"
+	}
+
+	return health
+}
@@ -24,20 +24,29 @@ func NewHandler(s Service) *Handler {
 	return &Handler{s, validator.New()}
 }
 
+// Register creates a plugin owned by the logged in developer.
 func (h *Handler) Register(w http.ResponseWriter, r *http.Request) {
+	owner := loggedInEmail(r)
+	if owner == "" {
+		h.errorResponse(w, http.StatusUnauthorized, "a developer account is required to register plugins")
+		return
+	}
+
 	data := struct {
-		Name           string   `json:"name" validate:"required"`
-		Description    string   `json:"description" validate:"required"`
-		DeveloperName  string   `json:"developer_name" validate:"required"`
-		DeveloperEmail string   `json:"developer_email" validate:"required"`
-		TemplateURL    string   `json:"template_url" validate:"required"`
-		SidebarURL     string   `json:"sidebar_url" validate:"required"`
-		InstallURL     string   `json:"install_url" validate:"required"`
-		IconURL        string   `json:"icon_url"`
-		Images         []string `json:"images,omitempty"`
-		Version        string   `json:"version"`
-		Category       string   `json:"category"`
-		Tags           []string `json:"tags,omitempty"`
+		Name           string         `json:"name" validate:"required"`
+		Description    string         `json:"description" validate:"required"`
+		DeveloperName  string         `json:"developer_name" validate:"required"`
+		DeveloperEmail string         `json:"developer_email" validate:"required"`
+		TemplateURL    string         `json:"template_url" validate:"required"`
+		SidebarURL     string         `json:"sidebar_url" validate:"required"`
+		InstallURL     string         `json:"install_url" validate:"required"`
+		IconURL        string         `json:"icon_url"`
+		Images         []string       `json:"images,omitempty"`
+		Version        string         `json:"version"`
+		Category       string         `json:"category"`
+		Tags           []string       `json:"tags,omitempty"`
+		SettingsSchema SettingsSchema `json:"settings_schema,omitempty" validate:"omitempty,dive"`
+		Scopes         []string       `json:"scopes,omitempty"`
 	}{}
 
 	if err := h.readJSON(r, &data); err != nil {
@@ -55,6 +64,16 @@ func (h *Handler) Register(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if err := data.SettingsSchema.Check(); err != nil {
+		h.errorResponse(w, http.StatusBadRequest, ErrorMessage(err))
+		return
+	}
+
+	if err := CheckScopes(data.Scopes); err != nil {
+		h.errorResponse(w, http.StatusBadRequest, ErrorMessage(err))
+		return
+	}
+
 	if p, err := h.Service.FindOne(r.Context(), bson.M{
 		"template_url": data.TemplateURL,
 	}); err == nil && p != nil {
@@ -74,8 +93,19 @@ func (h *Handler) Register(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	apiKey, keyHash, err := NewAPIKey()
+	if err != nil {
+		h.errorResponse(w, http.StatusInternalServerError, ErrorMessage(err))
+		LogError(err)
+
+		return
+	}
+
 	newPlugin.Approved = true
 	newPlugin.ApprovedAt = time.Now().String()
+	newPlugin.APIKeyHash = keyHash
+	newPlugin.OwnerEmail = owner
+	newPlugin.Maintainers = []string{}
 
 	if err := h.Service.Create(r.Context(), newPlugin); err != nil {
 		h.errorResponse(w, http.StatusInternalServerError, ErrorMessage(err))
@@ -84,7 +114,8 @@ func (h *Handler) Register(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	h.successResponse(w, http.StatusCreated, "plugin created", D{"plugin": newPlugin})
+	// the api key is only ever returned here, we keep just its hash.
+	h.successResponse(w, http.StatusCreated, "plugin created", D{"plugin": newPlugin, "api_key": apiKey})
 }
 
 func (h *Handler) Update(w http.ResponseWriter, r *http.Request) {
@@ -98,6 +129,21 @@ func (h *Handler) Update(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if err := h.validate.Struct(pp); err != nil {
+		h.errorResponse(w, http.StatusBadRequest, ErrorMessage(Errorf(EINVALID, "validation error: %v", err)))
+		return
+	}
+
+	if err := pp.SettingsSchema.Check(); err != nil {
+		h.errorResponse(w, http.StatusBadRequest, ErrorMessage(err))
+		return
+	}
+
+	if err := CheckScopes(pp.Scopes); err != nil {
+		h.errorResponse(w, http.StatusBadRequest, ErrorMessage(err))
+		return
+	}
+
 	objID, err := primitive.ObjectIDFromHex(id)
 	
 	if err != nil {
@@ -105,6 +151,10 @@ func (h *Handler) Update(w http.ResponseWriter, r *http.Request) {
        return
 	}
 
+	if !h.authorize(w, r, objID) {
+		return
+	}
+
 	if err := h.Service.Update(r.Context(), bson.M{"_id": objID}, pp); err != nil {
 		h.errorResponse(w, http.StatusInternalServerError, ErrorMessage(err))
 		LogError(err)
@@ -127,6 +177,10 @@ func(h *Handler) Delete(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if !h.authorize(w, r, objID) {
+		return
+	}
+
 	if err := h.Service.Delete(r.Context(), bson.M{"_id": objID}); err != nil {
 		h.errorResponse(w, http.StatusInternalServerError, ErrorMessage(err))
 		LogError(err)
@@ -137,6 +191,54 @@ func(h *Handler) Delete(w http.ResponseWriter, r *http.Request) {
 	h.successResponse(w, http.StatusOK, "plugin deleted", nil)
 }
 
+// RotateAPIKey issues a new api key for a plugin, invalidating the previous one.
+func (h *Handler) RotateAPIKey(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+
+	objID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		h.errorResponse(w, http.StatusUnprocessableEntity, ErrorMessage(Errorf(EINVALID, "cannot process request: invalid object id")))
+		return
+	}
+
+	if !h.authorize(w, r, objID) {
+		return
+	}
+
+	apiKey, keyHash, err := NewAPIKey()
+	if err != nil {
+		h.errorResponse(w, http.StatusInternalServerError, ErrorMessage(err))
+		LogError(err)
+
+		return
+	}
+
+	if err := h.Service.Update(r.Context(), bson.M{"_id": objID}, Patch{APIKeyHash: &keyHash}); err != nil {
+		h.errorResponse(w, http.StatusInternalServerError, ErrorMessage(err))
+		LogError(err)
+
+		return
+	}
+
+	h.successResponse(w, http.StatusOK, "plugin api key rotated", D{"api_key": apiKey})
+}
+
+// authorize makes sure the logged in user owns or maintains the plugin, or is a zuri admin.
+func (h *Handler) authorize(w http.ResponseWriter, r *http.Request, id primitive.ObjectID) bool {
+	p, err := h.Service.FindOne(r.Context(), bson.M{"_id": id})
+	if err != nil || p == nil {
+		h.errorResponse(w, http.StatusNotFound, ErrorMessage(Errorf(ENOENT, "plugin with id %s not found", id.Hex())))
+		return false
+	}
+
+	if !CanManage(r.Context(), p, loggedInEmail(r)) {
+		h.errorResponse(w, http.StatusForbidden, ErrorMessage(Errorf(EINVALID, "only the plugin's owner, maintainers or a zuri admin can do this")))
+		return false
+	}
+
+	return true
+}
+
 func (h *Handler) readJSON(r *http.Request, out interface{}) error {
 	return json.NewDecoder(r.Body).Decode(out)
 }
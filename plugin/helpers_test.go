@@ -2,6 +2,7 @@ package plugin
 
 import (
 	"context"
+	"net/http"
 	"testing"
 
 	"github.com/mitchellh/mapstructure"
@@ -19,6 +20,17 @@ func (t *testService) Create(ctx context.Context, p *Plugin) error {
 
 func (t *testService) FindOne(ctx context.Context, f interface{}) (*Plugin, error) {
 	filter := f.(bson.M)
+
+	if id, ok := filter["_id"]; ok {
+		for _, v := range t.store {
+			if v.ID == id {
+				return v, nil
+			}
+		}
+
+		return nil, Errorf(ENOENT, "record not found")
+	}
+
 	pMap := make(map[string]interface{})
 	dec, _ := mapstructure.NewDecoder(&mapstructure.DecoderConfig{
 		TagName:    "json",
@@ -89,6 +101,17 @@ func (t *testService) Delete(ctx context.Context, f interface{}) error {
 	return nil
 }
 
+type testUser string
+
+func (u testUser) GetEmail() string {
+	return string(u)
+}
+
+// withUser returns r as sent by the logged in user with the given email.
+func withUser(r *http.Request, email string) *http.Request {
+	return r.WithContext(context.WithValue(r.Context(), "user", testUser(email)))
+}
+
 func assertStatusCode(tb testing.TB, want, got int) {
 	tb.Helper()
 	if got != want {
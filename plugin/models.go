@@ -25,6 +25,8 @@ type Plugin struct {
 	InstallURL     string             `json:"install_url" bson:"install_url" validate:"required"`
 	IconURL        string             `json:"icon_url" bson:"icon_url"`
 	InstallCount   int64              `json:"install_count" bson:"install_count"`
+	RatingAverage  float64            `json:"rating_average" bson:"rating_average"`
+	RatingCount    int64              `json:"rating_count" bson:"rating_count"`
 	Approved       bool               `json:"approved" bson:"approved"`
 	Images         []string           `json:"images,omitempty" bson:"images,omitempty"`
 	Version        string             `json:"version" bson:"version"`
@@ -36,18 +38,26 @@ type Plugin struct {
 	SyncRequestURL string             `json:"sync_request_url" bson:"sync_request_url"`
 	Queue          []MessageModel     `json:"queue" bson:"queue"`
 	QueuePID       int                `json:"queuepid" bson:"queuepid"`
+	SettingsSchema SettingsSchema     `json:"settings_schema" bson:"settings_schema"`
+	OwnerEmail     string             `json:"owner_email" bson:"owner_email"`
+	Maintainers    []string           `json:"maintainers" bson:"maintainers"`
+	Scopes         []string           `json:"scopes" bson:"scopes"`
+	APIKeyHash     string             `json:"-" bson:"api_key_hash,omitempty"`
 }
 
 type Patch struct {
-	Name           *string  `json:"name,omitempty" bson:"name,omitempty"`
-	Description    *string  `json:"description,omitempty"  bson:"description,omitempty"`
-	Images         []string `json:"images,omitempty" bson:"images,omitempty"`
-	Tags           []string `json:"tags,omitempty"  bson:"tags,omitempty"`
-	Version        *string  `json:"version,omitempty"  bson:"version,omitempty"`
-	SidebarURL     *string  `json:"sidebar_url,omitempty"  bson:"sidebar_url,omitempty"`
-	InstallURL     *string  `json:"install_url,omitempty"  bson:"install_url,omitempty"`
-	TemplateURL    *string  `json:"template_url,omitempty"  bson:"template_url,omitempty"`
-	SyncRequestURL *string  `json:"sync_request_url" bson:"sync_request_url"`
+	Name           *string        `json:"name,omitempty" bson:"name,omitempty"`
+	Description    *string        `json:"description,omitempty"  bson:"description,omitempty"`
+	Images         []string       `json:"images,omitempty" bson:"images,omitempty"`
+	Tags           []string       `json:"tags,omitempty"  bson:"tags,omitempty"`
+	Version        *string        `json:"version,omitempty"  bson:"version,omitempty"`
+	SidebarURL     *string        `json:"sidebar_url,omitempty"  bson:"sidebar_url,omitempty"`
+	InstallURL     *string        `json:"install_url,omitempty"  bson:"install_url,omitempty"`
+	TemplateURL    *string        `json:"template_url,omitempty"  bson:"template_url,omitempty"`
+	SyncRequestURL *string        `json:"sync_request_url" bson:"sync_request_url"`
+	SettingsSchema SettingsSchema `json:"settings_schema,omitempty" bson:"settings_schema,omitempty" validate:"omitempty,dive"`
+	Scopes         []string       `json:"scopes,omitempty" bson:"scopes,omitempty"`
+	APIKeyHash     *string        `json:"-" bson:"-"`
 }
 
 func FindPluginByID(ctx context.Context, id string) (*Plugin, error) {
@@ -63,7 +73,7 @@ func FindPluginByID(ctx context.Context, id string) (*Plugin, error) {
 	}
 
 
-	res, err := utils.GetMongoDBDoc(PluginCollectionName, bson.M{"_id": objID, "deleted": false})
+	res, err := utils.GetMongoDBDoc(PluginCollectionName, bson.M{"_id": objID, "deleted": bson.M{"$ne": true}})
 
 	if err != nil {
 		return nil, err
@@ -153,7 +163,7 @@ func FindPluginByTemplateURL(ctx context.Context, url string) (*Plugin, error) {
 		bp *Plugin
 	)
 
-	res, err := utils.GetMongoDBDoc(PluginCollectionName, bson.M{"deleted": false, "template_url": url})
+	res, err := utils.GetMongoDBDoc(PluginCollectionName, bson.M{"deleted": bson.M{"$ne": true}, "template_url": url})
 
 	if err != nil {
 		return nil, err
@@ -13,7 +13,7 @@ import (
 	"zuri.chat/zccore/utils"
 )
 
-
+// SyncUpdate is called by a plugin with its api key to drop the queued updates it has processed.
 func SyncUpdate(w http.ResponseWriter, r *http.Request) {
 	pp := SyncUpdateRequest{}
 
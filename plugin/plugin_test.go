@@ -1,12 +1,15 @@
 package plugin
 
 import (
+	"context"
 	"fmt"
+	"net"
 	"net/http"
 	"net/http/httptest"
 	"strings"
 	"testing"
 
+	"github.com/gorilla/mux"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 )
 
@@ -27,9 +30,29 @@ func TestRegister(t *testing.T) {
 		w := httptest.NewRecorder()
 		r, _ := http.NewRequest("POST", "/plugins/register", strings.NewReader(jsonData))
 
-		ph.Register(w, r)
+		ph.Register(w, withUser(r, "dev@zuri.chat"))
 
 		assertStatusCode(t, 201, w.Code)
+
+		if !strings.Contains(w.Body.String(), "api_key") {
+			t.Error("expected the plugin api key in the response")
+		}
+
+		if ts.store[0].APIKeyHash == "" {
+			t.Error("expected the api key hash to be stored")
+		}
+
+		assertStringsEqual(t, ts.store[0].OwnerEmail, "dev@zuri.chat")
+	})
+
+	t.Run("registering requires a developer account", func(t *testing.T) {
+		ph := NewHandler(&testService{})
+		w := httptest.NewRecorder()
+		r, _ := http.NewRequest("POST", "/plugins/register", strings.NewReader(jsonData))
+
+		ph.Register(w, r)
+
+		assertStatusCode(t, 401, w.Code)
 	})
 
 	t.Run("plugins cannot register same data more than once", func(t *testing.T) {
@@ -38,9 +61,9 @@ func TestRegister(t *testing.T) {
 		ts := &testService{}
 		ph := NewHandler(ts)
 
-		ph.Register(httptest.NewRecorder(), r1)
+		ph.Register(httptest.NewRecorder(), withUser(r1, "dev@zuri.chat"))
 		w := httptest.NewRecorder()
-		ph.Register(w, r2)
+		ph.Register(w, withUser(r2, "dev@zuri.chat"))
 
 		assertStatusCode(t, 403, w.Code)
 
@@ -55,25 +78,132 @@ func TestUpdate(t *testing.T) {
 "name": "changed name",
 "description": "changed description"
 }`
+	newRequest := func(p *Plugin, email string) *http.Request {
+		r, _ := http.NewRequest("PATCH", fmt.Sprintf("/plugins/%s", p.ID.Hex()), strings.NewReader(jsonData))
+		r = mux.SetURLVars(r, map[string]string{"id": p.ID.Hex()})
+
+		return withUser(r, email)
+	}
+
 	t.Run("test successful plugin update", func(t *testing.T) {
 		store := []*Plugin{
 			{
 				ID:          primitive.NewObjectID(),
 				Name:        "old name",
 				Description: "old description",
+				OwnerEmail:  "owner@zuri.chat",
 			},
 		}
 		ts := &testService{store}
 		ph := NewHandler(ts)
 		w := httptest.NewRecorder()
-		r, _ := http.NewRequest("PATCH", fmt.Sprintf("/plugins/%s", store[0].ID.Hex()), strings.NewReader(jsonData))
 
-		ph.Update(w, r)
+		ph.Update(w, newRequest(store[0], "owner@zuri.chat"))
 
 		assertStatusCode(t, 200, w.Code)
 		assertStringsEqual(t, store[0].Name, "changed name")
 		assertStringsEqual(t, store[0].Description, "changed description")
 	})
+
+	t.Run("maintainers can update the plugin", func(t *testing.T) {
+		store := []*Plugin{
+			{
+				ID:          primitive.NewObjectID(),
+				Name:        "old name",
+				OwnerEmail:  "owner@zuri.chat",
+				Maintainers: []string{"maintainer@zuri.chat"},
+			},
+		}
+		ph := NewHandler(&testService{store})
+		w := httptest.NewRecorder()
+
+		ph.Update(w, newRequest(store[0], "maintainer@zuri.chat"))
+
+		assertStatusCode(t, 200, w.Code)
+	})
+
+	t.Run("other users cannot update the plugin", func(t *testing.T) {
+		defer stubZuriAdmin(false)()
+
+		store := []*Plugin{
+			{
+				ID:         primitive.NewObjectID(),
+				Name:       "old name",
+				OwnerEmail: "owner@zuri.chat",
+			},
+		}
+		ph := NewHandler(&testService{store})
+		w := httptest.NewRecorder()
+
+		ph.Update(w, newRequest(store[0], "stranger@zuri.chat"))
+
+		assertStatusCode(t, 403, w.Code)
+		assertStringsEqual(t, store[0].Name, "old name")
+	})
+
+	t.Run("zuri admins can update any plugin", func(t *testing.T) {
+		defer stubZuriAdmin(true)()
+
+		store := []*Plugin{
+			{
+				ID:         primitive.NewObjectID(),
+				Name:       "old name",
+				OwnerEmail: "owner@zuri.chat",
+			},
+		}
+		ph := NewHandler(&testService{store})
+		w := httptest.NewRecorder()
+
+		ph.Update(w, newRequest(store[0], "admin@zuri.chat"))
+
+		assertStatusCode(t, 200, w.Code)
+	})
+}
+
+func TestDelete(t *testing.T) {
+	defer stubZuriAdmin(false)()
+
+	p := &Plugin{ID: primitive.NewObjectID(), DeveloperEmail: "Legacy@zuri.chat", OwnerEmail: "owner@zuri.chat"}
+	ph := NewHandler(&testService{[]*Plugin{p}})
+
+	// the developer email is self asserted and doesn't make its holder the owner.
+	for email, code := range map[string]int{"stranger@zuri.chat": 403, "legacy@zuri.chat": 403, "owner@zuri.chat": 200} {
+		r, _ := http.NewRequest("DELETE", fmt.Sprintf("/plugins/%s", p.ID.Hex()), nil)
+		r = mux.SetURLVars(r, map[string]string{"id": p.ID.Hex()})
+		w := httptest.NewRecorder()
+
+		ph.Delete(w, withUser(r, email))
+
+		assertStatusCode(t, code, w.Code)
+	}
+}
+
+func TestCheckHealthSkipsPrivateAddresses(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer srv.Close()
+
+	for _, url := range []string{srv.URL, "http://169.254.169.254/latest/meta-data/", "file:///etc/passwd"} {
+		if h := checkHealth(context.Background(), &Plugin{TemplateURL: url}); h.Reachable {
+			t.Errorf("checkHealth(%s) reached the url, want it refused", url)
+		}
+	}
+
+	for ip, want := range map[string]bool{
+		"10.1.2.3": false, "127.0.0.1": false, "169.254.169.254": false, "::1": false,
+		"::ffff:192.168.0.1": false, "fd00::1": false, "8.8.8.8": true, "2606:4700::1111": true,
+	} {
+		if got := publicAddress(net.ParseIP(ip)); got != want {
+			t.Errorf("publicAddress(%s) = %v, want %v", ip, got, want)
+		}
+	}
+}
+
+// stubZuriAdmin makes every user a zuri admin or not, and returns a func restoring the check.
+func stubZuriAdmin(admin bool) func() {
+	orig := isZuriAdmin
+	isZuriAdmin = func(ctx context.Context, email string) bool { return admin }
+
+	return func() { isZuriAdmin = orig }
 }
 
 /*
@@ -0,0 +1,32 @@
+package plugin
+
+// Scopes a plugin can request, organization admins grant them when installing the plugin.
+const (
+	ScopeBillingCharge = "billing:charge"
+)
+
+var Scopes = map[string]string{
+	ScopeBillingCharge: "submit metered usage charged to the organization's tokens",
+}
+
+// CheckScopes returns an error naming the first scope that does not exist.
+func CheckScopes(scopes []string) error {
+	for _, s := range scopes {
+		if _, ok := Scopes[s]; !ok {
+			return Errorf(EINVALID, "unknown scope %q", s)
+		}
+	}
+
+	return nil
+}
+
+// HasScope reports whether the plugin requests the scope.
+func (p *Plugin) HasScope(scope string) bool {
+	for _, s := range p.Scopes {
+		if s == scope {
+			return true
+		}
+	}
+
+	return false
+}
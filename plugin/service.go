@@ -93,6 +93,18 @@ func (m *mongoService) Update(ctx context.Context, f interface{}, pp Patch) erro
 		set["sync_request_url"] = *(pp.SyncRequestURL)
 	}
 
+	if pp.SettingsSchema != nil {
+		set["settings_schema"] = pp.SettingsSchema
+	}
+
+	if pp.Scopes != nil {
+		set["scopes"] = pp.Scopes
+	}
+
+	if pp.APIKeyHash != nil {
+		set["api_key_hash"] = *(pp.APIKeyHash)
+	}
+
 	if pp.Images != nil {
 		push["images"] = bson.M{"$each": pp.Images}
 	}
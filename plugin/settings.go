@@ -0,0 +1,200 @@
+package plugin
+
+import (
+	"context"
+	"crypto/subtle"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/gorilla/mux"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"zuri.chat/zccore/utils"
+)
+
+const (
+	SettingString  = "string"
+	SettingNumber  = "number"
+	SettingBoolean = "boolean"
+	SettingSelect  = "select"
+	SettingSecret  = "secret"
+)
+
+// PluginContext is the request context key holding the plugin authenticated by RequireAPIKey.
+const PluginContext = "plugin"
+
+// length in bytes of generated plugin api keys.
+const apiKeyLength = 32
+
+// SettingField describes a single value a plugin expects an organization to configure.
+type SettingField struct {
+	Key         string      `json:"key" bson:"key" validate:"required"`
+	Label       string      `json:"label" bson:"label"`
+	Description string      `json:"description,omitempty" bson:"description,omitempty"`
+	Type        string      `json:"type" bson:"type" validate:"required,oneof=string number boolean select secret"`
+	Required    bool        `json:"required" bson:"required"`
+	Options     []string    `json:"options,omitempty" bson:"options,omitempty"`
+	Default     interface{} `json:"default,omitempty" bson:"default,omitempty"`
+}
+
+// SettingsSchema is the list of settings a plugin publishes.
+type SettingsSchema []SettingField
+
+// Field returns the field with the given key.
+func (s SettingsSchema) Field(key string) (SettingField, bool) {
+	for _, f := range s {
+		if f.Key == key {
+			return f, true
+		}
+	}
+
+	return SettingField{}, false
+}
+
+// Check makes sure the schema itself is sane: keys are unique and select fields have options.
+func (s SettingsSchema) Check() error {
+	seen := make(map[string]bool, len(s))
+
+	for _, f := range s {
+		if seen[f.Key] {
+			return Errorf(EINVALID, "duplicate setting key %q", f.Key)
+		}
+
+		seen[f.Key] = true
+
+		if f.Type == SettingSelect && len(f.Options) == 0 {
+			return Errorf(EINVALID, "setting %q of type select has no options", f.Key)
+		}
+	}
+
+	return nil
+}
+
+// Validate checks an organization's values against the schema.
+// Unknown keys are rejected and required fields must be present in either values or existing.
+func (s SettingsSchema) Validate(values, existing map[string]interface{}) error {
+	for key, val := range values {
+		f, ok := s.Field(key)
+		if !ok {
+			return Errorf(EINVALID, "unknown setting %q", key)
+		}
+
+		if val == nil {
+			if f.Required {
+				return Errorf(EINVALID, "setting %q is required", key)
+			}
+
+			continue
+		}
+
+		if err := f.validateValue(val); err != nil {
+			return err
+		}
+	}
+
+	for _, f := range s {
+		if !f.Required {
+			continue
+		}
+
+		if _, ok := values[f.Key]; ok {
+			continue
+		}
+
+		if _, ok := existing[f.Key]; !ok {
+			return Errorf(EINVALID, "setting %q is required", f.Key)
+		}
+	}
+
+	return nil
+}
+
+func (f SettingField) validateValue(val interface{}) error {
+	switch f.Type {
+	case SettingString, SettingSecret:
+		str, ok := val.(string)
+		if !ok {
+			return Errorf(EINVALID, "setting %q must be a string", f.Key)
+		}
+
+		if f.Required && strings.TrimSpace(str) == "" {
+			return Errorf(EINVALID, "setting %q is required", f.Key)
+		}
+	case SettingNumber:
+		switch val.(type) {
+		case float64, float32, int, int32, int64:
+		default:
+			return Errorf(EINVALID, "setting %q must be a number", f.Key)
+		}
+	case SettingBoolean:
+		if _, ok := val.(bool); !ok {
+			return Errorf(EINVALID, "setting %q must be a boolean", f.Key)
+		}
+	case SettingSelect:
+		str, ok := val.(string)
+		if !ok {
+			return Errorf(EINVALID, "setting %q must be one of %v", f.Key, f.Options)
+		}
+
+		for _, o := range f.Options {
+			if o == str {
+				return nil
+			}
+		}
+
+		return Errorf(EINVALID, "setting %q must be one of %v", f.Key, f.Options)
+	default:
+		return Errorf(EINVALID, "setting %q has unknown type %q", f.Key, f.Type)
+	}
+
+	return nil
+}
+
+// NewAPIKey generates a plugin api key and the hash that should be stored for it.
+func NewAPIKey() (key, hash string, err error) {
+	key, err = utils.GenSecureToken(apiKeyLength)
+	if err != nil {
+		return "", "", err
+	}
+
+	return key, utils.HashToken(key), nil
+}
+
+// RequireAPIKey authenticates requests made by a plugin on its own behalf.
+// The plugin is identified by the {id} route variable and must send its api key as a bearer token.
+func RequireAPIKey(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		key := strings.TrimSpace(strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer"))
+		if key == "" {
+			utils.GetError(fmt.Errorf("plugin api key required"), http.StatusUnauthorized, w)
+			return
+		}
+
+		objID, err := primitive.ObjectIDFromHex(mux.Vars(r)["id"])
+		if err != nil {
+			utils.GetError(fmt.Errorf("invalid plugin id"), http.StatusBadRequest, w)
+			return
+		}
+
+		doc, _ := utils.GetMongoDBDoc(PluginCollectionName, bson.M{"_id": objID})
+		if doc == nil {
+			utils.GetError(fmt.Errorf("invalid plugin credentials"), http.StatusUnauthorized, w)
+			return
+		}
+
+		var p Plugin
+		if err := utils.BsonToStruct(doc, &p); err != nil {
+			utils.GetError(err, http.StatusInternalServerError, w)
+			return
+		}
+
+		if p.APIKeyHash == "" || subtle.ConstantTimeCompare([]byte(p.APIKeyHash), []byte(utils.HashToken(key))) != 1 {
+			utils.GetError(fmt.Errorf("invalid plugin credentials"), http.StatusUnauthorized, w)
+			return
+		}
+
+		ctx := context.WithValue(r.Context(), PluginContext, &p)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	}
+}
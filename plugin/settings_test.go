@@ -0,0 +1,52 @@
+package plugin
+
+import (
+	"testing"
+)
+
+func TestSettingsSchemaValidate(t *testing.T) {
+	schema := SettingsSchema{
+		{Key: "channel", Type: SettingString, Required: true},
+		{Key: "limit", Type: SettingNumber},
+		{Key: "enabled", Type: SettingBoolean},
+		{Key: "mode", Type: SettingSelect, Options: []string{"fast", "slow"}},
+		{Key: "token", Type: SettingSecret},
+	}
+
+	tests := []struct {
+		name     string
+		values   map[string]interface{}
+		existing map[string]interface{}
+		wantErr  bool
+	}{
+		{"valid values", map[string]interface{}{"channel": "general", "limit": 10.0, "enabled": true, "mode": "fast", "token": "s3cret"}, nil, false},
+		{"required field already stored", map[string]interface{}{"limit": 3.0}, map[string]interface{}{"channel": "general"}, false},
+		{"missing required field", map[string]interface{}{"limit": 3.0}, nil, true},
+		{"unsetting required field", map[string]interface{}{"channel": nil}, map[string]interface{}{"channel": "general"}, true},
+		{"unknown key", map[string]interface{}{"channel": "general", "colour": "red"}, nil, true},
+		{"wrong number type", map[string]interface{}{"channel": "general", "limit": "ten"}, nil, true},
+		{"wrong boolean type", map[string]interface{}{"channel": "general", "enabled": "yes"}, nil, true},
+		{"option not allowed", map[string]interface{}{"channel": "general", "mode": "medium"}, nil, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := schema.Validate(tt.values, tt.existing)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestSettingsSchemaCheck(t *testing.T) {
+	dup := SettingsSchema{{Key: "a", Type: SettingString}, {Key: "a", Type: SettingNumber}}
+	if err := dup.Check(); err == nil {
+		t.Error("expected duplicate keys to be rejected")
+	}
+
+	noOptions := SettingsSchema{{Key: "mode", Type: SettingSelect}}
+	if err := noOptions.Check(); err == nil {
+		t.Error("expected select without options to be rejected")
+	}
+}
@@ -14,8 +14,8 @@ import (
 	"github.com/mailgun/mailgun-go/v4"
 	"github.com/sendgrid/sendgrid-go"
 	"github.com/sendgrid/sendgrid-go/helpers/mail"
-	"zuri.chat/zccore/utils"
 	"zuri.chat/zccore/logger"
+	"zuri.chat/zccore/utils"
 )
 
 type MailService interface {
@@ -39,6 +39,14 @@ const (
 	TokenBillingNotice
 	WorkSpaceInvite
 	WorkSpaceWelcome
+	PluginSpendAlert
+	AccountLocked
+	MagicLink
+	EmailChangeConfirm
+	EmailChangeNotice
+	OrgDeletion
+	OrgExportReady
+	DomainVerification
 )
 
 var MailTypes = map[MailType]MailType{
@@ -49,6 +57,14 @@ var MailTypes = map[MailType]MailType{
 	TokenBillingNotice: TokenBillingNotice,
 	WorkSpaceInvite:    WorkSpaceInvite,
 	WorkSpaceWelcome:   WorkSpaceWelcome,
+	PluginSpendAlert:   PluginSpendAlert,
+	AccountLocked:      AccountLocked,
+	MagicLink:          MagicLink,
+	EmailChangeConfirm: EmailChangeConfirm,
+	EmailChangeNotice:  EmailChangeNotice,
+	OrgDeletion:        OrgDeletion,
+	OrgExportReady:     OrgExportReady,
+	DomainVerification: DomainVerification,
 }
 
 type Mail struct {
@@ -80,6 +96,14 @@ func (ms *ZcMailService) LoadTemplate(mailReq *Mail) (string, error) {
 		TokenBillingNotice: ms.configs.TokenBillingNoticeTemplate,
 		WorkSpaceInvite:    ms.configs.WorkSpaceInviteTemplate,
 		WorkSpaceWelcome:   ms.configs.WorkSpaceWelcomeTemplate,
+		PluginSpendAlert:   ms.configs.PluginSpendAlertTemplate,
+		AccountLocked:      ms.configs.AccountLockedTemplate,
+		MagicLink:          ms.configs.MagicLinkTemplate,
+		EmailChangeConfirm: ms.configs.EmailChangeConfirmTemplate,
+		EmailChangeNotice:  ms.configs.EmailChangeNoticeTemplate,
+		OrgDeletion:        ms.configs.OrgDeletionTemplate,
+		OrgExportReady:     ms.configs.OrgExportTemplate,
+		DomainVerification: ms.configs.DomainVerifyTemplate,
 	}
 
 	templateFileName, ok := m[mailReq.mtype]
@@ -103,20 +127,20 @@ func (ms *ZcMailService) LoadTemplate(mailReq *Mail) (string, error) {
 func (ms *ZcMailService) SendMail(mailReq *Mail) error {
 	var (
 		verifier = emailverifier.
-        NewVerifier().
-        EnableSMTPCheck()
+			NewVerifier().
+			EnableSMTPCheck()
 	)
 
 	result := strings.Split(mailReq.to[0], "@")
-	
+
 	domain := result[1]
-    username := result[0]
+	username := result[0]
 
-    ret, err := verifier.CheckSMTP(domain, username)
+	ret, err := verifier.CheckSMTP(domain, username)
 
-    if err != nil {
+	if err != nil {
 		logger.Error("check smtp failed: %v", err)
-    }
+	}
 
 	if !ret.Deliverable {
 		return fmt.Errorf("email %s verification failed", mailReq.to[0])
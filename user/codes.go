@@ -0,0 +1,70 @@
+package user
+
+import (
+	"time"
+
+	"zuri.chat/zccore/utils"
+)
+
+// CodeLength is the number of digits in verification and password reset codes.
+const CodeLength = 6
+
+// NewEmailVerification returns a new account confirmation code and the record
+// to store for it. Only the keyed hash of the code is kept.
+func NewEmailVerification(key string, ttl time.Duration) (string, *UserEmailVerification, error) {
+	code, err := utils.GenNumericCode(CodeLength)
+	if err != nil {
+		return "", nil, err
+	}
+
+	now := time.Now()
+
+	return code, &UserEmailVerification{
+		Token:     utils.HashCode(code, key),
+		ExpiredAt: now.Add(ttl),
+		CreatedAt: now,
+	}, nil
+}
+
+// emailChangeTokenBytes is the size of the random email change link tokens.
+const emailChangeTokenBytes = 32
+
+// NewEmailChange returns the confirm and cancel tokens for a move to newEmail
+// and the record to store, which keeps only their hashes.
+func NewEmailChange(newEmail string, ttl time.Duration) (confirm, cancel string, change *UserEmailChange, err error) {
+	if confirm, err = utils.GenSecureToken(emailChangeTokenBytes); err != nil {
+		return "", "", nil, err
+	}
+
+	if cancel, err = utils.GenSecureToken(emailChangeTokenBytes); err != nil {
+		return "", "", nil, err
+	}
+
+	now := time.Now()
+
+	return confirm, cancel, &UserEmailChange{
+		NewEmail:    newEmail,
+		Token:       utils.HashToken(confirm),
+		CancelToken: utils.HashToken(cancel),
+		ExpiredAt:   now.Add(ttl),
+		CreatedAt:   now,
+	}, nil
+}
+
+// NewPasswordReset returns a new password reset code and the record to store for it.
+func NewPasswordReset(key string, ttl time.Duration, ip string) (string, *UserPasswordReset, error) {
+	code, err := utils.GenNumericCode(CodeLength)
+	if err != nil {
+		return "", nil, err
+	}
+
+	now := time.Now()
+
+	return code, &UserPasswordReset{
+		IPAddress: ip,
+		Token:     utils.HashCode(code, key),
+		ExpiredAt: now.Add(ttl),
+		UpdatedAt: now,
+		CreatedAt: now,
+	}, nil
+}
@@ -12,9 +12,8 @@ import (
 )
 
 var (
-	emailRegex      = `^[a-z0-9._%+\-]+@[a-z0-9.\-]+\.[a-z]{2,4}$`
-	validate        = validator.New()
-	defaultHashCost = 14
+	emailRegex = `^[a-z0-9._%+\-]+@[a-z0-9.\-]+\.[a-z]{2,4}$`
+	validate   = validator.New()
 )
 
 const (
@@ -58,19 +57,51 @@ type UserSettings struct {
 //nolint:revive //changing name will break a lot of codes
 type UserEmailVerification struct {
 	Verified  bool      `bson:"verified" json:"verified"`
-	Token     string    `bson:"token" json:"token"`
+	Token     string    `bson:"token" json:"-"` // keyed hash of the code
+	Attempts  int       `bson:"attempts" json:"-"`
 	ExpiredAt time.Time `bson:"expired_at" json:"expired_at"`
+	CreatedAt time.Time `bson:"created_at" json:"created_at"`
 }
 
 //nolint:revive //changing name will break a lot of codes
 type UserPasswordReset struct {
 	IPAddress string    `bson:"ip_address" json:"ip_address"`
-	Token     string    `bson:"token" json:"token"`
+	Token     string    `bson:"token" json:"-"` // keyed hash of the code
+	Attempts  int       `bson:"attempts" json:"-"`
 	ExpiredAt time.Time `bson:"expired_at" json:"expired_at"`
 	UpdatedAt time.Time `bson:"updated_at" json:"updated_at"`
 	CreatedAt time.Time `bson:"created_at" json:"created_at"`
 }
 
+// UserEmailChange is a requested move to a new address, waiting for the link
+// sent there to be followed. The old address gets a link to cancel it, which
+// moves the account back while the change is still recent.
+//
+//nolint:revive //changing name will break a lot of codes
+type UserEmailChange struct {
+	NewEmail    string    `bson:"new_email" json:"new_email"`
+	Token       string    `bson:"token" json:"-"`        // hash of the confirm token
+	CancelToken string    `bson:"cancel_token" json:"-"` // hash of the cancel token
+	ExpiredAt   time.Time `bson:"expired_at" json:"expired_at"`
+	CreatedAt   time.Time `bson:"created_at" json:"created_at"`
+	// set once confirmed, the cancel link then moves the account back here
+	OldEmail    string    `bson:"old_email,omitempty" json:"-"`
+	ConfirmedAt time.Time `bson:"confirmed_at,omitempty" json:"confirmed_at,omitempty"`
+}
+
+// UserTwoFactor holds a user's TOTP enrollment. The secrets are stored
+// encrypted and the recovery codes hashed, so none of it is ever serialised.
+//
+//nolint:revive //changing name will break a lot of codes
+type UserTwoFactor struct {
+	Enabled       bool      `bson:"enabled" json:"enabled"`
+	Secret        string    `bson:"secret" json:"-"`
+	PendingSecret string    `bson:"pending_secret" json:"-"`
+	RecoveryCodes []string  `bson:"recovery_codes" json:"-"`
+	LastUsedStep  int64     `bson:"last_used_step" json:"-"`
+	EnrolledAt    time.Time `bson:"enrolled_at" json:"enrolled_at"`
+}
+
 type Social struct {
 	ID       string `bson:"provider_id" json:"provider_id"`
 	Provider string `bson:"provider" json:"provider"`
@@ -96,6 +127,13 @@ type User struct {
 	Organizations     []string               `bson:"workspaces" json:"workspaces"` // should contain (organization) workspace ids
 	EmailVerification *UserEmailVerification `bson:"email_verification" json:"email_verification"`
 	PasswordResets    *UserPasswordReset     `bson:"password_resets" json:"password_resets"` // remove the array
+	TwoFactor         *UserTwoFactor         `bson:"two_factor,omitempty" json:"two_factor,omitempty"`
+	EmailChange       *UserEmailChange       `bson:"email_change,omitempty" json:"email_change,omitempty"`
+	// hashes of recent passwords, newest first
+	PasswordHistory   []string  `bson:"password_history,omitempty" json:"-"`
+	PasswordChangedAt time.Time `bson:"password_changed_at" json:"password_changed_at"`
+	// set when an organization forces a reset, login is refused until it happens
+	MustResetPassword bool `bson:"must_reset_password,omitempty" json:"must_reset_password,omitempty"`
 }
 
 // Struct that user can update directly.
@@ -120,9 +158,9 @@ type UUIDUserData struct {
 	LastName  string `bson:"last_name" json:"last_name"`
 }
 
-// Method to hash password.
-func GetHash(password string) (string, error) {
-	bytes, err := bcrypt.GenerateFromPassword([]byte(password), defaultHashCost)
+// Method to hash password with the configured bcrypt cost.
+func GetHash(password string, cost int) (string, error) {
+	bytes, err := bcrypt.GenerateFromPassword([]byte(password), cost)
 	return string(bytes), err
 }
 
@@ -0,0 +1,357 @@
+package user
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha1" //nolint:gosec //the breach range API is keyed by SHA-1
+	_ "embed"     // breached password list
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"golang.org/x/crypto/bcrypt"
+	"zuri.chat/zccore/utils"
+)
+
+const (
+	// no policy may go below the length the API always required.
+	minPasswordLength = 6
+	// bcrypt ignores anything past 72 bytes.
+	maxPasswordLength  = 72
+	maxPasswordHistory = 24
+)
+
+var (
+	ErrPasswordReused          = errors.New("password was used recently, choose a different one")
+	ErrInvalidPasswordPolicy   = fmt.Errorf("min_length must be between %d and %d and history between 0 and %d", minPasswordLength, maxPasswordLength, maxPasswordHistory)
+	ErrPasswordResetIsRequired = errors.New("your organization requires you to reset your password, check your email for a reset code")
+
+	//go:embed breached_passwords.txt
+	breachedPasswordList string
+	breachedPasswords    map[string]struct{}
+	breachedOnce         sync.Once
+
+	breachRangeURL    string
+	breachRangeOnce   sync.Once
+	breachRangeClient = &http.Client{Timeout: 5 * time.Second}
+)
+
+// PasswordPolicy describes what a new password must satisfy. The deployment
+// sets the baseline and organizations may only make it stricter.
+type PasswordPolicy struct {
+	MinLength      int  `json:"min_length" bson:"min_length"`
+	RequireUpper   bool `json:"require_upper" bson:"require_upper"`
+	RequireLower   bool `json:"require_lower" bson:"require_lower"`
+	RequireDigit   bool `json:"require_digit" bson:"require_digit"`
+	RequireSymbol  bool `json:"require_symbol" bson:"require_symbol"`
+	History        int  `json:"history" bson:"history"`
+	RejectBreached bool `json:"reject_breached" bson:"reject_breached"`
+}
+
+// PasswordPolicyError lists every rule a password broke.
+type PasswordPolicyError struct {
+	Problems []string
+}
+
+func (e *PasswordPolicyError) Error() string {
+	return "password " + strings.Join(e.Problems, ", ")
+}
+
+// DefaultPasswordPolicy is the deployment wide policy from the configuration.
+func DefaultPasswordPolicy(c *utils.Configurations) PasswordPolicy {
+	p := PasswordPolicy{
+		MinLength:      c.PasswordMinLength,
+		RequireUpper:   c.PasswordRequireUpper,
+		RequireLower:   c.PasswordRequireLower,
+		RequireDigit:   c.PasswordRequireDigit,
+		RequireSymbol:  c.PasswordRequireSymbol,
+		History:        c.PasswordHistory,
+		RejectBreached: c.PasswordRejectBreached,
+	}
+
+	if p.MinLength < minPasswordLength {
+		p.MinLength = minPasswordLength
+	}
+
+	if p.History > maxPasswordHistory {
+		p.History = maxPasswordHistory
+	}
+
+	return p
+}
+
+// Validate checks that an organization supplied policy is within bounds.
+func (p PasswordPolicy) Validate() error {
+	if p.MinLength != 0 && (p.MinLength < minPasswordLength || p.MinLength > maxPasswordLength) {
+		return ErrInvalidPasswordPolicy
+	}
+
+	if p.History < 0 || p.History > maxPasswordHistory {
+		return ErrInvalidPasswordPolicy
+	}
+
+	return nil
+}
+
+// Tighten returns the stricter combination of p and o.
+func (p PasswordPolicy) Tighten(o *PasswordPolicy) PasswordPolicy {
+	if o == nil {
+		return p
+	}
+
+	if o.MinLength > p.MinLength {
+		p.MinLength = o.MinLength
+	}
+
+	if o.History > p.History {
+		p.History = o.History
+	}
+
+	p.RequireUpper = p.RequireUpper || o.RequireUpper
+	p.RequireLower = p.RequireLower || o.RequireLower
+	p.RequireDigit = p.RequireDigit || o.RequireDigit
+	p.RequireSymbol = p.RequireSymbol || o.RequireSymbol
+	p.RejectBreached = p.RejectBreached || o.RejectBreached
+
+	return p
+}
+
+// Check reports every rule password breaks as a *PasswordPolicyError.
+func (p PasswordPolicy) Check(password string) error {
+	var problems []string
+
+	if len([]rune(password)) < p.MinLength {
+		problems = append(problems, fmt.Sprintf("must be at least %d characters", p.MinLength))
+	}
+
+	if len(password) > maxPasswordLength {
+		problems = append(problems, fmt.Sprintf("must be at most %d bytes", maxPasswordLength))
+	}
+
+	var upper, lower, digit, symbol bool
+
+	for _, r := range password {
+		switch {
+		case unicode.IsUpper(r):
+			upper = true
+		case unicode.IsLower(r):
+			lower = true
+		case unicode.IsDigit(r):
+			digit = true
+		case unicode.IsPunct(r) || unicode.IsSymbol(r) || unicode.IsSpace(r):
+			symbol = true
+		}
+	}
+
+	for _, rule := range []struct {
+		required, present bool
+		problem           string
+	}{
+		{p.RequireUpper, upper, "must contain an uppercase letter"},
+		{p.RequireLower, lower, "must contain a lowercase letter"},
+		{p.RequireDigit, digit, "must contain a digit"},
+		{p.RequireSymbol, symbol, "must contain a symbol"},
+	} {
+		if rule.required && !rule.present {
+			problems = append(problems, rule.problem)
+		}
+	}
+
+	if p.RejectBreached && IsBreachedPassword(password) {
+		problems = append(problems, "is too common and has appeared in data breaches")
+	}
+
+	if len(problems) > 0 {
+		return &PasswordPolicyError{Problems: problems}
+	}
+
+	return nil
+}
+
+// IsBreachedPassword reports whether password is on the shipped list of
+// common passwords or in the breach corpus behind the configured range API.
+// Only the first five hex digits of the password's SHA-1 leave the server. If
+// the API cannot be reached the shipped list is all that is checked.
+func IsBreachedPassword(password string) bool {
+	if isCommonPassword(password) {
+		return true
+	}
+
+	breached, err := inBreachRange(password)
+	if err != nil {
+		log.Printf("breached password lookup: %v", err)
+	}
+
+	return breached
+}
+
+func isCommonPassword(password string) bool {
+	breachedOnce.Do(func() {
+		breachedPasswords = make(map[string]struct{})
+		scanner := bufio.NewScanner(strings.NewReader(breachedPasswordList))
+
+		for scanner.Scan() {
+			line := strings.TrimSpace(scanner.Text())
+			if line == "" || strings.HasPrefix(line, "#") {
+				continue
+			}
+
+			breachedPasswords[strings.ToLower(line)] = struct{}{}
+		}
+	})
+
+	_, ok := breachedPasswords[strings.ToLower(password)]
+
+	return ok
+}
+
+// inBreachRange asks the range API for every breached hash sharing the first
+// five hex digits of password's SHA-1 and looks for the rest among them.
+func inBreachRange(password string) (bool, error) {
+	breachRangeOnce.Do(func() {
+		breachRangeURL = utils.NewConfigurations().PasswordBreachRangeURL
+	})
+
+	if breachRangeURL == "" {
+		return false, nil
+	}
+
+	sum := sha1.Sum([]byte(password)) //nolint:gosec //the breach range API is keyed by SHA-1
+	digest := strings.ToUpper(hex.EncodeToString(sum[:]))
+
+	req, err := http.NewRequest(http.MethodGet, breachRangeURL+digest[:5], nil)
+	if err != nil {
+		return false, err
+	}
+
+	// padded responses hide how many hashes share the prefix
+	req.Header.Set("Add-Padding", "true")
+
+	resp, err := breachRangeClient.Do(req)
+	if err != nil {
+		return false, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return false, fmt.Errorf("range API returned %s", resp.Status)
+	}
+
+	scanner := bufio.NewScanner(resp.Body)
+
+	for scanner.Scan() {
+		suffix, count := scanner.Text(), ""
+		if i := strings.IndexByte(suffix, ':'); i >= 0 {
+			suffix, count = suffix[:i], strings.TrimSpace(suffix[i+1:])
+		}
+
+		// padding entries have a count of 0
+		if strings.EqualFold(suffix, digest[5:]) && count != "0" {
+			return true, nil
+		}
+	}
+
+	return false, scanner.Err()
+}
+
+// PasswordReused reports whether password matches the current hash or one
+// of the last n hashes in history.
+func PasswordReused(password, current string, history []string, n int) bool {
+	if n <= 0 {
+		return false
+	}
+
+	hashes := append([]string{current}, history...)
+	if len(hashes) > n {
+		hashes = hashes[:n]
+	}
+
+	for _, h := range hashes {
+		if h != "" && bcrypt.CompareHashAndPassword([]byte(h), []byte(password)) == nil {
+			return true
+		}
+	}
+
+	return false
+}
+
+// PushPasswordHistory puts the hash being replaced at the front of history,
+// keeping at most n entries.
+func PushPasswordHistory(history []string, replaced string, n int) []string {
+	if n <= 0 || replaced == "" {
+		return nil
+	}
+
+	history = append([]string{replaced}, history...)
+	if len(history) > n {
+		history = history[:n]
+	}
+
+	return history
+}
+
+// PasswordPolicyFor is the policy for email: the deployment policy tightened
+// by every organization the user is a member of, plus any in orgIDs.
+func PasswordPolicyFor(c *utils.Configurations, email string, orgIDs ...string) PasswordPolicy {
+	policy := DefaultPasswordPolicy(c)
+	ctx := context.Background()
+
+	if email != "" {
+		memberOrgs, err := utils.GetCollection(MemberCollectionName).Distinct(ctx, "org_id", bson.M{"email": email, "deleted": bson.M{"$ne": true}})
+		if err == nil {
+			for _, id := range memberOrgs {
+				if s, ok := id.(string); ok {
+					orgIDs = append(orgIDs, s)
+				}
+			}
+		}
+	}
+
+	if len(orgIDs) == 0 {
+		return policy
+	}
+
+	ids := make([]interface{}, 0, len(orgIDs))
+
+	for _, id := range orgIDs {
+		if objID, err := primitive.ObjectIDFromHex(id); err == nil {
+			ids = append(ids, objID)
+		} else {
+			ids = append(ids, id)
+		}
+	}
+
+	opts := options.Find().SetProjection(bson.M{"settings.authentication.passwordpolicy": 1})
+
+	cursor, err := utils.GetCollection(OrganizationCollectionName).Find(ctx, bson.M{"_id": bson.M{"$in": ids}}, opts)
+	if err != nil {
+		return policy
+	}
+
+	var orgs []struct {
+		Settings struct {
+			Authentication struct {
+				PasswordPolicy *PasswordPolicy `bson:"passwordpolicy"`
+			} `bson:"authentication"`
+		} `bson:"settings"`
+	}
+
+	if err := cursor.All(ctx, &orgs); err != nil {
+		return policy
+	}
+
+	for _, o := range orgs {
+		policy = policy.Tighten(o.Settings.Authentication.PasswordPolicy)
+	}
+
+	return policy
+}
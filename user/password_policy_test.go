@@ -0,0 +1,149 @@
+package user
+
+import (
+	"crypto/sha1" //nolint:gosec //the breach range API is keyed by SHA-1
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// stubBreachRange serves a range API that knows breached, padded the way the
+// real one is.
+func stubBreachRange(t *testing.T, breached ...string) {
+	t.Helper()
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		prefix := strings.TrimPrefix(r.URL.Path, "/range/")
+
+		for _, pw := range breached {
+			sum := sha1.Sum([]byte(pw)) //nolint:gosec //the breach range API is keyed by SHA-1
+			if digest := strings.ToUpper(hex.EncodeToString(sum[:])); digest[:5] == prefix {
+				fmt.Fprintf(w, "%s:42\r\n", digest[5:])
+			}
+		}
+
+		fmt.Fprintf(w, "%s:0\r\n", strings.Repeat("0", 35))
+	}))
+	t.Cleanup(srv.Close)
+
+	breachRangeOnce.Do(func() {})
+	breachRangeURL = srv.URL + "/range/"
+
+	t.Cleanup(func() { breachRangeURL = "" })
+}
+
+func TestPasswordPolicyCheck(t *testing.T) {
+	stubBreachRange(t)
+
+	strict := PasswordPolicy{
+		MinLength:      10,
+		RequireUpper:   true,
+		RequireLower:   true,
+		RequireDigit:   true,
+		RequireSymbol:  true,
+		RejectBreached: true,
+	}
+
+	tests := []struct {
+		password string
+		problems int
+	}{
+		{"Tr0ub4dor&3xyz", 0},
+		{"short", 4},
+		{"alllowercaseletters", 3},
+		{"ALLUPPER1234!", 1},
+		{"Password1", 3},
+	}
+
+	for _, tt := range tests {
+		err := strict.Check(tt.password)
+
+		var pe *PasswordPolicyError
+		if tt.problems == 0 {
+			if err != nil {
+				t.Errorf("Check(%q) = %v, want nil", tt.password, err)
+			}
+
+			continue
+		}
+
+		if !errors.As(err, &pe) || len(pe.Problems) != tt.problems {
+			t.Errorf("Check(%q) = %v, want %d problems", tt.password, err, tt.problems)
+		}
+	}
+}
+
+func TestIsBreachedPassword(t *testing.T) {
+	stubBreachRange(t, "correct horse battery staple")
+
+	for _, pw := range []string{"password", "QWERTY123", "iloveyou", "correct horse battery staple"} {
+		if !IsBreachedPassword(pw) {
+			t.Errorf("IsBreachedPassword(%q) = false, want true", pw)
+		}
+	}
+
+	if IsBreachedPassword("# Frequently breached passwords, one per line, compared case-insensitively.") {
+		t.Error("comment lines must not be treated as passwords")
+	}
+
+	if IsBreachedPassword("Tr0ub4dor&3xyz") {
+		t.Error("IsBreachedPassword matched a password the range API does not know")
+	}
+
+	breachRangeURL = "http://127.0.0.1:1/range/"
+	if IsBreachedPassword("correct horse battery staple") || !IsBreachedPassword("password") {
+		t.Error("an unreachable range API must leave only the shipped list")
+	}
+}
+
+func TestPasswordPolicyTighten(t *testing.T) {
+	base := PasswordPolicy{MinLength: 8, History: 5, RejectBreached: true}
+	org := &PasswordPolicy{MinLength: 6, History: 10, RequireDigit: true}
+
+	got := base.Tighten(org)
+	want := PasswordPolicy{MinLength: 8, History: 10, RequireDigit: true, RejectBreached: true}
+
+	if got != want {
+		t.Errorf("Tighten = %+v, want %+v", got, want)
+	}
+
+	if base.Tighten(nil) != base {
+		t.Error("Tighten(nil) changed the policy")
+	}
+}
+
+func TestPasswordHistory(t *testing.T) {
+	hash := func(pw string) string {
+		b, err := bcrypt.GenerateFromPassword([]byte(pw), bcrypt.MinCost)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		return string(b)
+	}
+
+	current := hash("current-pass")
+	history := PushPasswordHistory([]string{hash("older-pass")}, hash("previous-pass"), 2)
+
+	if len(history) != 2 {
+		t.Fatalf("history has %d entries, want 2", len(history))
+	}
+
+	if !PasswordReused("current-pass", current, history, 3) || !PasswordReused("previous-pass", current, history, 3) {
+		t.Error("PasswordReused missed a remembered password")
+	}
+
+	if PasswordReused("older-pass", current, history, 2) {
+		t.Error("PasswordReused looked further back than the policy history")
+	}
+
+	if PasswordReused("current-pass", current, history, 0) {
+		t.Error("PasswordReused checked history with the policy disabled")
+	}
+}
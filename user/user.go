@@ -15,6 +15,7 @@ import (
 	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
+	"zuri.chat/zccore/service"
 	"zuri.chat/zccore/utils"
 )
 
@@ -58,29 +59,37 @@ func (uh *UserHandler) Create(response http.ResponseWriter, request *http.Reques
 		return
 	}
 
-	hashPassword, err := GetHash(user.Password)
+	if err := DefaultPasswordPolicy(uh.configs).Check(user.Password); err != nil {
+		utils.GetError(err, http.StatusBadRequest, response)
+		return
+	}
+
+	hashPassword, err := GetHash(user.Password, uh.configs.BcryptCost)
 	if err != nil {
 		utils.GetError(errHashingFailed, http.StatusBadRequest, response)
 		return
 	}
 
-	randomNumberLimit := 6
-	timeLimit := 24
-	_, comfimationToken := utils.RandomGen(randomNumberLimit, "d")
-
-	con := &UserEmailVerification{false, comfimationToken, time.Now().Add(time.Minute * time.Duration(timeLimit))}
+	code, con, err := NewEmailVerification(uh.configs.SecretKey, time.Duration(uh.configs.VerificationCodeTTL)*time.Second)
+	if err != nil {
+		utils.GetError(err, http.StatusInternalServerError, response)
+		return
+	}
 
 	suid := SuidService.NewSuid()
 	user.Email = userEmail
 	user.ID = suid.GenerateId(user.FirstName, 6)
 	user.CreatedAt = time.Now()
 	user.Password = hashPassword
+	user.PasswordChangedAt = user.CreatedAt
 	user.Deactivated = false
 	user.IsVerified = false
 	user.EmailVerification = con
 	user.Social = nil
 	user.Timezone = "Africa/Lagos" // set default timezone
 	detail, _ := utils.StructToMap(user)
+	// the code hash is hidden from json, store the record itself
+	detail["email_verification"] = con
 
 	res, err := utils.CreateMongoDBDoc(UserCollectionName, detail)
 
@@ -92,19 +101,18 @@ func (uh *UserHandler) Create(response http.ResponseWriter, request *http.Reques
 	log.Println("response is", res.InsertedID)
 
 	// Email Service <- send confirmation mail
-	//msger := uh.mailService.NewMail(
-	//	[]string{user.Email}, "Account Confirmation", service.MailConfirmation, map[string]interface{}{
-	//		"Username": user.Email,
-	//		"Code":     comfimationToken,
-	//	})
-	//
-	//if err := uh.mailService.SendMail(msger); err != nil {
-	//	fmt.Printf("Error occurred while sending mail: %s", err.Error())
-	//}
+	msger := uh.mailService.NewMail(
+		[]string{user.Email}, "Account Confirmation", service.MailConfirmation, map[string]interface{}{
+			"Username": user.Email,
+			"Code":     code,
+		})
+
+	if err := uh.mailService.SendMail(msger); err != nil {
+		fmt.Printf("Error occurred while sending mail: %s", err.Error())
+	}
 
 	respse := map[string]interface{}{
-		"user_id":           res.InsertedID,
-		"verification_code": comfimationToken,
+		"user_id": res.InsertedID,
 	}
 
 	utils.GetSuccess("user created", respse, response)
@@ -365,12 +373,75 @@ func (uh *UserHandler) GetUserOrganizations(response http.ResponseWriter, reques
 
 		basic["imgs"], basic["id"], basic["logo_url"] = basicimagesdata.Interfaces, orgDetails["_id"], orgDetails["logo_url"]
 		basic["name"], basic["workspace_url"] = orgDetails["name"], orgDetails["workspace_url"]
+		basic["joinable"] = false
 		orgs = append(orgs, basic)
 	}
 
+	joinable, err := joinableOrganizations(userEmail)
+	if err != nil {
+		log.Println(err)
+	}
+
+	orgs = append(orgs, joinable...)
+
 	utils.GetSuccess("user organizations retrieved successfully", orgs, response)
 }
 
+// joinableOrganizations lists the organizations a verified user may join
+// without an invite because they allow the domain of the user's email. Ones
+// the user is, or was, a member of are left out.
+func joinableOrganizations(email string) ([]map[string]interface{}, error) {
+	joinable := make([]map[string]interface{}, 0)
+
+	userDoc, _ := utils.GetMongoDBDoc(UserCollectionName, bson.M{"email": email})
+	if verified, _ := userDoc["isverified"].(bool); !verified {
+		return joinable, nil
+	}
+
+	memberships, err := utils.GetMongoDBDocs(MemberCollectionName, bson.M{"email": email})
+	if err != nil {
+		return joinable, err
+	}
+
+	member := make(map[string]bool)
+	for _, m := range memberships {
+		orgID, _ := m["org_id"].(string)
+		member[orgID] = true
+	}
+
+	domain := email[strings.LastIndex(email, "@")+1:]
+	filter := bson.M{
+		"allowed_domains": bson.M{"$elemMatch": bson.M{"domain": domain, "verified": true}},
+		"purge_after":     bson.M{"$exists": false},
+	}
+
+	orgDocs, err := utils.GetMongoDBDocs(OrganizationCollectionName, filter)
+	if err != nil {
+		return joinable, err
+	}
+
+	for _, org := range orgDocs {
+		orgID := fmt.Sprint(org["_id"])
+		if oid, ok := org["_id"].(primitive.ObjectID); ok {
+			orgID = oid.Hex()
+		}
+
+		if member[orgID] {
+			continue
+		}
+
+		joinable = append(joinable, map[string]interface{}{
+			"id":            org["_id"],
+			"name":          org["name"],
+			"logo_url":      org["logo_url"],
+			"workspace_url": org["workspace_url"],
+			"joinable":      true,
+		})
+	}
+
+	return joinable, nil
+}
+
 // Create a new user from UUID guest invite sent to user and a supplied password.
 func (uh *UserHandler) CreateUserFromUUID(w http.ResponseWriter, r *http.Request) {
 	w.Header().Add("content-type", "application/json")
@@ -390,10 +461,25 @@ func (uh *UserHandler) CreateUserFromUUID(w http.ResponseWriter, r *http.Request
 		return
 	}
 
-	// Check that UUID exists
-	res, err := utils.GetMongoDBDoc(OrganizationsInvitesCollectionName, bson.M{"uuid": uRequest.UUID})
+	// Check that UUID exists and the invite is still pending. Invites from
+	// before statuses existed only have has_accepted and no expiry.
+	filter := bson.M{
+		"uuid": uRequest.UUID,
+		"$and": bson.A{
+			bson.M{"$or": bson.A{
+				bson.M{"status": "pending"},
+				bson.M{"status": bson.M{"$exists": false}, "has_accepted": bson.M{"$ne": true}},
+			}},
+			bson.M{"$or": bson.A{
+				bson.M{"expires_at": bson.M{"$gt": time.Now()}},
+				bson.M{"expires_at": bson.M{"$exists": false}},
+			}},
+		},
+	}
+
+	res, err := utils.GetMongoDBDoc(OrganizationsInvitesCollectionName, filter)
 	if err != nil {
-		utils.GetError(errors.New("uuid does not exist"), http.StatusBadRequest, w)
+		utils.GetError(errors.New("invite does not exist, has been revoked or has expired"), http.StatusBadRequest, w)
 		return
 	}
 
@@ -418,14 +504,18 @@ func (uh *UserHandler) CreateUserFromUUID(w http.ResponseWriter, r *http.Request
 		return
 	}
 
-	// UserEmailVerification
-	randomNumberLimit := 6
-	timeLimit := 24
-	_, comfimationToken := utils.RandomGen(randomNumberLimit, "d")
-	con := &UserEmailVerification{true, comfimationToken, time.Now().Add(time.Minute * time.Duration(timeLimit))}
+	// invited guests confirm their email by accepting the invite
+	con := &UserEmailVerification{Verified: true, CreatedAt: time.Now()}
+
+	// the inviting organization's policy applies from the start
+	orgID, _ := res["org_id"].(string)
+	if err := PasswordPolicyFor(uh.configs, "", orgID).Check(uRequest.Password); err != nil {
+		utils.GetError(err, http.StatusBadRequest, w)
+		return
+	}
 
 	// Hash password
-	hashPassword, err := GetHash(uRequest.Password)
+	hashPassword, err := GetHash(uRequest.Password, uh.configs.BcryptCost)
 	if err != nil {
 		utils.GetError(errHashingFailed, http.StatusBadRequest, w)
 		return
@@ -436,6 +526,7 @@ func (uh *UserHandler) CreateUserFromUUID(w http.ResponseWriter, r *http.Request
 		LastName:          uRequest.LastName,
 		Email:             email,
 		Password:          hashPassword,
+		PasswordChangedAt: time.Now(),
 		IsVerified:        true,
 		EmailVerification: con,
 		CreatedAt:         time.Now(),
@@ -2,7 +2,6 @@ package utils
 
 import (
 	"fmt"
-	"time"
 
 	"github.com/spf13/viper"
 )
@@ -15,6 +14,20 @@ type Configurations struct {
 	SessionKey          string
 	SessionDBCollection string
 	SessionMaxAge       int
+	AccessTokenTTL      int
+	RefreshTokenTTL     int
+	VerificationCodeTTL int
+	ResetCodeTTL        int
+	CodeMaxAttempts     int
+	MagicLinkTTL        int
+	MagicLinkURL        string // page that posts the token to /auth/magic-link/verify
+	EmailChangeURL      string // page that posts email change tokens back to the API
+	OrgDeleteGraceDays  int    // days a deleted organization can still be restored
+	TrustedProxies      string // comma separated proxy addresses or CIDRs whose X-Forwarded-For is believed
+	ExportTTL           int
+	ExportURL           string // page that downloads a finished workspace export
+	InviteTTL           int
+	BcryptCost          int
 	UserDBCollection    string
 	SendGridAPIKey      string
 
@@ -35,6 +48,14 @@ type Configurations struct {
 	TokenBillingNoticeTemplate string
 	WorkSpaceInviteTemplate    string
 	WorkSpaceWelcomeTemplate   string
+	PluginSpendAlertTemplate   string
+	AccountLockedTemplate      string
+	MagicLinkTemplate          string
+	EmailChangeConfirmTemplate string
+	EmailChangeNoticeTemplate  string
+	OrgDeletionTemplate        string
+	OrgExportTemplate          string
+	DomainVerifyTemplate       string
 
 	CentrifugoKey      string
 	CentrifugoEndpoint string
@@ -43,8 +64,26 @@ type Configurations struct {
 	GoogleOAuthV3URL string
 	FacebookOAuthURL string
 
+	// JSON array of OpenID Connect providers, see oidc.Config
+	OIDCProviders string
+
 	HmacSampleSecret string
 
+	// deployment wide password policy, organizations may only tighten it
+	PasswordMinLength      int
+	PasswordRequireUpper   bool
+	PasswordRequireLower   bool
+	PasswordRequireDigit   bool
+	PasswordRequireSymbol  bool
+	PasswordHistory        int
+	PasswordRejectBreached bool
+	// k-anonymity range API breached passwords are looked up in, see
+	// https://haveibeenpwned.com/API/v3#SearchingPwnedPasswordsByRange
+	PasswordBreachRangeURL string
+
+	// key used to encrypt sensitive values at rest, e.g plugin secrets
+	EncryptionKey string
+
 	// Agora details
 	AppId         string
 	AppCerificate string
@@ -68,7 +107,24 @@ func NewConfigurations() *Configurations {
 	viper.SetDefault("SECRET_KEY", "5d5c7f94e29ba12a21f682be310d3af4")
 	viper.SetDefault("SESSION_KEY", "f6822af94e29ba112be310d3af45d5c7")
 	viper.SetDefault("HMAC_SECRET", "u7b8be9bd9b9ebd9b9dbdbee")
-	viper.SetDefault("SESSION_MAX_AGE", time.Now().Unix()+(31536000*200))
+	viper.SetDefault("SESSION_MAX_AGE", 2592000)   // 30 days, in seconds
+	viper.SetDefault("ACCESS_TOKEN_TTL", 900)      // 15 minutes
+	viper.SetDefault("REFRESH_TOKEN_TTL", 2592000) // 30 days
+	viper.SetDefault("VERIFICATION_CODE_TTL", 86400)
+	viper.SetDefault("RESET_CODE_TTL", 900)
+	viper.SetDefault("CODE_MAX_ATTEMPTS", 5)
+	viper.SetDefault("MAGIC_LINK_TTL", 900)
+	viper.SetDefault("MAGIC_LINK_URL", "https://zuri.chat/magic-link")
+	viper.SetDefault("EMAIL_CHANGE_URL", "https://zuri.chat/email-change")
+	viper.SetDefault("ORG_DELETE_GRACE_DAYS", 30)
+	viper.SetDefault("EXPORT_TTL", 604800) // 7 days
+	viper.SetDefault("EXPORT_URL", "https://zuri.chat/exports")
+	viper.SetDefault("INVITE_TTL", 604800) // 7 days
+	viper.SetDefault("BCRYPT_COST", 14)
+	viper.SetDefault("PASSWORD_MIN_LENGTH", 8)
+	viper.SetDefault("PASSWORD_HISTORY", 5)
+	viper.SetDefault("PASSWORD_REJECT_BREACHED", true)
+	viper.SetDefault("PASSWORD_BREACH_RANGE_URL", "https://api.pwnedpasswords.com/range/")
 	viper.SetDefault("USER_COLLECTION", "users")
 	viper.SetDefault("SESSION_COLLECTION", "session_store")
 	viper.SetDefault("CONFIRM_EMAIL_TEMPLATE", "./templates/confirm_email.html")
@@ -78,6 +134,14 @@ func NewConfigurations() *Configurations {
 	viper.SetDefault("TOKEN_BILLING_NOTICE_TEMPLATE", "./templates/token_billing_notice.html")
 	viper.SetDefault("WORKSPACE_INVITE_TEMPLATE", "./templates/workspace_invite.html")
 	viper.SetDefault("WORKSPACE_WELCOME_TEMPLATE", "./templates/workspace_welcome.html")
+	viper.SetDefault("PLUGIN_SPEND_ALERT_TEMPLATE", "./templates/plugin_spend_alert.html")
+	viper.SetDefault("ACCOUNT_LOCKED_TEMPLATE", "./templates/account_locked.html")
+	viper.SetDefault("MAGIC_LINK_TEMPLATE", "./templates/magic_link.html")
+	viper.SetDefault("EMAIL_CHANGE_CONFIRM_TEMPLATE", "./templates/email_change_confirm.html")
+	viper.SetDefault("EMAIL_CHANGE_NOTICE_TEMPLATE", "./templates/email_change_notice.html")
+	viper.SetDefault("ORG_DELETION_TEMPLATE", "./templates/organization_deletion.html")
+	viper.SetDefault("ORG_EXPORT_TEMPLATE", "./templates/organization_export.html")
+	viper.SetDefault("DOMAIN_VERIFY_TEMPLATE", "./templates/domain_verification.html")
 	viper.SetDefault("GOOGLE_OAUTH_V3", "https://www.googleapis.com/oauth2/v3/userinfo?access_token=:access_token")
 
 	configs := &Configurations{
@@ -87,6 +151,19 @@ func NewConfigurations() *Configurations {
 		SessionKey:          viper.GetString("SESSION_KEY"),
 		SessionDBCollection: viper.GetString("SESSION_COLLECTION"),
 		SessionMaxAge:       viper.GetInt("SESSION_MAX_AGE"),
+		AccessTokenTTL:      viper.GetInt("ACCESS_TOKEN_TTL"),
+		RefreshTokenTTL:     viper.GetInt("REFRESH_TOKEN_TTL"),
+		VerificationCodeTTL: viper.GetInt("VERIFICATION_CODE_TTL"),
+		ResetCodeTTL:        viper.GetInt("RESET_CODE_TTL"),
+		CodeMaxAttempts:     viper.GetInt("CODE_MAX_ATTEMPTS"),
+		MagicLinkTTL:        viper.GetInt("MAGIC_LINK_TTL"),
+		MagicLinkURL:        viper.GetString("MAGIC_LINK_URL"),
+		EmailChangeURL:      viper.GetString("EMAIL_CHANGE_URL"),
+		OrgDeleteGraceDays:  viper.GetInt("ORG_DELETE_GRACE_DAYS"),
+		ExportTTL:           viper.GetInt("EXPORT_TTL"),
+		ExportURL:           viper.GetString("EXPORT_URL"),
+		InviteTTL:           viper.GetInt("INVITE_TTL"),
+		BcryptCost:          viper.GetInt("BCRYPT_COST"),
 		UserDBCollection:    viper.GetString("USER_COLLECTION"),
 		SendGridAPIKey:      viper.GetString("SENDGRID_API_KEY"),
 		ESPType:             viper.GetString("ESP_TYPE"),
@@ -98,6 +175,14 @@ func NewConfigurations() *Configurations {
 		TokenBillingNoticeTemplate: viper.GetString("TOKEN_BILLING_NOTICE_TEMPLATE"),
 		WorkSpaceInviteTemplate:    viper.GetString("WORKSPACE_INVITE_TEMPLATE"),
 		WorkSpaceWelcomeTemplate:   viper.GetString("WORKSPACE_WELCOME_TEMPLATE"),
+		PluginSpendAlertTemplate:   viper.GetString("PLUGIN_SPEND_ALERT_TEMPLATE"),
+		AccountLockedTemplate:      viper.GetString("ACCOUNT_LOCKED_TEMPLATE"),
+		MagicLinkTemplate:          viper.GetString("MAGIC_LINK_TEMPLATE"),
+		EmailChangeConfirmTemplate: viper.GetString("EMAIL_CHANGE_CONFIRM_TEMPLATE"),
+		EmailChangeNoticeTemplate:  viper.GetString("EMAIL_CHANGE_NOTICE_TEMPLATE"),
+		OrgDeletionTemplate:        viper.GetString("ORG_DELETION_TEMPLATE"),
+		OrgExportTemplate:          viper.GetString("ORG_EXPORT_TEMPLATE"),
+		DomainVerifyTemplate:       viper.GetString("DOMAIN_VERIFY_TEMPLATE"),
 
 		SMTPUsername:  viper.GetString("SMTP_USERNAME"),
 		SMTPPassword:  viper.GetString("SMTP_PASSWORD"),
@@ -114,8 +199,23 @@ func NewConfigurations() *Configurations {
 		GoogleOAuthV3URL: viper.GetString("GOOGLE_OAUTH_V3"),
 		FacebookOAuthURL: viper.GetString("FACEBOOK_OAUTH"),
 
+		OIDCProviders: viper.GetString("OIDC_PROVIDERS"),
+
 		HmacSampleSecret: viper.GetString("HMAC_SECRET"),
 
+		PasswordMinLength:      viper.GetInt("PASSWORD_MIN_LENGTH"),
+		PasswordRequireUpper:   viper.GetBool("PASSWORD_REQUIRE_UPPER"),
+		PasswordRequireLower:   viper.GetBool("PASSWORD_REQUIRE_LOWER"),
+		PasswordRequireDigit:   viper.GetBool("PASSWORD_REQUIRE_DIGIT"),
+		PasswordRequireSymbol:  viper.GetBool("PASSWORD_REQUIRE_SYMBOL"),
+		PasswordHistory:        viper.GetInt("PASSWORD_HISTORY"),
+		PasswordRejectBreached: viper.GetBool("PASSWORD_REJECT_BREACHED"),
+		PasswordBreachRangeURL: viper.GetString("PASSWORD_BREACH_RANGE_URL"),
+
+		EncryptionKey: viper.GetString("ENCRYPTION_KEY"),
+
+		TrustedProxies: viper.GetString("TRUSTED_PROXIES"),
+
 		// Agora details
 		AppId:         viper.GetString("APP_ID"),
 		AppCerificate: viper.GetString("APP_CERTIFICATE"),
@@ -123,3 +223,18 @@ func NewConfigurations() *Configurations {
 
 	return configs
 }
+
+// MinEncryptionKeyLength is the shortest ENCRYPTION_KEY the server accepts.
+const MinEncryptionKeyLength = 32
+
+var ErrEncryptionKey = fmt.Errorf("ENCRYPTION_KEY must be set to a random value of at least %d characters", MinEncryptionKeyLength)
+
+// CheckEncryptionKey fails when ENCRYPTION_KEY is missing or too short. It has
+// no default on purpose, plugin and two factor secrets are sealed with it.
+func (c *Configurations) CheckEncryptionKey() error {
+	if len(c.EncryptionKey) < MinEncryptionKeyLength {
+		return ErrEncryptionKey
+	}
+
+	return nil
+}
@@ -44,7 +44,16 @@ func ConnectToDB(clusterURL string) error {
 
 	once.Do(func() {
 		ec.Check(defaultMongoHandle.Connect(clusterURL))
-		//ec.Check(CreateUniqueIndex("users", "email", 1))
+
+		if ec.err == nil {
+			// email changes rely on it to settle two moves to the same address.
+			// Older databases may hold users sharing an email, which have to be
+			// merged by hand before it can be built, so it doesn't stop the start.
+			if err := CreateUniqueIndex("users", "email", 1); err != nil {
+				log.Printf("%v, merge users sharing an email to build it", err)
+			}
+		}
+
 		//ec.Check(CreateUniqueIndex("plugins", "template_url", 1))
 		//ec.Check(CreateTextIndexForPlugins())
 	})
@@ -323,7 +332,7 @@ func CreateUniqueIndex(collName, field string, order int) error {
 
 	_, err := collection.Indexes().CreateOne(ctx, indexModel)
 	if err != nil {
-		return fmt.Errorf("failed to create unique index on field %s in %s", field, collName)
+		return fmt.Errorf("failed to create unique index on field %s in %s: %w", field, collName, err)
 	}
 
 	return nil
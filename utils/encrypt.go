@@ -3,11 +3,15 @@ package utils
 import (
 	"crypto/aes"
 	"crypto/cipher"
+	"crypto/hmac"
 	"crypto/md5"
 	"crypto/rand"
+	"crypto/sha256"
 	"encoding/base64"
 	"encoding/hex"
+	"errors"
 	"io"
+	"math/big"
 )
 
 var iv = []byte{35, 46, 57, 24, 85, 35, 24, 74, 87, 35, 88, 98, 66, 32, 14, 05}
@@ -64,6 +68,88 @@ func GCMEncrypt(data []byte, passphrase string) []byte {
 	return ciphertext
 }
 
+func GCMDecrypt(data []byte, passphrase string) ([]byte, error) {
+	block, err := aes.NewCipher([]byte(createHash(passphrase)))
+	if err != nil {
+		return nil, err
+	}
+
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+
+	nonceSize := gcm.NonceSize()
+	if len(data) < nonceSize {
+		return nil, errors.New("ciphertext too short")
+	}
+
+	nonce, ciphertext := data[:nonceSize], data[nonceSize:]
+
+	return gcm.Open(nil, nonce, ciphertext, nil)
+}
+
+// EncryptString seals text with GCMEncrypt and returns it base64 encoded so it can be stored as a string.
+func EncryptString(text, passphrase string) string {
+	return encodeBase64(GCMEncrypt([]byte(text), passphrase))
+}
+
+// DecryptString reverses EncryptString.
+func DecryptString(text, passphrase string) (string, error) {
+	data, err := base64.StdEncoding.DecodeString(text)
+	if err != nil {
+		return "", err
+	}
+
+	plaintext, err := GCMDecrypt(data, passphrase)
+	if err != nil {
+		return "", err
+	}
+
+	return string(plaintext), nil
+}
+
+// HashToken returns the hex encoded sha256 digest of a token, used to store secrets we only ever compare.
+func HashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// GenSecureToken returns n cryptographically random bytes, hex encoded.
+func GenSecureToken(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := io.ReadFull(rand.Reader, b); err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(b), nil
+}
+
+// HashCode keys the digest of a short code, such as a six digit reset code,
+// with key so a leaked hash cannot simply be brute forced.
+func HashCode(code, key string) string {
+	mac := hmac.New(sha256.New, []byte(key))
+	mac.Write([]byte(code))
+
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// GenNumericCode returns an n digit code from a cryptographically secure source.
+func GenNumericCode(n int) (string, error) {
+	code := make([]byte, n)
+
+	for i := range code {
+		d, err := rand.Int(rand.Reader, big.NewInt(10))
+		if err != nil {
+			return "", err
+		}
+
+		code[i] = byte('0' + d.Int64())
+	}
+
+	return string(code), nil
+}
+
 func Decrypt(key, text string) string {
 	block, err := aes.NewCipher([]byte(key))
 	if err != nil {
@@ -4,6 +4,7 @@ import (
 	"errors"
 	"net"
 	"net/http"
+	"strings"
 	"sync"
 
 	"golang.org/x/time/rate"
@@ -44,10 +45,96 @@ func Throttle(next http.HandlerFunc) http.HandlerFunc {
 		// current user
 		limiter := getVisitor(ip)
 		if !limiter.Allow() {
-			GetError(err, http.StatusTooManyRequests, w)
+			GetError(errors.New("too many requests, slow down"), http.StatusTooManyRequests, w)
 			return
 		}
 
 		next.ServeHTTP(w, r)
 	}
 }
+
+var (
+	trustedProxiesOnce sync.Once
+	trustedProxies     []*net.IPNet
+)
+
+// parseTrustedProxies reads a comma separated list of addresses and CIDR
+// ranges, skipping entries that are neither.
+func parseTrustedProxies(list string) []*net.IPNet {
+	var nets []*net.IPNet
+
+	for _, entry := range strings.Split(list, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		if !strings.Contains(entry, "/") {
+			if ip := net.ParseIP(entry); ip != nil && ip.To4() != nil {
+				entry += "/32"
+			} else {
+				entry += "/128"
+			}
+		}
+
+		if _, n, err := net.ParseCIDR(entry); err == nil {
+			nets = append(nets, n)
+		}
+	}
+
+	return nets
+}
+
+func isTrusted(ip string, trusted []*net.IPNet) bool {
+	parsed := net.ParseIP(ip)
+	if parsed == nil {
+		return false
+	}
+
+	for _, n := range trusted {
+		if n.Contains(parsed) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// ClientIP returns the address of the client that made r. X-Forwarded-For is
+// only honoured when the request came from one of the TRUSTED_PROXIES, and
+// then the nearest hop that is not itself a trusted proxy is used, since
+// anything further left was written by the client.
+func ClientIP(r *http.Request) string {
+	trustedProxiesOnce.Do(func() {
+		trustedProxies = parseTrustedProxies(NewConfigurations().TrustedProxies)
+	})
+
+	return clientIP(r, trustedProxies)
+}
+
+func clientIP(r *http.Request, trusted []*net.IPNet) string {
+	ip, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		ip = r.RemoteAddr
+	}
+
+	fwd := r.Header.Get("X-Forwarded-For")
+	if fwd == "" || !isTrusted(ip, trusted) {
+		return ip
+	}
+
+	hops := strings.Split(fwd, ",")
+	for i := len(hops) - 1; i >= 0; i-- {
+		hop := strings.TrimSpace(hops[i])
+		if hop == "" {
+			continue
+		}
+
+		ip = hop
+		if !isTrusted(hop, trusted) {
+			break
+		}
+	}
+
+	return ip
+}
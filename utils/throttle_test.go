@@ -0,0 +1,36 @@
+package utils
+
+import (
+	"net/http/httptest"
+	"testing"
+)
+
+func TestClientIP(t *testing.T) {
+	trusted := parseTrustedProxies("10.0.0.0/8, 192.168.1.7, not-an-ip")
+
+	tests := []struct {
+		remote string
+		xff    string
+		want   string
+	}{
+		{"203.0.113.9:4000", "", "203.0.113.9"},
+		{"203.0.113.9:4000", "1.2.3.4", "203.0.113.9"},
+		{"10.1.2.3:4000", "198.51.100.2", "198.51.100.2"},
+		{"10.1.2.3:4000", "1.2.3.4, 198.51.100.2", "198.51.100.2"},
+		{"192.168.1.7:4000", "1.2.3.4, 198.51.100.2, 10.0.0.5", "198.51.100.2"},
+		{"10.1.2.3:4000", "10.0.0.9, 10.0.0.5", "10.0.0.9"},
+	}
+
+	for _, tt := range tests {
+		r := httptest.NewRequest("GET", "/", nil)
+		r.RemoteAddr = tt.remote
+
+		if tt.xff != "" {
+			r.Header.Set("X-Forwarded-For", tt.xff)
+		}
+
+		if got := clientIP(r, trusted); got != tt.want {
+			t.Errorf("clientIP(%s, %q) = %s, want %s", tt.remote, tt.xff, got, tt.want)
+		}
+	}
+}